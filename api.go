@@ -0,0 +1,251 @@
+// Package qmigen generates the Go QMI implementation from libqmi style
+// data files, see cmd/qmigen for the command.
+package qmigen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/types"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Options of the generated code, the flags of the qmigen command
+type Options struct {
+	Package string // of the generated code, "qmi" when empty
+
+	// Common holds the common-refs and enums of qmi-common.json the data
+	// file refers to, as returned by LoadRegistry. Nil for qmi-common.json
+	// itself; GenerateFile then loads the one next to its input.
+	Common *Registry
+
+	// Strict fails on keys and entity types of data files which qmigen
+	// does not model, instead of warning
+	Strict bool
+
+	// Warnings receives a "warning: " line for each of those without
+	// Strict, they are dropped when nil
+	Warnings io.Writer
+
+	RetainRawTLVs    bool // retain raw TLVs of decoded messages
+	ExplicitRegister bool // register messages from generated Register functions instead of init()
+	Internal         bool // generate libqmi internal messages (id 0xFF00 and above)
+	OptionalPointers bool // generate optional TLVs (id 0x10 and above) as pointer fields
+	DirectEncoding   bool // encode integers with explicit byte order calls instead of binary.Read and binary.Write
+
+	// PresenceAccessors generates GetX() (value, present) methods for
+	// optional output TLVs, from what the decoder records of them for
+	// String() unless OptionalPointers makes them nil when absent. They
+	// are GetTxBytes() rather than TxBytes() because TxBytes is the name
+	// of the field itself.
+	PresenceAccessors bool
+
+	// StringPolicy decodes strings which are not valid UTF-8: "replace"
+	// invalid sequences with U+FFFD, "escape" their bytes as \xNN or fail
+	// with ErrInvalidUTF8 when "strict". They pass as is when empty.
+	StringPolicy string
+
+	// Acronyms names a hjson file with additional acronyms, merged into
+	// the built-in ones. It is a file of its own rather than an entity of
+	// the data files: import-libqmi replaces those with libqmi's, and all
+	// files of a package must spell the identifiers they share alike,
+	// which the flag in every //go:generate line keeps.
+	Acronyms string
+
+	// SizeReport receives the estimated generated code size per message
+	SizeReport io.Writer
+
+	// SkipTypeCheck writes the generated code without type-checking it,
+	// to debug partial output
+	SkipTypeCheck bool
+
+	// Output is the name of the generated file: qmi-common.go also gets
+	// the runtime. Source names the data file and Generator the qmigen
+	// command in its //go:generate line, both relative to Output.
+	Output    string
+	Source    string
+	Generator string
+}
+
+// generator is the state of one run of the generator, threaded through the
+// entities it converts. Runs share nothing but frozen registries, so they
+// may go on concurrently.
+type generator struct {
+	opts     Options           // of the file being generated, set by generate
+	reg      *Registry         // of the file being generated, set by generate
+	acronyms map[string]string // Acronyms and those of opts.Acronyms
+
+	symbols        symbolTable     // of the files generated so far
+	clientServices map[string]bool // with a generated client type
+
+	// declarations of the file being generated, emitted at its end
+	docComments   map[string]string
+	pendingEnums  []*QMIEnum
+	pendingScales []*QMIScale
+
+	usedRefs map[string]string   // hashes of common-refs of other files
+	origins  map[ast.Decl]string // data file entities of declarations
+	importer types.Importer      // of the standard library, once needed
+}
+
+func (o Options) packageName() string {
+	if o.Package == "" {
+		return "qmi"
+	}
+	return o.Package
+}
+
+// warnf writes a warning line to o.Warnings
+func (o Options) warnf(format string, args ...interface{}) {
+	if o.Warnings != nil {
+		fmt.Fprintf(o.Warnings, "warning: "+format+"\n", args...)
+	}
+}
+
+// generator defaults to the running command as seen from a sibling
+// directory, ../qmigen/qmigen
+func (o Options) generator() string {
+	if o.Generator != "" {
+		return o.Generator
+	}
+	genpath, err := filepath.Abs(os.Args[0])
+	if err != nil {
+		return os.Args[0]
+	}
+	return filepath.Join(
+		"..",
+		filepath.Base(filepath.Dir(genpath)),
+		filepath.Base(genpath),
+	)
+}
+
+// genFlags renders the options for the //go:generate line, as flags in the
+// order of their names
+func genFlags(o Options) string {
+	var flags []string
+	add := func(name string, set bool, value string) {
+		if set {
+			flags = append(flags, fmt.Sprintf("-%s=%s ", name, value))
+		}
+	}
+	add("acronyms", o.Acronyms != "", o.Acronyms)
+	add("direct-encoding", o.DirectEncoding, "true")
+	add("explicit-register", o.ExplicitRegister, "true")
+	add("internal", o.Internal, "true")
+	add("optional-pointers", o.OptionalPointers, "true")
+	add("presence-accessors", o.PresenceAccessors, "true")
+	add("raw-tlvs", o.RetainRawTLVs, "true")
+	// SizeReport is a one-off report on stdout, not part of the output
+	// SkipTypeCheck is a debugging aid, regenerating checks again
+	add("strict", o.Strict, "true")
+	add("string-policy", o.StringPolicy != "", o.StringPolicy)
+	return strings.Join(flags, "")
+}
+
+// newGenerator starts a run with the acronyms of o
+func newGenerator(o Options) (*generator, error) {
+	gen := &generator{
+		opts:           o,
+		acronyms:       map[string]string{},
+		symbols:        symbolTable{},
+		clientServices: map[string]bool{},
+		docComments:    map[string]string{},
+		usedRefs:       map[string]string{},
+		origins:        map[ast.Decl]string{},
+	}
+	if _, ok := stringPolicies[o.StringPolicy]; !ok {
+		return nil, fmt.Errorf("string policy %q is unsupported", o.StringPolicy)
+	}
+	for k, v := range Acronyms {
+		gen.acronyms[k] = v
+	}
+
+	if o.Acronyms != "" {
+		err := gen.loadAcronyms(o.Acronyms)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return gen, nil
+}
+
+// Generate returns the Go source generated from the data file spec
+func Generate(spec io.Reader, o Options) ([]byte, error) {
+	input, err := ioutil.ReadAll(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	gen, err := newGenerator(o)
+	if err != nil {
+		return nil, err
+	}
+	return gen.generate(NewRegistry(o.Common), input, o)
+}
+
+// GenerateFile writes output from the data file input
+func GenerateFile(output, input string, o Options) error {
+	gen, err := newGenerator(o)
+	if err != nil {
+		return err
+	}
+
+	if o.Common == nil && filepath.Base(input) != "qmi-common.json" {
+		o.Common, err = gen.loadRegistry(filepath.Join(filepath.Dir(input), "qmi-common.json"))
+		if err != nil {
+			return err
+		}
+	}
+	return gen.convert(NewRegistry(o.Common), output, input, o)
+}
+
+// GenerateFiles writes a file into outDir for each of the data files
+// inputs, qmi-service-ctl.json as qmi-service-ctl.go. Their identifiers
+// must not collide. The others layer on qmi-common.json when it is among
+// the inputs, converted first.
+func GenerateFiles(inputs []string, outDir string, o Options) error {
+	gen, err := newGenerator(o)
+	if err != nil {
+		return err
+	}
+
+	output := func(input string) string {
+		return filepath.Join(outDir, strings.TrimSuffix(filepath.Base(input), ".json")+".go")
+	}
+
+	for _, input := range inputs {
+		if filepath.Base(input) != "qmi-common.json" {
+			continue
+		}
+		common := NewRegistry(nil)
+		err = gen.convert(common, output(input), input, o)
+		if err != nil {
+			return err
+		}
+		common.Freeze()
+		o.Common = common
+	}
+
+	for _, input := range inputs {
+		if filepath.Base(input) == "qmi-common.json" {
+			continue
+		}
+		common := o.Common
+		if common == nil {
+			common, err = gen.loadRegistry(filepath.Join(filepath.Dir(input), "qmi-common.json"))
+			if err != nil {
+				return err
+			}
+		}
+		err = gen.convert(NewRegistry(common), output(input), input, o)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
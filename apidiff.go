@@ -0,0 +1,223 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// apiSymbol is an exported identifier of a generated package: a top level
+// declaration, a method or a struct field
+type apiSymbol struct {
+	Def string // the declaration with names of parameters left out
+
+	// added without breaking users, unlike methods of interfaces which
+	// their implementations lack
+	Extends bool
+}
+
+// apiString renders an expression of a declaration. Parameter names are
+// left out, renaming them does not change the API.
+func apiString(fs *token.FileSet, expr ast.Expr) string {
+	if ft, ok := expr.(*ast.FuncType); ok {
+		expr = &ast.FuncType{
+			Params:  unnamedFields(ft.Params),
+			Results: unnamedFields(ft.Results),
+		}
+	}
+
+	var b bytes.Buffer
+	printer.Fprint(&b, fs, expr)
+	return b.String()
+}
+
+// unnamedFields lists one field per parameter or result, without names
+func unnamedFields(fl *ast.FieldList) *ast.FieldList {
+	if fl == nil {
+		return nil
+	}
+	out := &ast.FieldList{}
+	for _, field := range fl.List {
+		n := len(field.Names)
+		if n == 0 {
+			n = 1
+		}
+		for i := 0; i < n; i++ {
+			out.List = append(out.List, &ast.Field{Type: field.Type})
+		}
+	}
+	return out
+}
+
+// loadAPI collects the exported symbols of the Go files in dir, keyed by
+// the name they are reported by: "type X", "method X.M", "field X.F"
+func loadAPI(dir string) (map[string]apiSymbol, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("%s: no Go files", dir)
+	}
+
+	api := map[string]apiSymbol{}
+	fs := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fs, file, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, decl := range f.Decls {
+			switch d := decl.(type) {
+			case *ast.FuncDecl:
+				if !d.Name.IsExported() {
+					continue
+				}
+				if d.Recv == nil {
+					api["func "+d.Name.Name] = apiSymbol{Def: apiString(fs, d.Type), Extends: true}
+					continue
+				}
+				recv := recvName(d.Recv.List[0].Type)
+				if !ast.IsExported(strings.TrimPrefix(recv, "*")) {
+					continue
+				}
+				// moving to a pointer receiver shrinks the method set
+				// of the value type, so it is a change of the method
+				key := fmt.Sprintf("method %s.%s", strings.TrimPrefix(recv, "*"), d.Name.Name)
+				api[key] = apiSymbol{
+					Def:     fmt.Sprintf("(%s) %s", recv, apiString(fs, d.Type)),
+					Extends: true,
+				}
+			case *ast.GenDecl:
+				for _, spec := range d.Specs {
+					switch s := spec.(type) {
+					case *ast.TypeSpec:
+						if s.Name.IsExported() {
+							addTypeAPI(api, fs, s)
+						}
+					case *ast.ValueSpec:
+						for i, name := range s.Names {
+							if !name.IsExported() {
+								continue
+							}
+							def := ""
+							if s.Type != nil {
+								def = apiString(fs, s.Type)
+							}
+							if d.Tok == token.CONST && i < len(s.Values) {
+								def = strings.TrimSpace(def + " = " + apiString(fs, s.Values[i]))
+							}
+							api[d.Tok.String()+" "+name.Name] = apiSymbol{Def: def, Extends: true}
+						}
+					}
+				}
+			}
+		}
+	}
+	return api, nil
+}
+
+// addTypeAPI adds a type along with the exported fields of a struct or the
+// methods of an interface
+func addTypeAPI(api map[string]apiSymbol, fs *token.FileSet, s *ast.TypeSpec) {
+	name := s.Name.Name
+	sym := apiSymbol{Extends: true}
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		sym.Def = "struct"
+		for _, field := range t.Fields.List {
+			names := field.Names
+			if len(names) == 0 {
+				// embedded, named after its type
+				typ := field.Type
+				if star, ok := typ.(*ast.StarExpr); ok {
+					typ = star.X
+				}
+				if sel, ok := typ.(*ast.SelectorExpr); ok {
+					typ = sel.Sel
+				}
+				names = []*ast.Ident{ast.NewIdent(recvName(typ))}
+			}
+			for _, n := range names {
+				if n.IsExported() {
+					api["field "+name+"."+n.Name] = apiSymbol{Def: apiString(fs, field.Type), Extends: true}
+				}
+			}
+		}
+	case *ast.InterfaceType:
+		sym.Def = "interface"
+		for _, method := range t.Methods.List {
+			if len(method.Names) == 0 {
+				// embedded interface
+				api["method "+name+"."+apiString(fs, method.Type)] = apiSymbol{Def: "embedded"}
+				continue
+			}
+			for _, n := range method.Names {
+				if n.IsExported() {
+					api["method "+name+"."+n.Name] = apiSymbol{Def: apiString(fs, method.Type)}
+				}
+			}
+		}
+	default:
+		sym.Def = apiString(fs, s.Type)
+	}
+	if s.Assign.IsValid() {
+		sym.Def = "= " + sym.Def
+	}
+	api["type "+name] = sym
+}
+
+// APIDiff prints the exported identifiers the generated package in newDir
+// gained, lost or changed compared to the one in oldDir, in the format of
+// Diff, and returns true if any change breaks its users.
+func APIDiff(w io.Writer, oldDir, newDir string) (bool, error) {
+	o, err := loadAPI(oldDir)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := loadAPI(newDir)
+	if err != nil {
+		return false, err
+	}
+
+	breaking := false
+	for _, k := range sortedKeys(o) {
+		os := o[k]
+		ns, ok := n[k]
+		if !ok {
+			fmt.Fprintf(w, "! %s removed\n", k)
+			breaking = true
+			continue
+		}
+		if os.Def != ns.Def {
+			fmt.Fprintf(w, "! %s: %s -> %s\n", k, os.Def, ns.Def)
+			breaking = true
+		}
+	}
+	for _, k := range sortedKeys(n) {
+		if _, ok := o[k]; ok {
+			continue
+		}
+		if n[k].Extends {
+			fmt.Fprintf(w, "+ %s added\n", k)
+		} else {
+			fmt.Fprintf(w, "! %s added\n", k)
+			breaking = true
+		}
+	}
+
+	return breaking, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,159 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// apiTree writes src as the generated file of a package in a temporary
+// directory of t
+func apiTree(t *testing.T, src string) string {
+	t.Helper()
+	dir := t.TempDir()
+	err := ioutil.WriteFile(filepath.Join(dir, "qmi-service-dms.go"), []byte("package qmi\n"+src), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+const apiBase = `
+type DMSGetIDsOutput struct {
+	Imei string
+	esn  string
+}
+
+func (msg *DMSGetIDsOutput) ServiceID() Service { return 2 }
+
+type Sender interface {
+	Send(msg Message) (Message, error)
+}
+
+type QMIDmsMode uint8
+
+const QMIDmsModeOnline QMIDmsMode = 0x00
+
+func NewDMSGetIDsInput(mask uint8) DMSGetIDsInput { return DMSGetIDsInput{} }
+
+func registerDMS() {}
+`
+
+func TestAPIDiff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		new      string
+		report   string
+		breaking bool
+	}{{
+		"unchanged", apiBase, "", false,
+	}, {
+		"method added", apiBase + `
+func (msg *DMSGetIDsOutput) MessageID() uint16 { return 0x25 }
+`,
+		"+ method DMSGetIDsOutput.MessageID added\n", false,
+	}, {
+		"type removed", `
+type DMSGetIDsOutput struct {
+	Imei string
+	esn  string
+}
+
+func (msg *DMSGetIDsOutput) ServiceID() Service { return 2 }
+
+type Sender interface {
+	Send(msg Message) (Message, error)
+}
+
+const QMIDmsModeOnline = 0x00
+
+func NewDMSGetIDsInput(mask uint8) DMSGetIDsInput { return DMSGetIDsInput{} }
+`,
+		"! const QMIDmsModeOnline: QMIDmsMode = 0x00 -> = 0x00\n" +
+			"! type QMIDmsMode removed\n", true,
+	}, {
+		"field type changed", `
+type DMSGetIDsOutput struct {
+	Imei []byte
+	esn  uint64
+	Meid string
+}
+
+func (msg *DMSGetIDsOutput) ServiceID() Service { return 2 }
+
+type Sender interface {
+	Send(msg Message) (Message, error)
+}
+
+type QMIDmsMode uint8
+
+const QMIDmsModeOnline QMIDmsMode = 0x00
+
+func NewDMSGetIDsInput(mask uint8) DMSGetIDsInput { return DMSGetIDsInput{} }
+`,
+		"! field DMSGetIDsOutput.Imei: string -> []byte\n" +
+			"+ field DMSGetIDsOutput.Meid added\n", true,
+	}, {
+		"interface method added, parameter renamed", `
+type DMSGetIDsOutput struct {
+	Imei string
+	esn  string
+}
+
+func (msg *DMSGetIDsOutput) ServiceID() Service { return 2 }
+
+type Sender interface {
+	Send(req Message) (Message, error)
+	Close() error
+}
+
+type QMIDmsMode uint8
+
+const QMIDmsModeOnline QMIDmsMode = 0x00
+
+func NewDMSGetIDsInput(bits uint8) DMSGetIDsInput { return DMSGetIDsInput{} }
+`,
+		"! method Sender.Close added\n", true,
+	}, {
+		"receiver and signature changed", `
+type DMSGetIDsOutput struct {
+	Imei string
+	esn  string
+}
+
+func (msg DMSGetIDsOutput) ServiceID() Service { return 2 }
+
+type Sender interface {
+	Send(msg Message) (Message, error)
+}
+
+type QMIDmsMode uint8
+
+const QMIDmsModeOnline QMIDmsMode = 0x00
+
+func NewDMSGetIDsInput(mask uint16) DMSGetIDsInput { return DMSGetIDsInput{} }
+`,
+		"! func NewDMSGetIDsInput: func(uint8) DMSGetIDsInput -> func(uint16) DMSGetIDsInput\n" +
+			"! method DMSGetIDsOutput.ServiceID: (*DMSGetIDsOutput) func() Service -> (DMSGetIDsOutput) func() Service\n", true,
+	}} {
+		var report bytes.Buffer
+		breaking, err := APIDiff(&report, apiTree(t, apiBase), apiTree(t, test.new))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if report.String() != test.report {
+			t.Errorf("%s: report\n%s\nwant\n%s", test.name, report.String(), test.report)
+		}
+		if breaking != test.breaking {
+			t.Errorf("%s: breaking = %t", test.name, breaking)
+		}
+	}
+
+	if _, err := APIDiff(ioutil.Discard, apiTree(t, apiBase), t.TempDir()); err == nil {
+		t.Error("diff against a directory without Go files succeeded")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,105 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+)
+
+// littleEndianArchs are the GOARCH values of little-endian hosts, which
+// take the fast path of leOrder
+var littleEndianArchs = []string{
+	"386", "amd64", "amd64p32", "arm", "arm64", "loong64",
+	"mips64le", "mips64p32le", "mipsle", "ppc64le", "riscv", "riscv64", "wasm",
+}
+
+// byteOrderFallbackTag forces the fallback of leOrder on any host, to test
+// it where no big-endian one is at hand
+const byteOrderFallbackTag = "qmi_byteorder_fallback"
+
+// byteOrderFast declares leOrder where the host is little-endian:
+// encoding/binary compiles down to plain loads and stores there
+const byteOrderFast = `import "encoding/binary"
+
+// leOrder encodes the little-endian fields of -direct-encoding, in host
+// order here
+var leOrder = binary.LittleEndian
+`
+
+// byteOrderFallback declares leOrder for the other hosts, assembling the
+// bytes one at a time
+const byteOrderFallback = `// leOrder encodes the little-endian fields of -direct-encoding, byte by
+// byte as the host order differs
+var leOrder byteByByte
+
+// byteByByte is binary.LittleEndian without relying on the compiler to
+// merge its byte accesses
+type byteByByte struct{}
+
+func leUint(b []byte) (v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+func lePut(b []byte, v uint64) {
+	for i := range b {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
+
+func (byteByByte) Uint16(b []byte) uint16 { return uint16(leUint(b[:2])) }
+func (byteByByte) Uint32(b []byte) uint32 { return uint32(leUint(b[:4])) }
+func (byteByByte) Uint64(b []byte) uint64 { return leUint(b[:8]) }
+
+func (byteByByte) PutUint16(b []byte, v uint16) { lePut(b[:2], uint64(v)) }
+func (byteByByte) PutUint32(b []byte, v uint32) { lePut(b[:4], uint64(v)) }
+func (byteByByte) PutUint64(b []byte, v uint64) { lePut(b[:8], v) }
+`
+
+// byteOrderFiles renders the files generated next to qmi-common.go which
+// declare leOrder, by name: the fast path and the fallback, exactly one of
+// them selected by the build tags of any host
+func byteOrderFiles(o Options) (map[string][]byte, error) {
+	negated := make([]string, len(littleEndianArchs))
+	for i, arch := range littleEndianArchs {
+		negated[i] = "!" + arch
+	}
+	little := strings.Join(littleEndianArchs, " || ")
+
+	files := map[string][]byte{}
+	for _, file := range []struct {
+		name       string
+		constraint string
+		plusBuild  []string
+		body       string
+	}{
+		{"qmi-byteorder.go",
+			fmt.Sprintf("(%s) && !%s", little, byteOrderFallbackTag),
+			[]string{strings.Join(littleEndianArchs, " "), "!" + byteOrderFallbackTag},
+			byteOrderFast},
+		{"qmi-byteorder-fallback.go",
+			fmt.Sprintf("!(%s) || %s", little, byteOrderFallbackTag),
+			[]string{strings.Join(negated, ",") + " " + byteOrderFallbackTag},
+			byteOrderFallback},
+	} {
+		var out bytes.Buffer
+		fmt.Fprintf(&out, "// Code generated by %s from %s, DO NOT EDIT.\n\n", o.generator(), o.Source)
+		fmt.Fprintf(&out, "//go:build %s\n", file.constraint)
+		for _, line := range file.plusBuild {
+			fmt.Fprintf(&out, "// +build %s\n", line)
+		}
+		fmt.Fprintf(&out, "\npackage %s\n\n%s", o.packageName(), file.body)
+		src, err := format.Source(out.Bytes())
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file.name, err)
+		}
+		files[file.name] = src
+	}
+	return files, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,66 @@
+package qmigen
+
+import (
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestByteOrderFiles expects the build tags of the byte order files to
+// select exactly one of them on any host: the fallback on big-endian ones
+// and with the fallback tag
+func TestByteOrderFiles(t *testing.T) {
+	files, err := byteOrderFiles(Options{Package: "modem", Source: "qmi-common.json", Generator: "qmigen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	for name, src := range files {
+		if !strings.HasPrefix(string(src), "// Code generated by qmigen from qmi-common.json, DO NOT EDIT.\n") ||
+			!strings.Contains(string(src), "\npackage modem\n") {
+			t.Errorf("%s:\n%s", name, src)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, name), src, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, test := range []struct {
+		arch string
+		tags []string
+		want string
+	}{
+		{"amd64", nil, "qmi-byteorder.go"},
+		{"arm", nil, "qmi-byteorder.go"},
+		{"mipsle", nil, "qmi-byteorder.go"},
+		{"mips", nil, "qmi-byteorder-fallback.go"},
+		{"mips64", nil, "qmi-byteorder-fallback.go"},
+		{"ppc64", nil, "qmi-byteorder-fallback.go"},
+		{"s390x", nil, "qmi-byteorder-fallback.go"},
+		{"amd64", []string{byteOrderFallbackTag}, "qmi-byteorder-fallback.go"},
+		{"mips", []string{byteOrderFallbackTag}, "qmi-byteorder-fallback.go"},
+	} {
+		ctx := build.Default
+		ctx.GOOS = "linux"
+		ctx.GOARCH = test.arch
+		ctx.BuildTags = test.tags
+		var matched []string
+		for name := range files {
+			ok, err := ctx.MatchFile(dir, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok {
+				matched = append(matched, name)
+			}
+		}
+		if len(matched) != 1 || matched[0] != test.want {
+			t.Errorf("%s %v: %v selected, want %s", test.arch, test.tags, matched, test.want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
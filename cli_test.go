@@ -0,0 +1,84 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestCLIGolden runs cmd/qmigen on the CTL data file and expects the
+// golden testdata/golden/qmi-service-ctl.go.golden, byte for byte, as
+// GenerateFile writes it too. After a deliberate change of the output
+// regenerate it with QMIGEN_GOLDEN=update.
+func TestCLIGolden(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds cmd/qmigen")
+	}
+	dir := t.TempDir()
+	goTool(t, ".", nil, "build", "-o", filepath.Join(dir, "bin", "qmigen"), "./cmd/qmigen")
+	for _, name := range []string{"qmi-common.json", "qmi-service-ctl.json"} {
+		data, err := ioutil.ReadFile(filepath.Join("testdata/data", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.MkdirAll(filepath.Join(dir, "data"), 0777)
+		if err == nil {
+			err = ioutil.WriteFile(filepath.Join(dir, "data", name), data, 0666)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, out := range []string{"cli", "lib"} {
+		err := os.Mkdir(filepath.Join(dir, out), 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command("bin/qmigen", "data/qmi-service-ctl.json", "cli/qmi-service-ctl.go")
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("qmigen: %s\n%s", err, out)
+	}
+	cli, err := ioutil.ReadFile(filepath.Join(dir, "cli", "qmi-service-ctl.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden := "testdata/golden/qmi-service-ctl.go.golden"
+	if os.Getenv("QMIGEN_GOLDEN") == "update" {
+		err = ioutil.WriteFile(golden, cli, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cli, want) {
+		t.Errorf("qmigen output differs from %s", golden)
+	}
+
+	err = GenerateFile(filepath.Join(dir, "lib", "qmi-service-ctl.go"), filepath.Join(dir, "data", "qmi-service-ctl.json"), Options{
+		Source:    "../data/qmi-service-ctl.json",
+		Generator: "../bin/qmigen",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lib, err := ioutil.ReadFile(filepath.Join(dir, "lib", "qmi-service-ctl.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(lib, cli) {
+		t.Error("GenerateFile output differs from qmigen")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
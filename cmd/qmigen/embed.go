@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+)
+
+// runEmbedRuntime is the embed-runtime command: regenerate footer.go from
+// runtime/qmi.go, or with -check fail when footer.go is stale
+func runEmbedRuntime(args []string) (ok bool, err error) {
+	flags := flag.NewFlagSet("embed-runtime", flag.ContinueOnError)
+	check := flags.Bool("check", false, "compare instead of writing, for CI")
+	src_file := flags.String("src", "runtime/qmi.go", "runtime source")
+	dest := flags.String("dest", "footer.go", "generated file")
+	err = flags.Parse(args)
+	if err != nil {
+		return false, err
+	}
+	if flags.NArg() != 0 {
+		return false, fmt.Errorf("usage: embed-runtime [-check] [-src <file>] [-dest <file>]")
+	}
+
+	data, err := qmigen.EmbedRuntime(*src_file)
+	if err != nil {
+		return false, err
+	}
+
+	if *check {
+		old, err := ioutil.ReadFile(*dest)
+		if err != nil {
+			return false, err
+		}
+		if !bytes.Equal(old, data) {
+			fmt.Printf("%s: stale, run go generate\n", *dest)
+			return false, nil
+		}
+		return true, nil
+	}
+
+	return true, qmigen.WriteOutput(*dest, data)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+)
+
+// runImportLibqmi is the import-libqmi command: write the translated data
+// files of a libqmi checkout into data/, or with -check fail when doing so
+// would change anything
+func runImportLibqmi(args []string) (ok bool, err error) {
+	flags := flag.NewFlagSet("import-libqmi", flag.ContinueOnError)
+	check := flags.Bool("check", false, "compare instead of writing, for CI")
+	dest := flags.String("dest", "data", "directory of the imported data files")
+	err = flags.Parse(args)
+	if err != nil {
+		return false, err
+	}
+	if flags.NArg() != 1 {
+		return false, fmt.Errorf("usage: import-libqmi [-check] [-dest <dir>] <libqmi>/data")
+	}
+
+	files, err := qmigen.ImportLibqmi(flags.Arg(0))
+	if err != nil {
+		return false, err
+	}
+
+	if *check {
+		diffs, err := qmigen.CheckImport(files, *dest)
+		if err != nil {
+			return false, err
+		}
+		for _, d := range diffs {
+			fmt.Printf("%s: %s\n", *dest, d)
+		}
+		return len(diffs) == 0, nil
+	}
+
+	err = os.MkdirAll(*dest, 0777)
+	if err != nil {
+		return false, err
+	}
+	for name, data := range files {
+		err = ioutil.WriteFile(filepath.Join(*dest, name), data, 0666)
+		if err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
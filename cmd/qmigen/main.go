@@ -0,0 +1,122 @@
+// Qmigen generates the Go QMI implementation from the data files, see
+// the qmigen package.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+)
+
+var RetainRawTLVs = flag.Bool("raw-tlvs", false, "retain raw TLVs of decoded messages")
+var AcronymsFile = flag.String("acronyms", "", "hjson file with additional acronyms")
+
+// With -explicit-register applications call RegisterAllCTL (or the
+// per-message functions) and RegisterCommonTLVs themselves before Open
+var ExplicitRegister = flag.Bool("explicit-register", false, "register messages from generated Register functions instead of init()")
+var Internal = flag.Bool("internal", false, "generate libqmi internal messages (id 0xFF00 and above)")
+var SizeReport = flag.Bool("size-report", false, "print estimated generated code size per message")
+var OptionalPointers = flag.Bool("optional-pointers", false, "generate optional TLVs (id 0x10 and above) as pointer fields")
+var DirectEncoding = flag.Bool("direct-encoding", false, "encode integers with explicit byte order calls instead of binary.Read and binary.Write")
+var PresenceAccessors = flag.Bool("presence-accessors", false, "generate GetX() (value, present) accessors for optional output TLVs")
+var StringPolicy = flag.String("string-policy", "", "decode strings which are not UTF-8: replace, escape or strict")
+var Force = flag.Bool("force", false, "regenerate ../qmi even if it holds files qmigen did not generate")
+var Strict = flag.Bool("strict", false, "fail on keys and entity types of data files which qmigen does not model, instead of warning")
+var SkipTypeCheck = flag.Bool("skip-typecheck", false, "write the generated code without type-checking it, to debug partial output")
+
+// defaultDataFiles are generated into ../qmi when run without arguments
+var defaultDataFiles = []string{
+	"data/qmi-common.json",
+	"data/qmi-service-ctl.json",
+	"data/qmi-service-dms.json",
+	"data/qmi-service-wds.json",
+}
+
+func main() {
+	flag.Parse()
+	args := flag.Args()
+
+	opts := qmigen.Options{
+		Strict:            *Strict,
+		RetainRawTLVs:     *RetainRawTLVs,
+		ExplicitRegister:  *ExplicitRegister,
+		Internal:          *Internal,
+		OptionalPointers:  *OptionalPointers,
+		DirectEncoding:    *DirectEncoding,
+		PresenceAccessors: *PresenceAccessors,
+		StringPolicy:      *StringPolicy,
+		Acronyms:          *AcronymsFile,
+		SkipTypeCheck:     *SkipTypeCheck,
+		Warnings:          os.Stderr,
+	}
+	if *SizeReport {
+		opts.SizeReport = os.Stdout
+	}
+
+	if len(args) == 3 && args[0] == "diff" {
+		breaking, err := qmigen.Diff(os.Stdout, args[1], args[2])
+		if err != nil {
+			panic(err)
+		}
+		if breaking {
+			os.Exit(1)
+		}
+	} else if len(args) == 3 && args[0] == "apidiff" {
+		breaking, err := qmigen.APIDiff(os.Stdout, args[1], args[2])
+		if err != nil {
+			panic(err)
+		}
+		if breaking {
+			os.Exit(1)
+		}
+	} else if len(args) > 0 && args[0] == "embed-runtime" {
+		ok, err := runEmbedRuntime(args[1:])
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	} else if len(args) > 0 && args[0] == "verify-build" {
+		ok, err := runVerifyBuild(args[1:], opts)
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	} else if len(args) > 0 && args[0] == "import-libqmi" {
+		ok, err := runImportLibqmi(args[1:])
+		if err != nil {
+			panic(err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+	} else if len(args) == 0 {
+		if !*Force {
+			err := qmigen.CheckOutputDir("../qmi")
+			if err != nil {
+				panic(err)
+			}
+		}
+		os.RemoveAll("../qmi")
+		os.MkdirAll("../qmi", 0777)
+
+		err := qmigen.GenerateFiles(defaultDataFiles, "../qmi", opts)
+		if err != nil {
+			panic(err)
+		}
+	} else if len(args) == 2 {
+		err := qmigen.GenerateFile(args[1], args[0], opts)
+		if err != nil {
+			panic(err)
+		}
+	} else {
+		panic(fmt.Sprintf("usage: %s [-raw-tlvs] [-acronyms <file>] [-explicit-register] [-size-report] [-internal] [-optional-pointers] [-direct-encoding] [-presence-accessors] [-string-policy replace|escape|strict] [-force] [-skip-typecheck] [<inputFile> <outputFile>] | diff <oldFile> <newFile> | apidiff <oldDir> <newDir> | verify-build [-dir <dir>] | import-libqmi [-check] [-dest <dir>] <libqmi>/data | embed-runtime [-check]", os.Args[0]))
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+)
+
+// runVerifyBuild is the verify-build command: generate the default data
+// files into a temporary directory, or take the package in -dir, and fail
+// unless go build, go vet and go test pass on it
+func runVerifyBuild(args []string, opts qmigen.Options) (ok bool, err error) {
+	flags := flag.NewFlagSet("verify-build", flag.ContinueOnError)
+	dir := flags.String("dir", "", "generated package to verify instead of a fresh default generation")
+	err = flags.Parse(args)
+	if err != nil {
+		return false, err
+	}
+	if flags.NArg() != 0 {
+		return false, fmt.Errorf("usage: verify-build [-dir <dir>]")
+	}
+
+	if *dir == "" {
+		tmp, err := ioutil.TempDir("", "qmigen-generated")
+		if err != nil {
+			return false, err
+		}
+		defer os.RemoveAll(tmp)
+
+		err = qmigen.GenerateFiles(defaultDataFiles, tmp, opts)
+		if err != nil {
+			return false, err
+		}
+		*dir = tmp
+	}
+
+	err = qmigen.VerifyBuild(*dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return false, nil
+	}
+	return true, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
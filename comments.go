@@ -0,0 +1,52 @@
+package qmigen
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+)
+
+// addDocComments inserts the doc comments of the run above the top level
+// declarations they name. They are attached once formatted, as the AST
+// carries no positions to place them by. Keys are the start of the
+// declaration's first line, e.g. "type WDSClient ".
+func (gen *generator) addDocComments(src []byte) []byte {
+	if len(gen.docComments) == 0 {
+		return src
+	}
+
+	// the longest prefix wins a line several match, whatever the order
+	// of the map
+	prefixes := make([]string, 0, len(gen.docComments))
+	for prefix := range gen.docComments {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Slice(prefixes, func(i, j int) bool {
+		if len(prefixes[i]) != len(prefixes[j]) {
+			return len(prefixes[i]) > len(prefixes[j])
+		}
+		return prefixes[i] < prefixes[j]
+	})
+
+	var out bytes.Buffer
+	for _, line := range bytes.SplitAfter(src, []byte("\n")) {
+		for _, prefix := range prefixes {
+			if !bytes.HasPrefix(line, []byte(prefix)) {
+				continue
+			}
+			for _, doc_line := range strings.Split(gen.docComments[prefix], "\n") {
+				if doc_line == "" {
+					// between paragraphs, as before Deprecated:
+					out.WriteString("//\n")
+					continue
+				}
+				out.WriteString("// " + doc_line + "\n")
+			}
+			break
+		}
+		out.Write(line)
+	}
+	return out.Bytes()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,72 @@
+package qmigen
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// commonRefCall returns the hash the runtime call fun of src passes for
+// the Operation Result common-ref
+func commonRefCall(t *testing.T, src []byte, fun, file string) string {
+	t.Helper()
+	re := regexp.MustCompile(fun + `\("Operation Result", "([0-9a-f]{16})", "` + regexp.QuoteMeta(file) + `"\)`)
+	m := re.FindSubmatch(src)
+	if m == nil {
+		t.Fatalf("no %s of Operation Result in %s", fun, file)
+	}
+	return string(m[1])
+}
+
+// TestCommonRefHashes expects the CTL file to pass the runtime the hash of
+// Operation Result qmi-common.go defines, and another one once the
+// definition changes
+func TestCommonRefHashes(t *testing.T) {
+	common, err := ioutil.ReadFile("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctl, err := ioutil.ReadFile("testdata/data/qmi-service-ctl.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Error Code of Operation Result grows to a guint32
+	changed := regexp.MustCompile(`("Error Code",\s*"format" : )"guint16"`).ReplaceAllString(string(common), `$1"guint32"`)
+	if changed == string(common) {
+		t.Fatal("Error Code not found in qmi-common.json")
+	}
+
+	var defined, used []string
+	for _, src := range []string{string(common), changed} {
+		dir := t.TempDir()
+		err = ioutil.WriteFile(filepath.Join(dir, "qmi-common.json"), []byte(src), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reg, err := LoadRegistry(filepath.Join(dir, "qmi-common.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := Generate(strings.NewReader(src), Options{Output: "qmi-common.go", SkipTypeCheck: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defined = append(defined, commonRefCall(t, out, "defineCommonRef", "qmi-common.go"))
+		out, err = Generate(strings.NewReader(string(ctl)), Options{Common: reg, Output: "qmi-service-ctl.go", SkipTypeCheck: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		used = append(used, commonRefCall(t, out, "useCommonRef", "qmi-service-ctl.go"))
+	}
+
+	if used[0] != defined[0] || used[1] != defined[1] {
+		t.Errorf("CTL uses %v of the definitions %v", used, defined)
+	}
+	if defined[0] == defined[1] {
+		t.Errorf("both definitions hash to %s", defined[0])
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,187 @@
+package qmigen
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+
+	"github.com/hjson/hjson-go"
+)
+
+type diffTLV struct {
+	ID     string
+	Format string
+	Since  string
+}
+
+type diffMessage struct {
+	Kind   string
+	ID     string
+	Since  string
+	Input  map[string]diffTLV
+	Output map[string]diffTLV
+}
+
+func diffTLVs(tlvs []QMITLV) map[string]diffTLV {
+	m := map[string]diffTLV{}
+	for _, tlv := range tlvs {
+		key := tlv.Name
+		if key == "" {
+			key = tlv.CommonRef
+		}
+		m[key] = diffTLV{
+			ID:     tlv.ID,
+			Format: tlv.Format,
+			Since:  tlv.Since,
+		}
+	}
+	return m
+}
+
+func loadMessages(inputFile string) (map[string]diffMessage, error) {
+	input, err := ioutil.ReadFile(inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw_entities []interface{}
+	err = hjson.Unmarshal(input, &raw_entities)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := map[string]diffMessage{}
+	for _, re := range raw_entities {
+		typI, ok := re.(map[string]interface{})
+		if !ok {
+			return nil, ErrUnexpectedType("not an object")
+		}
+
+		typS, _ := typI["type"].(string)
+		if typS != "Message" && typS != "Indication" {
+			continue
+		}
+
+		b, err := json.Marshal(re)
+		if err != nil {
+			return nil, err
+		}
+
+		qm := &QMIMessage{}
+		err = json.Unmarshal(b, qm)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs[typS+" "+qm.Service+" "+qm.Name] = diffMessage{
+			Kind:   typS,
+			ID:     qm.ID,
+			Since:  qm.Since,
+			Input:  diffTLVs(qm.Input),
+			Output: diffTLVs(qm.Output),
+		}
+	}
+
+	return msgs, nil
+}
+
+func sortedKeys(m interface{}) []string {
+	var keys []string
+	switch v := m.(type) {
+	case map[string]diffMessage:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]diffTLV:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	case map[string]apiSymbol:
+		for k := range v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// diffTLVSet reports changes between two TLV sets of a message and
+// returns true if any of them is breaking.
+func diffTLVSet(w io.Writer, prefix string, o, n map[string]diffTLV) bool {
+	breaking := false
+	for _, k := range sortedKeys(o) {
+		ot := o[k]
+		nt, ok := n[k]
+		if !ok {
+			fmt.Fprintf(w, "! %s TLV %q removed\n", prefix, k)
+			breaking = true
+			continue
+		}
+		if ot.ID != nt.ID {
+			fmt.Fprintf(w, "! %s TLV %q: id %s -> %s\n", prefix, k, ot.ID, nt.ID)
+			breaking = true
+		}
+		if ot.Format != nt.Format {
+			fmt.Fprintf(w, "! %s TLV %q: format %s -> %s\n", prefix, k, ot.Format, nt.Format)
+			breaking = true
+		}
+		if ot.Since != nt.Since {
+			fmt.Fprintf(w, "~ %s TLV %q: since %s -> %s\n", prefix, k, ot.Since, nt.Since)
+		}
+	}
+	for _, k := range sortedKeys(n) {
+		if _, ok := o[k]; !ok {
+			fmt.Fprintf(w, "+ %s TLV %q added\n", prefix, k)
+		}
+	}
+	return breaking
+}
+
+// Diff prints a report of semantic changes between two data files and
+// returns true if any of them is breaking.
+func Diff(w io.Writer, oldFile, newFile string) (bool, error) {
+	o, err := loadMessages(oldFile)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := loadMessages(newFile)
+	if err != nil {
+		return false, err
+	}
+
+	breaking := false
+	for _, k := range sortedKeys(o) {
+		om := o[k]
+		nm, ok := n[k]
+		if !ok {
+			fmt.Fprintf(w, "! %s removed\n", k)
+			breaking = true
+			continue
+		}
+		if om.ID != nm.ID {
+			fmt.Fprintf(w, "! %s: id %s -> %s\n", k, om.ID, nm.ID)
+			breaking = true
+		}
+		if om.Since != nm.Since {
+			fmt.Fprintf(w, "~ %s: since %s -> %s\n", k, om.Since, nm.Since)
+		}
+		if diffTLVSet(w, k+" input", om.Input, nm.Input) {
+			breaking = true
+		}
+		if diffTLVSet(w, k+" output", om.Output, nm.Output) {
+			breaking = true
+		}
+	}
+	for _, k := range sortedKeys(n) {
+		if _, ok := o[k]; !ok {
+			fmt.Fprintf(w, "+ %s added\n", k)
+		}
+	}
+
+	return breaking, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,115 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// writeTemp writes data into a file of a temporary directory of t
+func writeTemp(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := ioutil.WriteFile(path, []byte(data), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const diffBase = `[
+  { "name"    : "Get IDs",
+    "type"    : "Message",
+    "service" : "DMS",
+    "id"      : "0x0025",
+    "since"   : "1.0",
+    "output"  : [ { "common-ref" : "Operation Result" },
+                  { "name"   : "Imei",
+                    "id"     : "0x11",
+                    "type"   : "TLV",
+                    "since"  : "1.0",
+                    "format" : "string" } ] }
+]`
+
+func TestDiff(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		new      string
+		report   string
+		breaking bool
+	}{{
+		"unchanged", diffBase, "", false,
+	}, {
+		"message added", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0025", "since" : "1.0",
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Imei", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "string" } ] },
+  { "name" : "Event Report", "type" : "Indication", "service" : "DMS", "id" : "0x0001", "since" : "1.2" }
+]`,
+		"+ Indication DMS Event Report added\n", false,
+	}, {
+		"message removed", `[]`,
+		"! Message DMS Get IDs removed\n", true,
+	}, {
+		"message id", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0026", "since" : "1.0",
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Imei", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "string" } ] }
+]`,
+		"! Message DMS Get IDs: id 0x0025 -> 0x0026\n", true,
+	}, {
+		"message since", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0025", "since" : "1.2",
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Imei", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "string" } ] }
+]`,
+		"~ Message DMS Get IDs: since 1.0 -> 1.2\n", false,
+	}, {
+		"TLV added", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0025", "since" : "1.0",
+    "input" : [ { "name" : "Mask", "id" : "0x10", "type" : "TLV", "since" : "1.4", "format" : "guint8" } ],
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Imei", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "string" } ] }
+]`,
+		"+ Message DMS Get IDs input TLV \"Mask\" added\n", false,
+	}, {
+		"TLV removed", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0025", "since" : "1.0",
+    "output" : [ { "common-ref" : "Operation Result" } ] }
+]`,
+		"! Message DMS Get IDs output TLV \"Imei\" removed\n", true,
+	}, {
+		"TLV id, format and since", `[
+  { "name" : "Get IDs", "type" : "Message", "service" : "DMS", "id" : "0x0025", "since" : "1.0",
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Imei", "id" : "0x12", "type" : "TLV", "since" : "1.8", "format" : "guint64" } ] }
+]`,
+		"! Message DMS Get IDs output TLV \"Imei\": id 0x11 -> 0x12\n" +
+			"! Message DMS Get IDs output TLV \"Imei\": format string -> guint64\n" +
+			"~ Message DMS Get IDs output TLV \"Imei\": since 1.0 -> 1.8\n",
+		true,
+	}} {
+		var report bytes.Buffer
+		breaking, err := Diff(&report, writeTemp(t, "old.json", diffBase), writeTemp(t, "new.json", test.new))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if report.String() != test.report {
+			t.Errorf("%s: report\n%s\nwant\n%s", test.name, report.String(), test.report)
+		}
+		if breaking != test.breaking {
+			t.Errorf("%s: breaking = %t", test.name, breaking)
+		}
+	}
+}
+
+func TestDiffInvalid(t *testing.T) {
+	_, err := Diff(ioutil.Discard, writeTemp(t, "old.json", diffBase), writeTemp(t, "new.json", `{ "name" : `))
+	if err == nil {
+		t.Error("diff against a malformed file succeeded")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
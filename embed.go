@@ -0,0 +1,66 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+//go:generate go run ./cmd/qmigen embed-runtime
+
+const runtimeTrailer = "// vim: ai:ts=8:sw=8:noet:syntax=go\n"
+
+// EmbedRuntime renders footer.go from the runtime source: its imports become
+// runtimeImports, everything after them COMMON_FOOTER
+func EmbedRuntime(src_file string) ([]byte, error) {
+	src, err := ioutil.ReadFile(src_file)
+	if err != nil {
+		return nil, err
+	}
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, src_file, src, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Decls) == 0 {
+		return nil, fmt.Errorf("%s: no imports", src_file)
+	}
+
+	var imports []string
+	for _, spec := range f.Imports {
+		if spec.Name != nil {
+			return nil, fmt.Errorf("%s: named import %s is unsupported", src_file, spec.Path.Value)
+		}
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		imports = append(imports, path)
+	}
+
+	end := fs.Position(f.Decls[len(f.Decls)-1].(*ast.GenDecl).End()).Offset
+	footer := strings.TrimPrefix(string(src[end:]), "\n")
+	footer = strings.TrimSuffix(footer, runtimeTrailer)
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by qmigen embed-runtime from %s, DO NOT EDIT.\n\n", src_file)
+	fmt.Fprintf(&out, "package qmigen\n\n")
+	fmt.Fprintf(&out, "// runtimeImports are the imports of COMMON_FOOTER\n")
+	fmt.Fprintf(&out, "var runtimeImports = []string{\n")
+	for _, path := range imports {
+		fmt.Fprintf(&out, "%q,\n", path)
+	}
+	fmt.Fprintf(&out, "}\n\n")
+	fmt.Fprintf(&out, "// COMMON_FOOTER is appended to qmi-common.go\n")
+	// a raw string can't hold a backquote, splice those in
+	fmt.Fprintf(&out, "const COMMON_FOOTER = `%s`\n", strings.ReplaceAll(footer, "`", "` + \"`\" + `"))
+	return format.Source(out.Bytes())
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,44 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// TestEmbedRuntime expects the generated qmi-common.go to carry the body
+// of runtime/qmi.go verbatim along with its imports; TestEmbeddedRuntime
+// keeps footer.go up to date
+func TestEmbedRuntime(t *testing.T) {
+	dir := t.TempDir()
+	err := GenerateFiles([]string{"testdata/data/qmi-common.json"}, dir, Options{SkipTypeCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	common, err := ioutil.ReadFile(filepath.Join(dir, "qmi-common.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(common), COMMON_FOOTER+runtimeTrailer) {
+		t.Error("qmi-common.go does not end with the runtime")
+	}
+	for _, path := range runtimeImports {
+		if !bytes.Contains(common, []byte("\t"+strconv.Quote(path)+"\n")) {
+			t.Errorf("qmi-common.go does not import %s", path)
+		}
+	}
+
+	src, err := ioutil.ReadFile("runtime/qmi.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := strings.TrimSuffix(COMMON_FOOTER, "\n")
+	if !strings.Contains(string(src), body) {
+		t.Error("COMMON_FOOTER is not the body of runtime/qmi.go")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,594 @@
+package qmigen
+
+import (
+	"go/ast"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+type QMIEnumValue struct {
+	Name  string
+	Value string
+}
+
+type QMIEnum struct {
+	Name   string
+	Type   string // underlying Go type
+	Values []QMIEnumValue
+	Flags  bool // values are bits of a mask
+}
+
+// registerEnum returns the enum named by the public-format of an integer
+// field, declaring it if the field lists its values
+func (gen *generator) registerEnum(field QMITLVField, typ string) *QMIEnum {
+	if field.PublicFormat == "" || typ == "string" || typ == "bool" {
+		return nil
+	}
+
+	if enum := gen.reg.Enum(field.PublicFormat); enum != nil {
+		return enum
+	}
+	if len(field.Values) == 0 && len(field.Flags) == 0 {
+		return nil
+	}
+
+	enum := &QMIEnum{
+		Name:   field.PublicFormat,
+		Type:   typ,
+		Values: field.Values,
+	}
+	if len(field.Flags) > 0 {
+		enum.Values = field.Flags
+		enum.Flags = true
+	}
+	gen.reg.addEnum(enum)
+	gen.pendingEnums = append(gen.pendingEnums, enum)
+	return enum
+}
+
+// Ident spells libqmi's Qmi prefix the way the generated code does
+func (qe *QMIEnum) Ident(gen *generator) *ast.Ident {
+	n := qe.Name
+	if strings.HasPrefix(n, "Qmi") {
+		n = "QMI" + strings.TrimPrefix(n, "Qmi")
+	}
+	return ast.NewIdent(gen.goName(n))
+}
+
+func (qe *QMIEnum) ValueIdent(gen *generator, v QMIEnumValue) *ast.Ident {
+	return ast.NewIdent(qe.Ident(gen).Name + gen.goName(v.Name))
+}
+
+// GenDecls emits the enum type, its constants, String(), IsValid(), AllX()
+// and ParseX() accepting the String() form case-insensitively. Flags get
+// Has() instead of ParseX().
+func (qe *QMIEnum) GenDecls(gen *generator) []ast.Decl {
+	typ := qe.Ident(gen)
+
+	consts := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+	}
+	var all []ast.Expr
+	var cases []ast.Stmt
+	for _, v := range qe.Values {
+		consts.Specs = append(consts.Specs, &ast.ValueSpec{
+			Names: []*ast.Ident{qe.ValueIdent(gen, v)},
+			Type:  qe.Ident(gen),
+			Values: []ast.Expr{
+				&ast.BasicLit{
+					Kind:  token.INT,
+					Value: v.Value,
+				},
+			},
+		})
+		all = append(all, qe.ValueIdent(gen, v))
+		cases = append(cases, &ast.CaseClause{
+			List: []ast.Expr{qe.ValueIdent(gen, v)},
+			Body: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.BasicLit{
+							Kind:  token.STRING,
+							Value: strconv.Quote(v.Name),
+						},
+					},
+				},
+			},
+		})
+	}
+
+	recv := &ast.FieldList{
+		List: []*ast.Field{
+			&ast.Field{
+				Names: []*ast.Ident{ast.NewIdent("v")},
+				Type:  qe.Ident(gen),
+			},
+		},
+	}
+
+	fun_string := &ast.FuncDecl{
+		Recv: recv,
+		Name: ast.NewIdent("String"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent("string")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.SwitchStmt{
+					Tag:  ast.NewIdent("v"),
+					Body: &ast.BlockStmt{List: cases},
+				},
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent("fmt"),
+								Sel: ast.NewIdent("Sprintf"),
+							},
+							Args: []ast.Expr{
+								&ast.BasicLit{
+									Kind:  token.STRING,
+									Value: strconv.Quote(typ.Name + "(%d)"),
+								},
+								&ast.CallExpr{
+									Fun:  ast.NewIdent(qe.Type),
+									Args: []ast.Expr{ast.NewIdent("v")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	fun_all := &ast.FuncDecl{
+		Name: ast.NewIdent("All" + typ.Name),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: &ast.ArrayType{Elt: qe.Ident(gen)}},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.CompositeLit{
+							Type: &ast.ArrayType{Elt: qe.Ident(gen)},
+							Elts: all,
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// all := AllX()
+	// names := make([]string, len(all))
+	// for i, v := range all { names[i] = v.String() }
+	// i, err := parseEnum("X", s, names)
+	// if err != nil { return 0, err }
+	// return all[i], nil
+	fun_parse := &ast.FuncDecl{
+		Name: ast.NewIdent("Parse" + typ.Name),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{ast.NewIdent("s")},
+						Type:  ast.NewIdent("string"),
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: qe.Ident(gen)},
+					&ast.Field{Type: ast.NewIdent("error")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("all")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{Fun: ast.NewIdent("All" + typ.Name)},
+					},
+				},
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("names")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{
+							Fun: ast.NewIdent("make"),
+							Args: []ast.Expr{
+								&ast.ArrayType{Elt: ast.NewIdent("string")},
+								&ast.CallExpr{
+									Fun:  ast.NewIdent("len"),
+									Args: []ast.Expr{ast.NewIdent("all")},
+								},
+							},
+						},
+					},
+				},
+				&ast.RangeStmt{
+					Key:   ast.NewIdent("i"),
+					Value: ast.NewIdent("v"),
+					Tok:   token.DEFINE,
+					X:     ast.NewIdent("all"),
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{
+									&ast.IndexExpr{
+										X:     ast.NewIdent("names"),
+										Index: ast.NewIdent("i"),
+									},
+								},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{
+									&ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   ast.NewIdent("v"),
+											Sel: ast.NewIdent("String"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent("i"), ast.NewIdent("err")},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{
+							Fun: ast.NewIdent("parseEnum"),
+							Args: []ast.Expr{
+								&ast.BasicLit{
+									Kind:  token.STRING,
+									Value: strconv.Quote(typ.Name),
+								},
+								ast.NewIdent("s"),
+								ast.NewIdent("names"),
+							},
+						},
+					},
+				},
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{
+						X:  ast.NewIdent("err"),
+						Op: token.NEQ,
+						Y:  ast.NewIdent("nil"),
+					},
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ReturnStmt{
+								Results: []ast.Expr{
+									&ast.BasicLit{Kind: token.INT, Value: "0"},
+									ast.NewIdent("err"),
+								},
+							},
+						},
+					},
+				},
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.IndexExpr{
+							X:     ast.NewIdent("all"),
+							Index: ast.NewIdent("i"),
+						},
+						ast.NewIdent("nil"),
+					},
+				},
+			},
+		},
+	}
+
+	decl_type := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: typ,
+				Type: ast.NewIdent(qe.Type),
+			},
+		},
+	}
+
+	if qe.Flags {
+		return []ast.Decl{
+			decl_type,
+			consts,
+			qe.genFlagsString(gen),
+			qe.genHas(gen),
+			qe.genIsValid(gen),
+			fun_all,
+		}
+	}
+
+	return []ast.Decl{
+		decl_type,
+		consts,
+		fun_string,
+		qe.genIsValid(gen),
+		fun_all,
+		fun_parse,
+	}
+}
+
+// genIsValid tells values the data file defines:
+//
+//	func (v X) IsValid() bool { switch v { case A, B: return true }; return false }
+//
+// and for flags that no unknown bit is set:
+//
+//	func (v X) IsValid() bool { return v&^(A|B) == 0 }
+func (qe *QMIEnum) genIsValid(gen *generator) *ast.FuncDecl {
+
+	var known []ast.Expr
+	for _, val := range qe.Values {
+		known = append(known, qe.ValueIdent(gen, val))
+	}
+
+	var body []ast.Stmt
+	if qe.Flags {
+		mask := known[0]
+		for _, k := range known[1:] {
+			mask = &ast.BinaryExpr{X: mask, Op: token.OR, Y: k}
+		}
+		if len(known) > 1 {
+			mask = &ast.ParenExpr{X: mask}
+		}
+		body = []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.BinaryExpr{
+						X: &ast.BinaryExpr{
+							X:  ast.NewIdent("v"),
+							Op: token.AND_NOT,
+							Y:  mask,
+						},
+						Op: token.EQL,
+						Y:  &ast.BasicLit{Kind: token.INT, Value: "0"},
+					},
+				},
+			},
+		}
+	} else {
+		body = []ast.Stmt{
+			&ast.SwitchStmt{
+				Tag: ast.NewIdent("v"),
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.CaseClause{
+							List: known,
+							Body: []ast.Stmt{
+								&ast.ReturnStmt{
+									Results: []ast.Expr{ast.NewIdent("true")},
+								},
+							},
+						},
+					},
+				},
+			},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{ast.NewIdent("false")},
+			},
+		}
+	}
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("v")},
+					Type:  qe.Ident(gen),
+				},
+			},
+		},
+		Name: ast.NewIdent("IsValid"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent("bool")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{List: body},
+	}
+}
+
+// genFlagsString joins the names of set flags with "|", unknown bits are
+// appended in hex:
+//
+//	s := ""
+//	if v&A == A { s += "|A"; v &^= A }
+//	if v != 0 { s += fmt.Sprintf("|%#x", uint64(v)) }
+//	if s == "" { return "0" }
+//	return s[1:]
+func (qe *QMIEnum) genFlagsString(gen *generator) *ast.FuncDecl {
+
+	stmts := []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("s")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{
+				&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("")},
+			},
+		},
+	}
+	for _, val := range qe.Values {
+		stmts = append(stmts, &ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X: &ast.BinaryExpr{
+					X:  ast.NewIdent("v"),
+					Op: token.AND,
+					Y:  qe.ValueIdent(gen, val),
+				},
+				Op: token.EQL,
+				Y:  qe.ValueIdent(gen, val),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("s")},
+						Tok: token.ADD_ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.BasicLit{
+								Kind:  token.STRING,
+								Value: strconv.Quote("|" + val.Name),
+							},
+						},
+					},
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("v")},
+						Tok: token.AND_NOT_ASSIGN,
+						Rhs: []ast.Expr{qe.ValueIdent(gen, val)},
+					},
+				},
+			},
+		})
+	}
+	stmts = append(stmts,
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent("v"),
+				Op: token.NEQ,
+				Y:  &ast.BasicLit{Kind: token.INT, Value: "0"},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("s")},
+						Tok: token.ADD_ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X:   ast.NewIdent("fmt"),
+									Sel: ast.NewIdent("Sprintf"),
+								},
+								Args: []ast.Expr{
+									&ast.BasicLit{
+										Kind:  token.STRING,
+										Value: strconv.Quote("|%#x"),
+									},
+									&ast.CallExpr{
+										Fun:  ast.NewIdent("uint64"),
+										Args: []ast.Expr{ast.NewIdent("v")},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent("s"),
+				Op: token.EQL,
+				Y:  &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("")},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.ReturnStmt{
+						Results: []ast.Expr{
+							&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote("0")},
+						},
+					},
+				},
+			},
+		},
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				&ast.SliceExpr{
+					X:   ast.NewIdent("s"),
+					Low: &ast.BasicLit{Kind: token.INT, Value: "1"},
+				},
+			},
+		},
+	)
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("v")},
+					Type:  qe.Ident(gen),
+				},
+			},
+		},
+		Name: ast.NewIdent("String"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent("string")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{List: stmts},
+	}
+}
+
+// genHas: func (v X) Has(flag X) bool { return v&flag == flag }
+func (qe *QMIEnum) genHas(gen *generator) *ast.FuncDecl {
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("v")},
+					Type:  qe.Ident(gen),
+				},
+			},
+		},
+		Name: ast.NewIdent("Has"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{ast.NewIdent("flag")},
+						Type:  qe.Ident(gen),
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent("bool")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.BinaryExpr{
+							X: &ast.BinaryExpr{
+								X:  ast.NewIdent("v"),
+								Op: token.AND,
+								Y:  ast.NewIdent("flag"),
+							},
+							Op: token.EQL,
+							Y:  ast.NewIdent("flag"),
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
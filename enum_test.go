@@ -0,0 +1,134 @@
+package qmigen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+// enumMessage is a data file with a guint16 TLV of the public-format
+// QmiDmsTestMode, values or flags listing its names
+func enumMessage(values string) string {
+	return `[
+  { "name" : "DMS", "type" : "Service" },
+  { "name" : "Set Test Mode", "type" : "Message", "service" : "DMS", "id" : "0x5000", "since" : "1.0",
+    "input" : [ { "name" : "Mode", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint16",
+                  "public-format" : "QmiDmsTestMode", ` + values + ` } ] }
+]`
+}
+
+// enumDecls generates src and returns its types, constants with their
+// values, methods by receiver and the struct fields by type
+func enumDecls(t *testing.T, src string, o Options) (types map[string]string, consts map[string]string, methods map[string][]string, source string) {
+	t.Helper()
+	o.Common = NewRegistry(nil)
+	out, err := Generate(strings.NewReader(src), o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), "", out, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	types = map[string]string{}
+	consts = map[string]string{}
+	methods = map[string][]string{}
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					if id, ok := spec.Type.(*ast.Ident); ok {
+						types[spec.Name.Name] = id.Name
+					}
+				case *ast.ValueSpec:
+					if decl.Tok == token.CONST && len(spec.Values) == 1 {
+						if lit, ok := spec.Values[0].(*ast.BasicLit); ok {
+							consts[spec.Names[0].Name] = lit.Value
+						}
+					}
+				}
+			}
+		case *ast.FuncDecl:
+			if decl.Recv == nil {
+				continue
+			}
+			recv, ok := decl.Recv.List[0].Type.(*ast.Ident)
+			if ok {
+				methods[recv.Name] = append(methods[recv.Name], decl.Name.Name)
+			}
+		}
+	}
+	return types, consts, methods, string(out)
+}
+
+func hasMethod(methods []string, name string) bool {
+	for _, m := range methods {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestEnumType(t *testing.T) {
+	types, consts, methods, src := enumDecls(t, enumMessage(`"values" : [ { "name" : "Online", "value" : "0" }, { "name" : "Factory Test", "value" : "0x100" } ]`),
+		Options{DirectEncoding: true})
+
+	if typ := types["QMIDmsTestMode"]; typ != "uint16" {
+		t.Errorf("QMIDmsTestMode of %q, want uint16", typ)
+	}
+	for name, value := range map[string]string{
+		"QMIDmsTestModeOnline":      "0",
+		"QMIDmsTestModeFactoryTest": "0x100",
+	} {
+		if consts[name] != value {
+			t.Errorf("%s = %q, want %s", name, consts[name], value)
+		}
+	}
+	for _, m := range []string{"String", "IsValid"} {
+		if !hasMethod(methods["QMIDmsTestMode"], m) {
+			t.Errorf("QMIDmsTestMode lacks %s, has %v", m, methods["QMIDmsTestMode"])
+		}
+	}
+	if !strings.Contains(src, "Mode QMIDmsTestMode\n") {
+		t.Error("the field is not of the enum type")
+	}
+	// the value is encoded in the width of its format
+	if !strings.Contains(src, "PutUint16(scalar[:], uint16(msg.Mode))") {
+		t.Error("the enum is not written as a uint16")
+	}
+	if !strings.Contains(src, "msg.Mode = QMIDmsTestMode(leOrder.Uint16(b.Next(2)))") {
+		t.Error("the enum is not read as a uint16")
+	}
+}
+
+func TestFlagsType(t *testing.T) {
+	types, consts, methods, src := enumDecls(t, enumMessage(`"flags" : [ { "name" : "Loopback", "value" : "0x1" }, { "name" : "Offline", "value" : "0x8000" } ]`),
+		Options{})
+
+	if typ := types["QMIDmsTestMode"]; typ != "uint16" {
+		t.Errorf("QMIDmsTestMode of %q, want uint16", typ)
+	}
+	if consts["QMIDmsTestModeLoopback"] != "0x1" || consts["QMIDmsTestModeOffline"] != "0x8000" {
+		t.Errorf("constants %v", consts)
+	}
+	for _, m := range []string{"String", "Has", "IsValid"} {
+		if !hasMethod(methods["QMIDmsTestMode"], m) {
+			t.Errorf("QMIDmsTestMode lacks %s, has %v", m, methods["QMIDmsTestMode"])
+		}
+	}
+	// bits combine, there are no names to parse
+	if strings.Contains(src, "func ParseQMIDmsTestMode(") {
+		t.Error("ParseQMIDmsTestMode generated for flags")
+	}
+	if !strings.Contains(src, "binary.Write(w, binary.LittleEndian, msg.Mode)") {
+		t.Error("the flags are not written as the field")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
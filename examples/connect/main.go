@@ -0,0 +1,111 @@
+//go:build examples && linux
+// +build examples,linux
+
+// Connect brings up a data connection, prints the IP settings and, with
+// -ifname, configures the wwan interface with them. The connection is
+// stopped on exit.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/examples/internal/modem"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/netsetup"
+)
+
+var (
+	apn    = flag.String("apn", "internet", "access point name")
+	ifname = flag.String("ifname", "", "configure this interface")
+	hold   = flag.Duration("hold", 0, "keep the connection up this long")
+)
+
+// WDS Get Current Settings requested settings: PDP type, DNS, IP address,
+// gateway and MTU
+const requestedSettings = 1<<0 | 1<<4 | 1<<8 | 1<<9 | 1<<13
+
+func ipv4(v uint32) net.IP {
+	if v == 0 {
+		return nil
+	}
+	return net.IPv4(byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func prefixLen(mask uint32) int {
+	ones, _ := net.IPMask(ipv4(mask).To4()).Size()
+	return ones
+}
+
+func main() {
+	flag.Parse()
+
+	// network attach may take much longer than the default timeout
+	start := &qmi.WDSStartNetworkInput{APN: *apn}
+	dev, done, err := modem.Open(qmi.WithMessageTimeout(start.ServiceID(), start.MessageID(), 3*time.Minute))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer done()
+
+	wds, err := dev.WDS()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx := qmi.ContextWithFields(context.Background(), "example", "connect", "apn", *apn)
+
+	resp, err := wds.SendContext(ctx, start)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handle := resp.(*qmi.WDSStartNetworkOutput).PacketDataHandle
+	defer func() {
+		_, err := wds.SendContext(ctx, &qmi.WDSStopNetworkInput{PacketDataHandle: handle})
+		if err != nil {
+			log.Print(err)
+		}
+	}()
+
+	resp, err = wds.SendContext(ctx, &qmi.WDSGetCurrentSettingsInput{RequestedSettings: requestedSettings})
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	settings := resp.(*qmi.WDSGetCurrentSettingsOutput)
+
+	cfg := &netsetup.Config{
+		IPv4Address:   ipv4(settings.IPv4Address),
+		IPv4PrefixLen: prefixLen(settings.IPv4GatewaySubnetMask),
+		IPv4Gateway:   ipv4(settings.IPv4GatewayAddress),
+		MTU:           settings.MTU,
+	}
+	fmt.Printf("address: %s/%d\n", cfg.IPv4Address, cfg.IPv4PrefixLen)
+	fmt.Printf("gateway: %s\n", cfg.IPv4Gateway)
+	fmt.Printf("dns:     %s %s\n", ipv4(settings.PrimaryIPv4DNSAddress), ipv4(settings.SecondaryIPv4DNSAddress))
+	fmt.Printf("mtu:     %d\n", cfg.MTU)
+
+	if *ifname != "" {
+		nl, err := netsetup.Dial()
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer nl.Close()
+
+		setup, err := netsetup.Apply(nl, *ifname, cfg)
+		if err != nil {
+			log.Print(err)
+			return
+		}
+		defer setup.Revert()
+	}
+
+	time.Sleep(*hold)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
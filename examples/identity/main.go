@@ -0,0 +1,59 @@
+//go:build examples
+// +build examples
+
+// Identity prints the manufacturer, model, firmware revision and IDs of
+// the modem, queried concurrently with Gather.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/examples/internal/modem"
+)
+
+func main() {
+	flag.Parse()
+
+	dev, done, err := modem.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	ctx = qmi.ContextWithFields(ctx, "example", "identity")
+
+	resps, err := qmi.Gather(ctx, dev,
+		qmi.GatherItem{Name: "DMSGetManufacturer"},
+		qmi.GatherItem{Name: "DMSGetModel"},
+		qmi.GatherItem{Name: "DMSGetRevision"},
+		qmi.GatherItem{Name: "DMSGetIDs"},
+	)
+	if err != nil {
+		// ErrGather: print what we got
+		log.Print(err)
+	}
+
+	if m, ok := resps[0].(*qmi.DMSGetManufacturerOutput); ok {
+		fmt.Printf("manufacturer: %s\n", m.Manufacturer)
+	}
+	if m, ok := resps[1].(*qmi.DMSGetModelOutput); ok {
+		fmt.Printf("model:        %s\n", m.Model)
+	}
+	if m, ok := resps[2].(*qmi.DMSGetRevisionOutput); ok {
+		fmt.Printf("revision:     %s\n", m.Revision)
+	}
+	if m, ok := resps[3].(*qmi.DMSGetIDsOutput); ok {
+		fmt.Printf("imei:         %s\n", m.IMEI)
+		fmt.Printf("esn:          %s\n", m.Esn)
+		fmt.Printf("meid:         %s\n", m.Meid)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,61 @@
+//go:build examples
+// +build examples
+
+// Package modem opens the device for the example programs: a real modem,
+// or a recorded session replayed by a Cassette.
+package modem
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+)
+
+var (
+	Device   = flag.String("device", "/dev/cdc-wdm0", "QMI device")
+	Cassette = flag.String("cassette", "", "replay a recorded session instead of opening -device")
+	Record   = flag.String("record", "", "record the session with -device into this file")
+)
+
+// Open opens the device selected by the flags. The returned function closes
+// it and saves the recording, if any.
+func Open(opts ...qmi.Option) (*qmi.Device, func(), error) {
+	opts = append(opts, qmi.WithLogger(log.New(os.Stderr, "qmi: ", log.LstdFlags)))
+
+	if *Cassette != "" {
+		c, err := qmi.LoadCassette(*Cassette)
+		if err != nil {
+			return nil, nil, err
+		}
+		dev, err := c.Replay(opts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		return dev, func() { dev.Close() }, nil
+	}
+
+	var recorder *qmi.Cassette
+	if *Record != "" {
+		recorder = &qmi.Cassette{}
+		opts = append(opts, qmi.WithRecorder(recorder))
+	}
+
+	dev, err := qmi.Open(*Device, opts...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return dev, func() {
+		dev.Close()
+		if recorder != nil {
+			err := recorder.Save(*Record)
+			if err != nil {
+				log.Printf("save %s: %s", *Record, err)
+			}
+		}
+	}, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,114 @@
+//go:build examples && qmigo
+// +build examples,qmigo
+
+// Qmigo sends the requests which need no input to the modem and prints the
+// responses:
+//
+//	qmigo -device /dev/cdc-wdm0 dms get-ids
+//
+// Services are linked by the files of this directory, each built unless
+// the qmigo_select tag asks for those of its own tag only:
+//
+//	go build -tags 'examples qmigo qmigo_select qmigo_dms qmigo_wds'
+//
+// It needs the package generated with -explicit-register, which leaves the
+// services unregistered and so up to the linker.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/examples/internal/modem"
+)
+
+var (
+	list    = flag.Bool("list", false, "list the commands of the services linked in")
+	timeout = flag.Duration("timeout", 10*time.Second, "request timeout")
+)
+
+func init() {
+	qmi.RegisterAllCTL()
+	qmi.RegisterCommonTLVs()
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "usage: %s [flags] <service> <command>\n", os.Args[0])
+	flag.PrintDefaults()
+}
+
+// serviceName names a service on the command line, "dms"
+func serviceName(svc qmi.Service) string {
+	return strings.ToLower(strings.TrimPrefix(qmi.ServiceMap[svc], "QMI_SERVICE_"))
+}
+
+func printCommands() {
+	for _, cs := range qmi.CommandSets() {
+		var names []string
+		for name := range cs.Commands() {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("%s %s\n", serviceName(cs.Service()), name)
+		}
+	}
+}
+
+// command finds the request of a command, "dms get-ids"
+func command(service string, name string) (qmi.Message, error) {
+	for _, cs := range qmi.CommandSets() {
+		if serviceName(cs.Service()) != service {
+			continue
+		}
+		newRequest, ok := cs.Commands()[name]
+		if !ok {
+			return nil, fmt.Errorf("%s has no command %q", service, name)
+		}
+		return newRequest(), nil
+	}
+	return nil, fmt.Errorf("service %q is not linked in", service)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	if *list {
+		printCommands()
+		return
+	}
+	if flag.NArg() != 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	req, err := command(flag.Arg(0), flag.Arg(1))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dev, done, err := modem.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	resp, err := dev.SendContext(ctx, req)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	fmt.Println(resp)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,15 @@
+//go:build examples && qmigo && (!qmigo_select || qmigo_nas)
+// +build examples
+// +build qmigo
+// +build !qmigo_select qmigo_nas
+
+package main
+
+import "bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+
+func init() {
+	qmi.RegisterAllNAS()
+	qmi.RegisterNASCommands()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,15 @@
+//go:build examples && qmigo && (!qmigo_select || qmigo_wds)
+// +build examples
+// +build qmigo
+// +build !qmigo_select qmigo_wds
+
+package main
+
+import "bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+
+func init() {
+	qmi.RegisterAllWDS()
+	qmi.RegisterWDSCommands()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,111 @@
+//go:build examples
+// +build examples
+
+// Signal polls the signal strength with NAS Get Signal Info, which is not
+// generated by default and is sent as a message loaded at runtime.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/examples/internal/modem"
+)
+
+var interval = flag.Duration("interval", 5*time.Second, "polling interval")
+
+// A subset of NAS Get Signal Info from qmi-service-nas.json
+const definitions = `[{
+	"name": "Get Signal Info", "type": "Message", "service": "NAS", "id": "0x004F",
+	"input": [],
+	"output": [
+		{ "common-ref": "Operation Result" },
+		{ "name": "GSM Signal Strength", "id": "0x12", "type": "TLV", "format": "gint8" },
+		{ "name": "WCDMA Signal Strength", "id": "0x13", "type": "TLV", "format": "sequence",
+		  "contents": [ { "name": "RSSI", "format": "gint8" },
+		                { "name": "ECIO", "format": "gint16" } ] },
+		{ "name": "LTE Signal Strength", "id": "0x14", "type": "TLV", "format": "sequence",
+		  "contents": [ { "name": "RSSI", "format": "gint8" },
+		                { "name": "RSRQ", "format": "gint8" },
+		                { "name": "RSRP", "format": "gint16" },
+		                { "name": "SNR", "format": "gint16" } ] }
+	]
+}]`
+
+func poll(ctx context.Context, dev *qmi.Device) error {
+	req, err := dev.NewDynamic("NAS Get Signal Info")
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *interval)
+	defer cancel()
+	resp, err := dev.SendContext(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	fields := resp.(*qmi.DynamicMessage).Fields
+	var line []string
+	for _, tlv := range []string{"GSM Signal Strength", "WCDMA Signal Strength", "LTE Signal Strength"} {
+		if v, ok := fields[tlv]; ok {
+			line = append(line, fmt.Sprintf("%s: %v", tlv, v))
+		}
+	}
+	if len(line) == 0 {
+		line = append(line, "no signal")
+	}
+
+	stats := dev.Stats()
+	if stats.Responses > 0 {
+		line = append(line, fmt.Sprintf("avg latency: %s modem, %s dispatch",
+			stats.ModemLatency/time.Duration(stats.Responses),
+			stats.DispatchLatency/time.Duration(stats.Responses)))
+	}
+
+	fmt.Println(strings.Join(line, "; "))
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	dev, done, err := modem.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer done()
+
+	err = dev.LoadDefinitions(strings.NewReader(definitions))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt)
+
+	ctx := qmi.ContextWithFields(context.Background(), "example", "signal")
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	for {
+		err := poll(ctx, dev)
+		if err != nil {
+			log.Print(err)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,169 @@
+//go:build examples
+// +build examples
+
+// SMS sends a text message with WMS Raw Send, loaded at runtime like in the
+// signal example. The text is limited to a single SMS in the GSM 7-bit
+// default alphabet.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/examples/internal/modem"
+)
+
+var (
+	number = flag.String("number", "", "destination number, international with a leading +")
+	text   = flag.String("text", "", "message text")
+)
+
+// A subset of WMS Raw Send from qmi-service-wms.json
+const definitions = `[{
+	"name": "Raw Send", "type": "Message", "service": "WMS", "id": "0x0020",
+	"input": [
+		{ "name": "Raw Message Data", "id": "0x01", "type": "TLV", "format": "sequence",
+		  "contents": [ { "name": "Format", "format": "guint8" },
+		                { "name": "Raw Data", "format": "array", "size-prefix-format": "guint16",
+		                  "array-element": { "format": "guint8" } } ] }
+	],
+	"output": [
+		{ "common-ref": "Operation Result" },
+		{ "name": "Message ID", "id": "0x01", "type": "TLV", "format": "guint16" }
+	]
+}]`
+
+// 3GPP TS 23.038 default alphabet, by septet value
+const gsm7 = "@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà"
+
+const formatGWPP = 0x06
+
+func septets(s string) ([]byte, error) {
+	alphabet := []rune(gsm7)
+	var out []byte
+	for _, r := range s {
+		i := -1
+		for j, a := range alphabet {
+			// 0x1b is the escape to the extension table
+			if a == r && j != 0x1b {
+				i = j
+				break
+			}
+		}
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not in the GSM default alphabet", r)
+		}
+		out = append(out, byte(i))
+	}
+	return out, nil
+}
+
+// pack7 packs septets into octets, least significant bits first
+func pack7(septets []byte) []byte {
+	out := make([]byte, (len(septets)*7+7)/8)
+	for i, s := range septets {
+		bit := i * 7
+		out[bit/8] |= s << (bit % 8)
+		if bit%8 > 1 {
+			out[bit/8+1] |= s >> (8 - bit%8)
+		}
+	}
+	return out
+}
+
+// submitPDU encodes an SMS-SUBMIT with the default SMSC and no validity
+// period
+func submitPDU(number, text string) ([]byte, error) {
+	toa := byte(0x81)
+	if strings.HasPrefix(number, "+") {
+		toa = 0x91
+		number = number[1:]
+	}
+	if number == "" {
+		return nil, errors.New("empty number")
+	}
+
+	addr := make([]byte, (len(number)+1)/2)
+	for i, d := range number {
+		if d < '0' || d > '9' {
+			return nil, fmt.Errorf("bad digit %q in the number", d)
+		}
+		addr[i/2] |= byte(d-'0') << (4 * (i % 2))
+	}
+	if len(number)%2 == 1 {
+		addr[len(addr)-1] |= 0xf0
+	}
+
+	ud, err := septets(text)
+	if err != nil {
+		return nil, err
+	}
+	if len(ud) > 160 {
+		return nil, fmt.Errorf("text of %d characters does not fit a single SMS", len(ud))
+	}
+
+	pdu := []byte{
+		0x00, // SMSC from the SIM
+		0x01, // SMS-SUBMIT
+		0x00, // message reference set by the modem
+		byte(len(number)), toa,
+	}
+	pdu = append(pdu, addr...)
+	pdu = append(pdu,
+		0x00, // protocol identifier
+		0x00, // GSM 7-bit
+		byte(len(ud)))
+	return append(pdu, pack7(ud)...), nil
+}
+
+func main() {
+	flag.Parse()
+
+	pdu, err := submitPDU(*number, *text)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dev, done, err := modem.Open()
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer done()
+
+	err = dev.LoadDefinitions(strings.NewReader(definitions))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req, err := dev.NewDynamic("WMS Raw Send")
+	if err != nil {
+		log.Fatal(err)
+	}
+	data := make([]interface{}, len(pdu))
+	for i, b := range pdu {
+		data[i] = b
+	}
+	req.Fields["Raw Message Data"] = map[string]interface{}{
+		"Format":   uint8(formatGWPP),
+		"Raw Data": data,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	ctx = qmi.ContextWithFields(ctx, "example", "sms", "number", *number)
+
+	resp, err := dev.SendContext(ctx, req)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("sent, message reference %v\n", resp.(*qmi.DynamicMessage).Fields["Message ID"])
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
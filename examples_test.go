@@ -0,0 +1,41 @@
+package qmigen
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// TestExamples builds the example programs against the package generated
+// from testdata/data, and runs identity on its recorded session
+func TestExamples(t *testing.T) {
+	dir := generateFixture(t, Options{})
+	goTool(t, dir, nil, "vet", "-tags", "examples", generatorModule+"/examples/...")
+
+	cassette, err := filepath.Abs("examples/testdata/identity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command("go", "run", "-tags", "examples", generatorModule+"/examples/identity", "-cassette", cassette)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("identity: %s\n%s%s", err, out, stderr.Bytes())
+	}
+	want := "manufacturer: ACME\n" +
+		"model:        LM940\n" +
+		"revision:     24.01.516\n" +
+		"imei:         350000000000001\n" +
+		"esn:          80000001\n" +
+		"meid:         A0000000000001\n"
+	if string(out) != want {
+		t.Errorf("identity printed\n%s\nwant\n%s", out, want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -1,18 +1,20 @@
-package main
+package qmigen
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
 
 	"go/ast"
-	"go/format"
 	"go/token"
+	"hash/fnv"
 
 	"github.com/hjson/hjson-go"
 	"github.com/pascaldekloe/name"
@@ -24,19 +26,34 @@ type QMIService struct {
 }
 
 type QMIClient struct {
-	Name  string
+	Name  string // "QMI Client WDS"
 	Type  string
 	Since string
 }
 
 type QMIMessageIDEnum struct {
-	Name string
-	Type string
+	Name   string
+	Type   string
+	Values []QMIEnumValue // not used, messages carry their own IDs
+}
+
+// QMIErrors describes the result codes a service gives a meaning of its
+// own, or adds to those of QMIErrorDescription:
+//
+//	{"name": "QMI WDS Errors", "type": "Errors", "service": "WDS",
+//	 "errors": [{"name": "Call throttled", "value": "0x1001"}]}
+type QMIErrors struct {
+	Name    string
+	Type    string
+	Service string
+	Errors  []QMIEnumValue
 }
 
 type QMIIndicationIDEnum struct {
-	Name string
+	Name string // "QMI Indication WDS"
 	Type string
+
+	service string
 }
 
 type QMIMessage struct {
@@ -47,11 +64,70 @@ type QMIMessage struct {
 	Since   string
 	Input   []QMITLV
 	Output  []QMITLV
+
+	// Input TLVs are written in ascending tag order, which strict
+	// firmwares require, unless the message asks for declaration order
+	DeclarationOrder bool `json:"declaration-order"`
+
+	// Vendor messages may reuse IDs of standard ones, they are registered
+	// apart and preferred on devices opened WithVendor
+	Vendor string
+
+	// Some acknowledgments are never answered, Send returns once they
+	// are written
+	NoResponse bool `json:"no-response"`
+
+	// Requests configuring modem side reporting, which the modem forgets
+	// with the transport, get a client method of this name sending them
+	// again after Reconnect: "rearm" : "Configure Event Report"
+	Rearm string
+
+	id uint16 // ID parsed by parseID
+}
+
+// IsInternal tells libqmi's own messages, like CTL Internal Proxy Open,
+// which are understood by qmi-proxy rather than by modems
+func (qm *QMIMessage) IsInternal() bool {
+	return qm.id >= 0xFF00
 }
 
 type QMIIndication struct {
-	Name string
-	Type string
+	Name    string
+	Type    string
+	Service string
+	ID      string `json:"id"`
+	Since   string
+	Output  []QMITLV
+
+	id uint16
+}
+
+// parseMessageID accepts ids of messages and indications in any base
+// strconv understands, "0x0025" in libqmi data
+func parseMessageID(name, id string) (uint16, error) {
+	v, err := strconv.ParseUint(strings.TrimSpace(id), 0, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%s: id %q is not a 16-bit number", name, id)
+	}
+	return uint16(v), nil
+}
+
+func (qm *QMIMessage) parseID() (err error) {
+	qm.id, err = parseMessageID(qm.Name, qm.ID)
+	return
+}
+
+func (qi *QMIIndication) parseID() (err error) {
+	qi.id, err = parseMessageID(qi.Name, qi.ID)
+	return
+}
+
+// idLit renders a message or indication id canonically
+func idLit(id uint16) *ast.BasicLit {
+	return &ast.BasicLit{
+		Kind:  token.INT,
+		Value: fmt.Sprintf("0x%04X", id),
+	}
 }
 
 type QMITLVField struct {
@@ -62,6 +138,26 @@ type QMITLVField struct {
 	IntSize      int           `json:"guint-size,string"` // type=guint-sized
 	PublicFormat string        `json:"public-format"`
 	CommonRef    string        `json:"common-ref"`
+
+	SizePrefixFormat string `json:"size-prefix-format"` // type=array
+	StringEncoding   string `json:"string-encoding"`    // type=string: none, prefixed or nul-terminated
+	FixedSize        int    `json:"fixed-size,string"`  // type=array
+	Endian           string // "little" (default) or "big"
+
+	Values []QMIEnumValue // public-format enum
+	Flags  []QMIEnumValue // public-format bitmask, values are masks
+
+	PersonalInfo string `json:"personal-info"` // "yes" masks the field in String()
+
+	// integer fields counting steps of Scale (default 1) Unit get a type
+	// with Float() and String() in units
+	Scale  string
+	Unit   string
+	scaled *QMIScale
+
+	// fields of a struct or sequence present only when the earlier sibling
+	// compares to the value; on TLVs they are ignored
+	Prerequisites []QMIPrerequisite
 }
 
 type QMITLV struct {
@@ -69,6 +165,35 @@ type QMITLV struct {
 	ID    string `json:"id"`
 	Since string
 	QMITLVField
+
+	// Repeatable output TLVs may legally occur several times in a frame
+	// and decode into a slice of all instances
+	Repeatable bool
+
+	// with -optional-pointers message TLVs from 0x10 are pointers, nil
+	// when absent, and the rest must be present in a response
+	optional  bool
+	mandatory bool
+
+	// without -optional-pointers, decoding an optional output TLV records
+	// it in the present field, for String() and -presence-accessors
+	recorded bool
+
+	common bool // declared by a common-ref entity
+}
+
+// ValueField describes the Go value of the TLV, a slice of instances for
+// repeatable ones
+func (qt *QMITLV) ValueField() QMITLVField {
+	if !qt.Repeatable {
+		return qt.QMITLVField
+	}
+	elem := qt.QMITLVField
+	return QMITLVField{
+		Name:         qt.Name + " Instance",
+		Format:       "array",
+		ArrayElement: &elem,
+	}
 }
 
 type QMIPrerequisite struct {
@@ -76,63 +201,94 @@ type QMIPrerequisite struct {
 	Field     string
 	Operation string
 	Value     string
+	CommonRef string `json:"common-ref"`
 }
 
-var CommonIdents = map[string]*ast.Ident{}
+// CommonIdents are the identifiers of the runtime and the standard library
+// the generated code refers to
+var CommonIdents = map[string]bool{}
+
+// commonIdent returns a new node of one of CommonIdents for every use, the
+// generated tree must not share nodes
+func commonIdent(name string) *ast.Ident {
+	if !CommonIdents[name] {
+		panic("not a common identifier: " + name)
+	}
+	return ast.NewIdent(name)
+}
 
 func init() {
 	for _, ident := range []string{
 		"_", "nil",
 		"panic",
-		"int", "byte", "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "string",
+		"int", "byte", "uint8", "uint16", "uint32", "uint64", "int8", "int16", "int32", "int64", "string", "bool",
 		"qmi",
-		"make", "String",
-		"dev", "Device", "Send",
+		"make", "len", "copy", "String",
+		"dev", "Device", "Send", "client", "Client", "GetService",
+		"ctx", "context", "Context", "sendRearmed",
 		"m", "msg", "Message",
 		"service", "Service", "ServiceID", "MessageID",
-		"registerMessage", "Message",
-		"findTag",
+		"registerMessage", "registerRequest", "declareMessage", "declareVendorMessage", "MessageVendor", "NoResponse",
+		"registerIndication", "IndicationID",
+		"registerMessageInfo", "MessageInfo", "TLVInfo", "Vendor", "Direction", "Name", "Title", "TLVs",
+		"DirectionRequest", "DirectionResponse", "DirectionIndication",
+		"findTag", "findTags", "decodeTLV", "RepeatableTLVs",
 		"msg", "input", "output",
 		"err", "error",
 		"w", "io", "write", "Write", "Writer", "TLVWriteTo", "WriteTo",
-		"r", "Read", "Reader", "ReadFrom", "Uint16",
-		"b", "buf", "bytes", "Buffer", "Len",
+		"r", "Read", "Reader", "ReadFrom", "ReadFull", "Uint16",
+		"b", "buf", "bytes", "Buffer", "Bytes", "Len", "Next", "TrimRight", "TrimSuffix", "ReadString",
 		"TLVsWriteTo", "TLVsReadFrom",
-		"tlv", "binary", "LittleEndian",
+		"tlv", "binary", "LittleEndian", "BigEndian",
 		"fmt", "Errorf",
-		"OperationResult",
+		"OperationResult", "QMIStructOperationResult",
+		"Uint32", "Uint64", "PutUint16", "PutUint32", "PutUint64", "ErrUnexpectedEOF", "scalar",
+		"Size", "CommonTLV", "registerCommonTLV", "defineCommonRef", "useCommonRef",
+		"RawTLVs", "indexTLVs", "checkTLVs", "readString", "readFixedString",
+		"decodeGSM7", "encodeGSM7", "replaceInvalid", "escapeInvalid", "rejectInvalid",
+		"append", "false", "true", "Sprintf", "redactString", "formatMessage",
+		"present", "tlvSet", "add", "has", "ok", "recordedTLVs",
+		"messageName", "declareMessageNames", "id",
+		"declareServiceErrors", "QMIError",
+		"float64", "Float", "formatUnits",
+		"TokenFlow", "RegisterTokenFlow", "addTokenFlows", "tokenOf",
+		"RequestToken", "ResponseToken", "IndicationToken", "interface",
+		"RegisterCommands", "leOrder",
 	} {
-		CommonIdents[ident] = ast.NewIdent(ident)
+		CommonIdents[ident] = true
 	}
 }
 
-var CommonRefs = map[string]map[string]interface{}{}
+// CommonSize is the encoded size of scalar types
 var CommonSize = map[string]int{
-	"nil":    0,
-	"int":    8,
-	"byte":   1,
-	"uint8":  1,
-	"int8":   1,
-	"uint16": 2,
-	"int16":  2,
-	"uint32": 4,
-	"int32":  4,
-	"uint64": 8,
-	"int64":  8,
-	"string": -1,
+	"nil":     0,
+	"int":     8,
+	"byte":    1,
+	"uint8":   1,
+	"int8":    1,
+	"uint16":  2,
+	"int16":   2,
+	"uint32":  4,
+	"int32":   4,
+	"uint64":  8,
+	"int64":   8,
+	"float32": 4,
+	"float64": 8,
+	"bool":    1,
+	"string":  -1,
 }
 
 type QMIEntity interface {
-	Register(*ast.File) error
+	Register(*generator, *ast.File) error
 }
 
-func (qs *QMIService) Register(f *ast.File) error {
+func (qs *QMIService) Register(gen *generator, f *ast.File) error {
 	typ := &ast.GenDecl{
 		Tok:    token.TYPE,
 		TokPos: f.Pos() - 1,
 		Specs: []ast.Spec{
 			&ast.TypeSpec{
-				Name: ast.NewIdent("QMIService" + name.CamelCase(qs.Name, true)),
+				Name: ast.NewIdent("QMIService" + gen.goName(qs.Name)),
 				Type: &ast.StructType{
 					Fields: &ast.FieldList{
 						List: []*ast.Field{},
@@ -145,18 +301,18 @@ func (qs *QMIService) Register(f *ast.File) error {
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["service"]},
-					Type:  &ast.StarExpr{X: typ.Specs[0].(*ast.TypeSpec).Name},
+					Names: []*ast.Ident{commonIdent("service")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(typ.Specs[0].(*ast.TypeSpec).Name.Name)},
 				},
 			},
 		},
-		Name: CommonIdents["ServiceID"],
+		Name: commonIdent("ServiceID"),
 		Type: &ast.FuncType{
 			Params: &ast.FieldList{},
 			Results: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Type: CommonIdents["Service"],
+						Type: commonIdent("Service"),
 					},
 				},
 			},
@@ -172,44 +328,246 @@ func (qs *QMIService) Register(f *ast.File) error {
 		},
 	}
 	f.Decls = append(f.Decls, typ, fun)
+	gen.addAlias(f, "QMIService", qs.Name, "")
+
+	return nil
+}
+
+// Register generates a typed client for the service and the Device method
+// allocating it. Messages of the service registered afterwards get their
+// convenience method on the client too.
+//
+//	type WDSClient struct{ *Client }
+//	func (dev *Device) WDS() (*WDSClient, error)
+func (qc *QMIClient) Register(gen *generator, f *ast.File) error {
+	service := strings.TrimPrefix(qc.Name, "QMI Client ")
+	if service == qc.Name || service == "" {
+		return fmt.Errorf("%q does not name a service", qc.Name)
+	}
+	gen.clientServices[service] = true
+
+	typ := service + "Client"
+
+	since := ""
+	if qc.Since != "" {
+		since = fmt.Sprintf(", since libqmi %s", qc.Since)
+	}
+	gen.docComments["type "+typ+" "] = fmt.Sprintf("%s is a client of the %s service%s", typ, service, since)
+	gen.docComments["func (dev *Device) "+service+"() "] = fmt.Sprintf("%s returns the %s client, allocating it on first use", service, service)
+
+	f.Decls = append(
+		f.Decls,
+		&ast.GenDecl{
+			Tok: token.TYPE,
+			Specs: []ast.Spec{
+				&ast.TypeSpec{
+					Name: ast.NewIdent(typ),
+					Type: &ast.StructType{
+						Fields: &ast.FieldList{
+							List: []*ast.Field{
+								&ast.Field{
+									Type: &ast.StarExpr{X: commonIdent("Client")},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+		&ast.FuncDecl{
+			Recv: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("dev")},
+						Type:  &ast.StarExpr{X: commonIdent("Device")},
+					},
+				},
+			},
+			Name: ast.NewIdent(service),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{
+						&ast.Field{Type: &ast.StarExpr{X: ast.NewIdent(typ)}},
+						&ast.Field{Type: commonIdent("error")},
+					},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					// client, err := dev.GetService(QMI_SERVICE_WDS)
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{commonIdent("client"), commonIdent("err")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{
+							&ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X:   commonIdent("dev"),
+									Sel: commonIdent("GetService"),
+								},
+								Args: []ast.Expr{
+									ast.NewIdent("QMI_SERVICE_" + service),
+								},
+							},
+						},
+					},
+					&ast.IfStmt{
+						Cond: &ast.BinaryExpr{
+							X:  commonIdent("err"),
+							Op: token.NEQ,
+							Y:  commonIdent("nil"),
+						},
+						Body: &ast.BlockStmt{
+							List: []ast.Stmt{
+								&ast.ReturnStmt{
+									Results: []ast.Expr{commonIdent("nil"), commonIdent("err")},
+								},
+							},
+						},
+					},
+					&ast.ReturnStmt{
+						Results: []ast.Expr{
+							&ast.UnaryExpr{
+								Op: token.AND,
+								X: &ast.CompositeLit{
+									Type: ast.NewIdent(typ),
+									Elts: []ast.Expr{commonIdent("client")},
+								},
+							},
+							commonIdent("nil"),
+						},
+					},
+				},
+			},
+		},
+	)
 
 	return nil
 }
 
-func (qc *QMIClient) Register(f *ast.File) error {
+// Register declares <SVC>ErrorDescription, handed to the runtime from init()
+//
+//	var WDSErrorDescription = map[QMIError]string{0x1001: "Call throttled"}
+func (qe *QMIErrors) Register(gen *generator, f *ast.File) error {
+	if qe.Service == "" {
+		return fmt.Errorf("%q names no service", qe.Name)
+	}
+
+	var descs []ast.Expr
+	for _, e := range qe.Errors {
+		code, err := strconv.ParseUint(strings.TrimSpace(e.Value), 0, 16)
+		if err != nil {
+			return fmt.Errorf("error %q: value %q is not a uint16", e.Name, e.Value)
+		}
+		descs = append(descs, &ast.KeyValueExpr{
+			Key:   idLit(uint16(code)),
+			Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(e.Name)},
+		})
+	}
+
+	name := qe.Service + "ErrorDescription"
+	gen.docComments["var "+name+" "] = fmt.Sprintf("%s describes the result codes %s gives a meaning of its own", name, qe.Service)
+	f.Decls = append(f.Decls, &ast.GenDecl{
+		Tok: token.VAR,
+		Specs: []ast.Spec{
+			&ast.ValueSpec{
+				Names: []*ast.Ident{ast.NewIdent(name)},
+				Values: []ast.Expr{
+					&ast.CompositeLit{
+						Type: &ast.MapType{
+							Key:   commonIdent("QMIError"),
+							Value: commonIdent("string"),
+						},
+						Elts: descs,
+					},
+				},
+			},
+		},
+	})
 	return nil
 }
 
-func (qmie *QMIMessageIDEnum) Register(f *ast.File) error {
+func (qmie *QMIMessageIDEnum) Register(gen *generator, f *ast.File) error {
 	return nil
 }
 
-func (qiie *QMIIndicationIDEnum) Register(f *ast.File) error {
+// Register picks the service of the enum. Its constants cover every
+// indication of the file, so convert emits them through GenDecls once all
+// entities are registered.
+func (qiie *QMIIndicationIDEnum) Register(gen *generator, f *ast.File) error {
+	qiie.service = strings.TrimPrefix(qiie.Name, "QMI Indication ")
+	if qiie.service == qiie.Name || qiie.service == "" {
+		return fmt.Errorf("%q does not name a service", qiie.Name)
+	}
 	return nil
 }
 
-func (qm *QMIMessage) Register(f *ast.File) error {
-	inputs := &ast.GenDecl{
-		Tok:    token.TYPE,
-		TokPos: f.Pos() - 1,
-		Specs: []ast.Spec{
-			&ast.TypeSpec{
-				Name: ast.NewIdent(qm.Service + name.CamelCase(qm.Name, true) + "Input"),
-				Type: &ast.StructType{
-					Fields: &ast.FieldList{
-						List: []*ast.Field{},
+// GenDecls emits QMI_INDICATION_<SVC>_<NAME> constants of the service's
+// indications in ID order, and <SVC>IndicationMap naming them by ID
+func (qiie *QMIIndicationIDEnum) GenDecls(gen *generator, indications []*QMIIndication) ([]ast.Decl, error) {
+	var entries []*QMIIndication
+	for _, qi := range indications {
+		if qi.Service == qiie.service {
+			entries = append(entries, qi)
+		}
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].id < entries[j].id
+	})
+
+	consts := &ast.GenDecl{
+		Tok:    token.CONST,
+		Lparen: 1,
+	}
+	var names []ast.Expr
+	for _, qi := range entries {
+		key := "QMI_INDICATION_" + constName(qiie.service) + "_" + constName(qi.Name)
+		consts.Specs = append(consts.Specs, &ast.ValueSpec{
+			Names:  []*ast.Ident{ast.NewIdent(key)},
+			Type:   commonIdent("uint16"),
+			Values: []ast.Expr{idLit(qi.id)},
+		})
+		names = append(names, &ast.KeyValueExpr{
+			Key: ast.NewIdent(key),
+			Value: &ast.BasicLit{
+				Kind:  token.STRING,
+				Value: strconv.Quote(qi.Name),
+			},
+		})
+	}
+
+	return []ast.Decl{
+		consts,
+		&ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names: []*ast.Ident{ast.NewIdent(qiie.service + "IndicationMap")},
+					Values: []ast.Expr{
+						&ast.CompositeLit{
+							Type: &ast.MapType{
+								Key:   commonIdent("uint16"),
+								Value: commonIdent("string"),
+							},
+							Elts: names,
+						},
 					},
 				},
 			},
 		},
-	}
+	}, nil
+}
 
-	outputs := &ast.GenDecl{
+func (qm *QMIMessage) Register(gen *generator, f *ast.File) error {
+	inputs := &ast.GenDecl{
 		Tok:    token.TYPE,
 		TokPos: f.Pos() - 1,
 		Specs: []ast.Spec{
 			&ast.TypeSpec{
-				Name: ast.NewIdent(qm.Service + name.CamelCase(qm.Name, true) + "Output"),
+				Name: ast.NewIdent(qm.Service + gen.goName(qm.Name) + "Input"),
 				Type: &ast.StructType{
 					Fields: &ast.FieldList{
 						List: []*ast.Field{},
@@ -219,358 +577,314 @@ func (qm *QMIMessage) Register(f *ast.File) error {
 		},
 	}
 
-	n := 0
+	err := gen.prepareTLVs(qm.Input, false)
+	if err == nil {
+		err = gen.prepareTLVs(qm.Output, true)
+	}
+	if err == nil {
+		err = gen.prepareScales(qm.Service+gen.goName(qm.Name)+"Input", qm.Input)
+	}
+	if err == nil {
+		err = gen.prepareScales(qm.Service+gen.goName(qm.Name)+"Output", qm.Output)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", qm.Name, err)
+	}
 
 	input_sizes := make([]int, len(qm.Input))
+	var mandatory []*ast.Field
 	for i, input := range qm.Input {
-		typ, n1, err := parseType(input.QMITLVField)
+		if input.Repeatable {
+			return fmt.Errorf("%s: repeatable input TLV %q is not supported", qm.Name, input.Name)
+		}
+		typ, n1, err := gen.parseType(input.QMITLVField)
 		if err != nil {
 			return err
 		}
+		if input.optional {
+			typ = &ast.StarExpr{X: typ}
+		}
 		input_sizes[i] = n1
 		field := &ast.Field{
 			Type: typ,
 		}
 		if input.Name != "" {
-			field.Names = []*ast.Ident{ast.NewIdent(name.CamelCase(input.Name, true))}
+			field.Names = []*ast.Ident{ast.NewIdent(gen.goName(input.Name))}
+		}
+		if input.Tag() < 0x10 {
+			mandatory = append(mandatory, field)
 		}
 		inputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
 			inputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
 			field,
 		)
-		if n != -1 {
-			if n1 >= 0 {
-				n += n1 + 2 + 1
-			} else {
-				n = -1
-			}
-		}
 	}
 
-	has_op_result := false
-	output_sizes := make([]int, len(qm.Output))
-	for i, output := range qm.Output {
-		if output.CommonRef == "Operation Result" {
-			has_op_result = true
-		}
-		typ, n1, err := parseType(output.QMITLVField)
-		if err != nil {
-			return err
-		}
-		output_sizes[i] = n1
-		if output.Name != "" {
-			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
-				outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
-				&ast.Field{
-					Names: []*ast.Ident{ast.NewIdent(name.CamelCase(output.Name, true))},
-					Type:  typ,
-				},
-			)
-		} else {
-			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
-				outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
-				&ast.Field{
-					Type: typ,
-				},
-			)
-		}
+	out, err := gen.genOutputType(qm.Service+gen.goName(qm.Name)+"Output", qm.Output, qm.DeclarationOrder)
+	if err != nil {
+		return fmt.Errorf("%s: %w", qm.Name, err)
 	}
+	outputs := out.Type
+	outputs.TokPos = f.Pos() - 1
 
-	fun_id := &ast.FuncDecl{
+	input_name := inputs.Specs[0].(*ast.TypeSpec).Name.Name
+	output_name := outputs.Specs[0].(*ast.TypeSpec).Name.Name
+	fun_id := genConstMethod(input_name, "MessageID", "uint16", idLit(qm.id))
+	fun_service_id := genConstMethod(input_name, "ServiceID", "Service", ast.NewIdent("QMI_SERVICE_"+qm.Service))
+	fun_id_output := genConstMethod(output_name, "MessageID", "uint16", idLit(qm.id))
+	fun_service_id_output := genConstMethod(output_name, "ServiceID", "Service", ast.NewIdent("QMI_SERVICE_"+qm.Service))
+
+	fun := &ast.FuncDecl{
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  inputs.Specs[0].(*ast.TypeSpec).Name,
-				},
-			},
-		},
-		Name: CommonIdents["MessageID"],
-		Type: &ast.FuncType{
-			Params: &ast.FieldList{},
-			Results: &ast.FieldList{
-				List: []*ast.Field{
-					&ast.Field{
-						Type: CommonIdents["uint16"],
-					},
-				},
-			},
-		},
-		Body: &ast.BlockStmt{
-			List: []ast.Stmt{
-				&ast.ReturnStmt{
-					Results: []ast.Expr{
-						&ast.BasicLit{
-							Kind:  token.INT,
-							Value: qm.ID,
-						},
-					},
+					Names: []*ast.Ident{commonIdent("dev")},
+					Type:  &ast.StarExpr{X: commonIdent("Device")},
 				},
 			},
 		},
+		Name: ast.NewIdent(qm.Service + gen.goName(qm.Name)),
+		Type: genSendType(input_name, output_name),
+		Body: genSendBody(commonIdent("dev"), ast.NewIdent(output_name)),
 	}
 
-	fun_service_id := &ast.FuncDecl{
-		Recv: &ast.FieldList{
-			List: []*ast.Field{
-				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  inputs.Specs[0].(*ast.TypeSpec).Name,
-				},
-			},
-		},
-		Name: CommonIdents["ServiceID"],
-		Type: &ast.FuncType{
-			Params: &ast.FieldList{},
-			Results: &ast.FieldList{
+	fun_tlvs_writeTo, err := gen.genTLVsWriteTo(ast.NewIdent(input_name), qm.Input, input_sizes, qm.DeclarationOrder)
+	if err != nil {
+		return err
+	}
+
+	fun_tlvs_readFrom, err := gen.genTLVsReadFrom(ast.NewIdent(input_name), qm.Input, input_sizes, false)
+	if err != nil {
+		return err
+	}
+
+	fun_string, err := gen.genString(ast.NewIdent(input_name), qm.Input)
+	if err != nil {
+		return err
+	}
+
+	f.Decls = append(
+		f.Decls,
+		inputs, gen.genNewInput(input_name, mandatory), outputs,
+		fun,
+		fun_service_id, fun_id,
+		fun_service_id_output, fun_id_output,
+		fun_tlvs_readFrom, out.ReadFrom,
+		fun_tlvs_writeTo, out.WriteTo,
+		fun_string,
+	)
+
+	gen.addLegacyMethod(f, "dev", "Device", qm.Service, qm.Name, input_name, output_name)
+	f.Decls = append(f.Decls, gen.genValueSend(input_name))
+
+	if gen.clientServices[qm.Service] {
+		// func (client *WDSClient) StartNetwork(input WDSStartNetworkInput) (m *WDSStartNetworkOutput, err error)
+		f.Decls = append(f.Decls, &ast.FuncDecl{
+			Recv: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Type: CommonIdents["Service"],
-					},
-				},
-			},
-		},
-		Body: &ast.BlockStmt{
-			List: []ast.Stmt{
-				&ast.ReturnStmt{
-					Results: []ast.Expr{
-						ast.NewIdent("QMI_SERVICE_" + qm.Service),
+						Names: []*ast.Ident{commonIdent("client")},
+						Type:  &ast.StarExpr{X: ast.NewIdent(qm.Service + "Client")},
 					},
 				},
 			},
-		},
+			Name: ast.NewIdent(gen.goName(qm.Name)),
+			Type: genSendType(input_name, output_name),
+			Body: genSendBody(commonIdent("client"), ast.NewIdent(output_name)),
+		})
+		gen.addLegacyMethod(f, "client", qm.Service+"Client", "", qm.Name, input_name, output_name)
 	}
 
-	fun_id_output := &ast.FuncDecl{
-		Recv: &ast.FieldList{
-			List: []*ast.Field{
-				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  outputs.Specs[0].(*ast.TypeSpec).Name,
-				},
-			},
-		},
-		Name: fun_id.Name,
-		Type: fun_id.Type,
-		Body: fun_id.Body,
+	if qm.Rearm != "" && gen.clientServices[qm.Service] {
+		f.Decls = append(f.Decls, gen.genRearmed(qm, gen.goName(qm.Rearm), input_name))
 	}
 
-	fun_service_id_output := &ast.FuncDecl{
-		Recv: &ast.FieldList{
-			List: []*ast.Field{
-				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  outputs.Specs[0].(*ast.TypeSpec).Name,
-				},
+	f.Decls = append(f.Decls, out.Methods...)
+
+	gen.addAlias(f, qm.Service, qm.Name, "Input")
+	gen.addAlias(f, qm.Service, qm.Name, "Output")
+
+	if out.HasOpResult {
+		f.Decls = append(
+			f.Decls,
+			&ast.FuncDecl{
+				Recv: &ast.FieldList{
+					List: []*ast.Field{
+						&ast.Field{
+							Names: []*ast.Ident{commonIdent("msg")},
+							Type: &ast.StarExpr{
+								X: ast.NewIdent(output_name),
+							},
+						},
+					},
+				},
+				Name: commonIdent("OperationResult"),
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{
+								Type: commonIdent("QMIStructOperationResult"),
+							},
+						},
+					},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ReturnStmt{
+							Results: []ast.Expr{
+								&ast.SelectorExpr{
+									X:   commonIdent("msg"),
+									Sel: commonIdent("QMIStructOperationResult"),
+								},
+							},
+						},
+					},
+				},
 			},
-		},
-		Name: fun_service_id.Name,
-		Type: fun_service_id.Type,
-		Body: fun_service_id.Body,
+		)
 	}
 
-	fun := &ast.FuncDecl{
+	vendor, err := qm.VendorLit()
+	if err != nil {
+		return err
+	}
+	if vendor != nil {
+		// func (msg *DMSFooInput) MessageVendor() uint16 { return 0x1234 }
+		for _, typ := range []string{input_name, output_name} {
+			gen.docComments["type "+typ+" "] = fmt.Sprintf("%s is specific to vendor %s", typ, vendor.Value)
+			f.Decls = append(f.Decls, genConstMethod(typ, "MessageVendor", "uint16", &ast.BasicLit{Kind: vendor.Kind, Value: vendor.Value}))
+		}
+	}
+
+	if qm.NoResponse {
+		// func (msg *WMSFooInput) NoResponse() bool { return true }
+		f.Decls = append(f.Decls, genConstMethod(input_name, "NoResponse", "bool", commonIdent("true")))
+	}
+
+	gen.docRenamedFields(input_name, qm.Input)
+	gen.docRenamedFields(output_name, qm.Output)
+	return nil
+}
+
+// genValueSend keeps value call sites of Send compiling since the message
+// methods take pointers:
+//
+//	func (dev *Device) SendDMSGetIDsInput(input DMSGetIDsInput) (Message, error) {
+//		return dev.Send(&input)
+//	}
+func (gen *generator) genValueSend(input string) *ast.FuncDecl {
+	name := "Send" + input
+	gen.docComments["func (dev *Device) "+name+"("] = fmt.Sprintf("%s sends input passed by value, as Send took it before.\n\nDeprecated: pass *%s to Send.", name, input)
+	return &ast.FuncDecl{
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["dev"]},
-					Type:  &ast.StarExpr{X: CommonIdents["Device"]},
+					Names: []*ast.Ident{commonIdent("dev")},
+					Type:  &ast.StarExpr{X: commonIdent("Device")},
 				},
 			},
 		},
-		Name: ast.NewIdent(qm.Service + name.CamelCase(qm.Name, true)),
+		Name: ast.NewIdent(name),
 		Type: &ast.FuncType{
 			Params: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["input"]},
-						Type:  inputs.Specs[0].(*ast.TypeSpec).Name,
+						Names: []*ast.Ident{commonIdent("input")},
+						Type:  ast.NewIdent(input),
 					},
 				},
 			},
 			Results: &ast.FieldList{
 				List: []*ast.Field{
-					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["m"]},
-						Type:  &ast.StarExpr{X: outputs.Specs[0].(*ast.TypeSpec).Name},
-					},
-					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["err"]},
-						Type:  CommonIdents["error"],
-					},
+					&ast.Field{Type: commonIdent("Message")},
+					&ast.Field{Type: commonIdent("error")},
 				},
 			},
 		},
 		Body: &ast.BlockStmt{
 			List: []ast.Stmt{
-				&ast.DeclStmt{
-					Decl: &ast.GenDecl{
-						Tok: token.VAR,
-						Specs: []ast.Spec{
-							&ast.ValueSpec{
-								Names: []*ast.Ident{CommonIdents["msg"]},
-								Type:  CommonIdents["Message"],
-							},
-						},
-					},
-				},
-				&ast.AssignStmt{
-					Lhs: []ast.Expr{
-						CommonIdents["msg"],
-						CommonIdents["err"],
-					},
-					Tok: token.ASSIGN,
-					Rhs: []ast.Expr{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
 						&ast.CallExpr{
 							Fun: &ast.SelectorExpr{
-								X:   CommonIdents["dev"],
-								Sel: CommonIdents["Send"],
+								X:   commonIdent("dev"),
+								Sel: commonIdent("Send"),
 							},
 							Args: []ast.Expr{
-								CommonIdents["input"],
-							},
-						},
-					},
-				},
-				handleErr(),
-				&ast.AssignStmt{
-					Lhs: []ast.Expr{
-						CommonIdents["m"],
-					},
-					Tok: token.ASSIGN,
-					Rhs: []ast.Expr{
-						&ast.TypeAssertExpr{
-							X: CommonIdents["msg"],
-							Type: &ast.StarExpr{
-								X: outputs.Specs[0].(*ast.TypeSpec).Name,
-							},
-						},
-					},
-				},
-				&ast.ReturnStmt{},
-			},
-		},
-	}
-
-	tlv_write_stmts := []ast.Stmt{
-		&ast.DeclStmt{
-			Decl: &ast.GenDecl{
-				Tok: token.VAR,
-				Specs: []ast.Spec{
-					&ast.ValueSpec{
-						Names: []*ast.Ident{CommonIdents["buf"]},
-						Type: &ast.StarExpr{
-							X: &ast.SelectorExpr{
-								X:   CommonIdents["bytes"],
-								Sel: CommonIdents["Buffer"],
+								&ast.UnaryExpr{Op: token.AND, X: commonIdent("input")},
 							},
 						},
 					},
 				},
 			},
 		},
-		&ast.AssignStmt{
-			Lhs: []ast.Expr{
-				CommonIdents["_"],
-			},
-			Tok: token.ASSIGN,
-			Rhs: []ast.Expr{
-				CommonIdents["buf"],
-			},
-		},
 	}
+}
 
-	for i, input := range qm.Input {
-		write_stmts, err := input.GenWriteTo(CommonIdents["msg"], input_sizes[i])
-		if err != nil {
-			return err
-		}
-		tlv_write_stmts = append(
-			tlv_write_stmts,
-			write_stmts...,
-		)
-	}
-	tlv_write_stmts = append(tlv_write_stmts, &ast.ReturnStmt{
-		Results: []ast.Expr{
-			CommonIdents["nil"],
-		},
-	})
+// genRearmed sends the request from the client and from an OnRearm hook
+//
+//	func (client *NASClient) ConfigureEventReport(ctx context.Context, input NASSetEventReportInput) error {
+//		return client.Device.sendRearmed(ctx, "NAS Set Event Report", &input)
+//	}
+func (gen *generator) genRearmed(qm *QMIMessage, method string, input_name string) *ast.FuncDecl {
+	typ := qm.Service + "Client"
+	hook := qm.Service + " " + qm.Name
+	gen.docComments["func (client *"+typ+") "+method+"("] = fmt.Sprintf("%s sends %s, and again after every Reconnect", method, hook)
 
-	fun_tlvs_writeTo := &ast.FuncDecl{
+	return &ast.FuncDecl{
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  inputs.Specs[0].(*ast.TypeSpec).Name,
+					Names: []*ast.Ident{commonIdent("client")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(typ)},
 				},
 			},
 		},
-		Name: CommonIdents["TLVsWriteTo"],
+		Name: ast.NewIdent(method),
 		Type: &ast.FuncType{
 			Params: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["w"]},
+						Names: []*ast.Ident{commonIdent("ctx")},
 						Type: &ast.SelectorExpr{
-							X:   CommonIdents["io"],
-							Sel: CommonIdents["Writer"],
+							X:   commonIdent("context"),
+							Sel: commonIdent("Context"),
 						},
 					},
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("input")},
+						Type:  ast.NewIdent(input_name),
+					},
 				},
 			},
 			Results: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["err"]},
-						Type:  CommonIdents["error"],
+						Type: commonIdent("error"),
 					},
 				},
 			},
 		},
-		Body: &ast.BlockStmt{
-			List: tlv_write_stmts,
-		},
-	}
-
-	fun_tlvs_writeTo_output := &ast.FuncDecl{
-		Recv: &ast.FieldList{
-			List: []*ast.Field{
-				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  outputs.Specs[0].(*ast.TypeSpec).Name,
-				},
-			},
-		},
-		Name: fun_tlvs_writeTo.Name,
-		Type: fun_tlvs_writeTo.Type,
 		Body: &ast.BlockStmt{
 			List: []ast.Stmt{
-				&ast.ExprStmt{
-					X: &ast.CallExpr{
-						Fun: CommonIdents["panic"],
-						Args: []ast.Expr{
-							&ast.BasicLit{
-								Kind:  token.STRING,
-								Value: `"not implemented"`,
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X: &ast.SelectorExpr{
+									X:   commonIdent("client"),
+									Sel: commonIdent("Device"),
+								},
+								Sel: commonIdent("sendRearmed"),
 							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	tlv_read_stmts := []ast.Stmt{
-		&ast.DeclStmt{
-			Decl: &ast.GenDecl{
-				Tok: token.VAR,
-				Specs: []ast.Spec{
-					&ast.ValueSpec{
-						Names: []*ast.Ident{CommonIdents["b"]},
-						Type: &ast.StarExpr{
-							X: &ast.SelectorExpr{
-								X:   CommonIdents["bytes"],
-								Sel: CommonIdents["Buffer"],
+							Args: []ast.Expr{
+								commonIdent("ctx"),
+								&ast.BasicLit{
+									Kind:  token.STRING,
+									Value: strconv.Quote(hook),
+								},
+								&ast.UnaryExpr{Op: token.AND, X: commonIdent("input")},
 							},
 						},
 					},
@@ -578,137 +892,358 @@ func (qm *QMIMessage) Register(f *ast.File) error {
 			},
 		},
 	}
+}
 
-	for i, output := range qm.Output {
-		read_stmts, err := output.GenReadFrom(CommonIdents["msg"], output_sizes[i])
-		if err != nil {
-			return err
+// genNewInput returns the input with its mandatory TLVs, those below
+// 0x10, given as parameters; optional ones are set on it afterwards
+//
+//	func NewDMSSetOperatingModeInput(mode uint8) DMSSetOperatingModeInput {
+//		return DMSSetOperatingModeInput{Mode: mode}
+//	}
+func (gen *generator) genNewInput(input_name string, mandatory []*ast.Field) *ast.FuncDecl {
+	fun_name := "New" + input_name
+	gen.docComments["func "+fun_name+"("] = fmt.Sprintf("%s returns %s with its mandatory TLVs set", fun_name, input_name)
+
+	params := &ast.FieldList{}
+	lit := &ast.CompositeLit{Type: ast.NewIdent(input_name)}
+	for _, field := range mandatory {
+		var key string
+		if len(field.Names) > 0 {
+			key = field.Names[0].Name
+		} else {
+			// embedded common struct
+			key = recvName(field.Type)
 		}
-		tlv_read_stmts = append(
-			tlv_read_stmts,
-			read_stmts...,
-		)
+		param := gen.paramName(key)
+
+		params.List = append(params.List, &ast.Field{
+			Names: []*ast.Ident{ast.NewIdent(param)},
+			Type:  cloneExpr(field.Type),
+		})
+		lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+			Key:   ast.NewIdent(key),
+			Value: ast.NewIdent(param),
+		})
 	}
 
-	tlv_read_stmts = append(
-		tlv_read_stmts,
-		&ast.ReturnStmt{
-			Results: []ast.Expr{
-				CommonIdents["nil"],
+	return &ast.FuncDecl{
+		Name: ast.NewIdent(fun_name),
+		Type: &ast.FuncType{
+			Params: params,
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent(input_name)},
+				},
 			},
 		},
-	)
-
-	fun_tlvs_readFrom_out := &ast.FuncDecl{
-		Recv: &ast.FieldList{
-			List: []*ast.Field{
-				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type: &ast.StarExpr{
-						X: outputs.Specs[0].(*ast.TypeSpec).Name,
-					},
-				},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{Results: []ast.Expr{lit}},
 			},
 		},
-		Name: CommonIdents["TLVsReadFrom"],
+	}
+}
+
+// VendorLit returns the vendor ID of a vendor specific message, nil for
+// standard ones
+func (qm *QMIMessage) VendorLit() (*ast.BasicLit, error) {
+	if qm.Vendor == "" {
+		return nil, nil
+	}
+	vendor, err := strconv.ParseUint(strings.TrimSpace(qm.Vendor), 0, 16)
+	if err != nil || vendor == 0 {
+		return nil, fmt.Errorf("%s: bad vendor %q", qm.Name, qm.Vendor)
+	}
+	return &ast.BasicLit{
+		Kind:  token.INT,
+		Value: fmt.Sprintf("0x%04X", vendor),
+	}, nil
+}
+
+// cloneExpr copies the expressions the generator threads through several
+// places of the generated code, msg.Field[i].Sub and the like, for every
+// use to be a new node
+func cloneExpr(expr ast.Expr) ast.Expr {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		return ast.NewIdent(e.Name)
+	case *ast.BasicLit:
+		return &ast.BasicLit{Kind: e.Kind, Value: e.Value}
+	case *ast.SelectorExpr:
+		return &ast.SelectorExpr{X: cloneExpr(e.X), Sel: ast.NewIdent(e.Sel.Name)}
+	case *ast.IndexExpr:
+		return &ast.IndexExpr{X: cloneExpr(e.X), Index: cloneExpr(e.Index)}
+	case *ast.StarExpr:
+		return &ast.StarExpr{X: cloneExpr(e.X)}
+	case *ast.UnaryExpr:
+		return &ast.UnaryExpr{Op: e.Op, X: cloneExpr(e.X)}
+	case *ast.ParenExpr:
+		return &ast.ParenExpr{X: cloneExpr(e.X)}
+	case *ast.BinaryExpr:
+		return &ast.BinaryExpr{X: cloneExpr(e.X), Op: e.Op, Y: cloneExpr(e.Y)}
+	case *ast.CallExpr:
+		args := make([]ast.Expr, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = cloneExpr(arg)
+		}
+		return &ast.CallExpr{Fun: cloneExpr(e.Fun), Args: args}
+	case *ast.ArrayType:
+		var length ast.Expr
+		if e.Len != nil {
+			length = cloneExpr(e.Len)
+		}
+		return &ast.ArrayType{Len: length, Elt: cloneExpr(e.Elt)}
+	case *ast.StructType:
+		fields := &ast.FieldList{}
+		for _, field := range e.Fields.List {
+			clone := &ast.Field{Type: cloneExpr(field.Type)}
+			for _, n := range field.Names {
+				clone.Names = append(clone.Names, ast.NewIdent(n.Name))
+			}
+			if field.Tag != nil {
+				clone.Tag = &ast.BasicLit{Kind: field.Tag.Kind, Value: field.Tag.Value}
+			}
+			fields.List = append(fields.List, clone)
+		}
+		return &ast.StructType{Fields: fields}
+	}
+	panic(fmt.Sprintf("cloneExpr: unexpected %T", expr))
+}
+
+// genConstructor returns a constructor for registration:
+//
+//	func() Message { return &CTLAllocateCIDOutput{} }
+func genConstructor(typ string, result string) *ast.FuncLit {
+	return &ast.FuncLit{
 		Type: &ast.FuncType{
-			Params: &ast.FieldList{
-				List: []*ast.Field{
-					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["r"]},
-						Type: &ast.StarExpr{
-							X: &ast.SelectorExpr{
-								X:   CommonIdents["bytes"],
-								Sel: CommonIdents["Buffer"],
-							},
-						},
-					},
-				},
-			},
 			Results: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["err"]},
-						Type:  CommonIdents["error"],
+						Type: commonIdent(result),
 					},
 				},
 			},
 		},
 		Body: &ast.BlockStmt{
-			List: tlv_read_stmts,
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.UnaryExpr{
+							Op: token.AND,
+							X: &ast.CompositeLit{
+								Type: ast.NewIdent(typ),
+							},
+						},
+					},
+				},
+			},
 		},
 	}
+}
 
-	fun_tlvs_readFrom := &ast.FuncDecl{
+// genConstMethod returns a method of fresh nodes returning value:
+//
+//	func (msg *CTLAllocateCIDInput) MessageID() uint16 { return 0x0022 }
+func genConstMethod(recv string, name string, result string, value ast.Expr) *ast.FuncDecl {
+	return &ast.FuncDecl{
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["msg"]},
-					Type:  inputs.Specs[0].(*ast.TypeSpec).Name,
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(recv)},
+				},
+			},
+		},
+		Name: commonIdent(name),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Type: commonIdent(result),
+					},
 				},
 			},
 		},
-		Name: fun_tlvs_readFrom_out.Name,
-		Type: fun_tlvs_readFrom_out.Type,
 		Body: &ast.BlockStmt{
 			List: []ast.Stmt{
-				&ast.ExprStmt{
-					X: &ast.CallExpr{
-						Fun: CommonIdents["panic"],
-						Args: []ast.Expr{
-							&ast.BasicLit{
-								Kind:  token.STRING,
-								Value: `"not implemented"`,
-							},
-						},
-					},
+				&ast.ReturnStmt{
+					Results: []ast.Expr{value},
 				},
 			},
 		},
 	}
+}
 
-	f.Decls = append(
-		f.Decls,
-		inputs, outputs,
-		fun,
-		fun_service_id, fun_id,
-		fun_service_id_output, fun_id_output,
-		fun_tlvs_readFrom, fun_tlvs_readFrom_out,
-		fun_tlvs_writeTo, fun_tlvs_writeTo_output,
-	)
+// genMessageInfo registers what LookupMessage tells about a message type:
+//
+//	registerMessageInfo(MessageInfo{
+//		Service:   QMI_SERVICE_CTL,
+//		MessageID: 0x0022,
+//		Direction: DirectionRequest,
+//		Name:      "CTLAllocateCIDInput",
+//		TLVs:      []TLVInfo{{0x01, "Service", "guint8"}},
+//	})
+func (gen *generator) genMessageInfo(service string, id uint16, vendor *ast.BasicLit, direction string, typ string, title string, tlvs []QMITLV) (ast.Stmt, error) {
+	var infos []ast.Expr
+	for _, tlv := range tlvs {
+		tlv_name, format := tlv.Name, tlv.Format
+		if tlv.CommonRef != "" && (tlv_name == "" || format == "") {
+			common, err := tlv.ResolveCommonRef(gen)
+			if err != nil {
+				return nil, err
+			}
+			if tlv_name == "" {
+				tlv_name = common.Name
+			}
+			if format == "" {
+				format = common.Format
+			}
+		}
+		infos = append(infos, &ast.CompositeLit{
+			Elts: []ast.Expr{
+				tlv.TagLit(),
+				&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(tlv_name)},
+				&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(format)},
+			},
+		})
+	}
 
-	if has_op_result {
-		f.Decls = append(
-			f.Decls,
-			&ast.FuncDecl{
-				Recv: &ast.FieldList{
-					List: []*ast.Field{
-						&ast.Field{
-							Names: []*ast.Ident{CommonIdents["msg"]},
-							Type: &ast.StarExpr{
-								X: outputs.Specs[0].(*ast.TypeSpec).Name,
-							},
-						},
+	elts := []ast.Expr{
+		&ast.KeyValueExpr{Key: commonIdent("Service"), Value: ast.NewIdent("QMI_SERVICE_" + service)},
+		&ast.KeyValueExpr{Key: commonIdent("MessageID"), Value: idLit(id)},
+	}
+	if vendor != nil {
+		elts = append(elts, &ast.KeyValueExpr{Key: commonIdent("Vendor"), Value: cloneExpr(vendor)})
+	}
+	elts = append(
+		elts,
+		&ast.KeyValueExpr{Key: commonIdent("Direction"), Value: commonIdent(direction)},
+		&ast.KeyValueExpr{Key: commonIdent("Name"), Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(typ)}},
+		&ast.KeyValueExpr{Key: commonIdent("Title"), Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(title)}},
+	)
+	if len(infos) > 0 {
+		elts = append(elts, &ast.KeyValueExpr{
+			Key: commonIdent("TLVs"),
+			Value: &ast.CompositeLit{
+				Type: &ast.ArrayType{Elt: commonIdent("TLVInfo")},
+				Elts: infos,
+			},
+		})
+	}
+
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: commonIdent("registerMessageInfo"),
+			Args: []ast.Expr{
+				&ast.CompositeLit{
+					Type: commonIdent("MessageInfo"),
+					Elts: elts,
+				},
+			},
+		},
+	}, nil
+}
+
+// genMessageNames declares the names of the standard messages and
+// indications of each service in the file by ID, for logs and errors, and
+// hands them to the runtime from init() for ErrBadMessage:
+//
+//	var DMSMessageNames = map[uint16]string{0x0025: "Get IDs"}
+//
+//	func DMSMessageName(id uint16) string
+//
+//	declareMessageNames(QMI_SERVICE_DMS, "DMS", DMSMessageNames)
+func (gen *generator) genMessageNames(entities []QMIEntity) ([]ast.Decl, []ast.Stmt, error) {
+	var services []string
+	names := map[string]map[uint16]string{}
+	add := func(service string, id uint16, name string) {
+		if _, ok := names[service]; !ok {
+			services = append(services, service)
+			names[service] = map[uint16]string{}
+		}
+		// an indication usually shares the name of its message
+		if _, ok := names[service][id]; !ok {
+			names[service][id] = name
+		}
+	}
+	for _, entity := range entities {
+		switch v := entity.(type) {
+		case *QMIMessage:
+			vendor, err := v.VendorLit()
+			if err != nil {
+				return nil, nil, err
+			}
+			if vendor == nil {
+				add(v.Service, v.id, v.Name)
+			}
+		case *QMIIndication:
+			add(v.Service, v.id, v.Name)
+		}
+	}
+
+	var decls []ast.Decl
+	var stmts []ast.Stmt
+	for _, service := range services {
+		var ids []int
+		for id := range names[service] {
+			ids = append(ids, int(id))
+		}
+		sort.Ints(ids)
+
+		lit := &ast.CompositeLit{
+			Type: &ast.MapType{Key: commonIdent("uint16"), Value: commonIdent("string")},
+		}
+		for _, id := range ids {
+			lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+				Key:   idLit(uint16(id)),
+				Value: &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(names[service][uint16(id)])},
+			})
+		}
+
+		var_name := service + "MessageNames"
+		fun_name := service + "MessageName"
+		gen.docComments["var "+var_name+" "] = fmt.Sprintf("%s names the messages and indications of %s by ID, as the data files do", var_name, service)
+		gen.docComments["func "+fun_name+"("] = fmt.Sprintf("%s names a message or indication of %s for logs, %q, or gives its ID in hex", fun_name, service, service+" "+names[service][uint16(ids[0])])
+		decls = append(decls,
+			&ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names:  []*ast.Ident{ast.NewIdent(var_name)},
+						Values: []ast.Expr{lit},
 					},
 				},
-				Name: CommonIdents["OperationResult"],
+			},
+			// func DMSMessageName(id uint16) string { return messageName("DMS", DMSMessageNames, id) }
+			&ast.FuncDecl{
+				Name: ast.NewIdent(fun_name),
 				Type: &ast.FuncType{
-					Params: &ast.FieldList{},
-					Results: &ast.FieldList{
+					Params: &ast.FieldList{
 						List: []*ast.Field{
 							&ast.Field{
-								Type: CommonIdents["QMIStructOperationResult"],
+								Names: []*ast.Ident{commonIdent("id")},
+								Type:  commonIdent("uint16"),
 							},
 						},
 					},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{Type: commonIdent("string")},
+						},
+					},
 				},
 				Body: &ast.BlockStmt{
 					List: []ast.Stmt{
 						&ast.ReturnStmt{
 							Results: []ast.Expr{
-								&ast.SelectorExpr{
-									X:   CommonIdents["msg"],
-									Sel: CommonIdents["QMIStructOperationResult"],
+								&ast.CallExpr{
+									Fun: commonIdent("messageName"),
+									Args: []ast.Expr{
+										&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(service)},
+										ast.NewIdent(var_name),
+										commonIdent("id"),
+									},
 								},
 							},
 						},
@@ -716,95 +1251,1682 @@ func (qm *QMIMessage) Register(f *ast.File) error {
 				},
 			},
 		)
+		stmts = append(stmts, &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: commonIdent("declareMessageNames"),
+				Args: []ast.Expr{
+					ast.NewIdent("QMI_SERVICE_" + service),
+					&ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(service)},
+					ast.NewIdent(var_name),
+				},
+			},
+		})
 	}
+	return decls, stmts, nil
+}
+
+// TokenTLVs name the TLVs carrying the token which correlates a request
+// with the indication completing it, as PDC and UIM do
+var TokenTLVs = []string{"Token", "Response In Indication"}
 
+// tokenTLV returns the token TLV among tlvs, or nil
+func tokenTLV(tlvs []QMITLV) *QMITLV {
+	for i := range tlvs {
+		for _, name := range TokenTLVs {
+			if tlvs[i].Name == name {
+				return &tlvs[i]
+			}
+		}
+	}
 	return nil
 }
 
-func (qi *QMIIndication) Register(f *ast.File) error {
-	return nil
+// genTokenExtractor returns a function taking the token from a message
+// of type typ:
+//
+//	func(m Message) (interface{}, bool) {
+//		if msg, ok := m.(*PDCListConfigsIndication); ok {
+//			return tokenOf(msg.Token)
+//		}
+//		return nil, false
+//	}
+func genTokenExtractor(typ string, field string, pos token.Pos) *ast.FuncLit {
+	return &ast.FuncLit{
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("m")},
+						Type:  commonIdent("Message"),
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					// braces on one line print interface{} in one
+					&ast.Field{Type: &ast.InterfaceType{Methods: &ast.FieldList{Opening: pos, Closing: pos}}},
+					&ast.Field{Type: commonIdent("bool")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.IfStmt{
+					Init: &ast.AssignStmt{
+						Lhs: []ast.Expr{commonIdent("msg"), commonIdent("ok")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{
+							&ast.TypeAssertExpr{
+								X:    commonIdent("m"),
+								Type: &ast.StarExpr{X: ast.NewIdent(typ)},
+							},
+						},
+					},
+					Cond: commonIdent("ok"),
+					Body: &ast.BlockStmt{
+						List: []ast.Stmt{
+							&ast.ReturnStmt{
+								Results: []ast.Expr{
+									&ast.CallExpr{
+										Fun: commonIdent("tokenOf"),
+										Args: []ast.Expr{
+											&ast.SelectorExpr{
+												X:   commonIdent("msg"),
+												Sel: ast.NewIdent(field),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+				&ast.ReturnStmt{
+					Results: []ast.Expr{commonIdent("nil"), commonIdent("false")},
+				},
+			},
+		},
+	}
 }
 
-func (qt *QMITLV) GenTypeDecl() (*ast.GenDecl, int, error) {
-	n := 0
-	fieldList := []*ast.Field{}
+// genTokenFlows registers the requests of each service in the file which
+// an indication of the same ID completes, both carrying a token TLV, with
+// SendAndWait of every Device. The token is the response's if it has
+// one, else the request's:
+//
+//	func RegisterPDCTokenFlows(dev *Device) {
+//		dev.RegisterTokenFlow(QMI_SERVICE_PDC, 0x0024, &TokenFlow{...})
+//	}
+//
+//	addTokenFlows(RegisterPDCTokenFlows)
+func (gen *generator) genTokenFlows(entities []QMIEntity, pos token.Pos) ([]ast.Decl, map[string]ast.Stmt, []string, error) {
+	indications := map[string]map[uint16]*QMIIndication{}
+	for _, entity := range entities {
+		if v, ok := entity.(*QMIIndication); ok {
+			if indications[v.Service] == nil {
+				indications[v.Service] = map[uint16]*QMIIndication{}
+			}
+			indications[v.Service][v.id] = v
+		}
+	}
 
-	for _, field := range qt.Contents {
-		typ, n1, err := parseType(field)
+	var services []string
+	flows := map[string][]ast.Stmt{}
+	for _, entity := range entities {
+		v, ok := entity.(*QMIMessage)
+		if !ok {
+			continue
+		}
+		vendor, err := v.VendorLit()
 		if err != nil {
-			return nil, 0, err
+			return nil, nil, nil, err
+		}
+		ind := indications[v.Service][v.id]
+		if vendor != nil || ind == nil || tokenTLV(ind.Output) == nil {
+			continue
+		}
+
+		name := v.Service + gen.goName(v.Name)
+		elts := []ast.Expr{
+			&ast.KeyValueExpr{
+				Key:   commonIdent("IndicationID"),
+				Value: idLit(ind.id),
+			},
+		}
+		if tlv := tokenTLV(v.Output); tlv != nil {
+			elts = append(elts, &ast.KeyValueExpr{
+				Key:   commonIdent("ResponseToken"),
+				Value: genTokenExtractor(name+"Output", gen.goName(tlv.Name), pos),
+			})
+		} else if tlv := tokenTLV(v.Input); tlv != nil {
+			elts = append(elts, &ast.KeyValueExpr{
+				Key:   commonIdent("RequestToken"),
+				Value: genTokenExtractor(name+"Input", gen.goName(tlv.Name), pos),
+			})
+		} else {
+			continue
+		}
+		elts = append(elts, &ast.KeyValueExpr{
+			Key:   commonIdent("IndicationToken"),
+			Value: genTokenExtractor(v.Service+gen.goName(ind.Name)+"Indication", gen.goName(tokenTLV(ind.Output).Name), pos),
+		})
+
+		if flows[v.Service] == nil {
+			services = append(services, v.Service)
+		}
+		flows[v.Service] = append(flows[v.Service], &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   commonIdent("dev"),
+					Sel: commonIdent("RegisterTokenFlow"),
+				},
+				Args: []ast.Expr{
+					ast.NewIdent("QMI_SERVICE_" + v.Service),
+					idLit(v.id),
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X: &ast.CompositeLit{
+							Type: commonIdent("TokenFlow"),
+							Elts: elts,
+						},
+					},
+				},
+			},
+		})
+	}
+
+	var decls []ast.Decl
+	stmts := map[string]ast.Stmt{}
+	for _, service := range services {
+		fun_name := "Register" + service + "TokenFlows"
+		gen.docComments["func "+fun_name+"("] = fmt.Sprintf("%s registers the token flows of %s with dev, for SendAndWait", fun_name, service)
+		decls = append(decls, &ast.FuncDecl{
+			Name: ast.NewIdent(fun_name),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{
+					List: []*ast.Field{
+						&ast.Field{
+							Names: []*ast.Ident{commonIdent("dev")},
+							Type:  &ast.StarExpr{X: commonIdent("Device")},
+						},
+					},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: flows[service],
+			},
+		})
+		stmts[service] = &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun:  commonIdent("addTokenFlows"),
+				Args: []ast.Expr{ast.NewIdent(fun_name)},
+			},
+		}
+	}
+	return decls, stmts, services, nil
+}
+
+// genSendType returns the signature of the methods sending a message:
+//
+//	(input CTLAllocateCIDInput) (m *CTLAllocateCIDOutput, err error)
+func genSendType(input string, output string) *ast.FuncType {
+	return &ast.FuncType{
+		Params: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("input")},
+					Type:  ast.NewIdent(input),
+				},
+			},
+		},
+		Results: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("m")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(output)},
+				},
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("err")},
+					Type:  commonIdent("error"),
+				},
+			},
+		},
+	}
+}
+
+// genCommands declares the command line surface of each service in the
+// file, its requests which need no input TLV, for RegisterCommands:
+//
+//	type DMSCommands struct{}
+//
+//	func (DMSCommands) Service() Service { return QMI_SERVICE_DMS }
+//
+//	func (DMSCommands) Commands() map[string]func() Message {
+//		return map[string]func() Message{"get-ids": func() Message { return &DMSGetIDsInput{} }}
+//	}
+//
+// The map is built by the method, so a program which never registers the
+// service links none of its messages. CTL is left to the Device, vendor
+// messages to devices opened WithVendor.
+func (gen *generator) genCommands(entities []QMIEntity) ([]ast.Decl, map[string]ast.Stmt, []string, error) {
+	var services []string
+	commands := map[string]map[string]string{}
+	for _, entity := range entities {
+		v, ok := entity.(*QMIMessage)
+		if !ok || v.Service == "CTL" {
+			continue
+		}
+		vendor, err := v.VendorLit()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if vendor != nil || len(v.Input) > 0 {
+			continue
+		}
+		if _, ok := commands[v.Service]; !ok {
+			services = append(services, v.Service)
+			commands[v.Service] = map[string]string{}
+		}
+		command := strings.ToLower(strings.Join(strings.Fields(v.Name), "-"))
+		if other, ok := commands[v.Service][command]; ok {
+			return nil, nil, nil, fmt.Errorf("%s: command %q of %s is taken by %s", v.Name, command, v.Service, other)
+		}
+		commands[v.Service][command] = v.Service + gen.goName(v.Name) + "Input"
+	}
+
+	var decls []ast.Decl
+	stmts := map[string]ast.Stmt{}
+	for _, service := range services {
+		var names []string
+		for command := range commands[service] {
+			names = append(names, command)
+		}
+		sort.Strings(names)
+
+		// map[string]func() Message, a new node for every use
+		commands_type := func() *ast.MapType {
+			return &ast.MapType{
+				Key: commonIdent("string"),
+				Value: &ast.FuncType{
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{Type: commonIdent("Message")},
+						},
+					},
+				},
+			}
+		}
+		lit := &ast.CompositeLit{Type: commands_type()}
+		for _, command := range names {
+			lit.Elts = append(lit.Elts, &ast.KeyValueExpr{
+				Key:   &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(command)},
+				Value: genConstructor(commands[service][command], "Message"),
+			})
+		}
+
+		typ := service + "Commands"
+		gen.docComments["type "+typ+" "] = fmt.Sprintf("%s is the command line surface of %s, for RegisterCommands", typ, service)
+		gen.docComments["func ("+typ+") Service("] = fmt.Sprintf("Service returns QMI_SERVICE_%s", service)
+		gen.docComments["func ("+typ+") Commands("] = fmt.Sprintf("Commands returns the requests of %s which need no input by command name, %q", service, names[0])
+		recv := func() *ast.FieldList {
+			return &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: ast.NewIdent(typ)},
+				},
+			}
+		}
+		decls = append(decls,
+			&ast.GenDecl{
+				Tok: token.TYPE,
+				Specs: []ast.Spec{
+					&ast.TypeSpec{
+						Name: ast.NewIdent(typ),
+						Type: &ast.StructType{Fields: &ast.FieldList{}},
+					},
+				},
+			},
+			&ast.FuncDecl{
+				Recv: recv(),
+				Name: ast.NewIdent("Service"),
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{Type: commonIdent("Service")},
+						},
+					},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ReturnStmt{
+							Results: []ast.Expr{ast.NewIdent("QMI_SERVICE_" + service)},
+						},
+					},
+				},
+			},
+			&ast.FuncDecl{
+				Recv: recv(),
+				Name: ast.NewIdent("Commands"),
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+					Results: &ast.FieldList{
+						List: []*ast.Field{
+							&ast.Field{Type: commands_type()},
+						},
+					},
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.ReturnStmt{Results: []ast.Expr{lit}},
+					},
+				},
+			},
+		)
+
+		// RegisterCommands(DMSCommands{})
+		register := &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun:  commonIdent("RegisterCommands"),
+				Args: []ast.Expr{&ast.CompositeLit{Type: ast.NewIdent(typ)}},
+			},
+		}
+		if !gen.opts.ExplicitRegister {
+			stmts[service] = register
+			continue
 		}
-		fieldList = append(fieldList, &ast.Field{
-			Names: []*ast.Ident{
-				ast.NewIdent(name.CamelCase(field.Name, true)),
+		fun_name := "Register" + service + "Commands"
+		gen.docComments["func "+fun_name+"("] = fmt.Sprintf("%s adds the commands of %s to CommandSets", fun_name, service)
+		decls = append(decls, &ast.FuncDecl{
+			Name: ast.NewIdent(fun_name),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{register},
+			},
+		})
+	}
+	return decls, stmts, services, nil
+}
+
+// genSendBody sends input through sender, a Device or a typed client, and
+// asserts the output type
+func genSendBody(sender ast.Expr, output *ast.Ident) *ast.BlockStmt {
+	return &ast.BlockStmt{
+		List: []ast.Stmt{
+			&ast.DeclStmt{
+				Decl: &ast.GenDecl{
+					Tok: token.VAR,
+					Specs: []ast.Spec{
+						&ast.ValueSpec{
+							Names: []*ast.Ident{commonIdent("msg")},
+							Type:  commonIdent("Message"),
+						},
+					},
+				},
+			},
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{
+					commonIdent("msg"),
+					commonIdent("err"),
+				},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   sender,
+							Sel: commonIdent("Send"),
+						},
+						Args: []ast.Expr{
+							&ast.UnaryExpr{Op: token.AND, X: commonIdent("input")},
+						},
+					},
+				},
+			},
+			// a partially decoded output comes with an error
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{
+					X:  commonIdent("msg"),
+					Op: token.NEQ,
+					Y:  commonIdent("nil"),
+				},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{
+								commonIdent("m"),
+							},
+							Tok: token.ASSIGN,
+							Rhs: []ast.Expr{
+								&ast.TypeAssertExpr{
+									X: commonIdent("msg"),
+									Type: &ast.StarExpr{
+										X: output,
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			&ast.ReturnStmt{},
+		},
+	}
+}
+
+// prepareTLVs inherits IDs of the TLVs and, with -optional-pointers, marks
+// those from 0x10 optional and output ones below it mandatory
+func (gen *generator) prepareTLVs(tlvs []QMITLV, output bool) error {
+	for i := range tlvs {
+		err := tlvs[i].inheritID(gen)
+		if err != nil {
+			return err
+		}
+		if id := strings.TrimSpace(tlvs[i].ID); id != "" {
+			_, err = strconv.ParseUint(id, 0, 8)
+			if err != nil {
+				return fmt.Errorf("TLV %q: id %q is not a byte", tlvs[i].Name, tlvs[i].ID)
+			}
+		}
+		if gen.opts.OptionalPointers && tlvs[i].Name != "" && !tlvs[i].Repeatable {
+			tlvs[i].optional = tlvs[i].Tag() >= 0x10
+		}
+		if output {
+			tlvs[i].mandatory = gen.opts.OptionalPointers && tlvs[i].Tag() < 0x10
+			tlvs[i].recorded = !gen.opts.OptionalPointers && tlvs[i].accessed()
+		}
+	}
+	return nil
+}
+
+// accessed tells whether -presence-accessors generates a GetX() for the
+// output TLV, and String() can tell it absent: those from 0x10 decoding
+// into a field of their own
+func (qt *QMITLV) accessed() bool {
+	return qt.Name != "" && !qt.Repeatable && qt.Tag() >= 0x10
+}
+
+// genPresenceAccessor reports an optional output TLV along with whether the
+// message carried it, from the pointer with -optional-pointers and from the
+// present field otherwise
+//
+//	func (msg *WDSGetPacketStatisticsOutput) GetTxBytes() (uint64, bool) {
+//		return msg.TxBytes, msg.present.has(0x19)
+//	}
+func (gen *generator) genPresenceAccessor(typ string, qt QMITLV, field_type ast.Expr) *ast.FuncDecl {
+	field_name := gen.goName(qt.Name)
+	value := &ast.SelectorExpr{X: commonIdent("msg"), Sel: ast.NewIdent(field_name)}
+
+	results := &ast.FieldList{}
+	var stmts []ast.Stmt
+	if star, ok := field_type.(*ast.StarExpr); ok {
+		// if msg.X != nil { v, ok = *msg.X, true }; return
+		results.List = []*ast.Field{
+			&ast.Field{Names: []*ast.Ident{ast.NewIdent("v")}, Type: cloneExpr(star.X)},
+			&ast.Field{Names: []*ast.Ident{commonIdent("ok")}, Type: commonIdent("bool")},
+		}
+		stmts = []ast.Stmt{
+			&ast.IfStmt{
+				Cond: &ast.BinaryExpr{X: value, Op: token.NEQ, Y: commonIdent("nil")},
+				Body: &ast.BlockStmt{
+					List: []ast.Stmt{
+						&ast.AssignStmt{
+							Lhs: []ast.Expr{ast.NewIdent("v"), commonIdent("ok")},
+							Tok: token.ASSIGN,
+							Rhs: []ast.Expr{
+								&ast.StarExpr{X: cloneExpr(value)},
+								commonIdent("true"),
+							},
+						},
+					},
+				},
+			},
+			&ast.ReturnStmt{},
+		}
+	} else {
+		results.List = []*ast.Field{
+			&ast.Field{Type: cloneExpr(field_type)},
+			&ast.Field{Type: commonIdent("bool")},
+		}
+		stmts = []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					value,
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   &ast.SelectorExpr{X: commonIdent("msg"), Sel: commonIdent("present")},
+							Sel: commonIdent("has"),
+						},
+						Args: []ast.Expr{qt.TagLit()},
+					},
+				},
+			},
+		}
+	}
+
+	method := "Get" + field_name
+	gen.docComments["func (msg *"+typ+") "+method+"("] = fmt.Sprintf("%s returns %s and whether the message carried it", method, field_name)
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(typ)},
+				},
+			},
+		},
+		Name: ast.NewIdent(method),
+		Type: &ast.FuncType{Params: &ast.FieldList{}, Results: results},
+		Body: &ast.BlockStmt{List: stmts},
+	}
+}
+
+// outputType is a received message type generated from its output TLVs
+type outputType struct {
+	Type     *ast.GenDecl
+	ReadFrom *ast.FuncDecl
+	WriteTo  *ast.FuncDecl
+	Methods  []ast.Decl // String, RepeatableTLVs and presence accessors if needed
+
+	HasOpResult bool
+}
+
+// genOutputType declares typ with a field per TLV, the TLVsReadFrom
+// decoding them and the TLVsWriteTo encoding them, as shared by message
+// outputs and indications
+func (gen *generator) genOutputType(typ string, tlvs []QMITLV, declaration_order bool) (*outputType, error) {
+	outputs := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent(typ),
+				Type: &ast.StructType{
+					Fields: &ast.FieldList{
+						List: []*ast.Field{},
+					},
+				},
+			},
+		},
+	}
+
+	if gen.opts.RetainRawTLVs {
+		outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
+			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
+			&ast.Field{
+				Type: commonIdent("RawTLVs"),
+			},
+		)
+	}
+
+	has_op_result := false
+	var repeatable []ast.Expr
+	var accessors []ast.Decl
+	var recorded []ast.Expr
+	output_sizes := make([]int, len(tlvs))
+	for i, output := range tlvs {
+		if output.CommonRef == "Operation Result" {
+			has_op_result = true
+		}
+		if output.Repeatable {
+			if output.Name == "" {
+				return nil, fmt.Errorf("repeatable TLV %s needs a name", output.ID)
+			}
+			repeatable = append(repeatable, output.TagLit())
+		}
+		typ, n1, err := gen.parseType(output.ValueField())
+		if err != nil {
+			return nil, err
+		}
+		if output.optional {
+			typ = &ast.StarExpr{X: typ}
+		}
+		output_sizes[i] = n1
+		if gen.opts.PresenceAccessors && output.accessed() {
+			accessors = append(accessors, gen.genPresenceAccessor(
+				outputs.Specs[0].(*ast.TypeSpec).Name.Name, output, typ,
+			))
+		}
+		if output.recorded {
+			recorded = append(recorded, &ast.KeyValueExpr{
+				Key:   &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(gen.goName(output.Name))},
+				Value: output.TagLit(),
+			})
+		}
+		if output.Name != "" {
+			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
+				outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent(gen.goName(output.Name))},
+					Type:  typ,
+				},
+			)
+		} else {
+			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
+				outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
+				&ast.Field{
+					Type: typ,
+				},
+			)
+		}
+	}
+
+	if len(recorded) > 0 {
+		outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List = append(
+			outputs.Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List,
+			&ast.Field{
+				Names: []*ast.Ident{commonIdent("present")},
+				Type:  commonIdent("tlvSet"),
+			},
+		)
+	}
+
+	fun_tlvs_readFrom_out, err := gen.genTLVsReadFrom(ast.NewIdent(typ), tlvs, output_sizes, gen.opts.RetainRawTLVs)
+	if err != nil {
+		return nil, err
+	}
+
+	fun_tlvs_writeTo_out, err := gen.genTLVsWriteTo(ast.NewIdent(typ), tlvs, output_sizes, declaration_order)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &outputType{
+		Type:        outputs,
+		ReadFrom:    fun_tlvs_readFrom_out,
+		WriteTo:     fun_tlvs_writeTo_out,
+		HasOpResult: has_op_result,
+	}
+
+	if len(repeatable) > 0 {
+		out.Methods = append(out.Methods, genRepeatableTLVs(ast.NewIdent(typ), repeatable))
+	}
+
+	fun_string, err := gen.genString(ast.NewIdent(typ), tlvs)
+	if err != nil {
+		return nil, err
+	}
+	out.Methods = append(out.Methods, fun_string)
+	if len(recorded) > 0 {
+		out.Methods = append(out.Methods, genRecordedTLVs(typ, recorded))
+	}
+	out.Methods = append(out.Methods, accessors...)
+
+	return out, nil
+}
+
+// genRecordedTLVs gives String() the present field of typ and the fields of
+// the TLVs it records, by tag
+//
+//	func (msg *DMSGetIDsOutput) recordedTLVs() (tlvSet, map[string]uint8) {
+//		return msg.present, map[string]uint8{"Esn": 0x10, "IMEI": 0x11, "Meid": 0x12}
+//	}
+func genRecordedTLVs(typ string, fields []ast.Expr) *ast.FuncDecl {
+	tags := func() ast.Expr {
+		return &ast.MapType{Key: commonIdent("string"), Value: commonIdent("uint8")}
+	}
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(typ)},
+				},
+			},
+		},
+		Name: commonIdent("recordedTLVs"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: commonIdent("tlvSet")},
+					&ast.Field{Type: tags()},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.SelectorExpr{X: commonIdent("msg"), Sel: commonIdent("present")},
+						&ast.CompositeLit{Type: tags(), Elts: fields},
+					},
+				},
+			},
+		},
+	}
+}
+
+// genTLVsReadFrom decodes the TLVs of typ, storing the raw ones too with
+// -raw-tlvs on received messages
+func (gen *generator) genTLVsReadFrom(typ *ast.Ident, tlvs []QMITLV, sizes []int, raw bool) (*ast.FuncDecl, error) {
+	var tlv_read_stmts []ast.Stmt
+	if len(tlvs) > 0 {
+		tlv_read_stmts = append(
+			tlv_read_stmts,
+			&ast.DeclStmt{
+				Decl: &ast.GenDecl{
+					Tok: token.VAR,
+					Specs: []ast.Spec{
+						&ast.ValueSpec{
+							Names: []*ast.Ident{commonIdent("b")},
+							Type: &ast.StarExpr{
+								X: &ast.SelectorExpr{
+									X:   commonIdent("bytes"),
+									Sel: commonIdent("Buffer"),
+								},
+							},
+						},
+					},
+				},
+			},
+		)
+	}
+
+	if raw {
+		tlv_read_stmts = append(
+			tlv_read_stmts,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{
+					&ast.SelectorExpr{
+						X:   commonIdent("msg"),
+						Sel: commonIdent("RawTLVs"),
+					},
+				},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun:  commonIdent("indexTLVs"),
+						Args: []ast.Expr{commonIdent("r")},
+					},
+				},
+			},
+		)
+	}
+
+	for i, tlv := range tlvs {
+		read_stmts, err := tlv.GenReadFrom(gen, commonIdent("msg"), sizes[i])
+		if err != nil {
+			return nil, err
+		}
+		tlv_read_stmts = append(
+			tlv_read_stmts,
+			read_stmts...,
+		)
+	}
+
+	// a truncated TLV is skipped by findTag, report it once the
+	// intact ones are decoded
+	tlv_read_stmts = append(
+		tlv_read_stmts,
+		&ast.ReturnStmt{
+			Results: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  commonIdent("checkTLVs"),
+					Args: []ast.Expr{commonIdent("r")},
+				},
+			},
+		},
+	)
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type: &ast.StarExpr{
+						X: typ,
+					},
+				},
+			},
+		},
+		Name: commonIdent("TLVsReadFrom"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("r")},
+						Type: &ast.StarExpr{
+							X: &ast.SelectorExpr{
+								X:   commonIdent("bytes"),
+								Sel: commonIdent("Buffer"),
+							},
+						},
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("err")},
+						Type:  commonIdent("error"),
+					},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: tlv_read_stmts,
+		},
+	}, nil
+}
+
+// genTLVsWriteTo encodes the TLVs of typ, in tag order unless the message
+// asks for declaration order
+func (gen *generator) genTLVsWriteTo(typ *ast.Ident, tlvs []QMITLV, sizes []int, declaration_order bool) (*ast.FuncDecl, error) {
+	// each TLV of a variable size is staged in a buffer of its own,
+	// buf_<name>, to learn its length
+	var tlv_write_stmts []ast.Stmt
+
+	order := make([]int, len(tlvs))
+	for i := range order {
+		order[i] = i
+	}
+	if !declaration_order {
+		sort.SliceStable(order, func(i, j int) bool {
+			return tlvs[order[i]].Tag() < tlvs[order[j]].Tag()
+		})
+	}
+
+	for _, i := range order {
+		tlv := tlvs[i]
+		var write_stmts []ast.Stmt
+		var err error
+		if tlv.Repeatable {
+			write_stmts, err = tlv.genWriteRepeated(gen, commonIdent("msg"))
+		} else {
+			write_stmts, err = tlv.GenWriteTo(gen, commonIdent("msg"), sizes[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+		tlv_write_stmts = append(
+			tlv_write_stmts,
+			write_stmts...,
+		)
+	}
+	tlv_write_stmts = append(declareScalar(tlv_write_stmts), &ast.ReturnStmt{
+		Results: []ast.Expr{
+			commonIdent("nil"),
+		},
+	})
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: typ},
+				},
+			},
+		},
+		Name: commonIdent("TLVsWriteTo"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("w")},
+						Type: &ast.SelectorExpr{
+							X:   commonIdent("io"),
+							Sel: commonIdent("Writer"),
+						},
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("err")},
+						Type:  commonIdent("error"),
+					},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: tlv_write_stmts,
+		},
+	}, nil
+}
+
+// Register generates an Output-style type for an unsolicited message. It
+// implements Message, MessageID being the indication ID, so Subscribe
+// delivers it.
+func (qi *QMIIndication) Register(gen *generator, f *ast.File) error {
+	err := gen.prepareTLVs(qi.Output, true)
+	if err == nil {
+		err = gen.prepareScales(qi.Service+gen.goName(qi.Name)+"Indication", qi.Output)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", qi.Name, err)
+	}
+
+	out, err := gen.genOutputType(qi.Service+gen.goName(qi.Name)+"Indication", qi.Output, false)
+	if err != nil {
+		return fmt.Errorf("%s: %w", qi.Name, err)
+	}
+	out.Type.TokPos = f.Pos() - 1
+	typ := out.Type.Specs[0].(*ast.TypeSpec).Name.Name
+
+	f.Decls = append(
+		f.Decls,
+		out.Type,
+		genConstMethod(typ, "ServiceID", "Service", ast.NewIdent("QMI_SERVICE_"+qi.Service)),
+		genConstMethod(typ, "MessageID", "uint16", idLit(qi.id)),
+		genConstMethod(typ, "IndicationID", "uint16", idLit(qi.id)),
+		out.ReadFrom,
+		out.WriteTo,
+	)
+	f.Decls = append(f.Decls, out.Methods...)
+
+	gen.addAlias(f, qi.Service, qi.Name, "Indication")
+	gen.docRenamedFields(typ, qi.Output)
+	return nil
+}
+
+func (qt *QMITLV) GenTypeDecl(gen *generator) (*ast.GenDecl, int, error) {
+	n := 0
+	fieldList := []*ast.Field{}
+
+	err := qt.QMITLVField.prepareScale(gen, "QMIStruct")
+	if err != nil {
+		return nil, 0, err
+	}
+
+	for _, field := range qt.Contents {
+		typ, n1, err := gen.parseType(field)
+		if err != nil {
+			return nil, 0, err
+		}
+		if len(field.Prerequisites) > 0 {
+			n1 = -1
+		}
+		sfield := &ast.Field{
+			Type: typ,
+		}
+		// a common-ref without a name is embedded
+		if field.Name != "" || field.CommonRef == "" {
+			sfield.Names = []*ast.Ident{
+				ast.NewIdent(gen.goName(field.Name)),
+			}
+		}
+		fieldList = append(fieldList, sfield)
+		if n != -1 {
+			if n1 == -1 {
+				n = -1
+			} else {
+				n += n1
+			}
+		}
+	}
+
+	if len(qt.Contents) == 0 {
+		typ, n1, err := gen.parseType((*qt).QMITLVField)
+		if err != nil {
+			return nil, 0, err
+		}
+		n = n1
+		field := &ast.Field{
+			Type: typ,
+		}
+		if qt.Name != "" {
+			field.Names = []*ast.Ident{
+				ast.NewIdent(gen.goName(qt.Name)),
+			}
+		}
+		fieldList = append(fieldList, field)
+	}
+
+	if qt.common {
+		gen.reg.setSize(qt.Name, n)
+	}
+
+	t := &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name: ast.NewIdent("QMIStruct" + gen.goName(qt.Name)),
+				Type: &ast.StructType{
+					Fields: &ast.FieldList{
+						List: fieldList,
+					},
+				},
+			},
+		},
+	}
+
+	return t, n, nil
+}
+
+// SizePrefixType is the type of the element count preceding an array
+func (field *QMITLVField) SizePrefixType() (ast.Expr, error) {
+	switch field.SizePrefixFormat {
+	case "", "guint8":
+		return commonIdent("uint8"), nil
+	case "guint16":
+		return commonIdent("uint16"), nil
+	default:
+		return nil, fmt.Errorf("size prefix format %q is unsupported", field.SizePrefixFormat)
+	}
+}
+
+// GenReadFromValue reads a scalar or string value. A string spans the rest
+// of the TLV only when it is the whole TLV value, inside structs and
+// sequences it is bounded by a length prefix or its fixed size.
+func (field *QMITLVField) GenReadFromValue(gen *generator, value ast.Expr, in_record bool) ([]ast.Stmt, error) {
+	switch format := strings.TrimPrefix(field.Format, "g"); format {
+	case "int8", "uint8", "byte", "int16", "uint16", "int32", "uint32", "int64", "uint64", "float", "double", "boolean":
+		order, err := field.ByteOrder()
+		if err != nil {
+			return nil, err
+		}
+		typ, _, err := gen.parseType(*field)
+		if err != nil {
+			return nil, err
+		}
+		return gen.genReadScalar(value, typ, order, format), nil
+	case "string":
+		stmts, err := field.genReadFromString(gen, value, in_record)
+		if err != nil {
+			return nil, err
+		}
+		decode, err := field.genDecodeString(gen, value)
+		if err != nil {
+			return nil, err
+		}
+		return append(stmts, decode...), nil
+	default:
+		return nil, fmt.Errorf("format %q is unsupported", field.Format)
+	}
+}
+
+// genReadFromString reads the bytes of a string as they are
+func (field *QMITLVField) genReadFromString(gen *generator, value ast.Expr, in_record bool) ([]ast.Stmt, error) {
+	if field.FixedSize > 0 {
+		return genReadString(value, commonIdent("readFixedString"), &ast.BasicLit{
+			Kind:  token.INT,
+			Value: strconv.Itoa(field.FixedSize),
+		}), nil
+	}
+	encoding, err := field.StringLayout(in_record)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case encoding == "prefixed":
+		return field.GenReadFromPrefixedString(gen, value)
+	case encoding == "nul-terminated" && in_record:
+		// s, err = b.ReadString(0); s = s[:len(s)-1]
+		return []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value), commonIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   commonIdent("b"),
+							Sel: commonIdent("ReadString"),
+						},
+						Args: []ast.Expr{
+							&ast.BasicLit{Kind: token.INT, Value: "0"},
+						},
+					},
+				},
+			},
+			handleErr(),
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.SliceExpr{
+						X: cloneExpr(value),
+						High: &ast.BinaryExpr{
+							X: &ast.CallExpr{
+								Fun:  commonIdent("len"),
+								Args: []ast.Expr{cloneExpr(value)},
+							},
+							Op: token.SUB,
+							Y:  &ast.BasicLit{Kind: token.INT, Value: "1"},
+						},
+					},
+				},
+			},
+		}, nil
+	case encoding == "nul-terminated":
+		// the terminator is optional at the end of the TLV
+		return []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: commonIdent("string"),
+						Args: []ast.Expr{
+							&ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X:   commonIdent("bytes"),
+									Sel: commonIdent("TrimSuffix"),
+								},
+								Args: []ast.Expr{
+									&ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   commonIdent("b"),
+											Sel: commonIdent("Bytes"),
+										},
+									},
+									&ast.CompositeLit{
+										Type: &ast.ArrayType{Elt: commonIdent("byte")},
+										Elts: []ast.Expr{
+											&ast.BasicLit{Kind: token.INT, Value: "0"},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	case in_record:
+		// an unprefixed string ends its record
+		return []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: commonIdent("string"),
+						Args: []ast.Expr{
+							&ast.CallExpr{
+								Fun: &ast.SelectorExpr{
+									X:   commonIdent("b"),
+									Sel: commonIdent("Next"),
+								},
+								Args: []ast.Expr{
+									&ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   commonIdent("b"),
+											Sel: commonIdent("Len"),
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		}, nil
+	}
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{
+				cloneExpr(value),
+			},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   commonIdent("b"),
+						Sel: commonIdent("String"),
+					},
+					Args: []ast.Expr{},
+				},
+			},
+		},
+	}, nil
+}
+
+func littleEndian() ast.Expr {
+	return &ast.SelectorExpr{
+		X:   commonIdent("binary"),
+		Sel: commonIdent("LittleEndian"),
+	}
+}
+
+// ByteOrder selects the encoding of a scalar value, size prefixes are
+// always little endian
+func (field *QMITLVField) ByteOrder() (ast.Expr, error) {
+	switch field.Endian {
+	case "", "little":
+		return littleEndian(), nil
+	case "big":
+		return &ast.SelectorExpr{
+			X:   commonIdent("binary"),
+			Sel: commonIdent("BigEndian"),
+		}, nil
+	default:
+		return nil, fmt.Errorf("endian %q is unsupported", field.Endian)
+	}
+}
+
+// genBinaryRead emits err = binary.Read(b, order, &value)
+func genBinaryRead(value ast.Expr, order ast.Expr) ast.Stmt {
+	return &ast.AssignStmt{
+		Lhs: []ast.Expr{commonIdent("err")},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   commonIdent("binary"),
+					Sel: commonIdent("Read"),
+				},
+				Args: []ast.Expr{
+					commonIdent("b"),
+					order,
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X:  cloneExpr(value),
+					},
+				},
+			},
+		},
+	}
+}
+
+// directUints are the unsigned types DirectEncoding encodes the integer
+// formats through; floats and booleans stay with encoding/binary
+var directUints = map[string]string{
+	"byte":   "uint8",
+	"int8":   "uint8",
+	"uint8":  "uint8",
+	"int16":  "uint16",
+	"uint16": "uint16",
+	"int32":  "uint32",
+	"uint32": "uint32",
+	"int64":  "uint64",
+	"uint64": "uint64",
+}
+
+// directOrder is order for DirectEncoding: little-endian fields go
+// through leOrder, which byteOrderFiles declares per host byte order
+func directOrder(order ast.Expr) ast.Expr {
+	if sel, ok := order.(*ast.SelectorExpr); ok && sel.Sel.Name == "LittleEndian" {
+		return commonIdent("leOrder")
+	}
+	return order
+}
+
+// genReadScalar emits err = binary.Read(b, order, &value) or, with
+// DirectEncoding and an integer format, takes its bytes off b
+//
+//	if b.Len() < 2 {
+//		err = io.ErrUnexpectedEOF
+//		return
+//	}
+//	value = typ(leOrder.Uint16(b.Next(2)))
+func (gen *generator) genReadScalar(value ast.Expr, typ ast.Expr, order ast.Expr, format string) []ast.Stmt {
+	uint_type, ok := directUints[format]
+	if !gen.opts.DirectEncoding || !ok {
+		return []ast.Stmt{
+			genBinaryRead(value, order),
+			handleErr(),
+		}
+	}
+
+	size := &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(CommonSize[uint_type])}
+	var decoded ast.Expr = &ast.CallExpr{
+		Fun: &ast.SelectorExpr{
+			X:   commonIdent("b"),
+			Sel: commonIdent("Next"),
+		},
+		Args: []ast.Expr{size},
+	}
+	if uint_type == "uint8" {
+		decoded = &ast.IndexExpr{
+			X:     decoded,
+			Index: &ast.BasicLit{Kind: token.INT, Value: "0"},
+		}
+	} else {
+		decoded = &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   directOrder(order),
+				Sel: commonIdent("Uint" + strings.TrimPrefix(uint_type, "uint")),
+			},
+			Args: []ast.Expr{decoded},
+		}
+	}
+	if t, ok := typ.(*ast.Ident); !ok || t.Name != uint_type && !(uint_type == "uint8" && t.Name == "byte") {
+		decoded = &ast.CallExpr{
+			Fun:  cloneExpr(typ),
+			Args: []ast.Expr{decoded},
+		}
+	}
+
+	return []ast.Stmt{
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   commonIdent("b"),
+						Sel: commonIdent("Len"),
+					},
+				},
+				Op: token.LSS,
+				Y:  cloneExpr(size),
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{commonIdent("err")},
+						Tok: token.ASSIGN,
+						Rhs: []ast.Expr{
+							&ast.SelectorExpr{
+								X:   commonIdent("io"),
+								Sel: commonIdent("ErrUnexpectedEOF"),
+							},
+						},
+					},
+					&ast.ReturnStmt{},
+				},
+			},
+		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{cloneExpr(value)},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{decoded},
+		},
+	}
+}
+
+// genWriteScalar emits err = binary.Write(writer, order, value) or, with
+// DirectEncoding and an integer format, encodes it into scalar, the buffer
+// declareScalar gives the write function
+//
+//	leOrder.PutUint16(scalar[:], uint16(value))
+//	_, err = writer.Write(scalar[:2])
+func (gen *generator) genWriteScalar(value ast.Expr, writer ast.Expr, order ast.Expr, format string) []ast.Stmt {
+	uint_type, ok := directUints[format]
+	if !gen.opts.DirectEncoding || !ok {
+		return []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{commonIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   commonIdent("binary"),
+							Sel: commonIdent("Write"),
+						},
+						Args: []ast.Expr{
+							cloneExpr(writer),
+							order,
+							cloneExpr(value),
+						},
+					},
+				},
+			},
+			handleErr(),
+		}
+	}
+
+	// conversions the caller spelled already are not repeated
+	if call, ok := value.(*ast.CallExpr); !ok || !isIdent(call.Fun, uint_type) {
+		value = &ast.CallExpr{
+			Fun:  commonIdent(uint_type),
+			Args: []ast.Expr{cloneExpr(value)},
+		}
+	}
+
+	return append([]ast.Stmt{
+		genPutScalar(0, order, uint_type, value),
+	}, genWriteScalarBuf(writer, CommonSize[uint_type])...)
+}
+
+// genPutScalar emits the encoding of value of uint_type into scalar at off
+func genPutScalar(off int, order ast.Expr, uint_type string, value ast.Expr) ast.Stmt {
+	at := &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(off)}
+	if uint_type == "uint8" {
+		return &ast.AssignStmt{
+			Lhs: []ast.Expr{
+				&ast.IndexExpr{
+					X:     commonIdent("scalar"),
+					Index: at,
+				},
+			},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{cloneExpr(value)},
+		}
+	}
+
+	var low ast.Expr
+	if off > 0 {
+		low = at
+	}
+	return &ast.ExprStmt{
+		X: &ast.CallExpr{
+			Fun: &ast.SelectorExpr{
+				X:   directOrder(order),
+				Sel: commonIdent("PutUint" + strings.TrimPrefix(uint_type, "uint")),
+			},
+			Args: []ast.Expr{
+				&ast.SliceExpr{
+					X:   commonIdent("scalar"),
+					Low: low,
+				},
+				cloneExpr(value),
+			},
+		},
+	}
+}
+
+// genWriteScalarBuf emits _, err = writer.Write(scalar[:n])
+func genWriteScalarBuf(writer ast.Expr, n int) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{commonIdent("_"), commonIdent("err")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   cloneExpr(writer),
+						Sel: commonIdent("Write"),
+					},
+					Args: []ast.Expr{
+						&ast.SliceExpr{
+							X:    commonIdent("scalar"),
+							High: &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(n)},
+						},
+					},
+				},
+			},
+		},
+		handleErr(),
+	}
+}
+
+// isIdent reports whether n is the identifier name
+func isIdent(n ast.Node, name string) bool {
+	ident, ok := n.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+// declareScalar prepends var scalar [8]byte to the statements of a write
+// function which genWriteScalar encoded into it
+func declareScalar(stmts []ast.Stmt) []ast.Stmt {
+	used := false
+	for _, stmt := range stmts {
+		ast.Inspect(stmt, func(n ast.Node) bool {
+			used = used || isIdent(n, "scalar")
+			return !used
+		})
+	}
+	if !used {
+		return stmts
+	}
+
+	return append([]ast.Stmt{
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{commonIdent("scalar")},
+						Type: &ast.ArrayType{
+							Len: &ast.BasicLit{Kind: token.INT, Value: "8"},
+							Elt: commonIdent("byte"),
+						},
+					},
+				},
+			},
+		},
+	}, stmts...)
+}
+
+// stringPolicies map -string-policy to the runtime function applied to
+// decoded strings
+var stringPolicies = map[string]string{
+	"":        "",
+	"replace": "replaceInvalid",
+	"escape":  "escapeInvalid",
+	"strict":  "rejectInvalid",
+}
+
+// stringPublicFormat checks the public-format of a string, "gsm7" for the
+// GSM default alphabet is the only one
+func (field *QMITLVField) stringPublicFormat() (string, error) {
+	switch field.PublicFormat {
+	case "", "gsm7":
+		return field.PublicFormat, nil
+	default:
+		return "", fmt.Errorf("public-format %q of a string is unsupported", field.PublicFormat)
+	}
+}
+
+// genDecodeString converts a string read as is to UTF-8:
+//
+//	value = decodeGSM7(value)
+//	value, err = replaceInvalid(value)
+func (field *QMITLVField) genDecodeString(gen *generator, value ast.Expr) ([]ast.Stmt, error) {
+	public_format, err := field.stringPublicFormat()
+	if err != nil {
+		return nil, err
+	}
+
+	var stmts []ast.Stmt
+	if public_format == "gsm7" {
+		stmts = append(stmts, &ast.AssignStmt{
+			Lhs: []ast.Expr{cloneExpr(value)},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  commonIdent("decodeGSM7"),
+					Args: []ast.Expr{cloneExpr(value)},
+				},
+			},
+		})
+	}
+	if policy := stringPolicies[gen.opts.StringPolicy]; policy != "" {
+		stmts = append(stmts,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value), commonIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun:  commonIdent(policy),
+						Args: []ast.Expr{cloneExpr(value)},
+					},
+				},
 			},
-			Type: typ,
-		})
-		if n != -1 {
-			if n1 == -1 {
-				n = -1
-			} else {
-				n += n1
-			}
-		}
+			handleErr(),
+		)
 	}
+	return stmts, nil
+}
 
-	if len(qt.Contents) == 0 {
-		typ, n1, err := parseType((*qt).QMITLVField)
-		if err != nil {
-			return nil, 0, err
-		}
-		n = n1
-		field := &ast.Field{
-			Type: typ,
-		}
-		if qt.Name != "" {
-			field.Names = []*ast.Ident{
-				ast.NewIdent(name.CamelCase(qt.Name, true)),
-			}
+// StringLayout resolves the "string-encoding" of a string which is not
+// fixed-size. By default strings inside records and those with a
+// size-prefix-format are prefixed, others span the rest of the TLV.
+func (field *QMITLVField) StringLayout(in_record bool) (string, error) {
+	switch field.StringEncoding {
+	case "":
+		if in_record || field.SizePrefixFormat != "" {
+			return "prefixed", nil
 		}
-		fieldList = append(fieldList, field)
+		return "none", nil
+	case "none", "prefixed", "nul-terminated":
+		return field.StringEncoding, nil
+	default:
+		return "", fmt.Errorf("string encoding %q is unsupported", field.StringEncoding)
 	}
+}
 
-	CommonSize[qt.Name] = n
+// GenReadFromPrefixedString reads a string preceded by its length, as
+// strings are encoded inside records
+func (field *QMITLVField) GenReadFromPrefixedString(gen *generator, value ast.Expr) ([]ast.Stmt, error) {
+	length := "l_" + name.SnakeCase(field.Name)
+	length_type, err := field.SizePrefixType()
+	if err != nil {
+		return nil, err
+	}
 
-	t := &ast.GenDecl{
-		Tok: token.TYPE,
-		Specs: []ast.Spec{
-			&ast.TypeSpec{
-				Name: ast.NewIdent("QMIStruct" + name.CamelCase(qt.Name, true)),
-				Type: &ast.StructType{
-					Fields: &ast.FieldList{
-						List: fieldList,
+	return append([]ast.Stmt{
+		&ast.DeclStmt{
+			Decl: &ast.GenDecl{
+				Tok: token.VAR,
+				Specs: []ast.Spec{
+					&ast.ValueSpec{
+						Names: []*ast.Ident{ast.NewIdent(length)},
+						Type:  length_type,
 					},
 				},
 			},
 		},
-	}
+	}, append(
+		gen.genReadScalar(ast.NewIdent(length), length_type, littleEndian(), length_type.(*ast.Ident).Name),
+		genReadString(value, commonIdent("readString"), &ast.CallExpr{
+			Fun:  commonIdent("int"),
+			Args: []ast.Expr{ast.NewIdent(length)},
+		})...,
+	)...), nil
+}
 
-	return t, n, nil
+// genReadString emits value, err = read(b, n) for readString and
+// readFixedString, which fail on short payloads
+func genReadString(value ast.Expr, read *ast.Ident, n ast.Expr) []ast.Stmt {
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{cloneExpr(value), commonIdent("err")},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  cloneExpr(read),
+					Args: []ast.Expr{commonIdent("b"), cloneExpr(n)},
+				},
+			},
+		},
+		handleErr(),
+	}
 }
 
-func (field *QMITLVField) GenReadFromPayload(parent ast.Expr) ([]ast.Stmt, error) {
-	ident := ast.NewIdent(name.CamelCase(field.Name, true))
+func (field *QMITLVField) GenReadFromPayload(gen *generator, parent ast.Expr, in_record bool) ([]ast.Stmt, error) {
+	field_name := gen.goName(field.Name)
 	switch strings.TrimPrefix(field.Format, "g") {
-	case "", "array":
-		// TODO
-		return []ast.Stmt{}, nil
+	case "":
+		if field.CommonRef == "" {
+			return []ast.Stmt{}, nil
+		}
+		common, err := field.ResolveCommonRef(gen)
+		if err != nil {
+			return nil, err
+		}
+		parent = field.CommonRefValue(gen, parent)
+		if len(common.Contents) == 0 {
+			return common.GenReadFromPayload(gen, parent, in_record)
+		}
+		return gen.genContents(parent, common.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+			return sub_field.GenReadFromPayload(gen, parent, true)
+		})
 	case "uint-sized":
-		buf_name := ast.NewIdent("buf_" + name.SnakeCase(field.Name))
+		buf_name := "buf_" + name.SnakeCase(field.Name)
 		return []ast.Stmt{
 			&ast.AssignStmt{
 				Lhs: []ast.Expr{
-					buf_name,
+					ast.NewIdent(buf_name),
 				},
 				Tok: token.DEFINE,
 				Rhs: []ast.Expr{
 					&ast.CallExpr{
-						Fun: CommonIdents["make"],
+						Fun: commonIdent("make"),
 						Args: []ast.Expr{
 							&ast.ArrayType{
-								Elt: CommonIdents["byte"],
+								Elt: commonIdent("byte"),
 							},
 							&ast.BasicLit{
 								Kind:  token.INT,
@@ -814,276 +2936,605 @@ func (field *QMITLVField) GenReadFromPayload(parent ast.Expr) ([]ast.Stmt, error
 					},
 				},
 			},
-			&ast.ExprStmt{
-				X: &ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   CommonIdents["r"],
-						Sel: CommonIdents["Read"],
-					},
-					Args: []ast.Expr{
-						buf_name,
+			// _, err = io.ReadFull(b, buf_x)
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent("_"), commonIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   commonIdent("io"),
+							Sel: commonIdent("ReadFull"),
+						},
+						Args: []ast.Expr{
+							commonIdent("b"),
+							ast.NewIdent(buf_name),
+						},
 					},
 				},
 			},
+			handleErr(),
 			&ast.AssignStmt{
 				Lhs: []ast.Expr{
 					&ast.SelectorExpr{
-						X:   parent,
-						Sel: ident,
+						X:   cloneExpr(parent),
+						Sel: ast.NewIdent(field_name),
 					},
 				},
 				Tok: token.ASSIGN,
 				Rhs: []ast.Expr{
-					buf_name,
+					ast.NewIdent(buf_name),
 				},
 			},
 		}, nil
 
-	case "int8", "uint8", "byte", "int16", "uint16", "int32", "uint32", "uint64":
-		return []ast.Stmt{
-			&ast.ExprStmt{
-				X: &ast.CallExpr{
-					Fun: &ast.SelectorExpr{
-						X:   CommonIdents["binary"],
-						Sel: CommonIdents["Read"],
-					},
-					Args: []ast.Expr{
-						CommonIdents["b"],
-						&ast.SelectorExpr{
-							X:   CommonIdents["binary"],
-							Sel: CommonIdents["LittleEndian"],
-						},
-						&ast.UnaryExpr{
-							Op: token.AND,
-							X: &ast.SelectorExpr{
-								X:   parent,
-								Sel: ident,
-							},
+	case "int8", "uint8", "byte", "int16", "uint16", "int32", "uint32", "int64", "uint64", "float", "double", "boolean", "string":
+		return field.GenReadFromValue(gen, &ast.SelectorExpr{
+			X:   cloneExpr(parent),
+			Sel: ast.NewIdent(field_name),
+		}, in_record)
+	case "array":
+		slice := &ast.SelectorExpr{
+			X:   cloneExpr(parent),
+			Sel: ast.NewIdent(field_name),
+		}
+		count := "n_" + name.SnakeCase(field.Name)
+		index := "i_" + name.SnakeCase(field.Name)
+		elem := &ast.IndexExpr{
+			X:     cloneExpr(slice),
+			Index: ast.NewIdent(index),
+		}
+
+		count_type, err := field.SizePrefixType()
+		if err != nil {
+			return nil, err
+		}
+
+		elem_type, _, err := gen.parseType(*field.ArrayElement)
+		if err != nil {
+			return nil, err
+		}
+
+		var elem_stmts []ast.Stmt
+		switch field.ArrayElement.Format {
+		case "struct", "sequence":
+			elem_stmts, err = gen.genContents(elem, field.ArrayElement.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+				return sub_field.GenReadFromPayload(gen, elem, true)
+			})
+			if err != nil {
+				return nil, err
+			}
+		default:
+			elem_stmts, err = field.ArrayElement.GenReadFromValue(gen, elem, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		read_elems := &ast.RangeStmt{
+			Key: ast.NewIdent(index),
+			Tok: token.DEFINE,
+			X:   cloneExpr(slice),
+			Body: &ast.BlockStmt{
+				List: elem_stmts,
+			},
+		}
+
+		if field.FixedSize > 0 {
+			return []ast.Stmt{read_elems}, nil
+		}
+
+		read_count := gen.genReadScalar(ast.NewIdent(count), count_type, littleEndian(), count_type.(*ast.Ident).Name)
+		return append(append([]ast.Stmt{
+			&ast.DeclStmt{
+				Decl: &ast.GenDecl{
+					Tok: token.VAR,
+					Specs: []ast.Spec{
+						&ast.ValueSpec{
+							Names: []*ast.Ident{ast.NewIdent(count)},
+							Type:  count_type,
 						},
 					},
 				},
 			},
-		}, nil
-	case "string":
-		return []ast.Stmt{
+		}, read_count...),
 			&ast.AssignStmt{
-				Lhs: []ast.Expr{
-					&ast.SelectorExpr{
-						X:   parent,
-						Sel: ident,
-					},
-				},
+				Lhs: []ast.Expr{cloneExpr(slice)},
 				Tok: token.ASSIGN,
 				Rhs: []ast.Expr{
 					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   CommonIdents["b"],
-							Sel: CommonIdents["String"],
+						Fun: commonIdent("make"),
+						Args: []ast.Expr{
+							&ast.ArrayType{Elt: elem_type},
+							ast.NewIdent(count),
 						},
-						Args: []ast.Expr{},
 					},
 				},
 			},
-		}, nil
-	case "sequence":
-		var stmts []ast.Stmt
-		if _, ok := CommonRefs[field.Name]; !ok {
+			read_elems,
+		), nil
+	case "sequence", "struct":
+		if _, ok := gen.reg.Ref(field.Name); !ok {
 			parent = &ast.SelectorExpr{
-				X:   parent,
-				Sel: ident,
+				X:   cloneExpr(parent),
+				Sel: ast.NewIdent(field_name),
 			}
 		}
-		for _, sub_field := range field.Contents {
-			field_stmts, err := sub_field.GenReadFromPayload(parent)
-			if err != nil {
-				return nil, err
-			}
-			stmts = append(stmts, field_stmts...)
+		return gen.genContents(parent, field.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+			return sub_field.GenReadFromPayload(gen, parent, true)
+		})
+	default:
+		return nil, fmt.Errorf("format %q is unsupported", field.Format)
+	}
+}
+
+// GenWriteToValue writes a scalar or string value
+func (field *QMITLVField) GenWriteToValue(gen *generator, value ast.Expr, writer ast.Expr, in_record bool) ([]ast.Stmt, error) {
+	switch format := strings.TrimPrefix(field.Format, "g"); format {
+	case "byte", "int8", "uint8", "uint16", "uint32", "uint64", "int16", "int32", "int64", "float", "double", "boolean":
+		order, err := field.ByteOrder()
+		if err != nil {
+			return nil, err
 		}
-		return stmts, nil
-	case "struct":
+		return gen.genWriteScalar(value, writer, order, format), nil
+	case "string":
 		var stmts []ast.Stmt
-		if _, ok := CommonRefs[field.Name]; !ok {
-			parent = &ast.SelectorExpr{
-				X:   parent,
-				Sel: ident,
+		public_format, err := field.stringPublicFormat()
+		if err != nil {
+			return nil, err
+		}
+		if public_format == "gsm7" {
+			// gsm_name := encodeGSM7(value)
+			septets := "gsm_" + name.SnakeCase(field.Name)
+			stmts = []ast.Stmt{
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent(septets)},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{
+							Fun:  commonIdent("encodeGSM7"),
+							Args: []ast.Expr{cloneExpr(value)},
+						},
+					},
+				},
 			}
+			value = ast.NewIdent(septets)
 		}
-		for _, field := range field.Contents {
-			field_stmts, err := field.GenReadFromPayload(parent)
+		if field.FixedSize > 0 {
+			// zero padded to the fixed size
+			padded := "s_" + name.SnakeCase(field.Name)
+			stmts = append(stmts,
+				&ast.AssignStmt{
+					Lhs: []ast.Expr{ast.NewIdent(padded)},
+					Tok: token.DEFINE,
+					Rhs: []ast.Expr{
+						&ast.CallExpr{
+							Fun: commonIdent("make"),
+							Args: []ast.Expr{
+								&ast.ArrayType{
+									Elt: commonIdent("byte"),
+								},
+								&ast.BasicLit{
+									Kind:  token.INT,
+									Value: strconv.Itoa(field.FixedSize),
+								},
+							},
+						},
+					},
+				},
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun:  commonIdent("copy"),
+						Args: []ast.Expr{ast.NewIdent(padded), cloneExpr(value)},
+					},
+				},
+			)
+			value = ast.NewIdent(padded)
+		} else if encoding, err := field.StringLayout(in_record); err != nil {
+			return nil, err
+		} else if encoding == "nul-terminated" {
+			value = &ast.BinaryExpr{
+				X:  cloneExpr(value),
+				Op: token.ADD,
+				Y: &ast.BasicLit{
+					Kind:  token.STRING,
+					Value: strconv.Quote("\x00"),
+				},
+			}
+		} else if encoding == "prefixed" {
+			count_type, err := field.SizePrefixType()
 			if err != nil {
 				return nil, err
 			}
-			stmts = append(stmts, field_stmts...)
+			stmts = append(stmts, gen.genWriteScalar(&ast.CallExpr{
+				Fun: count_type,
+				Args: []ast.Expr{
+					&ast.CallExpr{
+						Fun:  commonIdent("len"),
+						Args: []ast.Expr{cloneExpr(value)},
+					},
+				},
+			}, writer, littleEndian(), count_type.(*ast.Ident).Name)...)
+		}
+		var data ast.Expr = &ast.CallExpr{
+			Fun: &ast.ArrayType{
+				Elt: commonIdent("byte"),
+			},
+			Args: []ast.Expr{
+				cloneExpr(value),
+			},
 		}
-		return stmts, nil
+		if field.FixedSize > 0 {
+			data = value
+		}
+		return append(stmts,
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{
+					commonIdent("_"),
+					commonIdent("err"),
+				},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   cloneExpr(writer),
+							Sel: commonIdent("Write"),
+						},
+						Args: []ast.Expr{data},
+					},
+				},
+			},
+			handleErr(),
+		), nil
 	default:
 		return nil, fmt.Errorf("format %q is unsupported", field.Format)
 	}
 }
 
-func (field *QMITLVField) GenWriteToPayload(parent ast.Expr, writer ast.Expr) ([]ast.Stmt, error) {
-	ident := ast.NewIdent(name.CamelCase(field.Name, true))
+func (field *QMITLVField) GenWriteToPayload(gen *generator, parent ast.Expr, writer ast.Expr, in_record bool) ([]ast.Stmt, error) {
+	field_name := gen.goName(field.Name)
 	switch strings.TrimPrefix(field.Format, "g") {
 	case "":
-		// TODO: support common-ref
-		return []ast.Stmt{}, nil
-	case "byte", "int8", "uint8", "uint16", "uint32", "uint64", "int16", "int32":
+		if field.CommonRef == "" {
+			return []ast.Stmt{}, nil
+		}
+		common, err := field.ResolveCommonRef(gen)
+		if err != nil {
+			return nil, err
+		}
+		parent = field.CommonRefValue(gen, parent)
+		if len(common.Contents) == 0 {
+			return common.GenWriteToPayload(gen, parent, writer, in_record)
+		}
+		return gen.genContents(parent, common.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+			return sub_field.GenWriteToPayload(gen, parent, writer, true)
+		})
+	case "uint-sized":
+		// zero padded or truncated to the declared size
+		padded := ast.NewIdent("s_" + name.SnakeCase(field.Name))
 		return []ast.Stmt{
 			&ast.AssignStmt{
-				Lhs: []ast.Expr{CommonIdents["err"]},
-				Tok: token.ASSIGN,
+				Lhs: []ast.Expr{cloneExpr(padded)},
+				Tok: token.DEFINE,
 				Rhs: []ast.Expr{
 					&ast.CallExpr{
-						Fun: &ast.SelectorExpr{
-							X:   CommonIdents["binary"],
-							Sel: CommonIdents["Write"],
-						},
+						Fun: commonIdent("make"),
 						Args: []ast.Expr{
-							writer,
-							&ast.SelectorExpr{
-								X:   CommonIdents["binary"],
-								Sel: CommonIdents["LittleEndian"],
+							&ast.ArrayType{
+								Elt: commonIdent("byte"),
 							},
-							&ast.SelectorExpr{
-								X:   parent,
-								Sel: ident,
+							&ast.BasicLit{
+								Kind:  token.INT,
+								Value: strconv.Itoa(field.IntSize),
 							},
 						},
 					},
 				},
 			},
-			handleErr(),
-		}, nil
-	case "string":
-		return []ast.Stmt{
+			&ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun: commonIdent("copy"),
+					Args: []ast.Expr{
+						cloneExpr(padded),
+						&ast.SelectorExpr{
+							X:   cloneExpr(parent),
+							Sel: ast.NewIdent(field_name),
+						},
+					},
+				},
+			},
 			&ast.AssignStmt{
 				Lhs: []ast.Expr{
-					CommonIdents["_"],
-					CommonIdents["err"],
+					commonIdent("_"),
+					commonIdent("err"),
 				},
 				Tok: token.ASSIGN,
 				Rhs: []ast.Expr{
 					&ast.CallExpr{
 						Fun: &ast.SelectorExpr{
-							X:   writer,
-							Sel: CommonIdents["Write"],
-						},
-						Args: []ast.Expr{
-							&ast.CallExpr{
-								Fun: &ast.ArrayType{
-									Elt: CommonIdents["byte"],
-								},
-								Args: []ast.Expr{
-									&ast.SelectorExpr{
-										X:   parent,
-										Sel: ident,
-									},
-								},
-							},
+							X:   cloneExpr(writer),
+							Sel: commonIdent("Write"),
 						},
+						Args: []ast.Expr{cloneExpr(padded)},
 					},
 				},
 			},
 			handleErr(),
 		}, nil
-	case "sequence":
-		var stmts []ast.Stmt
-		if _, ok := CommonRefs[field.Name]; !ok {
+	case "byte", "int8", "uint8", "uint16", "uint32", "uint64", "int16", "int32", "int64", "float", "double", "boolean", "string":
+		return field.GenWriteToValue(
+			gen,
+			&ast.SelectorExpr{
+				X:   cloneExpr(parent),
+				Sel: ast.NewIdent(field_name),
+			},
+			cloneExpr(writer),
+			in_record,
+		)
+	case "sequence", "struct":
+		if _, ok := gen.reg.Ref(field.Name); !ok {
 			parent = &ast.SelectorExpr{
-				X:   parent,
-				Sel: ident,
+				X:   cloneExpr(parent),
+				Sel: ast.NewIdent(field_name),
 			}
 		}
-		for _, field := range field.Contents {
-			field_stmts, err := field.GenWriteToPayload(
-				parent,
-				writer,
-			)
+		return gen.genContents(parent, field.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+			return sub_field.GenWriteToPayload(gen, parent, writer, true)
+		})
+	case "array":
+		slice := &ast.SelectorExpr{
+			X:   cloneExpr(parent),
+			Sel: ast.NewIdent(field_name),
+		}
+		elem := "elem_" + name.SnakeCase(field.Name)
+
+		count_type, err := field.SizePrefixType()
+		if err != nil {
+			return nil, err
+		}
+
+		var elem_stmts []ast.Stmt
+		switch field.ArrayElement.Format {
+		case "struct", "sequence":
+			elem_stmts, err = gen.genContents(ast.NewIdent(elem), field.ArrayElement.Contents, func(sub_field *QMITLVField) ([]ast.Stmt, error) {
+				return sub_field.GenWriteToPayload(gen, ast.NewIdent(elem), writer, true)
+			})
 			if err != nil {
 				return nil, err
 			}
-			stmts = append(stmts, field_stmts...)
-		}
-		return stmts, nil
-	case "struct":
-		var stmts []ast.Stmt
-		if _, ok := CommonRefs[field.Name]; !ok {
-			parent = &ast.SelectorExpr{
-				X:   parent,
-				Sel: ident,
-			}
-		}
-		for _, field := range field.Contents {
-			field_stmts, err := field.GenWriteToPayload(parent, writer)
+		default:
+			field_stmts, err := field.ArrayElement.GenWriteToValue(gen, ast.NewIdent(elem), writer, true)
 			if err != nil {
 				return nil, err
 			}
-			stmts = append(stmts, field_stmts...)
+			elem_stmts = field_stmts
 		}
-		return stmts, nil
-	case "array":
-		return []ast.Stmt{}, nil // TODO
+
+		write_elems := &ast.RangeStmt{
+			Key:   commonIdent("_"),
+			Value: ast.NewIdent(elem),
+			Tok:   token.DEFINE,
+			X:     cloneExpr(slice),
+			Body: &ast.BlockStmt{
+				List: elem_stmts,
+			},
+		}
+
+		if field.FixedSize > 0 {
+			return []ast.Stmt{write_elems}, nil
+		}
+
+		return append(gen.genWriteScalar(&ast.CallExpr{
+			Fun: count_type,
+			Args: []ast.Expr{
+				&ast.CallExpr{
+					Fun:  commonIdent("len"),
+					Args: []ast.Expr{cloneExpr(slice)},
+				},
+			},
+		}, writer, littleEndian(), count_type.(*ast.Ident).Name), write_elems), nil
 	default:
 		return nil, fmt.Errorf("format %q is unsupported", field.Format)
 	}
 }
 
-func (qt *QMITLV) GenReadFrom(parent ast.Expr, n int) ([]ast.Stmt, error) {
-	var stmts []ast.Stmt
-	id := qt.ID
-	if id == "" { // HACK
-		id = "2"
+// ResolveCommonRef returns the shared definition the field refers to
+func (field *QMITLVField) ResolveCommonRef(gen *generator) (*QMITLV, error) {
+	def, ok := gen.commonRef(field.CommonRef)
+	if !ok {
+		return nil, fmt.Errorf("unknown common-ref %q", field.CommonRef)
+	}
+
+	b, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+
+	common := &QMITLV{}
+	err = json.Unmarshal(b, common)
+	if err != nil {
+		return nil, err
+	}
+	return common, nil
+}
+
+// CommonRefValue selects where a common-ref field is stored: its own field
+// when named, the embedded QMIStructX otherwise
+func (field *QMITLVField) CommonRefValue(gen *generator, parent ast.Expr) ast.Expr {
+	sel := "QMIStruct" + gen.goName(field.CommonRef)
+	if field.Name != "" {
+		sel = gen.goName(field.Name)
+	}
+	return &ast.SelectorExpr{
+		X:   cloneExpr(parent),
+		Sel: ast.NewIdent(sel),
 	}
+}
+
+// inheritID takes the id of a common-ref TLV referenced without one
+func (qt *QMITLV) inheritID(gen *generator) error {
+	if qt.ID != "" || qt.CommonRef == "" {
+		return nil
+	}
+	common, err := qt.ResolveCommonRef(gen)
+	if err != nil {
+		return err
+	}
+	qt.ID = common.ID
+	return nil
+}
+
+// Tag is the numeric TLV id, common TLVs without one are the result TLV
+func (qt *QMITLV) Tag() uint64 {
+	tag, err := strconv.ParseUint(strings.TrimSpace(qt.ID), 0, 8)
+	if err != nil {
+		return 2
+	}
+	return tag
+}
+
+// TagLit renders Tag canonically, whichever way the data file spells it
+func (qt *QMITLV) TagLit() *ast.BasicLit {
+	return &ast.BasicLit{
+		Kind:  token.INT,
+		Value: fmt.Sprintf("0x%02X", qt.Tag()),
+	}
+}
+
+func (qt *QMITLV) GenReadFrom(gen *generator, parent ast.Expr, n int) ([]ast.Stmt, error) {
+	var stmts []ast.Stmt
 	stmts = append(
 		stmts,
 		&ast.AssignStmt{
 			Lhs: []ast.Expr{
-				CommonIdents["b"],
+				commonIdent("b"),
 			},
 			Tok: token.ASSIGN,
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
-					Fun: CommonIdents["findTag"],
+					Fun: commonIdent("findTag"),
 					Args: []ast.Expr{
-						CommonIdents["r"],
-						&ast.BasicLit{
-							Kind:  token.INT,
-							Value: id,
-						},
+						commonIdent("r"),
+						qt.TagLit(),
 					},
 				},
 			},
 		},
 	)
-	read_data, err := qt.GenReadFromPayload(parent)
+	read_parent := parent
+	if qt.Repeatable || qt.optional {
+		read_parent = ast.NewIdent("e")
+	}
+	read_data, err := qt.GenReadFromPayload(gen, read_parent, false)
 	if err != nil {
 		return nil, err
 	}
+	if len(read_data) > 0 {
+		// err = decodeTLV(0x11, "Name", func() (err error) { ...; return })
+		tlv_name := qt.Name
+		if tlv_name == "" {
+			tlv_name = qt.CommonRef
+		}
+		read_data = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{commonIdent("err")},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: commonIdent("decodeTLV"),
+						Args: []ast.Expr{
+							qt.TagLit(),
+							&ast.BasicLit{
+								Kind:  token.STRING,
+								Value: strconv.Quote(tlv_name),
+							},
+							&ast.FuncLit{
+								Type: &ast.FuncType{
+									Params: &ast.FieldList{},
+									Results: &ast.FieldList{
+										List: []*ast.Field{
+											&ast.Field{
+												Names: []*ast.Ident{commonIdent("err")},
+												Type:  commonIdent("error"),
+											},
+										},
+									},
+								},
+								Body: &ast.BlockStmt{
+									List: append(read_data, &ast.ReturnStmt{}),
+								},
+							},
+						},
+					},
+				},
+			},
+			handleErr(),
+		}
+	}
+	if qt.recorded {
+		// msg.present.add(0x10)
+		read_data = append(read_data, &ast.ExprStmt{
+			X: &ast.CallExpr{
+				Fun: &ast.SelectorExpr{
+					X:   &ast.SelectorExpr{X: cloneExpr(parent), Sel: commonIdent("present")},
+					Sel: commonIdent("add"),
+				},
+				Args: []ast.Expr{qt.TagLit()},
+			},
+		})
+	}
+	if qt.Repeatable {
+		return qt.genReadRepeated(gen, parent, "e", read_data)
+	}
+	if qt.optional && len(read_data) > 0 {
+		// var e struct{ Name T }; err = decodeTLV(...); msg.Name = &e.Name
+		decl, err := qt.genElemDecl(gen, "e")
+		if err != nil {
+			return nil, err
+		}
+		field_name := gen.goName(qt.Name)
+		read_data = []ast.Stmt{
+			decl,
+			read_data[0],
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{
+					&ast.SelectorExpr{X: cloneExpr(parent), Sel: ast.NewIdent(field_name)},
+				},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X:  &ast.SelectorExpr{X: ast.NewIdent("e"), Sel: ast.NewIdent(field_name)},
+					},
+				},
+			},
+			read_data[1],
+		}
+	}
 	check_b := &ast.IfStmt{
 		Cond: &ast.BinaryExpr{
-			X:  CommonIdents["b"],
+			X:  commonIdent("b"),
 			Op: token.NEQ,
-			Y:  CommonIdents["nil"],
+			Y:  commonIdent("nil"),
 		},
 		Body: &ast.BlockStmt{List: read_data},
 	}
-	if id == "2" {
+	if qt.Tag() == 2 || qt.mandatory {
 		check_b.Else = &ast.BlockStmt{
 			List: []ast.Stmt{
 				&ast.AssignStmt{
-					Lhs: []ast.Expr{CommonIdents["err"]},
+					Lhs: []ast.Expr{commonIdent("err")},
 					Tok: token.ASSIGN,
 					Rhs: []ast.Expr{
 						&ast.CallExpr{
 							Fun: &ast.SelectorExpr{
-								X:   CommonIdents["fmt"],
-								Sel: CommonIdents["Errorf"],
+								X:   commonIdent("fmt"),
+								Sel: commonIdent("Errorf"),
 							},
 							Args: []ast.Expr{
 								&ast.BasicLit{
 									Kind:  token.STRING,
-									Value: `"cannot find tag 2"`,
+									Value: strconv.Quote(fmt.Sprintf("cannot find tag %d", qt.Tag())),
 								},
 							},
 						},
@@ -1100,12 +3551,136 @@ func (qt *QMITLV) GenReadFrom(parent ast.Expr, n int) ([]ast.Stmt, error) {
 	return stmts, nil
 }
 
+// genReadRepeated decodes every instance of a repeatable TLV
+//
+//	for _, b = range findTags(r, 0x11) {
+//		var e struct{ Name T }
+//		...
+//		msg.Name = append(msg.Name, e.Name)
+//	}
+func (qt *QMITLV) genReadRepeated(gen *generator, parent ast.Expr, elem string, read_data []ast.Stmt) ([]ast.Stmt, error) {
+	decl, err := qt.genElemDecl(gen, elem)
+	if err != nil {
+		return nil, err
+	}
+
+	field_name := gen.goName(qt.Name)
+	slice := &ast.SelectorExpr{X: cloneExpr(parent), Sel: ast.NewIdent(field_name)}
+
+	body := append([]ast.Stmt{decl}, read_data...)
+	body = append(body, &ast.AssignStmt{
+		Lhs: []ast.Expr{cloneExpr(slice)},
+		Tok: token.ASSIGN,
+		Rhs: []ast.Expr{
+			&ast.CallExpr{
+				Fun: commonIdent("append"),
+				Args: []ast.Expr{
+					cloneExpr(slice),
+					&ast.SelectorExpr{X: ast.NewIdent(elem), Sel: ast.NewIdent(field_name)},
+				},
+			},
+		},
+	})
+
+	return []ast.Stmt{
+		&ast.RangeStmt{
+			Key:   commonIdent("_"),
+			Value: commonIdent("b"),
+			Tok:   token.ASSIGN,
+			X: &ast.CallExpr{
+				Fun: commonIdent("findTags"),
+				Args: []ast.Expr{
+					commonIdent("r"),
+					qt.TagLit(),
+				},
+			},
+			Body: &ast.BlockStmt{List: body},
+		},
+	}, nil
+}
+
+// genElemStruct is the type of a single TLV value decoded or encoded apart
+// from the message: struct{ Name T }
+func (qt *QMITLV) genElemStruct(gen *generator) (*ast.StructType, error) {
+	typ, _, err := gen.parseType(qt.QMITLVField)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.StructType{
+		Fields: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent(gen.goName(qt.Name))},
+					Type:  typ,
+				},
+			},
+		},
+	}, nil
+}
+
+func (qt *QMITLV) genElemDecl(gen *generator, elem string) (ast.Stmt, error) {
+	typ, err := qt.genElemStruct(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.DeclStmt{
+		Decl: &ast.GenDecl{
+			Tok: token.VAR,
+			Specs: []ast.Spec{
+				&ast.ValueSpec{
+					Names: []*ast.Ident{ast.NewIdent(elem)},
+					Type:  typ,
+				},
+			},
+		},
+	}, nil
+}
+
+// genRepeatableTLVs lists the tags duplicateTLVs must accept
+func genRepeatableTLVs(typ *ast.Ident, tags []ast.Expr) *ast.FuncDecl {
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: cloneExpr(typ)},
+				},
+			},
+		},
+		Name: commonIdent("RepeatableTLVs"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Type: &ast.ArrayType{Elt: commonIdent("uint8")},
+					},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.CompositeLit{
+							Type: &ast.ArrayType{Elt: commonIdent("uint8")},
+							Elts: tags,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func handleErr() ast.Stmt {
 	return &ast.IfStmt{
 		Cond: &ast.BinaryExpr{
-			X:  CommonIdents["err"],
+			X:  commonIdent("err"),
 			Op: token.NEQ,
-			Y:  CommonIdents["nil"],
+			Y:  commonIdent("nil"),
 		},
 		Body: &ast.BlockStmt{
 			List: []ast.Stmt{
@@ -1115,26 +3690,27 @@ func handleErr() ast.Stmt {
 	}
 }
 
-func (qt *QMITLV) GenWriteTo(parent ast.Expr, n int) ([]ast.Stmt, error) {
+func (qt *QMITLV) GenWriteTo(gen *generator, parent ast.Expr, n int) ([]ast.Stmt, error) {
+	if qt.optional {
+		return qt.genWriteOptional(gen, parent, n)
+	}
+
 	write_tag := &ast.AssignStmt{
-		Lhs: []ast.Expr{CommonIdents["_"], CommonIdents["err"]},
+		Lhs: []ast.Expr{commonIdent("_"), commonIdent("err")},
 		Tok: token.ASSIGN,
 		Rhs: []ast.Expr{
 			&ast.CallExpr{
 				Fun: &ast.SelectorExpr{
-					X:   CommonIdents["w"],
-					Sel: CommonIdents["Write"],
+					X:   commonIdent("w"),
+					Sel: commonIdent("Write"),
 				},
 				Args: []ast.Expr{
 					&ast.CompositeLit{
 						Type: &ast.ArrayType{
-							Elt: CommonIdents["byte"],
+							Elt: commonIdent("byte"),
 						},
 						Elts: []ast.Expr{
-							&ast.BasicLit{
-								Kind:  token.INT,
-								Value: qt.ID,
-							},
+							qt.TagLit(),
 						},
 					},
 				},
@@ -1142,27 +3718,33 @@ func (qt *QMITLV) GenWriteTo(parent ast.Expr, n int) ([]ast.Stmt, error) {
 		},
 	}
 	if n >= 0 {
-		write_data, err := qt.GenWriteToPayload(parent, CommonIdents["w"])
+		write_data, err := qt.GenWriteToPayload(gen, parent, commonIdent("w"), false)
 		if err != nil {
 			return nil, err
 		}
+		if gen.opts.DirectEncoding {
+			return append(qt.genWriteHeader(&ast.BasicLit{
+				Kind:  token.INT,
+				Value: strconv.Itoa(n),
+			}), write_data...), nil
+		}
 		write_length := &ast.AssignStmt{
-			Lhs: []ast.Expr{CommonIdents["err"]},
+			Lhs: []ast.Expr{commonIdent("err")},
 			Tok: token.ASSIGN,
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
 					Fun: &ast.SelectorExpr{
-						X:   CommonIdents["binary"],
-						Sel: CommonIdents["Write"],
+						X:   commonIdent("binary"),
+						Sel: commonIdent("Write"),
 					},
 					Args: []ast.Expr{
-						CommonIdents["w"],
+						commonIdent("w"),
 						&ast.SelectorExpr{
-							X:   CommonIdents["binary"],
-							Sel: CommonIdents["LittleEndian"],
+							X:   commonIdent("binary"),
+							Sel: commonIdent("LittleEndian"),
 						},
 						&ast.CallExpr{
-							Fun: CommonIdents["uint16"],
+							Fun: commonIdent("uint16"),
 							Args: []ast.Expr{
 								&ast.BasicLit{
 									Kind:  token.INT,
@@ -1187,48 +3769,48 @@ func (qt *QMITLV) GenWriteTo(parent ast.Expr, n int) ([]ast.Stmt, error) {
 		if n == "" {
 			n = qt.CommonRef
 		}
-		buffer := ast.NewIdent("buf_" + name.SnakeCase(n))
+		buffer := "buf_" + name.SnakeCase(n)
 		make_buffer := &ast.AssignStmt{
-			Lhs: []ast.Expr{buffer},
+			Lhs: []ast.Expr{ast.NewIdent(buffer)},
 			Tok: token.DEFINE,
 			Rhs: []ast.Expr{
 				&ast.UnaryExpr{
 					Op: token.AND,
 					X: &ast.CompositeLit{
 						Type: &ast.SelectorExpr{
-							X:   CommonIdents["bytes"],
-							Sel: CommonIdents["Buffer"],
+							X:   commonIdent("bytes"),
+							Sel: commonIdent("Buffer"),
 						},
 					},
 				},
 			},
 		}
-		write_data, err := qt.GenWriteToPayload(parent, buffer)
+		write_data, err := qt.GenWriteToPayload(gen, parent, ast.NewIdent(buffer), false)
 		if err != nil {
 			return nil, err
 		}
 		write_length := &ast.AssignStmt{
-			Lhs: []ast.Expr{CommonIdents["err"]},
+			Lhs: []ast.Expr{commonIdent("err")},
 			Tok: token.ASSIGN,
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
 					Fun: &ast.SelectorExpr{
-						X:   CommonIdents["binary"],
-						Sel: CommonIdents["Write"],
+						X:   commonIdent("binary"),
+						Sel: commonIdent("Write"),
 					},
 					Args: []ast.Expr{
-						CommonIdents["w"],
+						commonIdent("w"),
 						&ast.SelectorExpr{
-							X:   CommonIdents["binary"],
-							Sel: CommonIdents["LittleEndian"],
+							X:   commonIdent("binary"),
+							Sel: commonIdent("LittleEndian"),
 						},
 						&ast.CallExpr{
-							Fun: CommonIdents["uint16"],
+							Fun: commonIdent("uint16"),
 							Args: []ast.Expr{
 								&ast.CallExpr{
 									Fun: &ast.SelectorExpr{
-										X:   buffer,
-										Sel: CommonIdents["Len"],
+										X:   ast.NewIdent(buffer),
+										Sel: commonIdent("Len"),
 									},
 								},
 							},
@@ -1239,22 +3821,39 @@ func (qt *QMITLV) GenWriteTo(parent ast.Expr, n int) ([]ast.Stmt, error) {
 		}
 		flush_buf := &ast.AssignStmt{
 			Lhs: []ast.Expr{
-				CommonIdents["_"],
-				CommonIdents["err"],
+				commonIdent("_"),
+				commonIdent("err"),
 			},
 			Tok: token.ASSIGN,
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
 					Fun: &ast.SelectorExpr{
-						X:   buffer,
-						Sel: CommonIdents["WriteTo"],
+						X:   ast.NewIdent(buffer),
+						Sel: commonIdent("WriteTo"),
 					},
 					Args: []ast.Expr{
-						CommonIdents["w"],
+						commonIdent("w"),
 					},
 				},
 			},
 		}
+		if gen.opts.DirectEncoding {
+			// the header follows the payload whose length it carries
+			return append(
+				append([]ast.Stmt{make_buffer}, write_data...),
+				append(qt.genWriteHeader(&ast.CallExpr{
+					Fun: commonIdent("uint16"),
+					Args: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   ast.NewIdent(buffer),
+								Sel: commonIdent("Len"),
+							},
+						},
+					},
+				}), flush_buf, handleErr())...,
+			), nil
+		}
 		return append(
 			append(
 				[]ast.Stmt{make_buffer, write_tag, handleErr()},
@@ -1268,8 +3867,182 @@ func (qt *QMITLV) GenWriteTo(parent ast.Expr, n int) ([]ast.Stmt, error) {
 	}
 }
 
-func (qt *QMITLV) GenReadFromFunc(t *ast.GenDecl, n int) (*ast.FuncDecl, error) {
-	read_stmts, err := qt.GenReadFrom(CommonIdents["tlv"], n)
+// genWriteHeader writes the tag and the length of the TLV through scalar in
+// one call, for DirectEncoding
+func (qt *QMITLV) genWriteHeader(length ast.Expr) []ast.Stmt {
+	return append([]ast.Stmt{
+		genPutScalar(0, nil, "uint8", qt.TagLit()),
+		genPutScalar(1, littleEndian(), "uint16", length),
+	}, genWriteScalarBuf(commonIdent("w"), 3)...)
+}
+
+// genWriteOptional skips an absent optional TLV
+//
+//	if msg.Name != nil {
+//		e := struct{ Name T }{*msg.Name}
+//		...
+//	}
+func (qt *QMITLV) genWriteOptional(gen *generator, parent ast.Expr, n int) ([]ast.Stmt, error) {
+	value := &ast.SelectorExpr{X: cloneExpr(parent), Sel: ast.NewIdent(gen.goName(qt.Name))}
+
+	present := *qt
+	present.optional = false
+	write_stmts, err := present.GenWriteTo(gen, ast.NewIdent("e"), n)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, err := qt.genElemStruct(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ast.Stmt{
+		&ast.IfStmt{
+			Cond: &ast.BinaryExpr{
+				X:  cloneExpr(value),
+				Op: token.NEQ,
+				Y:  commonIdent("nil"),
+			},
+			Body: &ast.BlockStmt{
+				List: append([]ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("e")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{
+							&ast.CompositeLit{
+								Type: typ,
+								Elts: []ast.Expr{
+									&ast.StarExpr{X: cloneExpr(value)},
+								},
+							},
+						},
+					},
+				}, write_stmts...),
+			},
+		},
+	}, nil
+}
+
+// genWriteRepeated writes a TLV per instance of a repeatable one
+//
+//	for _, v := range msg.Name {
+//		e := struct{ Name T }{v}
+//		...
+//	}
+func (qt *QMITLV) genWriteRepeated(gen *generator, parent ast.Expr) ([]ast.Stmt, error) {
+
+	instance := *qt
+	instance.Repeatable = false
+	instance.optional = false
+	_, n, err := gen.parseType(instance.QMITLVField)
+	if err != nil {
+		return nil, err
+	}
+	write_stmts, err := instance.GenWriteTo(gen, ast.NewIdent("e"), n)
+	if err != nil {
+		return nil, err
+	}
+
+	typ, err := qt.genElemStruct(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	return []ast.Stmt{
+		&ast.RangeStmt{
+			Key:   commonIdent("_"),
+			Value: ast.NewIdent("v"),
+			Tok:   token.DEFINE,
+			X:     &ast.SelectorExpr{X: cloneExpr(parent), Sel: ast.NewIdent(gen.goName(qt.Name))},
+			Body: &ast.BlockStmt{
+				List: append([]ast.Stmt{
+					&ast.AssignStmt{
+						Lhs: []ast.Expr{ast.NewIdent("e")},
+						Tok: token.DEFINE,
+						Rhs: []ast.Expr{
+							&ast.CompositeLit{
+								Type: typ,
+								Elts: []ast.Expr{ast.NewIdent("v")},
+							},
+						},
+					},
+				}, write_stmts...),
+			},
+		},
+	}, nil
+}
+
+func (qt *QMITLV) GenReadFromFunc(gen *generator, t *ast.GenDecl, n int) (*ast.FuncDecl, error) {
+	read_stmts, err := qt.GenReadFrom(gen, commonIdent("tlv"), n)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("tlv")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(t.Specs[0].(*ast.TypeSpec).Name.Name)},
+				},
+			},
+		},
+		Name: commonIdent("ReadFrom"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("r")},
+						Type: &ast.StarExpr{
+							X: &ast.SelectorExpr{
+								X:   commonIdent("bytes"),
+								Sel: commonIdent("Buffer"),
+							},
+						},
+					},
+				},
+			},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Names: []*ast.Ident{commonIdent("err")},
+						Type:  commonIdent("error"),
+					},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: append(
+				append(
+					[]ast.Stmt{
+						&ast.DeclStmt{
+							Decl: &ast.GenDecl{
+								Tok: token.VAR,
+								Specs: []ast.Spec{
+									&ast.ValueSpec{
+										Names: []*ast.Ident{commonIdent("b")},
+										Type: &ast.StarExpr{
+											X: &ast.SelectorExpr{
+												X:   commonIdent("bytes"),
+												Sel: commonIdent("Buffer"),
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+					read_stmts...,
+				),
+				&ast.ReturnStmt{},
+			),
+		},
+	}, nil
+}
+
+func (qt *QMITLV) GenWriteToFunc(gen *generator, t *ast.GenDecl, n int) (*ast.FuncDecl, error) {
+	write_stmts, err := qt.GenWriteTo(gen, commonIdent("tlv"), n)
 	if err != nil {
 		return nil, err
 	}
@@ -1278,22 +4051,20 @@ func (qt *QMITLV) GenReadFromFunc(t *ast.GenDecl, n int) (*ast.FuncDecl, error)
 		Recv: &ast.FieldList{
 			List: []*ast.Field{
 				&ast.Field{
-					Names: []*ast.Ident{CommonIdents["tlv"]},
-					Type:  &ast.StarExpr{X: t.Specs[0].(*ast.TypeSpec).Name},
+					Names: []*ast.Ident{commonIdent("tlv")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(t.Specs[0].(*ast.TypeSpec).Name.Name)},
 				},
 			},
 		},
-		Name: CommonIdents["ReadFrom"],
+		Name: commonIdent("TLVWriteTo"),
 		Type: &ast.FuncType{
 			Params: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["r"]},
-						Type: &ast.StarExpr{
-							X: &ast.SelectorExpr{
-								X:   CommonIdents["bytes"],
-								Sel: CommonIdents["Buffer"],
-							},
+						Names: []*ast.Ident{commonIdent("w")},
+						Type: &ast.SelectorExpr{
+							X:   commonIdent("io"),
+							Sel: commonIdent("Writer"),
 						},
 					},
 				},
@@ -1301,43 +4072,98 @@ func (qt *QMITLV) GenReadFromFunc(t *ast.GenDecl, n int) (*ast.FuncDecl, error)
 			Results: &ast.FieldList{
 				List: []*ast.Field{
 					&ast.Field{
-						Names: []*ast.Ident{CommonIdents["err"]},
-						Type:  CommonIdents["error"],
+						Names: []*ast.Ident{commonIdent("err")},
+						Type:  commonIdent("error"),
 					},
 				},
 			},
 		},
 		Body: &ast.BlockStmt{
-			List: append(
-				append(
-					[]ast.Stmt{
-						&ast.DeclStmt{
-							Decl: &ast.GenDecl{
-								Tok: token.VAR,
-								Specs: []ast.Spec{
-									&ast.ValueSpec{
-										Names: []*ast.Ident{CommonIdents["b"]},
-										Type: &ast.StarExpr{
-											X: &ast.SelectorExpr{
-												X:   CommonIdents["bytes"],
-												Sel: CommonIdents["Buffer"],
-											},
-										},
-									},
-								},
+			List: append(declareScalar(write_stmts), &ast.ReturnStmt{}),
+		},
+	}, nil
+}
+
+func (qt *QMITLV) GenSizeFunc(t *ast.GenDecl, n int) *ast.FuncDecl {
+	var body []ast.Stmt
+	if n >= 0 {
+		body = []ast.Stmt{
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.BasicLit{
+						Kind:  token.INT,
+						Value: strconv.Itoa(n + 2 + 1),
+					},
+				},
+			},
+		}
+	} else {
+		body = []ast.Stmt{
+			&ast.AssignStmt{
+				Lhs: []ast.Expr{commonIdent("buf")},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{
+					&ast.UnaryExpr{
+						Op: token.AND,
+						X: &ast.CompositeLit{
+							Type: &ast.SelectorExpr{
+								X:   commonIdent("bytes"),
+								Sel: commonIdent("Buffer"),
 							},
 						},
 					},
-					read_stmts...,
-				),
-				&ast.ReturnStmt{},
-			),
+				},
+			},
+			&ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun: &ast.SelectorExpr{
+						X:   commonIdent("tlv"),
+						Sel: commonIdent("TLVWriteTo"),
+					},
+					Args: []ast.Expr{commonIdent("buf")},
+				},
+			},
+			&ast.ReturnStmt{
+				Results: []ast.Expr{
+					&ast.CallExpr{
+						Fun: &ast.SelectorExpr{
+							X:   commonIdent("buf"),
+							Sel: commonIdent("Len"),
+						},
+					},
+				},
+			},
+		}
+	}
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("tlv")},
+					Type:  &ast.StarExpr{X: ast.NewIdent(t.Specs[0].(*ast.TypeSpec).Name.Name)},
+				},
+			},
 		},
-	}, nil
+		Name: commonIdent("Size"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{
+						Type: commonIdent("int"),
+					},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{
+			List: body,
+		},
+	}
 }
 
-func (qt *QMITLV) Register(f *ast.File) error {
-	t, n, err := qt.GenTypeDecl()
+func (qt *QMITLV) Register(gen *generator, f *ast.File) error {
+	t, n, err := qt.GenTypeDecl(gen)
 	if err != nil {
 		return err
 	}
@@ -1346,23 +4172,42 @@ func (qt *QMITLV) Register(f *ast.File) error {
 		return fmt.Errorf("bad TLV: %#v", qt)
 	}
 
-	fun_readFrom, err := qt.GenReadFromFunc(t, n)
+	fun_readFrom, err := qt.GenReadFromFunc(gen, t, n)
+	if err != nil {
+		return err
+	}
+
+	fun_writeTo, err := qt.GenWriteToFunc(gen, t, n)
 	if err != nil {
 		return err
 	}
 
-	f.Decls = append(f.Decls, t, fun_readFrom)
+	f.Decls = append(f.Decls, t, fun_readFrom, fun_writeTo, qt.GenSizeFunc(t, n))
+	gen.addAlias(f, "QMIStruct", qt.Name, "")
 	return nil
 }
 
-func parseType(field QMITLVField) (ast.Expr, int, error) {
+func (gen *generator) parseType(field QMITLVField) (ast.Expr, int, error) {
 	switch field.Format {
 	case "array":
-		typ, _, err := parseType(*field.ArrayElement)
+		typ, n, err := gen.parseType(*field.ArrayElement)
 		if err != nil {
 			return nil, 0, err
 		}
 
+		if field.FixedSize > 0 {
+			if n >= 0 {
+				n *= field.FixedSize
+			}
+			return &ast.ArrayType{
+				Len: &ast.BasicLit{
+					Kind:  token.INT,
+					Value: strconv.Itoa(field.FixedSize),
+				},
+				Elt: typ,
+			}, n, nil
+		}
+
 		return &ast.ArrayType{Elt: typ}, -1, nil
 	case "struct", "sequence":
 		stype := &ast.StructType{
@@ -1372,11 +4217,16 @@ func parseType(field QMITLVField) (ast.Expr, int, error) {
 		}
 		n := 0
 		for _, field := range field.Contents {
-			typ, n1, err := parseType(field)
+			typ, n1, err := gen.parseType(field)
 			if err != nil {
 				return nil, 0, err
 			}
-			if n != -1 {
+			if len(field.Prerequisites) > 0 {
+				n1 = -1
+			}
+			if n1 < 0 {
+				n = -1
+			} else if n != -1 {
 				n += n1
 			}
 			sfield := &ast.Field{
@@ -1384,7 +4234,7 @@ func parseType(field QMITLVField) (ast.Expr, int, error) {
 			}
 			if field.Name != "" {
 				sfield.Names = []*ast.Ident{
-					ast.NewIdent(name.CamelCase(field.Name, true)),
+					ast.NewIdent(gen.goName(field.Name)),
 				}
 			}
 			stype.Fields.List = append(stype.Fields.List, sfield)
@@ -1392,19 +4242,35 @@ func parseType(field QMITLVField) (ast.Expr, int, error) {
 
 		return stype, n, nil
 	case "guint-sized":
-		return &ast.ArrayType{Elt: CommonIdents["byte"]}, field.IntSize, nil
+		return &ast.ArrayType{Elt: commonIdent("byte")}, field.IntSize, nil
 	default:
 		tname := strings.TrimPrefix(field.Format, "g")
+		switch tname {
+		case "float":
+			tname = "float32"
+		case "double":
+			tname = "float64"
+		case "boolean":
+			tname = "bool"
+		}
 		n, ok := CommonSize[tname]
+		if field.scaled != nil {
+			return ast.NewIdent(field.scaled.Name), n, nil
+		}
 		if !ok && field.CommonRef != "" {
-			_, ok = CommonRefs[field.CommonRef]
-			if ok {
-				ident, ok := CommonIdents["QMIStruct"+name.CamelCase(field.CommonRef, true)]
-				if ok {
-					return ident, CommonSize[field.CommonRef], nil
-				}
+			_, ok = gen.commonRef(field.CommonRef)
+			if !ok {
+				return nil, 0, fmt.Errorf("unknown common-ref %q", field.CommonRef)
 			}
+			size, _ := gen.reg.Size(field.CommonRef)
+			return ast.NewIdent("QMIStruct" + gen.goName(field.CommonRef)), size, nil
 		} else if ok {
+			if enum := gen.registerEnum(field, tname); enum != nil {
+				return enum.Ident(gen), n, nil
+			}
+			if tname == "string" && field.FixedSize > 0 {
+				n = field.FixedSize
+			}
 			return ast.NewIdent(tname), n, nil
 		}
 
@@ -1412,15 +4278,154 @@ func parseType(field QMITLVField) (ast.Expr, int, error) {
 	}
 }
 
-func (qp *QMIPrerequisite) Register(f *ast.File) error {
+func (qp *QMIPrerequisite) Register(gen *generator, f *ast.File) error {
 	return nil
 }
 
+var PrerequisiteOps = map[string]token.Token{
+	"==": token.EQL,
+	"!=": token.NEQ,
+	"<":  token.LSS,
+	"<=": token.LEQ,
+	">":  token.GTR,
+	">=": token.GEQ,
+	"&":  token.AND, // any of the mask bits set
+}
+
+// resolve returns the shared prerequisite a common-ref one refers to
+func (qp *QMIPrerequisite) resolve(gen *generator) (*QMIPrerequisite, error) {
+	if qp.CommonRef == "" {
+		return qp, nil
+	}
+	ref, ok := gen.commonRef(qp.CommonRef)
+	if !ok {
+		return nil, fmt.Errorf("prerequisite common-ref %q not found", qp.CommonRef)
+	}
+	b, err := json.Marshal(ref)
+	if err != nil {
+		return nil, err
+	}
+	resolved := &QMIPrerequisite{}
+	err = json.Unmarshal(b, resolved)
+	if err != nil {
+		return nil, err
+	}
+	return resolved, nil
+}
+
+// ValueExpr is an integer literal, a boolean or a constant of a known enum
+func (qp *QMIPrerequisite) ValueExpr(gen *generator) (ast.Expr, error) {
+	_, err := strconv.ParseInt(qp.Value, 0, 64)
+	if err == nil {
+		return &ast.BasicLit{Kind: token.INT, Value: qp.Value}, nil
+	}
+	switch qp.Value {
+	case "TRUE", "true":
+		return ast.NewIdent("true"), nil
+	case "FALSE", "false":
+		return commonIdent("false"), nil
+	}
+	if enum, v, ok := gen.reg.EnumValue(qp.Value); ok {
+		return enum.ValueIdent(gen, v), nil
+	}
+	return nil, fmt.Errorf("prerequisite value %q is neither a number nor a known enum value", qp.Value)
+}
+
+// GenCond compares the field named by the prerequisite, which must come
+// before the field at index i of contents, in parent
+func (qp *QMIPrerequisite) GenCond(gen *generator, parent ast.Expr, contents []QMITLVField, i int) (ast.Expr, error) {
+	qp, err := qp.resolve(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	op, ok := PrerequisiteOps[qp.Operation]
+	if !ok {
+		return nil, fmt.Errorf("prerequisite operation %q is unsupported", qp.Operation)
+	}
+
+	found := false
+	for _, sibling := range contents[:i] {
+		if sibling.Name == qp.Field {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("prerequisite field %q of %q is not an earlier sibling", qp.Field, contents[i].Name)
+	}
+
+	value, err := qp.ValueExpr(gen)
+	if err != nil {
+		return nil, err
+	}
+
+	field := &ast.SelectorExpr{
+		X:   cloneExpr(parent),
+		Sel: ast.NewIdent(gen.goName(qp.Field)),
+	}
+	if op == token.AND {
+		// parent.Field&value != 0
+		return &ast.BinaryExpr{
+			X: &ast.BinaryExpr{
+				X:  field,
+				Op: token.AND,
+				Y:  value,
+			},
+			Op: token.NEQ,
+			Y:  &ast.BasicLit{Kind: token.INT, Value: "0"},
+		}, nil
+	}
+	return &ast.BinaryExpr{
+		X:  field,
+		Op: op,
+		Y:  value,
+	}, nil
+}
+
+// genContents generates the statements of each field of a struct or
+// sequence, wrapped in an if when the field has prerequisites
+func (gen *generator) genContents(parent ast.Expr, contents []QMITLVField, gen_field func(field *QMITLVField) ([]ast.Stmt, error)) ([]ast.Stmt, error) {
+	var stmts []ast.Stmt
+	for i := range contents {
+		field := &contents[i]
+		field_stmts, err := gen_field(field)
+		if err != nil {
+			return nil, err
+		}
+
+		var cond ast.Expr
+		for j := range field.Prerequisites {
+			c, err := field.Prerequisites[j].GenCond(gen, parent, contents, i)
+			if err != nil {
+				return nil, err
+			}
+			if cond == nil {
+				cond = c
+			} else {
+				cond = &ast.BinaryExpr{X: cond, Op: token.LAND, Y: c}
+			}
+		}
+		if cond != nil && len(field_stmts) > 0 {
+			field_stmts = []ast.Stmt{
+				&ast.IfStmt{
+					Cond: cond,
+					Body: &ast.BlockStmt{List: field_stmts},
+				},
+			}
+		}
+
+		stmts = append(stmts, field_stmts...)
+	}
+	return stmts, nil
+}
+
 var QMIEntityMap = map[string]func() interface{}{
 	"Service":            func() interface{} { return &QMIService{} },
 	"Client":             func() interface{} { return &QMIClient{} },
 	"Message-ID-Enum":    func() interface{} { return &QMIMessageIDEnum{} },
 	"Indication-ID-Enum": func() interface{} { return &QMIIndicationIDEnum{} },
+	"Errors":             func() interface{} { return &QMIErrors{} },
 	"Message":            func() interface{} { return &QMIMessage{} },
 	"Indication":         func() interface{} { return &QMIIndication{} },
 	"TLV":                func() interface{} { return &QMITLV{} },
@@ -1435,17 +4440,7 @@ func (e ErrUnexpectedType) Error() string {
 
 func addCommon(f *ast.File) {
 	var declspec []ast.Spec
-	for _, import_module := range []string{
-		"bytes",
-		"context",
-		"encoding/binary",
-		"fmt",
-		"io",
-		"log",
-		"os",
-		"sync",
-		"syscall",
-	} {
+	for _, import_module := range runtimeImports {
 		spec := &ast.ImportSpec{
 			Path: &ast.BasicLit{
 				Kind:  token.STRING,
@@ -1486,7 +4481,7 @@ func addCommon(f *ast.File) {
 			},
 		})
 		smap = append(smap, &ast.KeyValueExpr{
-			Key: value,
+			Key: cloneExpr(value),
 			Value: &ast.BasicLit{
 				Kind:  token.STRING,
 				Value: fmt.Sprintf("%q", key),
@@ -1499,8 +4494,8 @@ func addCommon(f *ast.File) {
 			Values: []ast.Expr{
 				&ast.CompositeLit{
 					Type: &ast.MapType{
-						Key:   constspec[0].(*ast.ValueSpec).Type,
-						Value: CommonIdents["string"],
+						Key:   cloneExpr(constspec[0].(*ast.ValueSpec).Type),
+						Value: commonIdent("string"),
 					},
 					Elts: smap,
 				},
@@ -1523,197 +4518,662 @@ func addCommon(f *ast.File) {
 	}, f.Decls...)
 }
 
-func convert(outputFile, inputFile string) error {
-	wd, err := os.Getwd()
-	if err != nil {
-		return err
+// importsUsed keeps the import paths whose package decls refer to
+func importsUsed(decls []ast.Decl, paths []string) []string {
+	used := map[string]bool{}
+	for _, decl := range decls {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if sel, ok := n.(*ast.SelectorExpr); ok {
+				if x, ok := sel.X.(*ast.Ident); ok {
+					used[x.Name] = true
+				}
+			}
+			return true
+		})
 	}
 
-	if !filepath.IsAbs(inputFile) {
-		inputFile, err = filepath.Rel(
-			filepath.Dir(filepath.Join(wd, outputFile)),
-			filepath.Join(wd, inputFile),
-		)
+	var imports []string
+	for _, p := range paths {
+		if used[path.Base(p)] {
+			imports = append(imports, p)
+		}
+	}
+	return imports
+}
+
+// CommonDeps lists the common-refs used by the field and its contents
+func (field *QMITLVField) CommonDeps() []string {
+	var deps []string
+	if field.CommonRef != "" {
+		deps = append(deps, field.CommonRef)
+	}
+	for _, sub_field := range field.Contents {
+		deps = append(deps, sub_field.CommonDeps()...)
+	}
+	if field.ArrayElement != nil {
+		deps = append(deps, field.ArrayElement.CommonDeps()...)
+	}
+	return deps
+}
+
+// registerCommonRefs declares every common-ref of the file before
+// generating any, then registers the common TLVs so that those embedded
+// in others come first regardless of their order in the file. Returns
+// the registered names and the indices of common-ref entities.
+func (gen *generator) registerCommonRefs(f *ast.File, raw_entities []interface{}) ([]string, map[int]bool, error) {
+	is_common := map[int]bool{}
+	defs := map[string]*QMITLV{}
+	var order []string
+
+	for i, re := range raw_entities {
+		if isComment(re) {
+			continue
+		}
+		typI, ok := re.(map[string]interface{})
+		if !ok {
+			return nil, nil, ErrUnexpectedType("not an object")
+		}
+
+		cRef, ok := typI["common-ref"].(string)
+		if !ok {
+			continue
+		}
+		is_common[i] = true
+
+		delete(typI, "common-ref")
+		typI["name"] = cRef
+		gen.reg.addRef(cRef, typI)
+
+		if typS, _ := typI["type"].(string); typS != "TLV" {
+			continue
+		}
+
+		tlv := &QMITLV{common: true}
+		b, err := json.Marshal(re)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = json.Unmarshal(b, tlv)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = gen.auditEntity(re, tlv)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		defs[cRef] = tlv
+		order = append(order, cRef)
+	}
+
+	var registered []string
+	state := map[string]int{} // 1 while registering dependencies, 2 when done
+	var register func(cRef string) error
+	register = func(cRef string) error {
+		tlv, ok := defs[cRef]
+		if !ok || state[cRef] == 2 {
+			// defined by a file converted earlier
+			return nil
+		}
+		if state[cRef] == 1 {
+			return fmt.Errorf("common-ref %q contains itself", cRef)
+		}
+
+		state[cRef] = 1
+		for _, dep := range tlv.CommonDeps() {
+			err := register(dep)
+			if err != nil {
+				return err
+			}
+		}
+
+		n := len(f.Decls)
+		err := tlv.Register(gen, f)
+		if err != nil {
+			return err
+		}
+
+		entity := fmt.Sprintf("common-ref %q", cRef)
+		err = gen.declareDecls(f.Decls[n:], entity)
+		if err == nil {
+			methods := methodsOf(f.Decls[n:], "QMIStruct"+gen.goName(cRef))
+			err = gen.declareFields(methodTable(methods), tlv.Contents, "field")
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", entity, err)
+		}
+		state[cRef] = 2
+		registered = append(registered, cRef)
+		return nil
+	}
+
+	for _, cRef := range order {
+		err := register(cRef)
 		if err != nil {
-			panic(err)
+			return nil, nil, err
+		}
+	}
+
+	return registered, is_common, nil
+}
+
+// commonRef looks up the definition of a common-ref, recording the hash of
+// those defined by other files: TLVs generated from another definition
+// than theirs would be mis-sized
+func (gen *generator) commonRef(name string) (map[string]interface{}, bool) {
+	def, ok := gen.reg.Ref(name)
+	if _, local := gen.reg.refs[name]; ok && !local {
+		gen.usedRefs[name] = commonRefHash(def)
+	}
+	return def, ok
+}
+
+// commonRefHash identifies a common-ref definition by its JSON encoding,
+// in which map keys are sorted
+func commonRefHash(def map[string]interface{}) string {
+	// decoded from JSON, def encodes back
+	b, _ := json.Marshal(def)
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// genCommonRefChecks calls the runtime with the common-refs the file defines
+// and those it relies upon, which must agree across the package:
+//
+//	defineCommonRef("Operation Result", "c627ac101d06b0b5", "qmi-common.go")
+//	useCommonRef("Operation Result", "c627ac101d06b0b5", "qmi-service-ctl.go")
+func (gen *generator) genCommonRefChecks() []ast.Stmt {
+	var stmts []ast.Stmt
+	add := func(fun, name, hash string) {
+		args := []ast.Expr{}
+		for _, s := range []string{name, hash, filepath.Base(gen.opts.Output)} {
+			args = append(args, &ast.BasicLit{Kind: token.STRING, Value: strconv.Quote(s)})
 		}
+		stmts = append(stmts, &ast.ExprStmt{
+			X: &ast.CallExpr{Fun: commonIdent(fun), Args: args},
+		})
+	}
+
+	var defined []string
+	for name := range gen.reg.refs {
+		defined = append(defined, name)
+	}
+	sort.Strings(defined)
+	for _, name := range defined {
+		add("defineCommonRef", name, commonRefHash(gen.reg.refs[name]))
 	}
 
+	var used []string
+	for name := range gen.usedRefs {
+		used = append(used, name)
+	}
+	sort.Strings(used)
+	for _, name := range used {
+		add("useCommonRef", name, gen.usedRefs[name])
+	}
+	return stmts
+}
+
+// convert writes outputFile from the data file inputFile, declaring the
+// common-refs and enums of the file in reg
+func (gen *generator) convert(reg *Registry, outputFile, inputFile string, o Options) error {
 	input, err := ioutil.ReadFile(inputFile)
 	if err != nil {
 		return err
 	}
 
+	// the //go:generate line runs in the directory of the output
+	if o.Source == "" {
+		o.Source = inputFile
+		if !filepath.IsAbs(inputFile) {
+			wd, err := os.Getwd()
+			if err != nil {
+				return err
+			}
+			o.Source, err = filepath.Rel(
+				filepath.Dir(filepath.Join(wd, outputFile)),
+				filepath.Join(wd, inputFile),
+			)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if o.Output == "" {
+		o.Output = outputFile
+	}
+
+	src, err := gen.generate(reg, input, o)
+	if err != nil {
+		return err
+	}
+	err = WriteOutput(outputFile, src)
+	if err != nil || filepath.Base(outputFile) != "qmi-common.go" {
+		return err
+	}
+
+	files, err := byteOrderFiles(o)
+	if err != nil {
+		return err
+	}
+	for name, src := range files {
+		err = WriteOutput(filepath.Join(filepath.Dir(outputFile), name), src)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// generate returns the source generated from the data file input,
+// declaring the common-refs and enums of the file in reg
+func (gen *generator) generate(reg *Registry, input []byte, o Options) ([]byte, error) {
+	gen.opts = o
+	gen.reg = reg
+
 	var raw_entities []interface{}
 	var entities []QMIEntity
+	var sizes []sizeEntry
 
-	err = hjson.Unmarshal(input, &raw_entities)
+	err := hjson.Unmarshal(input, &raw_entities)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	fs := token.NewFileSet()
 	f := &ast.File{
-		Name:  CommonIdents["qmi"],
+		Name:  ast.NewIdent(gen.opts.packageName()),
 		Scope: ast.NewScope(nil),
 	}
 
-	for _, re := range raw_entities {
+	common_tlvs, is_common, err := gen.registerCommonRefs(f, raw_entities)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, re := range raw_entities {
+		if is_common[i] || isComment(re) {
+			continue
+		}
+
 		typI, ok := re.(map[string]interface{})
 		if !ok {
-			return ErrUnexpectedType("not an object")
+			return nil, ErrUnexpectedType("not an object")
 		}
 
 		typS, ok := typI["type"].(string)
 		if !ok {
-			return ErrUnexpectedType("no \"type\" field")
-		}
-
-		cRef, ok := typI["common-ref"].(string)
-		if ok {
-			delete(typI, "common-ref")
-			typI["name"] = cRef
-			CommonRefs[cRef] = typI
-			n := "QMIStruct" + name.CamelCase(cRef, true)
-			CommonIdents[n] = ast.NewIdent(n)
-			if typS == "TLV" {
-				tlv := &QMITLV{}
-				b, err := json.Marshal(re)
-				if err != nil {
-					return err
-				}
-
-				err = json.Unmarshal(b, tlv)
-				if err != nil {
-					return err
-				}
-
-				err = tlv.Register(f)
-				if err != nil {
-					return err
-				}
-			}
-			continue
+			return nil, ErrUnexpectedType("no \"type\" field")
 		}
 
 		cons, ok := QMIEntityMap[typS]
-		if !ok {
-			return ErrUnexpectedType(typS)
+		if !ok && gen.opts.Strict {
+			return nil, ErrUnexpectedType(typS)
+		} else if !ok {
+			name, _ := typI["name"].(string)
+			gen.opts.warnf("%s %q: unknown entity type, skipped", typS, name)
+			continue
 		}
 
 		entity := cons()
 
 		b, err := json.Marshal(re)
 		if err != nil {
-			return err
+			return nil, err
 		}
 
 		err = json.Unmarshal(b, entity)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		err = gen.auditEntity(re, entity)
+		if err != nil {
+			return nil, err
 		}
 
 		entity_impl := entity.(QMIEntity)
 
-		err = entity_impl.Register(f)
+		if p, ok := entity_impl.(interface{ parseID() error }); ok {
+			err = p.parseID()
+			if err != nil {
+				return nil, fmt.Errorf("error processing %s: %w", typS, err)
+			}
+		}
+
+		if qm, ok := entity_impl.(*QMIMessage); ok && qm.IsInternal() && !gen.opts.Internal {
+			continue
+		}
+
+		n := len(f.Decls)
+		err = entity_impl.Register(gen, f)
+		if err != nil {
+			return nil, fmt.Errorf("error processing %s: %w", typS, err)
+		}
+
+		name, _ := typI["name"].(string)
+		err = gen.declareDecls(f.Decls[n:], fmt.Sprintf("%s %q", typS, name))
 		if err != nil {
-			return fmt.Errorf("error processing %s: %w", typS, err)
+			return nil, err
+		}
+		if c, ok := entity_impl.(interface {
+			checkSymbols(*generator, []ast.Decl) error
+		}); ok {
+			err = c.checkSymbols(gen, f.Decls[n:])
+			if err != nil {
+				return nil, fmt.Errorf("error processing %s %q: %w", typS, name, err)
+			}
+		}
+
+		if qm, ok := entity_impl.(*QMIMessage); ok && gen.opts.SizeReport != nil {
+			sizes = append(sizes, sizeEntry{
+				Name:  qm.Service + " " + qm.Name,
+				Decls: len(f.Decls) - n,
+				Bytes: declSize(f.Decls[n:]),
+			})
 		}
 
 		entities = append(entities, entity_impl)
 	}
 
-	f_out, err := os.OpenFile(outputFile, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0666)
-	if err != nil {
-		return err
+	var indications []*QMIIndication
+	for _, entity := range entities {
+		if qi, ok := entity.(*QMIIndication); ok {
+			indications = append(indications, qi)
+		}
 	}
-
-	genpath, err := filepath.Abs(os.Args[0])
-	if err != nil {
-		genpath = os.Args[0]
-	} else {
-		genpath = filepath.Join(
-			"..",
-			filepath.Base(filepath.Dir(genpath)),
-			filepath.Base(genpath),
-		)
+	for _, entity := range entities {
+		if qiie, ok := entity.(*QMIIndicationIDEnum); ok {
+			decls, err := qiie.GenDecls(gen, indications)
+			if err != nil {
+				return nil, fmt.Errorf("error processing Indication-ID-Enum: %w", err)
+			}
+			f.Decls = append(f.Decls, decls...)
+		}
 	}
-	fmt.Fprintf(f_out, "//go:generate %s %s $GOFILE\n", genpath, inputFile)
 
-	if filepath.Base(outputFile) == "qmi-common.go" {
+	out := &bytes.Buffer{}
+
+	// tools only take the file for generated with the marker above the
+	// package clause
+	genpath := gen.opts.generator()
+	fmt.Fprintf(out, "// Code generated by %s from %s, DO NOT EDIT.\n\n", genpath, gen.opts.Source)
+	fmt.Fprintf(out, "//go:generate %s %s%s $GOFILE\n", genpath, genFlags(gen.opts), gen.opts.Source)
+
+	if filepath.Base(gen.opts.Output) == "qmi-common.go" {
 		addCommon(f)
-	} else {
-		var declspec []ast.Spec
-		for _, import_module := range []string{
-			"bytes",
-			"encoding/binary",
-			"fmt",
-			"io",
-		} {
-			spec := &ast.ImportSpec{
-				Path: &ast.BasicLit{
-					Kind:  token.STRING,
-					Value: fmt.Sprintf("%q", import_module),
-				},
-			}
-			f.Imports = append(f.Imports, spec)
-			declspec = append(declspec, spec)
-		}
-		f.Decls = append([]ast.Decl{
-			&ast.GenDecl{
-				Tok:   token.IMPORT,
-				Specs: declspec,
-			},
-		}, f.Decls...)
 	}
 
 	init_stmts := []ast.Stmt{}
 
+	// with -explicit-register nothing is registered from init(), so the
+	// linker can drop messages the application never references
+	all_stmts := map[string][]ast.Stmt{}
+	var services []string
+
+	registered := map[string]bool{}
+
 	for _, entity := range entities {
 		switch v := entity.(type) {
+		case *QMIErrors:
+			// declareServiceErrors(QMI_SERVICE_WDS, WDSErrorDescription)
+			init_stmts = append(init_stmts, &ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun: commonIdent("declareServiceErrors"),
+					Args: []ast.Expr{
+						ast.NewIdent("QMI_SERVICE_" + v.Service),
+						ast.NewIdent(v.Service + "ErrorDescription"),
+					},
+				},
+			})
 		case *QMIMessage:
-			ident := ast.NewIdent(v.Service + name.CamelCase(v.Name, true) + "Output")
+			type_name := v.Service + gen.goName(v.Name) + "Output"
+			registered[type_name] = true
 
-			flit := &ast.FuncLit{
-				Type: &ast.FuncType{
-					Results: &ast.FieldList{
-						List: []*ast.Field{
-							&ast.Field{
-								Type: CommonIdents["Message"],
-							},
+			// declareMessage(QMI_SERVICE_CTL, 0x0022, "CTLAllocateCIDOutput")
+			declare := &ast.CallExpr{
+				Fun: commonIdent("declareMessage"),
+				Args: []ast.Expr{
+					ast.NewIdent("QMI_SERVICE_" + v.Service),
+					idLit(v.id),
+					&ast.BasicLit{
+						Kind:  token.STRING,
+						Value: strconv.Quote(type_name),
+					},
+				},
+			}
+			vendor, err := v.VendorLit()
+			if err != nil {
+				return nil, err
+			}
+			if vendor != nil {
+				// declareVendorMessage(QMI_SERVICE_DMS, 0x5556, 0x1234, "DMSFooOutput")
+				declare.Fun = commonIdent("declareVendorMessage")
+				declare.Args = []ast.Expr{declare.Args[0], declare.Args[1], vendor, declare.Args[2]}
+			}
+			init_stmts = append(init_stmts, &ast.ExprStmt{X: declare})
+
+			request_info, err := gen.genMessageInfo(v.Service, v.id, vendor, "DirectionRequest", v.Service+gen.goName(v.Name)+"Input", v.Name, v.Input)
+			if err != nil {
+				return nil, err
+			}
+			response_info, err := gen.genMessageInfo(v.Service, v.id, vendor, "DirectionResponse", type_name, v.Name, v.Output)
+			if err != nil {
+				return nil, err
+			}
+
+			reg_stmts := []ast.Stmt{
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: commonIdent("registerMessage"),
+						Args: []ast.Expr{
+							genConstructor(type_name, "Message"),
 						},
 					},
 				},
-				Body: &ast.BlockStmt{
-					List: []ast.Stmt{
-						&ast.ReturnStmt{
-							Results: []ast.Expr{
-								&ast.UnaryExpr{
-									Op: token.AND,
-									X: &ast.CompositeLit{
-										Type: ident,
-									},
-								},
-							},
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: commonIdent("registerRequest"),
+						Args: []ast.Expr{
+							genConstructor(v.Service+gen.goName(v.Name)+"Input", "Message"),
 						},
 					},
 				},
+				request_info,
+				response_info,
+			}
+
+			if !gen.opts.ExplicitRegister {
+				init_stmts = append(init_stmts, reg_stmts...)
+				continue
+			}
+
+			// func RegisterCTLAllocateCID() { registerMessage(...) }
+			reg_name := "Register" + v.Service + gen.goName(v.Name)
+			f.Decls = append(f.Decls, &ast.FuncDecl{
+				Name: ast.NewIdent(reg_name),
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+				},
+				Body: &ast.BlockStmt{
+					List: reg_stmts,
+				},
+			})
+
+			if _, ok := all_stmts[v.Service]; !ok {
+				services = append(services, v.Service)
+			}
+			all_stmts[v.Service] = append(
+				all_stmts[v.Service],
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: ast.NewIdent(reg_name),
+					},
+				},
+			)
+		case *QMIIndication:
+			type_name := v.Service + gen.goName(v.Name) + "Indication"
+			registered[type_name] = true
+
+			info, err := gen.genMessageInfo(v.Service, v.id, nil, "DirectionIndication", type_name, v.Name, v.Output)
+			if err != nil {
+				return nil, err
 			}
 
-			init_stmts = append(
-				init_stmts,
+			// registerIndication(func() Message { return &WDSPacketServiceStatusIndication{} })
+			reg_stmts := []ast.Stmt{
 				&ast.ExprStmt{
 					X: &ast.CallExpr{
-						Fun: CommonIdents["registerMessage"],
+						Fun: commonIdent("registerIndication"),
 						Args: []ast.Expr{
-							flit,
+							genConstructor(type_name, "Message"),
 						},
 					},
 				},
+				info,
+			}
+
+			if !gen.opts.ExplicitRegister {
+				init_stmts = append(init_stmts, reg_stmts...)
+				continue
+			}
+
+			reg_name := "Register" + type_name
+			f.Decls = append(f.Decls, &ast.FuncDecl{
+				Name: ast.NewIdent(reg_name),
+				Type: &ast.FuncType{
+					Params: &ast.FieldList{},
+				},
+				Body: &ast.BlockStmt{
+					List: reg_stmts,
+				},
+			})
+
+			if _, ok := all_stmts[v.Service]; !ok {
+				services = append(services, v.Service)
+			}
+			all_stmts[v.Service] = append(
+				all_stmts[v.Service],
+				&ast.ExprStmt{
+					X: &ast.CallExpr{
+						Fun: ast.NewIdent(reg_name),
+					},
+				},
 			)
 		}
 	}
 
+	err = checkRegistry(f, registered)
+	if err != nil {
+		return nil, err
+	}
+
+	name_decls, name_stmts, err := gen.genMessageNames(entities)
+	if err != nil {
+		return nil, err
+	}
+	f.Decls = append(f.Decls, name_decls...)
+	init_stmts = append(init_stmts, name_stmts...)
+
+	flow_decls, flow_stmts, flow_services, err := gen.genTokenFlows(entities, f.Pos()-1)
+	if err != nil {
+		return nil, err
+	}
+	f.Decls = append(f.Decls, flow_decls...)
+	for _, service := range flow_services {
+		if !gen.opts.ExplicitRegister {
+			init_stmts = append(init_stmts, flow_stmts[service])
+			continue
+		}
+		// registered with the messages
+		all_stmts[service] = append(all_stmts[service], flow_stmts[service])
+	}
+
+	for _, service := range services {
+		f.Decls = append(f.Decls, &ast.FuncDecl{
+			Name: ast.NewIdent("RegisterAll" + service),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+			},
+			Body: &ast.BlockStmt{
+				List: all_stmts[service],
+			},
+		})
+	}
+
+	command_decls, command_stmts, command_services, err := gen.genCommands(entities)
+	if err != nil {
+		return nil, err
+	}
+	err = gen.declareDecls(command_decls, "commands")
+	if err != nil {
+		return nil, err
+	}
+	f.Decls = append(f.Decls, command_decls...)
+	if !gen.opts.ExplicitRegister {
+		for _, service := range command_services {
+			init_stmts = append(init_stmts, command_stmts[service])
+		}
+	}
+
+	common_stmts := []ast.Stmt{}
+	for _, cRef := range common_tlvs {
+		common_stmts = append(
+			common_stmts,
+			&ast.ExprStmt{
+				X: &ast.CallExpr{
+					Fun: commonIdent("registerCommonTLV"),
+					Args: []ast.Expr{
+						&ast.BasicLit{
+							Kind:  token.STRING,
+							Value: strconv.Quote(cRef),
+						},
+						genConstructor("QMIStruct"+gen.goName(cRef), "CommonTLV"),
+					},
+				},
+			},
+		)
+	}
+
+	for _, enum := range gen.pendingEnums {
+		decls := enum.GenDecls(gen)
+		err = gen.declareDecls(decls, fmt.Sprintf("public-format %q", enum.Name))
+		if err != nil {
+			return nil, err
+		}
+		f.Decls = append(f.Decls, decls...)
+	}
+	gen.pendingEnums = nil
+	for _, scale := range gen.pendingScales {
+		decls := scale.GenDecls(gen)
+		err = gen.declareDecls(decls, fmt.Sprintf("field %q", scale.Field))
+		if err != nil {
+			return nil, err
+		}
+		f.Decls = append(f.Decls, decls...)
+	}
+	gen.pendingScales = nil
+
+	if gen.opts.ExplicitRegister && len(common_stmts) > 0 {
+		f.Decls = append(f.Decls, &ast.FuncDecl{
+			Name: ast.NewIdent("RegisterCommonTLVs"),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+			},
+			Body: &ast.BlockStmt{
+				List: common_stmts,
+			},
+		})
+	} else {
+		init_stmts = append(init_stmts, common_stmts...)
+	}
+
+	// checked from init() even with -explicit-register: they only cost
+	// strings, and mis-sized TLVs would go unnoticed otherwise
+	init_stmts = append(gen.genCommonRefChecks(), init_stmts...)
+	gen.usedRefs = map[string]string{}
+
 	if len(init_stmts) > 0 {
 		fun_init := &ast.FuncDecl{
 			Name: ast.NewIdent("init"),
@@ -1728,71 +5188,71 @@ func convert(outputFile, inputFile string) error {
 		f.Decls = append(f.Decls, fun_init)
 	}
 
-	// DEBUG: ast.Print(fs, f)
-
-	defer f_out.Close()
-
-	defer func() {
-		fmt.Fprintf(
-			f_out,
-			"\n// Code generated by %s from %s, DO NOT EDIT.\n",
-			genpath,
-			inputFile,
-		)
-
-		if filepath.Base(outputFile) == "qmi-common.go" {
-			f_out.Write([]byte(COMMON_FOOTER))
+	if filepath.Base(gen.opts.Output) != "qmi-common.go" {
+		// a file of indications only has no use for fmt
+		var declspec []ast.Spec
+		for _, import_module := range importsUsed(f.Decls, []string{
+			"bytes",
+			"context",
+			"encoding/binary",
+			"fmt",
+			"io",
+		}) {
+			spec := &ast.ImportSpec{
+				Path: &ast.BasicLit{
+					Kind:  token.STRING,
+					Value: fmt.Sprintf("%q", import_module),
+				},
+			}
+			f.Imports = append(f.Imports, spec)
+			declspec = append(declspec, spec)
 		}
+		if len(declspec) > 0 {
+			f.Decls = append([]ast.Decl{
+				&ast.GenDecl{
+					Tok:   token.IMPORT,
+					Specs: declspec,
+				},
+			}, f.Decls...)
+		}
+	}
 
-		f_out.Write([]byte("// vim: ai:ts=8:sw=8:noet:syntax=go\n"))
-	}()
-
-	return format.Node(f_out, fs, f)
-}
+	// DEBUG: ast.Print(fs, f)
 
-func main() {
-	if len(os.Args) <= 1 {
-		os.RemoveAll("../qmi")
-		os.MkdirAll("../qmi", 0777)
+	err = checkSharedNodes(f)
+	if err != nil {
+		return nil, err
+	}
 
-		err := convert("../qmi/qmi-common.go", "data/qmi-common.json")
-		if err != nil {
-			panic(err)
-		}
+	src, err := formatVerified(fs, f)
+	if err != nil {
+		return nil, err
+	}
 
-		err = convert("../qmi/qmi-service-ctl.go", "data/qmi-service-ctl.json")
-		if err != nil {
-			panic(err)
-		}
+	if gen.opts.SizeReport != nil && len(sizes) > 0 {
+		printSizeReport(gen.opts.SizeReport, gen.opts.Output, sizes)
+	}
 
-		err = convert("../qmi/qmi-service-dms.go", "data/qmi-service-dms.json")
-		if err != nil {
-			panic(err)
-		}
+	out.Write(gen.addDocComments(src))
+	out.WriteString("\n")
+	gen.docComments = map[string]string{}
 
-		err = convert("../qmi/qmi-service-wds.go", "data/qmi-service-wds.json")
-		if err != nil {
-			panic(err)
-		}
-	} else if len(os.Args) == 3 {
-		wd, err := os.Getwd()
-		if err != nil {
-			panic(err)
-		}
+	if filepath.Base(gen.opts.Output) == "qmi-common.go" {
+		out.WriteString(COMMON_FOOTER)
+	}
 
-		dir := filepath.Dir(filepath.Join(wd, os.Args[1]))
-		err = convert("/dev/null", filepath.Join(dir, "qmi-common.json"))
-		if err != nil {
-			panic(err)
-		}
+	out.WriteString("// vim: ai:ts=8:sw=8:noet:syntax=go\n")
 
-		err = convert(os.Args[2], os.Args[1])
+	if !gen.opts.SkipTypeCheck {
+		err = gen.typeCheck(out.Bytes(), f.Decls)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
-	} else {
-		panic(fmt.Sprintf("usage: %s [<inputFile> <outputFile>]", os.Args[0]))
 	}
+	gen.origins = map[ast.Decl]string{}
+	gen.reg.setSource(filepath.Base(gen.opts.Output), out.Bytes())
+
+	return out.Bytes(), nil
 }
 
 // vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,1082 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// generateTestdata generates the data files of testdata/data with o into a
+// temporary directory and parses the files
+func generateTestdata(t *testing.T, o Options) (*token.FileSet, []*ast.File) {
+	t.Helper()
+	inputs, err := filepath.Glob("testdata/data/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	o.Generator = "qmigen"
+	err = GenerateFiles(inputs, dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := token.NewFileSet()
+	var files []*ast.File
+	for _, input := range inputs {
+		name := filepath.Base(input)
+		f, err := parser.ParseFile(fs, filepath.Join(dir, name[:len(name)-len(".json")]+".go"), nil, parser.ParseComments)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, f)
+	}
+	return fs, files
+}
+
+// TestPointerReceivers makes sure messages implement Message through
+// pointers only, as the registry constructs them
+func TestPointerReceivers(t *testing.T) {
+	methods := map[string]bool{
+		"ServiceID":    true,
+		"MessageID":    true,
+		"TLVsWriteTo":  true,
+		"TLVsReadFrom": true,
+		"String":       true,
+	}
+
+	fs, files := generateTestdata(t, Options{})
+	checked := 0
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fun, ok := decl.(*ast.FuncDecl)
+			if !ok || fun.Recv == nil || !methods[fun.Name.Name] {
+				continue
+			}
+			typ := fun.Recv.List[0].Type
+			star, ok := typ.(*ast.StarExpr)
+			if ok {
+				typ = star.X
+			}
+			name := typ.(*ast.Ident).Name
+			if !strings.HasSuffix(name, "Input") && !strings.HasSuffix(name, "Output") && !strings.HasSuffix(name, "Indication") {
+				continue
+			}
+			if !ok {
+				t.Errorf("%s: %s.%s has a value receiver", fs.Position(fun.Pos()), name, fun.Name.Name)
+			}
+			checked++
+		}
+	}
+	if checked == 0 {
+		t.Error("no message methods generated")
+	}
+}
+
+// arrayMessage is a data file with an array TLV of the given size prefix
+// format
+func arrayMessage(prefix string) string {
+	attr := ""
+	if prefix != "" {
+		attr = `"size-prefix-format" : "` + prefix + `",`
+	}
+	return `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Network Scan", "type" : "Message", "service" : "NAS", "id" : "0x0021", "since" : "1.0",
+    "output" : [ { "name" : "Bands", "id" : "0x10", "type" : "TLV", "since" : "1.0",
+                   "format" : "array", ` + attr + ` "array-element" : { "format" : "guint16" } } ] }
+]`
+}
+
+func TestSizePrefixFormat(t *testing.T) {
+	for _, test := range []struct {
+		prefix string
+		count  string
+	}{
+		{"", "uint8(len(msg.Bands))"},
+		{"guint8", "uint8(len(msg.Bands))"},
+		{"guint16", "uint16(len(msg.Bands))"},
+	} {
+		src, err := Generate(strings.NewReader(arrayMessage(test.prefix)), Options{Common: NewRegistry(nil)})
+		if err != nil {
+			t.Errorf("%q: %s", test.prefix, err)
+			continue
+		}
+		if !strings.Contains(string(src), test.count) {
+			t.Errorf("%q: generated code lacks %s", test.prefix, test.count)
+		}
+	}
+
+	_, err := Generate(strings.NewReader(arrayMessage("guint64")), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), `"guint64"`) {
+		t.Errorf("err = %v, want one naming guint64", err)
+	}
+}
+
+// scalarFormats are the integer formats of the data files, each read and
+// written by generated code
+var scalarFormats = []string{"guint8", "gint8", "guint16", "gint16", "guint32", "gint32", "guint64", "gint64", "byte"}
+
+// scalarMessage is a data file with an output TLV of each scalar format,
+// named after the format
+func scalarMessage() string {
+	var tlvs []string
+	for i, format := range scalarFormats {
+		tlvs = append(tlvs, fmt.Sprintf(`{ "name" : "%s", "id" : "0x%02x", "type" : "TLV", "since" : "1.0", "format" : "%s" }`,
+			format, 0x10+i, format))
+	}
+	return `[
+  { "name" : "DMS", "type" : "Service" },
+  { "name" : "Get Scalars", "type" : "Message", "service" : "DMS", "id" : "0x5000", "since" : "1.0",
+    "output" : [ ` + strings.Join(tlvs, ",\n") + ` ] }
+]`
+}
+
+// fieldsUsed returns the fields of msg selected in the bodies of method
+func fieldsUsed(f *ast.File, method string) map[string]bool {
+	used := map[string]bool{}
+	for _, decl := range f.Decls {
+		fun, ok := decl.(*ast.FuncDecl)
+		if !ok || fun.Recv == nil || fun.Name.Name != method {
+			continue
+		}
+		ast.Inspect(fun.Body, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if ok {
+				if x, ok := sel.X.(*ast.Ident); ok && x.Name == "msg" {
+					used[sel.Sel.Name] = true
+				}
+			}
+			return true
+		})
+	}
+	return used
+}
+
+// TestScalarFormats makes sure every scalar format is both read and
+// written, through encoding/binary and with -direct-encoding
+func TestScalarFormats(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		src, err := Generate(strings.NewReader(scalarMessage()), Options{Common: NewRegistry(nil), DirectEncoding: direct})
+		if err != nil {
+			t.Fatalf("direct %v: %s", direct, err)
+		}
+		f, err := parser.ParseFile(token.NewFileSet(), "", src, 0)
+		if err != nil {
+			t.Fatalf("direct %v: %s", direct, err)
+		}
+		read := fieldsUsed(f, "TLVsReadFrom")
+		written := fieldsUsed(f, "TLVsWriteTo")
+		for _, format := range scalarFormats {
+			field := strings.Title(format)
+			if !read[field] {
+				t.Errorf("direct %v: %s is not read", direct, format)
+			}
+			if !written[field] {
+				t.Errorf("direct %v: %s is not written", direct, format)
+			}
+		}
+	}
+}
+
+// portMessage is a data file with a port TLV in the given byte order
+func portMessage(endian string) string {
+	return `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Port", "type" : "Message", "service" : "WDS", "id" : "0x5000", "since" : "1.0",
+    "output" : [ { "name" : "Port", "id" : "0x10", "type" : "TLV", "since" : "1.0",
+                   "format" : "guint16", "endian" : "` + endian + `" } ] }
+]`
+}
+
+func TestEndian(t *testing.T) {
+	for _, test := range []struct {
+		endian string
+		direct bool
+		order  string
+	}{
+		{"", false, "binary.LittleEndian"},
+		{"little", true, "leOrder"},
+		{"big", false, "binary.BigEndian"},
+		{"big", true, "binary.BigEndian"},
+	} {
+		src, err := Generate(strings.NewReader(portMessage(test.endian)), Options{Common: NewRegistry(nil), DirectEncoding: test.direct})
+		if err != nil {
+			t.Errorf("%q: %s", test.endian, err)
+			continue
+		}
+		// both the read and the write of the port, TLV lengths stay
+		// little endian
+		n := 0
+		for _, line := range strings.Split(string(src), "\n") {
+			if !strings.Contains(line, "msg.Port") || !strings.Contains(line, "Endian") && !strings.Contains(line, "leOrder") {
+				continue
+			}
+			if !strings.Contains(line, test.order) {
+				t.Errorf("%q, direct %v: %s", test.endian, test.direct, strings.TrimSpace(line))
+			}
+			n++
+		}
+		if n != 2 {
+			t.Errorf("%q, direct %v: the port is encoded %d times", test.endian, test.direct, n)
+		}
+	}
+
+	_, err := Generate(strings.NewReader(portMessage("network")), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), `endian "network" is unsupported`) {
+		t.Errorf("err = %v, want one naming the endian", err)
+	}
+}
+
+// TestInternalMessages expects CTL Internal Proxy Open only with Internal
+func TestInternalMessages(t *testing.T) {
+	for _, internal := range []bool{false, true} {
+		_, files := generateTestdata(t, Options{Internal: internal})
+		types := map[string]bool{}
+		for _, f := range files {
+			for name, obj := range f.Scope.Objects {
+				if obj.Kind == ast.Typ {
+					types[name] = true
+				}
+			}
+		}
+		if !types["CTLSyncInput"] {
+			t.Fatalf("internal %v: CTL not generated", internal)
+		}
+		if types["CTLInternalProxyOpenInput"] != internal {
+			t.Errorf("internal %v: CTLInternalProxyOpenInput generated: %v", internal, !internal)
+		}
+	}
+}
+
+// TestRepeatableInput rejects repeatable request TLVs, which only
+// responses may carry
+func TestRepeatableInput(t *testing.T) {
+	src := `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Set Cells", "type" : "Message", "service" : "NAS", "id" : "0x5000", "since" : "1.0",
+    "input" : [ { "name" : "Cell", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "guint32",
+                  "repeatable" : true } ] }
+]`
+	_, err := Generate(strings.NewReader(src), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), `repeatable input TLV "Cell" is not supported`) {
+		t.Errorf("err = %v, want one naming the repeatable input", err)
+	}
+}
+
+func TestUnknownCommonRef(t *testing.T) {
+	src := `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Get Serving System", "type" : "Message", "service" : "NAS", "id" : "0x0024", "since" : "1.0",
+    "output" : [ { "common-ref" : "Serving Network" } ] }
+]`
+	_, err := Generate(strings.NewReader(src), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), `unknown common-ref "Serving Network"`) {
+		t.Errorf("err = %v, want one naming the common-ref", err)
+	}
+}
+
+func TestStringEncodingUnsupported(t *testing.T) {
+	src := `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Start Network", "type" : "Message", "service" : "WDS", "id" : "0x0020", "since" : "1.0",
+    "input" : [ { "name" : "Username", "id" : "0x17", "type" : "TLV", "since" : "1.0", "format" : "string",
+                  "string-encoding" : "utf16" } ] }
+]`
+	_, err := Generate(strings.NewReader(src), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), `string encoding "utf16" is unsupported`) {
+		t.Errorf("err = %v, want one naming the encoding", err)
+	}
+}
+
+// TestCommonRefCycle rejects common-refs containing each other
+func TestCommonRefCycle(t *testing.T) {
+	src := `[
+  { "common-ref" : "A", "name" : "A", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+    "contents" : [ { "common-ref" : "B" } ] },
+  { "common-ref" : "B", "name" : "B", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+    "contents" : [ { "common-ref" : "A" }, { "name" : "X", "format" : "guint8" } ] }
+]`
+	_, err := Generate(strings.NewReader(src), Options{Common: NewRegistry(nil)})
+	if err == nil || !strings.Contains(err.Error(), "contains itself") {
+		t.Errorf("err = %v, want a cycle reported", err)
+	}
+}
+
+// TestTokenFlows makes sure the flows of PDC and UIM are registered from
+// init, or with the messages with -explicit-register
+func TestTokenFlows(t *testing.T) {
+	for _, explicit := range []bool{false, true} {
+		_, files := generateTestdata(t, Options{ExplicitRegister: explicit})
+		registrar := map[string]string{}
+		for _, f := range files {
+			for _, decl := range f.Decls {
+				fun, ok := decl.(*ast.FuncDecl)
+				if !ok || fun.Recv != nil {
+					continue
+				}
+				ast.Inspect(fun.Body, func(n ast.Node) bool {
+					call, ok := n.(*ast.CallExpr)
+					if ok && isIdent(call.Fun, "addTokenFlows") {
+						registrar[call.Args[0].(*ast.Ident).Name] = fun.Name.Name
+					}
+					return true
+				})
+			}
+		}
+
+		for _, service := range []string{"PDC", "UIM"} {
+			want := "init"
+			if explicit {
+				want = "RegisterAll" + service
+			}
+			if got := registrar["Register"+service+"TokenFlows"]; got != want {
+				t.Errorf("explicit %t: %s flows added from %q, want %q", explicit, service, got, want)
+			}
+		}
+		if len(registrar) != 2 {
+			t.Errorf("explicit %t: flows %v", explicit, registrar)
+		}
+	}
+}
+
+// TestReadErrorsChecked expects no read of the generated decoders to drop
+// its error
+func TestReadErrorsChecked(t *testing.T) {
+	for _, direct := range []bool{false, true} {
+		fs, files := generateTestdata(t, Options{DirectEncoding: direct})
+		for _, f := range files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				stmt, ok := n.(*ast.ExprStmt)
+				if !ok {
+					return true
+				}
+				call, ok := stmt.X.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				if sel, ok := call.Fun.(*ast.SelectorExpr); ok && strings.HasPrefix(sel.Sel.Name, "Read") {
+					t.Errorf("direct %v: %s: result of %s dropped", direct, fs.Position(call.Pos()), sel.Sel.Name)
+				}
+				return true
+			})
+		}
+	}
+}
+
+// TestErrorsEntity generates the result codes of a service and rejects
+// those it cannot
+func TestErrorsEntity(t *testing.T) {
+	errorsEntity := func(service, value string) string {
+		return `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "QMI WDS Errors", "type" : "Errors", "service" : "` + service + `",
+    "errors" : [ { "name" : "Call throttled", "value" : "` + value + `" } ] }
+]`
+	}
+
+	src, err := Generate(strings.NewReader(errorsEntity("WDS", "0x1001")), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`var WDSErrorDescription = map[QMIError]string{0x1001: "Call throttled"}`,
+		"declareServiceErrors(QMI_SERVICE_WDS, WDSErrorDescription)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %s in\n%s", want, src)
+		}
+	}
+
+	for _, test := range []struct{ service, value string }{
+		{"", "0x1001"},
+		{"WDS", "0x10000"},
+		{"WDS", "throttled"},
+	} {
+		_, err := Generate(strings.NewReader(errorsEntity(test.service, test.value)), Options{Common: NewRegistry(nil)})
+		if err == nil {
+			t.Errorf("service %q, value %q accepted", test.service, test.value)
+		}
+	}
+}
+
+// nameMessage is a data file with a string output TLV of the given
+// public-format
+func nameMessage(format string) string {
+	return `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Get Name", "type" : "Message", "service" : "NAS", "id" : "0x5000", "since" : "1.0",
+    "output" : [ { "name" : "Name", "id" : "0x10", "type" : "TLV", "since" : "1.0",
+                   "format" : "string", "public-format" : "` + format + `" } ] }
+]`
+}
+
+// TestStringPolicy expects the decoders to pass strings through the
+// function of each policy, after the GSM alphabet
+func TestStringPolicy(t *testing.T) {
+	for _, test := range []struct {
+		policy, format string
+		want           []string
+	}{
+		{"", "", nil},
+		{"replace", "", []string{"replaceInvalid(msg.Name)"}},
+		{"escape", "", []string{"escapeInvalid(msg.Name)"}},
+		{"strict", "gsm7", []string{"decodeGSM7(msg.Name)", "rejectInvalid(msg.Name)"}},
+		{"", "gsm7", []string{"decodeGSM7(msg.Name)"}},
+	} {
+		src, err := Generate(strings.NewReader(nameMessage(test.format)), Options{Common: NewRegistry(nil), StringPolicy: test.policy})
+		if err != nil {
+			t.Errorf("%q, %q: %s", test.policy, test.format, err)
+			continue
+		}
+		at := 0
+		for _, want := range test.want {
+			i := strings.Index(string(src[at:]), want)
+			if i < 0 {
+				t.Errorf("%q, %q: no %s in order", test.policy, test.format, want)
+				break
+			}
+			at += i
+		}
+		for _, f := range []string{"replaceInvalid(", "escapeInvalid(", "rejectInvalid("} {
+			if strings.Contains(string(src), f) && !strings.Contains(strings.Join(test.want, " "), f) {
+				t.Errorf("%q, %q: %s called", test.policy, test.format, f)
+			}
+		}
+	}
+
+	_, err := Generate(strings.NewReader(nameMessage("")), Options{Common: NewRegistry(nil), StringPolicy: "lenient"})
+	if err == nil {
+		t.Error("policy lenient accepted")
+	}
+	_, err = Generate(strings.NewReader(nameMessage("ucs2")), Options{Common: NewRegistry(nil)})
+	if err == nil {
+		t.Error("public-format ucs2 accepted")
+	}
+}
+
+// indicationMessage is a data file with a response and an indication
+// sharing their ID
+const indicationMessage = `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Status", "type" : "Message", "service" : "WDS", "id" : "0x5022", "since" : "1.0",
+    "output" : [ { "name" : "Status", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] },
+  { "name" : "Status", "type" : "Indication", "service" : "WDS", "id" : "0x5022", "since" : "1.0",
+    "output" : [ { "name" : "Status", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] }
+]`
+
+// TestIndicationType expects an indication type of its own, registered
+// apart from the response
+func TestIndicationType(t *testing.T) {
+	for _, explicit := range []bool{false, true} {
+		out, err := Generate(strings.NewReader(indicationMessage), Options{Common: NewRegistry(nil), ExplicitRegister: explicit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		src := string(out)
+		if !strings.Contains(src, "type WDSStatusIndication struct") {
+			t.Fatalf("explicit %v: no WDSStatusIndication in\n%s", explicit, src)
+		}
+		for _, m := range []string{"ServiceID", "MessageID", "IndicationID", "TLVsReadFrom"} {
+			if !strings.Contains(src, "func (msg *WDSStatusIndication) "+m+"(") {
+				t.Errorf("explicit %v: no WDSStatusIndication.%s", explicit, m)
+			}
+		}
+		if n := strings.Count(src, "registerIndication("); n != 1 {
+			t.Errorf("explicit %v: %d registerIndication calls", explicit, n)
+		}
+		if register := strings.Contains(src, "func RegisterWDSStatusIndication()"); register != explicit {
+			t.Errorf("explicit %v: RegisterWDSStatusIndication declared %v", explicit, register)
+		}
+	}
+}
+
+// TestIndicationOnlyFile type-checks a file declaring nothing but an
+// indication, which must import only the packages it uses
+func TestIndicationOnlyFile(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src := `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Event Report", "type" : "Indication", "service" : "WDS", "id" : "0x0001", "since" : "1.0",
+    "output" : [ { "name" : "Tx Packets Ok", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "guint32" },
+                 { "common-ref" : "Serving Cell" },
+                 { "name" : "Channel Rate", "id" : "0x16", "type" : "TLV", "since" : "1.0", "format" : "struct",
+                   "contents" : [ { "name" : "Tx Rate", "format" : "guint32" }, { "name" : "Rx Rate", "format" : "guint32" } ] } ] }
+]`
+	for _, o := range []Options{{Common: common}, {Common: common, OptionalPointers: true}} {
+		out, err := Generate(strings.NewReader(src), o)
+		if err != nil {
+			t.Fatalf("optional pointers %v: %s", o.OptionalPointers, err)
+		}
+		if strings.Contains(string(out), `"fmt"`) {
+			t.Errorf("optional pointers %v: fmt imported", o.OptionalPointers)
+		}
+	}
+}
+
+// tagsMessage is a data file with an input TLV of each id
+func tagsMessage(ids ...string) string {
+	var tlvs []string
+	for i, id := range ids {
+		tlvs = append(tlvs, `{ "name" : "TLV `+strconv.Itoa(i)+`", "id" : "`+id+`", "type" : "TLV", "since" : "1.0", "format" : "guint8" }`)
+	}
+	return `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Set Tags", "type" : "Message", "service" : "WDS", "id" : "0x5000", "since" : "1.0",
+    "input" : [ ` + strings.Join(tlvs, ",\n") + ` ] }
+]`
+}
+
+// TestTagLiterals generates tags at the ends of the signed and unsigned
+// byte ranges, written in decimal or padded, as canonical hex literals
+// type-checked with the runtime
+func TestTagLiterals(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := Generate(strings.NewReader(tagsMessage("0x00", "0x7F", "128", " 0xff ")), Options{Common: common})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range []string{"0x00", "0x7F", "0x80", "0xFF"} {
+		for _, want := range []string{"w.Write([]byte{" + tag + "})", "findTag(r, " + tag + ")"} {
+			if !strings.Contains(string(src), want) {
+				t.Errorf("no %s in\n%s", want, src)
+			}
+		}
+	}
+
+	for _, id := range []string{"0x100", "-1", "ten"} {
+		_, err := Generate(strings.NewReader(tagsMessage(id)), Options{Common: NewRegistry(nil)})
+		if err == nil {
+			t.Errorf("id %q accepted", id)
+		}
+	}
+}
+
+// TestIndicationIDs expects the constants of the indications of a service
+// sorted by ID, whatever the order of the data file, and a map naming them
+func TestIndicationIDs(t *testing.T) {
+	indication := func(name, id string) string {
+		return `{ "name" : "` + name + `", "type" : "Indication", "service" : "WDS", "id" : "` + id + `", "since" : "1.0",
+    "output" : [ { "name" : "Status", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] }`
+	}
+	src := `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "QMI Indication WDS", "type" : "Indication-ID-Enum" },
+  ` + indication("Packet Service Status", "0x0022") + `,
+  ` + indication("Event Report", "0x0001") + `,
+  ` + indication("Extended IP Config", "0x008C") + `
+]`
+	_, consts, _, out := enumDecls(t, src, Options{})
+	want := []string{
+		"QMI_INDICATION_WDS_EVENT_REPORT",
+		"QMI_INDICATION_WDS_PACKET_SERVICE_STATUS",
+		"QMI_INDICATION_WDS_EXTENDED_IP_CONFIG",
+	}
+	for i, name := range want {
+		if consts[name] != []string{"0x0001", "0x0022", "0x008C"}[i] {
+			t.Errorf("%s = %q", name, consts[name])
+		}
+		if i > 0 && strings.Index(out, name+" ") < strings.Index(out, want[i-1]+" ") {
+			t.Errorf("%s before %s", name, want[i-1])
+		}
+		if !strings.Contains(out, name+": ") {
+			t.Errorf("%s not in WDSIndicationMap", name)
+		}
+	}
+	if !strings.Contains(out, "var WDSIndicationMap = map[uint16]string{") {
+		t.Errorf("no WDSIndicationMap in\n%s", out)
+	}
+}
+
+// TestClientType expects a client type documented with its Since, and
+// methods on it for the messages following the Client entry
+func TestClientType(t *testing.T) {
+	message := func(name, id string) string {
+		return `{ "name" : "` + name + `", "type" : "Message", "service" : "WDS", "id" : "` + id + `", "since" : "1.0",
+    "output" : [ { "name" : "Status", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] }`
+	}
+	src, err := Generate(strings.NewReader(`[
+  { "name" : "WDS", "type" : "Service" },
+  `+message("Get Early", "0x5001")+`,
+  { "name" : "QMI Client WDS", "type" : "Client", "since" : "1.2" },
+  `+message("Get Status", "0x5002")+`
+]`), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// WDSClient is a client of the WDS service, since libqmi 1.2\ntype WDSClient struct {\n\t*Client\n}",
+		"func (dev *Device) WDS() (*WDSClient, error) {",
+		"func (client *WDSClient) GetStatus(input WDSGetStatusInput) (m *WDSGetStatusOutput, err error) {",
+		"func (dev *Device) WDSGetStatus(input WDSGetStatusInput)",
+		"func (dev *Device) WDSGetEarly(input WDSGetEarlyInput)",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %q in\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "func (client *WDSClient) GetEarly(") {
+		t.Error("method for a message before the Client entry")
+	}
+}
+
+// TestRearm expects a client method re-arming the messages marked with
+// "rearm" in the data file, named by it, and none for the others
+func TestRearm(t *testing.T) {
+	message := func(name, id, rearm string) string {
+		return `{ "name" : "` + name + `", "type" : "Message", "service" : "WDS", "id" : "` + id + `", "since" : "1.0",` + rearm + `
+    "input" : [ { "name" : "Interval", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ],
+    "output" : [ { "name" : "Status", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] }`
+	}
+	src, err := Generate(strings.NewReader(`[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "QMI Client WDS", "type" : "Client", "since" : "1.0" },
+  `+message("Set Event Report", "0x0001", ` "rearm" : "Configure Event Report",`)+`,
+  `+message("Set Interval", "0x5000", "")+`
+]`), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// ConfigureEventReport sends WDS Set Event Report, and again after every Reconnect\n" +
+			"func (client *WDSClient) ConfigureEventReport(ctx context.Context, input WDSSetEventReportInput) error {",
+		`client.Device.sendRearmed(ctx, "WDS Set Event Report", &input)`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %q in\n%s", want, src)
+		}
+	}
+	if n := strings.Count(string(src), "sendRearmed("); n != 1 {
+		t.Errorf("%d messages re-armed", n)
+	}
+}
+
+// prerequisiteMessage is a data file with a sequence whose second field
+// depends on the first, or on a later one with later
+func prerequisiteMessage(operation, value string, later bool) string {
+	field := "Mode"
+	if later {
+		field = "Last"
+	}
+	return `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Get Mode", "type" : "Message", "service" : "NAS", "id" : "0x5000", "since" : "1.0",
+    "output" : [ { "name" : "Info", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+                   "contents" : [ { "name" : "Mode", "format" : "guint8" },
+                                  { "name" : "Value", "format" : "guint16",
+                                    "prerequisites" : [ { "field" : "` + field + `", "operation" : "` + operation + `", "value" : "` + value + `" } ] },
+                                  { "name" : "Last", "format" : "guint8" } ] } ] }
+]`
+}
+
+// TestPrerequisiteOps expects the reads and writes of a field guarded by
+// each comparison, and prerequisites the generator cannot honor rejected
+func TestPrerequisiteOps(t *testing.T) {
+	for op, cond := range map[string]string{
+		"==": "msg.Info.Mode == 1",
+		"!=": "msg.Info.Mode != 1",
+		"<":  "msg.Info.Mode < 1",
+		"<=": "msg.Info.Mode <= 1",
+		">":  "msg.Info.Mode > 1",
+		">=": "msg.Info.Mode >= 1",
+		"&":  "msg.Info.Mode&1 != 0",
+	} {
+		src, err := Generate(strings.NewReader(prerequisiteMessage(op, "1", false)), Options{Common: NewRegistry(nil)})
+		if err != nil {
+			t.Errorf("%s: %s", op, err)
+			continue
+		}
+		if n := strings.Count(string(src), "if "+cond+" {"); n != 2 {
+			t.Errorf("%s: %d guards %q, want a read and a write", op, n, cond)
+		}
+	}
+
+	for _, test := range []struct {
+		operation, value string
+		later            bool
+	}{
+		{"~", "1", false},
+		{"==", "Tuned", false},
+		{"==", "1", true},
+	} {
+		_, err := Generate(strings.NewReader(prerequisiteMessage(test.operation, test.value, test.later)), Options{Common: NewRegistry(nil)})
+		if err == nil {
+			t.Errorf("%+v accepted", test)
+		}
+	}
+}
+
+// vendorMessages is a standard message and a vendor specific one of the
+// same ID
+func vendorMessages(vendor string) string {
+	return `[
+  { "name" : "DMS", "type" : "Service" },
+  { "name" : "Get Band Capabilities", "type" : "Message", "service" : "DMS", "id" : "0x0045", "since" : "1.0",
+    "output" : [ { "name" : "Band Capability", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint64" } ] },
+  { "name" : "Telit Get Firmware Info", "type" : "Message", "service" : "DMS", "id" : "0x0045", "vendor" : "` + vendor + `", "since" : "1.0",
+    "output" : [ { "name" : "Version", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "string" } ] }
+]`
+}
+
+// TestVendorMessages expects the vendor specific message declared apart
+// from the standard one, which alone names the ID, and bad vendors rejected
+func TestVendorMessages(t *testing.T) {
+	src, err := Generate(strings.NewReader(vendorMessages("0x1bc7")), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// DMSTelitGetFirmwareInfoInput is specific to vendor 0x1BC7",
+		`declareVendorMessage(QMI_SERVICE_DMS, 0x0045, 0x1BC7, "DMSTelitGetFirmwareInfoOutput")`,
+		`declareMessage(QMI_SERVICE_DMS, 0x0045, "DMSGetBandCapabilitiesOutput")`,
+		`0x0045: "Get Band Capabilities"}`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %s in\n%s", want, src)
+		}
+	}
+
+	for _, vendor := range []string{"0", "0x10000", "telit"} {
+		_, err := Generate(strings.NewReader(vendorMessages(vendor)), Options{Common: NewRegistry(nil)})
+		if err == nil {
+			t.Errorf("vendor %q accepted", vendor)
+		}
+	}
+}
+
+// idMessage is a WDS message of id
+func idMessage(id string) string {
+	return `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Thing", "type" : "Message", "service" : "WDS", "id" : "` + id + `", "since" : "1.0" }
+]`
+}
+
+// TestMessageIDs expects hex, decimal and padded ids as the same canonical
+// literal, and ids which do not parse rejected naming the message
+func TestMessageIDs(t *testing.T) {
+	for _, id := range []string{"0x0025", "0X25", "37", " 0x25 "} {
+		src, err := Generate(strings.NewReader(idMessage(id)), Options{Common: NewRegistry(nil)})
+		if err != nil {
+			t.Errorf("id %q: %s", id, err)
+			continue
+		}
+		for _, want := range []string{"return 0x0025", `declareMessage(QMI_SERVICE_WDS, 0x0025, "WDSGetThingOutput")`} {
+			if !strings.Contains(string(src), want) {
+				t.Errorf("id %q: no %s in\n%s", id, want, src)
+			}
+		}
+	}
+
+	for _, id := range []string{"0x10000", "-1", "twenty", ""} {
+		_, err := Generate(strings.NewReader(idMessage(id)), Options{Common: NewRegistry(nil)})
+		if err == nil || !strings.Contains(err.Error(), "Get Thing") {
+			t.Errorf("id %q: %v", id, err)
+		}
+	}
+}
+
+// scaleMessage is a NAS message of a scaled field next to a plain one,
+// keys being the extra keys of the former
+func scaleMessage(format, keys string) string {
+	return `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Get Signal", "type" : "Message", "service" : "NAS", "id" : "0x004F", "since" : "1.0",
+    "output" : [ { "name" : "Signal", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+                   "contents" : [ { "name" : "SNR", "format" : "` + format + `", ` + keys + ` },
+                                  { "name" : "RSRQ", "format" : "gint8" } ] } ] }
+]`
+}
+
+// TestScaleKeys expects a type of its own for a scaled field only, and
+// scales which are not positive numbers or not of integers rejected
+func TestScaleKeys(t *testing.T) {
+	src, err := Generate(strings.NewReader(scaleMessage("gint16", `"scale" : "0.1", "unit" : "dB"`)), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"SNR  NASGetSignalOutputSignalSNR",
+		"RSRQ int8",
+		"// NASGetSignalOutputSignalSNR counts steps of 0.1 dB",
+		"type NASGetSignalOutputSignalSNR int16",
+		"return float64(v) / 10",
+		`return formatUnits(v.Float(), "dB")`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %s in\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "NASGetSignalOutputSignalRSRQ") {
+		t.Error("unscaled RSRQ got a type")
+	}
+
+	src, err = Generate(strings.NewReader(scaleMessage("guint32", `"scale" : "100", "unit" : "bps"`)), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "return float64(v) * 100") {
+		t.Errorf("no multiplication by 100 in\n%s", src)
+	}
+
+	for _, bad := range []struct{ format, keys string }{
+		{"gint16", `"scale" : "0"`},
+		{"gint16", `"scale" : "-0.1"`},
+		{"gint16", `"scale" : "tenth"`},
+		{"string", `"unit" : "dB"`},
+	} {
+		_, err := Generate(strings.NewReader(scaleMessage(bad.format, bad.keys)), Options{Common: NewRegistry(nil)})
+		if err == nil || !strings.Contains(err.Error(), "SNR") {
+			t.Errorf("%s %s: %v", bad.format, bad.keys, err)
+		}
+	}
+}
+
+// TestCommands expects the requests without input TLVs as commands of
+// their service, registered from init() or by RegisterWDSCommands with
+// -explicit-register
+func TestCommands(t *testing.T) {
+	src := `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Thing", "type" : "Message", "service" : "WDS", "id" : "0x0025", "since" : "1.0" },
+  { "name" : "Set Thing", "type" : "Message", "service" : "WDS", "id" : "0x0026", "since" : "1.0",
+    "input" : [ { "name" : "Thing", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" } ] }
+]`
+	for _, explicit := range []bool{false, true} {
+		out, err := Generate(strings.NewReader(src), Options{Common: NewRegistry(nil), ExplicitRegister: explicit})
+		if err != nil {
+			t.Fatal(err)
+		}
+		register := "\tRegisterCommands(WDSCommands{})\n"
+		if explicit {
+			register = "func RegisterWDSCommands() {\n\tRegisterCommands(WDSCommands{})\n}"
+		}
+		for _, want := range []string{
+			"type WDSCommands struct",
+			"func (WDSCommands) Service() Service {\n\treturn QMI_SERVICE_WDS\n}",
+			`return map[string]func() Message{"get-thing": func() Message {`,
+			"return &WDSGetThingInput{}",
+			register,
+		} {
+			if !strings.Contains(string(out), want) {
+				t.Errorf("explicit %v: no %s in\n%s", explicit, want, out)
+			}
+		}
+		if strings.Contains(string(out), `"set-thing"`) {
+			t.Errorf("explicit %v: command of a request with input TLVs in\n%s", explicit, out)
+		}
+	}
+}
+
+// TestGenerateParallel converts every service of testdata/data twice at
+// once over a shared common registry, and expects the output of serial
+// runs: run with -race, it checks runs share no mutable state
+func TestGenerateParallel(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := filepath.Glob("testdata/data/qmi-service-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o := Options{Common: common, SkipTypeCheck: true}
+
+	serial := map[string][]byte{}
+	for _, input := range inputs {
+		data, err := ioutil.ReadFile(input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		src, err := Generate(bytes.NewReader(data), o)
+		if err != nil {
+			t.Fatalf("%s: %s", input, err)
+		}
+		serial[input] = src
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 2*len(inputs))
+	for i := 0; i < 2; i++ {
+		for _, input := range inputs {
+			wg.Add(1)
+			go func(input string) {
+				defer wg.Done()
+				data, err := ioutil.ReadFile(input)
+				if err != nil {
+					errs <- err
+					return
+				}
+				src, err := Generate(bytes.NewReader(data), o)
+				if err != nil {
+					errs <- fmt.Errorf("%s: %w", input, err)
+					return
+				}
+				if !bytes.Equal(src, serial[input]) {
+					errs <- fmt.Errorf("%s: differs from the serial run", input)
+				}
+			}(input)
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// TestWriteToPrologue expects generated TLVsWriteTo to declare no buffer
+// but the buf_<name> of the TLVs staging their payload, and to blank none.
+// The frames they write are compared byte by byte by the runtime tests.
+func TestWriteToPrologue(t *testing.T) {
+	for _, o := range []Options{{}, {DirectEncoding: true, OptionalPointers: true}} {
+		fs, files := generateTestdata(t, o)
+		for _, f := range files {
+			for _, decl := range f.Decls {
+				fun, ok := decl.(*ast.FuncDecl)
+				if !ok || fun.Name.Name != "TLVsWriteTo" {
+					continue
+				}
+				// those of the runtime, of DynamicMessage, are no concern
+				if recv := recvName(fun.Recv.List[0].Type); !strings.HasSuffix(recv, "Input") && !strings.HasSuffix(recv, "Output") && !strings.HasSuffix(recv, "Indication") {
+					continue
+				}
+				ast.Inspect(fun.Body, func(n ast.Node) bool {
+					switch n := n.(type) {
+					case *ast.Ident:
+						if n.Name == "buf" {
+							t.Errorf("%+v: %s: buf in TLVsWriteTo", o, fs.Position(n.Pos()))
+						}
+					case *ast.AssignStmt:
+						if len(n.Lhs) == 1 && isIdent(n.Lhs[0], "_") {
+							t.Errorf("%+v: %s: blank assignment in TLVsWriteTo", o, fs.Position(n.Pos()))
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+}
+
+// TestNewInput expects the constructors of inputs to take their mandatory
+// TLVs as parameters, named clear of keywords, and no optional ones
+func TestNewInput(t *testing.T) {
+	src, err := Generate(strings.NewReader(`[
+  { "name" : "DMS", "type" : "Service" },
+  { "name" : "Set Operating Mode", "type" : "Message", "service" : "DMS", "id" : "0x002E", "since" : "1.0",
+    "input" : [ { "name" : "Mode", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint8" },
+                { "name" : "Type", "id" : "0x02", "type" : "TLV", "since" : "1.0", "format" : "guint16" },
+                { "name" : "Timeout", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "guint32" } ] },
+  { "name" : "Get Operating Mode", "type" : "Message", "service" : "DMS", "id" : "0x002D", "since" : "1.0",
+    "input" : [ { "name" : "Timeout", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "guint32" } ] }
+]`), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"// NewDMSSetOperatingModeInput returns DMSSetOperatingModeInput with its mandatory TLVs set\n" +
+			"func NewDMSSetOperatingModeInput(mode uint8, type_ uint16) DMSSetOperatingModeInput {\n" +
+			"\treturn DMSSetOperatingModeInput{Mode: mode, Type: type_}\n}",
+		"func NewDMSGetOperatingModeInput() DMSGetOperatingModeInput {\n" +
+			"\treturn DMSGetOperatingModeInput{}\n}",
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %q in\n%s", want, src)
+		}
+	}
+	if strings.Contains(string(src), "timeout uint32") {
+		t.Error("optional TLV taken as a parameter")
+	}
+}
+
+// TestPresenceAccessors expects GetX() for the optional output TLVs only,
+// reading the present field the decoder fills unless they are pointers
+func TestPresenceAccessors(t *testing.T) {
+	data := `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Packet Statistics", "type" : "Message", "service" : "WDS", "id" : "0x0024", "since" : "1.0",
+    "output" : [ { "name" : "Mask", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "guint32" },
+                 { "name" : "Tx Bytes", "id" : "0x19", "type" : "TLV", "since" : "1.0", "format" : "guint64" } ] }
+]`
+	for _, test := range []struct {
+		optional_pointers bool
+		want              []string
+		unwanted          []string
+	}{
+		{false, []string{
+			"\tpresent tlvSet\n",
+			"\t\tmsg.present.add(0x19)\n",
+			"// GetTxBytes returns TxBytes and whether the message carried it\n" +
+				"func (msg *WDSGetPacketStatisticsOutput) GetTxBytes() (uint64, bool) {\n" +
+				"\treturn msg.TxBytes, msg.present.has(0x19)\n}",
+		}, []string{"GetMask", "present.add(0x01)"}},
+		{true, []string{
+			"func (msg *WDSGetPacketStatisticsOutput) GetTxBytes() (v uint64, ok bool) {\n" +
+				"\tif msg.TxBytes != nil {\n" +
+				"\t\tv, ok = *msg.TxBytes, true\n" +
+				"\t}\n" +
+				"\treturn\n}",
+		}, []string{"GetMask", "present"}},
+	} {
+		src, err := Generate(strings.NewReader(data), Options{
+			Common:            NewRegistry(nil),
+			PresenceAccessors: true,
+			OptionalPointers:  test.optional_pointers,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, want := range test.want {
+			if !strings.Contains(string(src), want) {
+				t.Errorf("optional pointers %v: no %q in\n%s", test.optional_pointers, want, src)
+			}
+		}
+		for _, unwanted := range test.unwanted {
+			if strings.Contains(string(src), unwanted) {
+				t.Errorf("optional pointers %v: %q in\n%s", test.optional_pointers, unwanted, src)
+			}
+		}
+	}
+}
+
+// TestMessageNames expects the names of a service sorted by ID, an
+// indication among them unless it shares the ID of a message, and handed to
+// the runtime
+func TestMessageNames(t *testing.T) {
+	src, err := Generate(strings.NewReader(`[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Packet Service Status", "type" : "Message", "service" : "WDS", "id" : "0x0022", "since" : "1.0" },
+  { "name" : "Packet Service Status", "type" : "Indication", "service" : "WDS", "id" : "0x0022", "since" : "1.0" },
+  { "name" : "Event Report", "type" : "Indication", "service" : "WDS", "id" : "0x0001", "since" : "1.0" }
+]`), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`var WDSMessageNames = map[uint16]string{0x0001: "Event Report", 0x0022: "Get Packet Service Status"}`,
+		"func WDSMessageName(id uint16) string {\n" +
+			"\treturn messageName(\"WDS\", WDSMessageNames, id)\n}",
+		`declareMessageNames(QMI_SERVICE_WDS, "WDS", WDSMessageNames)`,
+	} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %s in\n%s", want, src)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
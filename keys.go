@@ -0,0 +1,122 @@
+package qmigen
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// isComment tells comment entries of data files: strings, and objects
+// keyed only by "//..."
+func isComment(raw interface{}) bool {
+	switch v := raw.(type) {
+	case string:
+		return true
+	case map[string]interface{}:
+		if len(v) == 0 {
+			return false
+		}
+		for k := range v {
+			if !strings.HasPrefix(k, "//") {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// jsonFields maps the lower case keys encoding/json decodes into fields
+// of the struct typ, including embedded ones, to the field types
+func jsonFields(typ reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if field.Anonymous {
+			for k, t := range jsonFields(field.Type) {
+				fields[k] = t
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			continue
+		}
+		key := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			key = tag
+		}
+		fields[strings.ToLower(key)] = field.Type
+	}
+	return fields
+}
+
+// unknownKeys lists the paths of keys in raw which have no field in typ,
+// deleting them along with "//" comment keys when strip is set
+func unknownKeys(raw interface{}, typ reflect.Type, path string, strip bool) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	var unknown []string
+	switch v := raw.(type) {
+	case map[string]interface{}:
+		if typ.Kind() != reflect.Struct {
+			return nil
+		}
+		fields := jsonFields(typ)
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if strings.HasPrefix(k, "//") {
+				if strip {
+					delete(v, k)
+				}
+				continue
+			}
+			t, ok := fields[strings.ToLower(k)]
+			if !ok {
+				unknown = append(unknown, path+k)
+				if strip {
+					delete(v, k)
+				}
+				continue
+			}
+			unknown = append(unknown, unknownKeys(v[k], t, path+k+".", strip)...)
+		}
+	case []interface{}:
+		if typ.Kind() != reflect.Slice {
+			return nil
+		}
+		for i, elem := range v {
+			unknown = append(unknown, unknownKeys(elem, typ.Elem(), fmt.Sprintf("%s[%d].", strings.TrimSuffix(path, "."), i), strip)...)
+		}
+	}
+	return unknown
+}
+
+// auditEntity reports the keys of a data file entity which decoding into
+// entity drops, all in one line naming the entity: a warning to
+// Options.Warnings, or an error with -strict
+func (gen *generator) auditEntity(raw interface{}, entity interface{}) error {
+	unknown := unknownKeys(raw, reflect.TypeOf(entity), "", false)
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	m, _ := raw.(map[string]interface{})
+	typ, _ := m["type"].(string)
+	name, _ := m["name"].(string)
+	if gen.opts.Strict {
+		return fmt.Errorf("%s %q: unknown keys %s", typ, name, strings.Join(unknown, ", "))
+	}
+	gen.opts.warnf("%s %q: unknown keys %s", typ, name, strings.Join(unknown, ", "))
+	return nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,49 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// TestLibqmiData generates the libqmi data file excerpt as libqmi ships
+// it, warning of the keys and entities qmigen does not model, which fail
+// with -strict
+func TestLibqmiData(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	spec, err := ioutil.ReadFile("testdata/libqmi/qmi-service-dms.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var warnings bytes.Buffer
+	src, err := Generate(strings.NewReader(string(spec)), Options{Common: common, Warnings: &warnings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"DMSGetIDsOutput", "DMSSetOperatingModeInput", "DMSEventReportIndication"} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("no %s generated", want)
+		}
+	}
+	want := `warning: Service "DMS": unknown keys since
+warning: Message "Reset": unknown keys version
+warning: Message "Get IDs": unknown keys output[1].max-size, output[2].max-size, output[3].max-size, version
+warning: Message "Set Operating Mode": unknown keys version
+warning: Prerequisite-Alias "Swi Get Current Firmware": unknown entity type, skipped
+`
+	if warnings.String() != want {
+		t.Errorf("warnings:\n%s\nwant:\n%s", warnings.String(), want)
+	}
+
+	_, err = Generate(strings.NewReader(string(spec)), Options{Common: common, Strict: true})
+	if err == nil || !strings.Contains(err.Error(), "unknown keys") {
+		t.Errorf("strict: %v", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,167 @@
+package qmigen
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/hjson/hjson-go"
+)
+
+// provenanceFile records where the imported data files come from and what
+// the import stripped from them
+const provenanceFile = "LIBQMI"
+
+// libqmiFiles are the data files of a libqmi checkout to import
+var libqmiFiles = []string{"qmi-common.json", "qmi-service-*.json"}
+
+// translateLibqmi drops what qmigen does not model from a libqmi data
+// file: comments, entity types and keys. Returns the file as imported
+// and a line for every stripped entity or key.
+func translateLibqmi(input []byte) ([]byte, []string, error) {
+	var raw_entities []interface{}
+	err := hjson.Unmarshal(input, &raw_entities)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entities := []interface{}{}
+	var stripped []string
+	for _, re := range raw_entities {
+		if isComment(re) {
+			continue
+		}
+		typI, ok := re.(map[string]interface{})
+		if !ok {
+			return nil, nil, ErrUnexpectedType("not an object")
+		}
+
+		typS, _ := typI["type"].(string)
+		name, ok := typI["name"].(string)
+		if !ok {
+			name, _ = typI["common-ref"].(string)
+		}
+		entity := fmt.Sprintf("%s %q", typS, name)
+
+		cons, ok := QMIEntityMap[typS]
+		if !ok {
+			stripped = append(stripped, entity)
+			continue
+		}
+		for _, k := range unknownKeys(typI, reflect.TypeOf(cons()), "", true) {
+			stripped = append(stripped, fmt.Sprintf("%s: key %s", entity, k))
+		}
+		entities = append(entities, typI)
+	}
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(entities)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), stripped, nil
+}
+
+// upstreamCommit describes the libqmi checkout holding src
+func upstreamCommit(src string) string {
+	out, err := exec.Command("git", "-C", src, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "unknown"
+	}
+	commit := strings.TrimSpace(string(out))
+
+	out, err = exec.Command("git", "-C", src, "status", "--porcelain", "--", ".").Output()
+	if err == nil && len(out) > 0 {
+		commit += " (modified)"
+	}
+	return commit
+}
+
+// ImportLibqmi translates the data files in src, the data directory of a
+// libqmi checkout. Returns the contents of the files to write into our
+// data directory by name, the provenance file included.
+func ImportLibqmi(src string) (map[string][]byte, error) {
+	var names []string
+	for _, pattern := range libqmiFiles {
+		matches, err := filepath.Glob(filepath.Join(src, pattern))
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, matches...)
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s: no libqmi data files", src)
+	}
+	sort.Strings(names)
+
+	files := map[string][]byte{}
+	var provenance bytes.Buffer
+	fmt.Fprintf(&provenance, "# written by qmigen import-libqmi, do not edit\n")
+	fmt.Fprintf(&provenance, "upstream %s\n", upstreamCommit(src))
+
+	for _, path := range names {
+		input, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		name := filepath.Base(path)
+		data, stripped, err := translateLibqmi(input)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		files[name] = data
+
+		fmt.Fprintf(&provenance, "file %s\n", name)
+		for _, s := range stripped {
+			fmt.Fprintf(&provenance, "\tstripped %s\n", s)
+		}
+	}
+	files[provenanceFile] = provenance.Bytes()
+
+	return files, nil
+}
+
+// CheckImport compares the files of an import with those in dest, listing
+// the differences
+func CheckImport(files map[string][]byte, dest string) ([]string, error) {
+	var diffs []string
+	for name, data := range files {
+		old, err := ioutil.ReadFile(filepath.Join(dest, name))
+		if os.IsNotExist(err) {
+			diffs = append(diffs, name+" is missing")
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(old, data) {
+			diffs = append(diffs, name+" differs")
+		}
+	}
+
+	for _, pattern := range libqmiFiles {
+		matches, err := filepath.Glob(filepath.Join(dest, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range matches {
+			if _, ok := files[filepath.Base(path)]; !ok {
+				diffs = append(diffs, filepath.Base(path)+" is not upstream")
+			}
+		}
+	}
+
+	sort.Strings(diffs)
+	return diffs, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
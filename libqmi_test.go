@@ -0,0 +1,55 @@
+package qmigen
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestImportLibqmi imports the libqmi excerpt of testdata/libqmi, copied
+// out of the repository to have no upstream commit, and expects the golden
+// files of testdata/libqmi/imported, which generate with -strict
+func TestImportLibqmi(t *testing.T) {
+	src := t.TempDir()
+	copyFiles(t, src, "testdata/libqmi/*.json")
+	files, err := ImportLibqmi(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err := CheckImport(files, "testdata/libqmi/imported")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diffs) > 0 {
+		t.Errorf("import differs from testdata/libqmi/imported: %v", diffs)
+	}
+
+	dest := t.TempDir()
+	copyFiles(t, dest, "testdata/libqmi/imported/*")
+	err = ioutil.WriteFile(filepath.Join(dest, "qmi-service-dms.json"), []byte("[]\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dest, "qmi-service-foo.json"), []byte("[]\n"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffs, err = CheckImport(files, dest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"qmi-service-dms.json differs", "qmi-service-foo.json is not upstream"}; !reflect.DeepEqual(diffs, want) {
+		t.Errorf("diffs %q, want %q", diffs, want)
+	}
+
+	err = GenerateFile(filepath.Join(t.TempDir(), "dms.go"), "testdata/libqmi/imported/qmi-service-dms.json", Options{
+		Generator: "qmigen",
+		Strict:    true,
+	})
+	if err != nil {
+		t.Errorf("imported data: %s", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!freebsd,!netbsd,!openbsd
+
+package qmigen
+
+// lockDir is a no-op where flock is unavailable, the rename in
+// WriteOutput still keeps the output consistent
+func lockDir(dir string) (func(), error) {
+	return func() {}, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,38 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+// +build linux darwin freebsd netbsd openbsd
+
+package qmigen
+
+import (
+	"testing"
+	"time"
+)
+
+// TestLockDir expects a second lock of a directory to wait for the first
+func TestLockDir(t *testing.T) {
+	dir := t.TempDir()
+	unlock, err := lockDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locked := make(chan func())
+	go func() {
+		unlock, err := lockDir(dir)
+		if err != nil {
+			t.Error(err)
+		}
+		locked <- unlock
+	}()
+	select {
+	case <-locked:
+		t.Fatal("directory locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if unlock := <-locked; unlock != nil {
+		unlock()
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
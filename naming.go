@@ -0,0 +1,251 @@
+package qmigen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"strings"
+	"unicode"
+
+	"github.com/hjson/hjson-go"
+	"github.com/pascaldekloe/name"
+)
+
+// Canonical spelling of words in generated identifiers, keyed by lower case;
+// a run adds those of its -acronyms file
+var Acronyms = map[string]string{
+	"3gpp":   "3GPP",
+	"3gpp2":  "3GPP2",
+	"apn":    "APN",
+	"cdma":   "CDMA",
+	"cid":    "CID",
+	"dns":    "DNS",
+	"gsm":    "GSM",
+	"iccid":  "ICCID",
+	"id":     "ID",
+	"imei":   "IMEI",
+	"imsi":   "IMSI",
+	"ip":     "IP",
+	"ipv4":   "IPv4",
+	"ipv6":   "IPv6",
+	"lte":    "LTE",
+	"mcc":    "MCC",
+	"mnc":    "MNC",
+	"msisdn": "MSISDN",
+	"mtu":    "MTU",
+	"pdn":    "PDN",
+	"pdp":    "PDP",
+	"pin":    "PIN",
+	"plmn":   "PLMN",
+	"puk":    "PUK",
+	"qos":    "QoS",
+	"sim":    "SIM",
+	"sms":    "SMS",
+	"umts":   "UMTS",
+	"wcdma":  "WCDMA",
+}
+
+// loadAcronyms merges a hjson object of additional acronyms into those of
+// the run
+func (gen *generator) loadAcronyms(file string) error {
+	input, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	var acronyms map[string]interface{}
+	err = hjson.Unmarshal(input, &acronyms)
+	if err != nil {
+		return err
+	}
+
+	for k, v := range acronyms {
+		s, ok := v.(string)
+		if !ok {
+			return ErrUnexpectedType("acronym " + k + " is not a string")
+		}
+		gen.acronyms[strings.ToLower(k)] = s
+	}
+
+	return nil
+}
+
+// goName derives an exported Go identifier from a data file name.
+// Words are split on anything but letters and digits and spelled
+// according to the acronyms of the run.
+func (gen *generator) goName(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+
+	var b strings.Builder
+	for _, w := range words {
+		if a, ok := gen.acronyms[strings.ToLower(w)]; ok {
+			b.WriteString(a)
+		} else {
+			r := []rune(w)
+			r[0] = unicode.ToUpper(r[0])
+			b.WriteString(string(r))
+		}
+	}
+
+	n := b.String()
+	if n != "" && unicode.IsDigit([]rune(n)[0]) {
+		n = "X" + n
+	}
+	return n
+}
+
+// paramName turns an exported field name into a parameter name, lower
+// casing its leading acronym or word: IMEISoftwareVersion imeiSoftwareVersion
+func (gen *generator) paramName(s string) string {
+	n := 0
+	for _, a := range gen.acronyms {
+		if len(a) > n && strings.HasPrefix(s, a) {
+			n = len(a)
+		}
+	}
+
+	r := []rune(s)
+	if n == 0 {
+		for n < len(r) && unicode.IsUpper(r[n]) {
+			n++
+		}
+		if n > 1 && n < len(r) && unicode.IsLower(r[n]) {
+			// the last capital starts the next word
+			n--
+		}
+	}
+	for i := 0; i < n || i == 0 && i < len(r); i++ {
+		r[i] = unicode.ToLower(r[i])
+	}
+
+	p := string(r)
+	if token.Lookup(p).IsKeyword() {
+		p += "_"
+	}
+	return p
+}
+
+// constName spells s like libqmi's C constants: upper case words joined
+// by underscores
+func constName(s string) string {
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.ToUpper(strings.Join(words, "_"))
+}
+
+// legacyName is the identifier generated before goName was introduced
+func legacyName(s string) string {
+	return name.CamelCase(s, true)
+}
+
+// addAlias keeps a renamed type available under its legacy identifier
+func (gen *generator) addAlias(f *ast.File, prefix, s, suffix string) {
+	legacy := prefix + legacyName(s) + suffix
+	current := prefix + gen.goName(s) + suffix
+	if legacy == current || !token.IsIdentifier(legacy) {
+		return
+	}
+
+	gen.docComments["type "+legacy+" "] = fmt.Sprintf("%s is the legacy name of %s.\n\nDeprecated: use %s.", legacy, current, current)
+	f.Decls = append(f.Decls, &ast.GenDecl{
+		Tok: token.TYPE,
+		Specs: []ast.Spec{
+			&ast.TypeSpec{
+				Name:   ast.NewIdent(legacy),
+				Assign: 1,
+				Type:   ast.NewIdent(current),
+			},
+		},
+	})
+}
+
+// addLegacyMethod keeps a renamed send method of recv available under its
+// legacy name, forwarding to the current one:
+//
+//	func (dev *Device) CTLAllocateCid(input CTLAllocateCIDInput) (m *CTLAllocateCIDOutput, err error) {
+//		return dev.CTLAllocateCID(input)
+//	}
+func (gen *generator) addLegacyMethod(f *ast.File, recv string, recv_type string, prefix, s string, input string, output string) {
+	legacy := prefix + legacyName(s)
+	current := prefix + gen.goName(s)
+	if legacy == current || !token.IsIdentifier(legacy) {
+		return
+	}
+
+	gen.docComments["func ("+recv+" *"+recv_type+") "+legacy+"("] = fmt.Sprintf("%s is the legacy name of %s.\n\nDeprecated: use %s.", legacy, current, current)
+	f.Decls = append(f.Decls, &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent(recv)},
+					Type:  &ast.StarExpr{X: ast.NewIdent(recv_type)},
+				},
+			},
+		},
+		Name: ast.NewIdent(legacy),
+		Type: genSendType(input, output),
+		Body: &ast.BlockStmt{
+			List: []ast.Stmt{
+				&ast.ReturnStmt{
+					Results: []ast.Expr{
+						&ast.CallExpr{
+							Fun: &ast.SelectorExpr{
+								X:   commonIdent(recv),
+								Sel: ast.NewIdent(current),
+							},
+							Args: []ast.Expr{commonIdent("input")},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// renamedFields lists the fields of tlvs and their contents the naming
+// layer renamed, "AllocationInfo.Cid is now AllocationInfo.CID". Go has no
+// field aliases, so these keep no legacy name.
+func (gen *generator) renamedFields(tlvs []QMITLVField, legacy_path, current_path string) []string {
+	var renamed []string
+	for _, tlv := range tlvs {
+		legacy, current := legacy_path, current_path
+		if tlv.Name != "" {
+			legacy += legacyName(tlv.Name)
+			current += gen.goName(tlv.Name)
+			if legacy != current {
+				renamed = append(renamed, fmt.Sprintf("%s is now %s", legacy, current))
+			}
+			legacy += "."
+			current += "."
+		}
+		renamed = append(renamed, gen.renamedFields(tlv.Contents, legacy, current)...)
+		if tlv.ArrayElement != nil {
+			renamed = append(renamed, gen.renamedFields(tlv.ArrayElement.Contents, legacy, current)...)
+		}
+	}
+	return renamed
+}
+
+// docRenamedFields adds the fields renamedFields finds in tlvs to the doc
+// comment of typ
+func (gen *generator) docRenamedFields(typ string, tlvs []QMITLV) {
+	fields := make([]QMITLVField, len(tlvs))
+	for i := range tlvs {
+		fields[i] = tlvs[i].QMITLVField
+	}
+	renamed := gen.renamedFields(fields, "", "")
+	if len(renamed) == 0 {
+		return
+	}
+	doc := fmt.Sprintf("Fields renamed by the naming layer, which keep no legacy name: %s.", strings.Join(renamed, ", "))
+	if prev, ok := gen.docComments["type "+typ+" "]; ok {
+		doc = prev + "\n\n" + doc
+	}
+	gen.docComments["type "+typ+" "] = doc
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,132 @@
+package qmigen
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGoName(t *testing.T) {
+	gen, err := newGenerator(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// names of libqmi's data files
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"Get IDs", "GetIDs"},
+		{"Imei", "IMEI"},
+		{"IPv6 Address", "IPv6Address"},
+		{"Ipv4 Gateway Subnet Mask", "IPv4GatewaySubnetMask"},
+		{"3GPP2 Subscription Info", "X3GPP2SubscriptionInfo"},
+		{"Requested Technology 3gpp", "RequestedTechnology3GPP"},
+		{"PDP/PDN Type", "PDPPDNType"},
+		{"Extended QoS Capability", "ExtendedQoSCapability"},
+		{"Primary IPv4 DNS Address", "PrimaryIPv4DNSAddress"},
+		{"Mcc Mnc", "MCCMNC"},
+		{"Lte-Rsrp", "LTERsrp"},
+		{"UIM ICCID", "UIMICCID"},
+		{"Get Signal Strength (new)", "GetSignalStrengthNew"},
+		{"Cid", "CID"},
+		{"apn type mask", "APNTypeMask"},
+	} {
+		if got := gen.goName(test.in); got != test.want {
+			t.Errorf("goName(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestAcronymsFile(t *testing.T) {
+	file := writeTemp(t, "acronyms.hjson", `{
+		// the additions of a project
+		lte: LTE
+		rsrp: RSRP
+		ipv6: IPV6
+	}`)
+	gen, err := newGenerator(Options{Acronyms: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"Lte-Rsrp", "LTERSRP"},
+		{"IPv6 Address", "IPV6Address"},
+		{"Imei", "IMEI"},
+	} {
+		if got := gen.goName(test.in); got != test.want {
+			t.Errorf("goName(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+	if Acronyms["rsrp"] != "" {
+		t.Error("a run changed the built-in acronyms")
+	}
+
+	_, err = newGenerator(Options{Acronyms: writeTemp(t, "bad.hjson", `{ lte: 4 }`)})
+	if err == nil {
+		t.Error("acronym spelled by a number accepted")
+	}
+}
+
+func TestParamName(t *testing.T) {
+	gen, err := newGenerator(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		in, want string
+	}{
+		{"IMEISoftwareVersion", "imeiSoftwareVersion"},
+		{"Mode", "mode"},
+		{"IPv4Address", "ipv4Address"},
+		{"PDPType", "pdpType"},
+		{"XMLData", "xmlData"},
+		{"Type", "type_"},
+		{"X3GPP2Info", "x3GPP2Info"},
+	} {
+		if got := gen.paramName(test.in); got != test.want {
+			t.Errorf("paramName(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestConstName(t *testing.T) {
+	if got := constName("Packet Data Handle"); got != "PACKET_DATA_HANDLE" {
+		t.Errorf("constName = %q", got)
+	}
+	if got := constName("IPv4/IPv6"); got != "IPV4_IPV6" {
+		t.Errorf("constName = %q", got)
+	}
+}
+
+// TestLegacyAliases makes sure identifiers spelled differently before the
+// naming layer remain as deprecated aliases and forwarding methods, and
+// that renamed fields are documented
+func TestLegacyAliases(t *testing.T) {
+	src, err := Generate(strings.NewReader(`[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "QMI Client WDS", "type" : "Client", "since" : "1.0" },
+  { "name" : "Get Pdn Throttle Info", "type" : "Message", "service" : "WDS", "id" : "0x006C", "since" : "1.0",
+    "output" : [ { "name" : "Apn", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "string" } ] }
+]`), Options{Common: NewRegistry(nil)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, decl := range []string{
+		"type WDSGetPDNThrottleInfoOutput struct",
+		"type WDSGetPdnThrottleInfoOutput = WDSGetPDNThrottleInfoOutput",
+		"type WDSGetPdnThrottleInfoInput = WDSGetPDNThrottleInfoInput",
+		"// Deprecated: use WDSGetPDNThrottleInfoOutput.\ntype WDSGetPdnThrottleInfoOutput ",
+		"APN string",
+		"// Deprecated: use WDSGetPDNThrottleInfo.\nfunc (dev *Device) WDSGetPdnThrottleInfo(input WDSGetPDNThrottleInfoInput) (m *WDSGetPDNThrottleInfoOutput, err error) {\n\treturn dev.WDSGetPDNThrottleInfo(input)\n}",
+		"// Deprecated: use GetPDNThrottleInfo.\nfunc (client *WDSClient) GetPdnThrottleInfo(input WDSGetPDNThrottleInfoInput) (m *WDSGetPDNThrottleInfoOutput, err error) {\n\treturn client.GetPDNThrottleInfo(input)\n}",
+		"// Fields renamed by the naming layer, which keep no legacy name: Apn is now APN.\ntype WDSGetPDNThrottleInfoOutput struct",
+	} {
+		if !strings.Contains(string(src), decl) {
+			t.Errorf("generated code lacks %q", decl)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,192 @@
+//go:build linux && netns
+// +build linux,netns
+
+// The tests against the kernel run as root in a network namespace of
+// their own: go test -tags netns ./netsetup
+package netsetup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"os"
+	"runtime"
+	"syscall"
+	"testing"
+	"unsafe"
+)
+
+// inNetns runs f on a thread moved into a new network namespace, which
+// has nothing but a loopback interface down. The thread dies with f.
+func inNetns(t *testing.T, f func()) {
+	t.Helper()
+	done := make(chan error)
+	go func() {
+		// never unlocked, the runtime drops the thread on exit
+		runtime.LockOSThread()
+		err := syscall.Unshare(syscall.CLONE_NEWNET)
+		if err != nil {
+			done <- err
+			return
+		}
+		defer close(done)
+		f()
+	}()
+	if err := <-done; err != nil {
+		t.Skipf("unshare: %s", err)
+	}
+}
+
+// tun creates the tun interface name, a raw-ip link like wwan, which
+// lasts until the returned file is closed
+func tun(t *testing.T, name string) *os.File {
+	f, err := os.OpenFile("/dev/net/tun", os.O_RDWR, 0)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+
+	var ifr [40]byte
+	copy(ifr[:], name)
+	nativeEndian.PutUint16(ifr[16:], syscall.IFF_TUN|syscall.IFF_NO_PI)
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TUNSETIFF, uintptr(unsafe.Pointer(&ifr[0])))
+	if errno != 0 {
+		f.Close()
+		t.Errorf("TUNSETIFF: %s", errno)
+		return nil
+	}
+	return f
+}
+
+// defaultRoutes returns the gateways of the IPv4 default routes of the
+// main table through interface index
+func defaultRoutes(t *testing.T, index int) []net.IP {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETROUTE, syscall.AF_INET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gws []net.IP
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWROUTE {
+			continue
+		}
+		var rtm syscall.RtMsg
+		binary.Read(bytes.NewReader(m.Data), nativeEndian, &rtm)
+		if rtm.Table != syscall.RT_TABLE_MAIN || rtm.Dst_len != 0 {
+			continue
+		}
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var gw net.IP
+		oif := -1
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.RTA_GATEWAY:
+				gw = net.IP(attr.Value)
+			case syscall.RTA_OIF:
+				oif = int(nativeEndian.Uint32(attr.Value))
+			}
+		}
+		if oif == index {
+			gws = append(gws, gw)
+		}
+	}
+	return gws
+}
+
+func hasAddr(t *testing.T, iface *net.Interface, ip net.IP) bool {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		if addr.(*net.IPNet).IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestNetnsApplyRevert(t *testing.T) {
+	inNetns(t, func() {
+		nl, err := Dial()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer nl.Close()
+		f := tun(t, "wwan0")
+		if f == nil {
+			return
+		}
+		defer f.Close()
+
+		cfg := &Config{
+			IPv4Address:   net.IPv4(10, 0, 0, 2),
+			IPv4PrefixLen: 30,
+			IPv4Gateway:   net.IPv4(10, 0, 0, 1),
+			MTU:           1430,
+		}
+		s, err := Apply(nl, "wwan0", cfg)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		iface, err := net.InterfaceByName("wwan0")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if iface.Flags&net.FlagUp == 0 || iface.MTU != 1430 {
+			t.Errorf("applied: flags %s, MTU %d", iface.Flags, iface.MTU)
+		}
+		if !hasAddr(t, iface, cfg.IPv4Address) {
+			t.Error("address not applied")
+		}
+		if gws := defaultRoutes(t, iface.Index); len(gws) != 1 || !gws[0].Equal(cfg.IPv4Gateway) {
+			t.Errorf("default routes %v", gws)
+		}
+
+		err = s.Revert()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		iface, _ = net.InterfaceByName("wwan0")
+		if iface.Flags&net.FlagUp != 0 {
+			t.Error("reverted interface is up")
+		}
+		if hasAddr(t, iface, cfg.IPv4Address) {
+			t.Error("address not reverted")
+		}
+		if gws := defaultRoutes(t, iface.Index); len(gws) != 0 {
+			t.Errorf("default routes %v after revert", gws)
+		}
+	})
+}
+
+func TestNetnsNoInterface(t *testing.T) {
+	inNetns(t, func() {
+		nl, err := Dial()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer nl.Close()
+
+		_, err = Apply(nl, "wwan0", &Config{})
+		if err == nil {
+			t.Error("Apply to a missing interface succeeded")
+		}
+	})
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
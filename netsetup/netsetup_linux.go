@@ -0,0 +1,272 @@
+//go:build linux
+// +build linux
+
+// Package netsetup configures a raw-ip wwan interface with the settings
+// reported by WDS Get Current Settings, talking rtnetlink directly.
+package netsetup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+var nativeEndian binary.ByteOrder
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 1 {
+		nativeEndian = binary.LittleEndian
+	} else {
+		nativeEndian = binary.BigEndian
+	}
+}
+
+// Config is filled by the caller from the decoded Get Current Settings
+// output. Zero values are skipped.
+type Config struct {
+	IPv4Address   net.IP
+	IPv4PrefixLen int
+	IPv4Gateway   net.IP
+
+	IPv6Address   net.IP
+	IPv6PrefixLen int
+	IPv6Gateway   net.IP
+
+	MTU uint32
+}
+
+// Netlink executes a single rtnetlink request and waits for its ACK
+type Netlink interface {
+	Request(typ uint16, flags uint16, data []byte) error
+}
+
+type Socket struct {
+	fd  int
+	seq uint32
+}
+
+func Dial() (*Socket, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, err
+	}
+
+	err = syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+
+	return &Socket{fd: fd}, nil
+}
+
+func (s *Socket) Close() error {
+	return syscall.Close(s.fd)
+}
+
+func (s *Socket) Request(typ uint16, flags uint16, data []byte) error {
+	s.seq++
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, nativeEndian, syscall.NlMsghdr{
+		Len:   uint32(syscall.SizeofNlMsghdr + len(data)),
+		Type:  typ,
+		Flags: flags | syscall.NLM_F_REQUEST | syscall.NLM_F_ACK,
+		Seq:   s.seq,
+	})
+	buf.Write(data)
+
+	err := syscall.Sendto(s.fd, buf.Bytes(), 0, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK})
+	if err != nil {
+		return err
+	}
+
+	rb := make([]byte, syscall.Getpagesize())
+	for {
+		n, _, err := syscall.Recvfrom(s.fd, rb, 0)
+		if err != nil {
+			return err
+		}
+
+		msgs, err := syscall.ParseNetlinkMessage(rb[:n])
+		if err != nil {
+			return err
+		}
+
+		for _, m := range msgs {
+			if m.Header.Seq != s.seq || m.Header.Type != syscall.NLMSG_ERROR {
+				continue
+			}
+			if len(m.Data) < 4 {
+				return syscall.EINVAL
+			}
+			if errno := int32(nativeEndian.Uint32(m.Data)); errno != 0 {
+				return syscall.Errno(-errno)
+			}
+			return nil
+		}
+	}
+}
+
+func rtattr(buf *bytes.Buffer, typ uint16, data []byte) {
+	binary.Write(buf, nativeEndian, syscall.RtAttr{
+		Len:  uint16(syscall.SizeofRtAttr + len(data)),
+		Type: typ,
+	})
+	buf.Write(data)
+	for buf.Len()%syscall.NLMSG_ALIGNTO != 0 {
+		buf.WriteByte(0)
+	}
+}
+
+func uint32attr(v uint32) []byte {
+	b := make([]byte, 4)
+	nativeEndian.PutUint32(b, v)
+	return b
+}
+
+func family(ip net.IP) (uint8, net.IP) {
+	if ip4 := ip.To4(); ip4 != nil {
+		return syscall.AF_INET, ip4
+	}
+	return syscall.AF_INET6, ip.To16()
+}
+
+func addrMsg(index int, ip net.IP, prefixLen int) []byte {
+	fam, ip := family(ip)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, nativeEndian, syscall.IfAddrmsg{
+		Family:    fam,
+		Prefixlen: uint8(prefixLen),
+		Scope:     syscall.RT_SCOPE_UNIVERSE,
+		Index:     uint32(index),
+	})
+	rtattr(buf, syscall.IFA_LOCAL, ip)
+	rtattr(buf, syscall.IFA_ADDRESS, ip)
+	return buf.Bytes()
+}
+
+// routeMsg describes a default route through the interface. Raw-ip links
+// have no link layer, so the gateway is marked on-link.
+func routeMsg(index int, gw net.IP) []byte {
+	fam, gw := family(gw)
+
+	buf := &bytes.Buffer{}
+	binary.Write(buf, nativeEndian, syscall.RtMsg{
+		Family:   fam,
+		Table:    syscall.RT_TABLE_MAIN,
+		Protocol: syscall.RTPROT_BOOT,
+		Scope:    syscall.RT_SCOPE_UNIVERSE,
+		Type:     syscall.RTN_UNICAST,
+		Flags:    syscall.RTNH_F_ONLINK,
+	})
+	rtattr(buf, syscall.RTA_GATEWAY, gw)
+	rtattr(buf, syscall.RTA_OIF, uint32attr(uint32(index)))
+	return buf.Bytes()
+}
+
+func linkMsg(index int, flags uint32, mtu uint32) []byte {
+	buf := &bytes.Buffer{}
+	binary.Write(buf, nativeEndian, syscall.IfInfomsg{
+		Family: syscall.AF_UNSPEC,
+		Index:  int32(index),
+		Flags:  flags,
+		Change: syscall.IFF_UP | syscall.IFF_NOARP,
+	})
+	if mtu != 0 {
+		rtattr(buf, syscall.IFLA_MTU, uint32attr(mtu))
+	}
+	return buf.Bytes()
+}
+
+type step struct {
+	typ  uint16
+	data []byte
+}
+
+// Setup is an applied configuration which can be reverted on disconnect
+type Setup struct {
+	nl     Netlink
+	index  int
+	revert []step
+}
+
+// Apply brings the interface up without ARP and configures addresses,
+// MTU and default routes. On failure everything applied so far is
+// reverted.
+func Apply(nl Netlink, ifname string, cfg *Config) (*Setup, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, err
+	}
+
+	return ApplyIndex(nl, iface.Index, cfg)
+}
+
+func ApplyIndex(nl Netlink, index int, cfg *Config) (*Setup, error) {
+	s := &Setup{
+		nl:    nl,
+		index: index,
+	}
+
+	err := nl.Request(syscall.RTM_NEWLINK, 0, linkMsg(index, syscall.IFF_UP|syscall.IFF_NOARP, cfg.MTU))
+	if err != nil {
+		return nil, fmt.Errorf("link up: %w", err)
+	}
+	s.revert = append(s.revert, step{syscall.RTM_NEWLINK, linkMsg(index, syscall.IFF_NOARP, 0)})
+
+	for _, addr := range []struct {
+		ip        net.IP
+		prefixLen int
+		gw        net.IP
+	}{
+		{cfg.IPv4Address, cfg.IPv4PrefixLen, cfg.IPv4Gateway},
+		{cfg.IPv6Address, cfg.IPv6PrefixLen, cfg.IPv6Gateway},
+	} {
+		if addr.ip == nil {
+			continue
+		}
+
+		msg := addrMsg(index, addr.ip, addr.prefixLen)
+		err = nl.Request(syscall.RTM_NEWADDR, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, msg)
+		if err != nil {
+			s.Revert()
+			return nil, fmt.Errorf("address %s: %w", addr.ip, err)
+		}
+		s.revert = append(s.revert, step{syscall.RTM_DELADDR, msg})
+
+		if addr.gw == nil {
+			continue
+		}
+
+		msg = routeMsg(index, addr.gw)
+		err = nl.Request(syscall.RTM_NEWROUTE, syscall.NLM_F_CREATE|syscall.NLM_F_EXCL, msg)
+		if err != nil {
+			s.Revert()
+			return nil, fmt.Errorf("default route via %s: %w", addr.gw, err)
+		}
+		s.revert = append(s.revert, step{syscall.RTM_DELROUTE, msg})
+	}
+
+	return s, nil
+}
+
+// Revert undoes Apply in reverse order and brings the interface down
+func (s *Setup) Revert() error {
+	var first error
+	for i := len(s.revert) - 1; i >= 0; i-- {
+		err := s.nl.Request(s.revert[i].typ, 0, s.revert[i].data)
+		if err != nil && first == nil {
+			first = err
+		}
+	}
+	s.revert = nil
+	return first
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,164 @@
+//go:build linux
+// +build linux
+
+package netsetup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// fakeNetlink records requests and fails the one numbered fail, counting
+// from 1
+type fakeNetlink struct {
+	requests []step
+	fail     int
+}
+
+var errFake = errors.New("fake netlink failure")
+
+func (nl *fakeNetlink) Request(typ uint16, flags uint16, data []byte) error {
+	nl.requests = append(nl.requests, step{typ, data})
+	if len(nl.requests) == nl.fail {
+		return errFake
+	}
+	return nil
+}
+
+func (nl *fakeNetlink) types() []uint16 {
+	var types []uint16
+	for _, req := range nl.requests {
+		types = append(types, req.typ)
+	}
+	return types
+}
+
+func equalTypes(a, b []uint16) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var testConfig = &Config{
+	IPv4Address:   net.IPv4(10, 0, 0, 2),
+	IPv4PrefixLen: 30,
+	IPv4Gateway:   net.IPv4(10, 0, 0, 1),
+	IPv6Address:   net.ParseIP("2001:db8::2"),
+	IPv6PrefixLen: 64,
+	MTU:           1430,
+}
+
+func TestApply(t *testing.T) {
+	nl := &fakeNetlink{}
+	s, err := ApplyIndex(nl, 7, testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []uint16{syscall.RTM_NEWLINK, syscall.RTM_NEWADDR, syscall.RTM_NEWROUTE, syscall.RTM_NEWADDR}
+	if got := nl.types(); !equalTypes(got, want) {
+		t.Fatalf("requests %v, want %v", got, want)
+	}
+
+	link := nl.requests[0].data
+	var info syscall.IfInfomsg
+	binary.Read(bytes.NewReader(link), nativeEndian, &info)
+	if info.Index != 7 || info.Flags != syscall.IFF_UP|syscall.IFF_NOARP {
+		t.Errorf("link %+v", info)
+	}
+	attrs, err := syscall.ParseNetlinkRouteAttr(&syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_NEWLINK},
+		Data:   link,
+	})
+	if err != nil || len(attrs) != 1 || attrs[0].Attr.Type != syscall.IFLA_MTU ||
+		nativeEndian.Uint32(attrs[0].Value) != 1430 {
+		t.Errorf("link attributes %+v, %v", attrs, err)
+	}
+
+	addr := nl.requests[1].data
+	var ifa syscall.IfAddrmsg
+	binary.Read(bytes.NewReader(addr), nativeEndian, &ifa)
+	if ifa.Family != syscall.AF_INET || ifa.Prefixlen != 30 || ifa.Index != 7 {
+		t.Errorf("address %+v", ifa)
+	}
+	attrs, _ = syscall.ParseNetlinkRouteAttr(&syscall.NetlinkMessage{
+		Header: syscall.NlMsghdr{Type: syscall.RTM_NEWADDR},
+		Data:   addr,
+	})
+	if len(attrs) != 2 || !net.IP(attrs[0].Value).Equal(testConfig.IPv4Address) {
+		t.Errorf("address attributes %+v", attrs)
+	}
+
+	route := nl.requests[2].data
+	var rtm syscall.RtMsg
+	binary.Read(bytes.NewReader(route), nativeEndian, &rtm)
+	if rtm.Flags&syscall.RTNH_F_ONLINK == 0 || rtm.Dst_len != 0 {
+		t.Errorf("route %+v", rtm)
+	}
+
+	nl.requests = nil
+	err = s.Revert()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []uint16{syscall.RTM_DELADDR, syscall.RTM_DELROUTE, syscall.RTM_DELADDR, syscall.RTM_NEWLINK}
+	if got := nl.types(); !equalTypes(got, want) {
+		t.Fatalf("revert %v, want %v", got, want)
+	}
+	binary.Read(bytes.NewReader(nl.requests[3].data), nativeEndian, &info)
+	if info.Flags&syscall.IFF_UP != 0 {
+		t.Error("revert leaves the link up")
+	}
+
+	nl.requests = nil
+	if s.Revert() != nil || len(nl.requests) != 0 {
+		t.Error("a second Revert sent requests")
+	}
+}
+
+func TestApplySkipsZeroValues(t *testing.T) {
+	nl := &fakeNetlink{}
+	_, err := ApplyIndex(nl, 7, &Config{IPv4Address: net.IPv4(10, 0, 0, 2), IPv4PrefixLen: 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := nl.types(); !equalTypes(got, []uint16{syscall.RTM_NEWLINK, syscall.RTM_NEWADDR}) {
+		t.Errorf("requests %v", got)
+	}
+	if len(nl.requests[0].data) != syscall.SizeofIfInfomsg {
+		t.Error("MTU 0 set")
+	}
+}
+
+func TestApplyFailureReverts(t *testing.T) {
+	for _, test := range []struct {
+		fail   int
+		revert []uint16
+	}{
+		{1, nil},
+		{2, []uint16{syscall.RTM_NEWLINK}},
+		{3, []uint16{syscall.RTM_DELADDR, syscall.RTM_NEWLINK}},
+		{4, []uint16{syscall.RTM_DELROUTE, syscall.RTM_DELADDR, syscall.RTM_NEWLINK}},
+	} {
+		nl := &fakeNetlink{fail: test.fail}
+		s, err := ApplyIndex(nl, 7, testConfig)
+		if !errors.Is(err, errFake) || s != nil {
+			t.Errorf("failing request %d: %v, %v", test.fail, s, err)
+			continue
+		}
+		if got := nl.types()[test.fail:]; !equalTypes(got, test.revert) {
+			t.Errorf("failing request %d: reverted with %v, want %v", test.fail, got, test.revert)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,110 @@
+package qmigen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// WriteOutput replaces path with data atomically: concurrent go:generate
+// runs never see a partially written file. Writers of the same directory
+// are serialized by an advisory lock.
+func WriteOutput(path string, data []byte) error {
+	if fi, err := os.Stat(path); err == nil && !fi.Mode().IsRegular() {
+		// e.g. /dev/null when only common definitions are loaded
+		return ioutil.WriteFile(path, data, 0666)
+	}
+
+	dir := filepath.Dir(path)
+	unlock, err := lockDir(dir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	tmp, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// generatedMarker marks the files convert writes, at their top; files of
+// older versions carry it at their end
+var generatedMarker = regexp.MustCompile(`(?m)^// Code generated by .* DO NOT EDIT\.$`)
+
+// CheckOutputDir makes sure dir can be removed for regeneration: it exists,
+// holds nothing but files we generated and the working directory is not in
+// it. -force skips the check.
+func CheckOutputDir(dir string) error {
+	refuse := func(format string, a ...interface{}) error {
+		return fmt.Errorf("refusing to remove %s: %s (-force overrides)", dir, fmt.Sprintf(format, a...))
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err == nil {
+		abs, err = filepath.EvalSymlinks(abs)
+	}
+	if os.IsNotExist(err) {
+		return refuse("it does not exist, run from the qmigen directory")
+	} else if err != nil {
+		return err
+	}
+	fi, err := os.Stat(abs)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return refuse("not a directory")
+	}
+
+	wd, err := os.Getwd()
+	if err == nil {
+		wd, err = filepath.EvalSymlinks(wd)
+	}
+	if err != nil {
+		return err
+	}
+	if wd == abs || strings.HasPrefix(wd, abs+string(filepath.Separator)) || abs == string(filepath.Separator) {
+		return refuse("it contains the working directory %s", wd)
+	}
+
+	entries, err := ioutil.ReadDir(abs)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if !e.Mode().IsRegular() {
+			return refuse("%s is not a generated file", e.Name())
+		}
+		data, err := ioutil.ReadFile(filepath.Join(abs, e.Name()))
+		if err != nil {
+			return err
+		}
+		if !generatedMarker.Match(data) {
+			return refuse("%s is not a generated file", e.Name())
+		}
+	}
+	return nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,177 @@
+package qmigen
+
+import (
+	"bytes"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentGenerate runs generators on the same output at once and
+// expects one complete file and no temporary ones left
+func TestConcurrentGenerate(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "dms.go")
+
+	var wg sync.WaitGroup
+	errs := make([]error, 4)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = GenerateFile(output, "testdata/data/qmi-service-dms.json", Options{Generator: "qmigen"})
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	src, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), output, src, 0); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(src), "\npackage "); n != 1 {
+		t.Errorf("%d package clauses", n)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range files {
+		if fi.Name() != "dms.go" {
+			t.Errorf("%s left behind", fi.Name())
+		}
+	}
+}
+
+// TestCheckOutputDir refuses to remove directories which are missing, not
+// directories, hold the working directory or anything we did not generate
+func TestCheckOutputDir(t *testing.T) {
+	generated := "package qmi\n\n// Code generated by qmigen. DO NOT EDIT.\n"
+	for _, test := range []struct {
+		name   string
+		files  map[string]string // "sub/" makes a directory
+		refuse string
+	}{
+		{"empty", map[string]string{}, ""},
+		{"generated", map[string]string{"qmi-common.go": generated, "qmi-service-dms.go": generated}, ""},
+		{"hand-written", map[string]string{"qmi-common.go": generated, "main.go": "package main\n"}, "main.go is not a generated file"},
+		{"subdirectory", map[string]string{"qmi-common.go": generated, "sub/": ""}, "sub is not a generated file"},
+	} {
+		dir := filepath.Join(t.TempDir(), "qmi")
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range test.files {
+			var err error
+			if strings.HasSuffix(name, "/") {
+				err = os.Mkdir(filepath.Join(dir, name), 0777)
+			} else {
+				err = ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0666)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := CheckOutputDir(dir)
+		switch {
+		case test.refuse == "" && err != nil:
+			t.Errorf("%s: %s", test.name, err)
+		case test.refuse != "" && (err == nil || !strings.Contains(err.Error(), test.refuse)):
+			t.Errorf("%s: %v, want %q", test.name, err, test.refuse)
+		}
+	}
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "qmi")
+	if err := ioutil.WriteFile(file, []byte(generated), 0666); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct{ dir, refuse string }{
+		{filepath.Join(dir, "missing"), "it does not exist"},
+		{file, "not a directory"},
+		{".", "it contains the working directory"},
+		{filepath.Dir(wd), "it contains the working directory"},
+	} {
+		err := CheckOutputDir(test.dir)
+		if err == nil || !strings.Contains(err.Error(), test.refuse) || !strings.Contains(err.Error(), "-force") {
+			t.Errorf("%s: %v, want %q", test.dir, err, test.refuse)
+		}
+	}
+}
+
+// TestDeterministicOutput converts testdata/data thrice, with default and
+// with most options, and expects byte-identical files each time, opening
+// with the generated code marker where Go tools look for it
+func TestDeterministicOutput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("converts testdata/data six times")
+	}
+	inputs, err := filepath.Glob("testdata/data/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		o    Options
+	}{
+		{"default", Options{}},
+		{"options", Options{RetainRawTLVs: true, ExplicitRegister: true, OptionalPointers: true, DirectEncoding: true, PresenceAccessors: true}},
+	} {
+		o := test.o
+		o.Generator = "qmigen"
+		var first map[string][]byte
+		for run := 0; run < 3; run++ {
+			dir := t.TempDir()
+			err = GenerateFiles(inputs, dir, o)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files, err := ioutil.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out := map[string][]byte{}
+			for _, fi := range files {
+				src, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !strings.HasPrefix(string(src), "// Code generated by qmigen from ") {
+					t.Errorf("%s does not open with the generated code marker", fi.Name())
+				}
+				out[fi.Name()] = src
+			}
+			if first == nil {
+				first = out
+				continue
+			}
+			if len(out) != len(first) {
+				t.Errorf("%s: %d files, then %d", test.name, len(first), len(out))
+			}
+			for name, src := range out {
+				if !bytes.Equal(src, first[name]) {
+					t.Errorf("%s: %s differs between runs", test.name, name)
+				}
+			}
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,220 @@
+package qmigen
+
+import (
+	"go/ast"
+	"go/token"
+
+	"github.com/pascaldekloe/name"
+)
+
+// IsPersonal reports whether libqmi marks the field as personal info
+// (IMSI, MSISDN, location and alike)
+func (field *QMITLVField) IsPersonal() bool {
+	return field.PersonalInfo == "yes"
+}
+
+// GenRedact masks personal fields of value, a copy of the decoded message.
+// Strings keep their last digits, everything else is zeroed. Slices are
+// copied before their elements are touched.
+func (field *QMITLVField) GenRedact(gen *generator, value ast.Expr, personal bool) ([]ast.Stmt, error) {
+	personal = personal || field.IsPersonal()
+
+	switch field.Format {
+	case "struct", "sequence":
+		var stmts []ast.Stmt
+		for _, sub_field := range field.Contents {
+			if sub_field.Name == "" {
+				continue
+			}
+			sub_stmts, err := sub_field.GenRedact(gen, &ast.SelectorExpr{
+				X:   cloneExpr(value),
+				Sel: ast.NewIdent(gen.goName(sub_field.Name)),
+			}, personal)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, sub_stmts...)
+		}
+		return stmts, nil
+	case "array":
+		index := "i_" + name.SnakeCase(field.Name)
+		elem_stmts, err := field.ArrayElement.GenRedact(gen, &ast.IndexExpr{
+			X:     cloneExpr(value),
+			Index: ast.NewIdent(index),
+		}, personal)
+		if err != nil || len(elem_stmts) == 0 {
+			return nil, err
+		}
+
+		var stmts []ast.Stmt
+		if field.FixedSize <= 0 {
+			typ, _, err := gen.parseType(*field)
+			if err != nil {
+				return nil, err
+			}
+			stmts = append(stmts, &ast.AssignStmt{
+				Lhs: []ast.Expr{cloneExpr(value)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{
+					&ast.CallExpr{
+						Fun: commonIdent("append"),
+						Args: []ast.Expr{
+							&ast.CallExpr{
+								Fun:  typ,
+								Args: []ast.Expr{commonIdent("nil")},
+							},
+							cloneExpr(value),
+						},
+						Ellipsis: 1,
+					},
+				},
+			})
+		}
+		return append(stmts, &ast.RangeStmt{
+			Key:  ast.NewIdent(index),
+			Tok:  token.DEFINE,
+			X:    cloneExpr(value),
+			Body: &ast.BlockStmt{List: elem_stmts},
+		}), nil
+	}
+
+	if !personal {
+		return nil, nil
+	}
+
+	var masked ast.Expr
+	switch field.Format {
+	case "string":
+		masked = &ast.CallExpr{
+			Fun:  commonIdent("redactString"),
+			Args: []ast.Expr{cloneExpr(value)},
+		}
+	case "guint-sized":
+		masked = commonIdent("nil")
+	case "boolean", "gboolean":
+		masked = commonIdent("false")
+	case "":
+		// common structs carry no personal info
+		return nil, nil
+	default:
+		masked = &ast.BasicLit{Kind: token.INT, Value: "0"}
+	}
+
+	return []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{cloneExpr(value)},
+			Tok: token.ASSIGN,
+			Rhs: []ast.Expr{masked},
+		},
+	}, nil
+}
+
+// genString generates String() for an input or output type, printing it
+// with formatMessage. Types holding personal info print a redacted copy.
+//
+//	func (msg *XOutput) String() string {
+//		v := *msg
+//		v.IMSI = redactString(v.IMSI)
+//		return formatMessage(&v)
+//	}
+func (gen *generator) genString(typ *ast.Ident, tlvs []QMITLV) (*ast.FuncDecl, error) {
+	stmts := []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent("v")},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.StarExpr{X: commonIdent("msg")}},
+		},
+	}
+
+	redacted := false
+	for _, tlv := range tlvs {
+		if tlv.Name == "" {
+			continue
+		}
+		value := &ast.SelectorExpr{
+			X:   ast.NewIdent("v"),
+			Sel: ast.NewIdent(gen.goName(tlv.Name)),
+		}
+		var target ast.Expr = value
+		if tlv.optional {
+			target = ast.NewIdent("o_" + name.SnakeCase(tlv.Name))
+		}
+
+		value_field := tlv.ValueField()
+		field_stmts, err := value_field.GenRedact(gen, target, false)
+		if err != nil {
+			return nil, err
+		}
+		if len(field_stmts) == 0 {
+			continue
+		}
+		redacted = true
+
+		if tlv.optional {
+			// if v.X != nil { o_x := *v.X; ...; v.X = &o_x }
+			field_stmts = []ast.Stmt{
+				&ast.IfStmt{
+					Cond: &ast.BinaryExpr{
+						X:  cloneExpr(value),
+						Op: token.NEQ,
+						Y:  commonIdent("nil"),
+					},
+					Body: &ast.BlockStmt{
+						List: append(append([]ast.Stmt{
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{cloneExpr(target)},
+								Tok: token.DEFINE,
+								Rhs: []ast.Expr{&ast.StarExpr{X: cloneExpr(value)}},
+							},
+						}, field_stmts...),
+							&ast.AssignStmt{
+								Lhs: []ast.Expr{cloneExpr(value)},
+								Tok: token.ASSIGN,
+								Rhs: []ast.Expr{
+									&ast.UnaryExpr{Op: token.AND, X: cloneExpr(target)},
+								},
+							},
+						),
+					},
+				},
+			}
+		}
+		stmts = append(stmts, field_stmts...)
+	}
+	var printed ast.Expr = &ast.UnaryExpr{Op: token.AND, X: ast.NewIdent("v")}
+	if !redacted {
+		stmts = nil
+		printed = commonIdent("msg")
+	}
+	stmts = append(stmts, &ast.ReturnStmt{
+		Results: []ast.Expr{
+			&ast.CallExpr{
+				Fun:  commonIdent("formatMessage"),
+				Args: []ast.Expr{printed},
+			},
+		},
+	})
+
+	return &ast.FuncDecl{
+		Recv: &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{commonIdent("msg")},
+					Type:  &ast.StarExpr{X: typ},
+				},
+			},
+		},
+		Name: commonIdent("String"),
+		Type: &ast.FuncType{
+			Params: &ast.FieldList{},
+			Results: &ast.FieldList{
+				List: []*ast.Field{
+					&ast.Field{Type: commonIdent("string")},
+				},
+			},
+		},
+		Body: &ast.BlockStmt{List: stmts},
+	}, nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,58 @@
+package qmigen
+
+import (
+	"strings"
+	"testing"
+)
+
+// personalMessage is a data file with personal info at the top of a TLV,
+// inside a sequence and in the elements of an array
+const personalMessage = `[
+  { "name" : "NAS", "type" : "Service" },
+  { "name" : "Get Home Network", "type" : "Message", "service" : "NAS", "id" : "0x0025", "since" : "1.0",
+    "output" : [ { "name" : "MSISDN", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "string",
+                   "personal-info" : "yes" },
+                 { "name" : "Location", "id" : "0x11", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+                   "contents" : [ { "name" : "Cell ID", "format" : "guint32", "personal-info" : "yes" },
+                                  { "name" : "Verified", "format" : "gboolean", "personal-info" : "yes" },
+                                  { "name" : "RAT", "format" : "guint8" } ] },
+                 { "name" : "IMSIs", "id" : "0x12", "type" : "TLV", "since" : "1.0", "format" : "array",
+                   "personal-info" : "yes", "array-element" : { "format" : "string" } } ] }
+]`
+
+func TestPersonalRedact(t *testing.T) {
+	for _, optional := range []bool{false, true} {
+		src, err := Generate(strings.NewReader(personalMessage), Options{Common: NewRegistry(nil), OptionalPointers: optional})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := string(src)
+		i := strings.Index(s, "*NASGetHomeNetworkOutput) String() string {")
+		if i < 0 {
+			t.Fatal("no String()")
+		}
+		body := s[i:]
+		body = body[:strings.Index(body, "\n}\n")]
+
+		for _, want := range []string{
+			"redactString(",
+			".CellID = 0",
+			".Verified = false",
+			// the array is copied before its elements are masked
+			"append([]string(nil), ",
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("optional %v: String() lacks %s:\n%s", optional, want, body)
+			}
+		}
+		if strings.Contains(body, ".RAT =") {
+			t.Errorf("optional %v: RAT masked:\n%s", optional, body)
+		}
+		// the message itself is never written
+		if strings.Contains(body, "msg.MSISDN =") || strings.Contains(body, "msg.Location") {
+			t.Errorf("optional %v: String() masks the message:\n%s", optional, body)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
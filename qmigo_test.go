@@ -0,0 +1,73 @@
+package qmigen
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestQmigo builds qmigo with every service and with DMS and WDS only,
+// against the package generated with -explicit-register: the small one
+// lists no NAS command, and is smaller
+func TestQmigo(t *testing.T) {
+	dir := generateFixture(t, Options{ExplicitRegister: true})
+	bin := t.TempDir()
+
+	build := func(name string, tags string) (string, os.FileInfo) {
+		t.Helper()
+		out := filepath.Join(bin, name)
+		goTool(t, dir, nil, "build", "-tags", tags, "-o", out, generatorModule+"/examples/qmigo")
+		info, err := os.Stat(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out, info
+	}
+	run := func(bin string, args ...string) string {
+		t.Helper()
+		cmd := exec.Command(bin, args...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("%s %s: %s\n%s%s", filepath.Base(bin), strings.Join(args, " "), err, out, stderr.Bytes())
+		}
+		return string(out)
+	}
+
+	full, full_info := build("qmigo", "examples qmigo")
+	small, small_info := build("qmigo-small", "examples qmigo qmigo_select qmigo_dms qmigo_wds")
+
+	full_list := run(full, "-list")
+	small_list := run(small, "-list")
+	for _, command := range []string{"dms get-ids\n", "wds get-packet-service-status\n"} {
+		if !strings.Contains(full_list, command) || !strings.Contains(small_list, command) {
+			t.Errorf("%q missing from\n%s\nor\n%s", command, full_list, small_list)
+		}
+	}
+	if !strings.Contains(full_list, "\nnas ") {
+		t.Errorf("no NAS command in\n%s", full_list)
+	}
+	if strings.Contains(small_list, "\nnas ") {
+		t.Errorf("NAS linked into the DMS and WDS build:\n%s", small_list)
+	}
+
+	if small_info.Size() >= full_info.Size() {
+		t.Errorf("DMS and WDS build is %d bytes, every service %d", small_info.Size(), full_info.Size())
+	}
+	t.Logf("qmigo: %d bytes, DMS and WDS only: %d bytes", full_info.Size(), small_info.Size())
+
+	cassette, err := filepath.Abs("examples/testdata/identity.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := run(small, "-cassette", cassette, "dms", "get-manufacturer")
+	if !strings.Contains(out, "ACME") {
+		t.Errorf("dms get-manufacturer printed %q", out)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
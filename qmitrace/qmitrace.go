@@ -0,0 +1,269 @@
+//go:build qmitrace
+// +build qmitrace
+
+// Package qmitrace logs QMI frames in the layout of qmicli --verbose, for
+// the tools and people used to reading those traces. It needs the
+// generated qmi package, hence the qmitrace build tag.
+package qmitrace
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+)
+
+// Tracer logs the frames of a device, its Tap goes to qmi.WithFrameTap:
+//
+//	t := &qmitrace.Tracer{Path: "/dev/cdc-wdm0"}
+//	dev, err := qmi.Open(t.Path, qmi.WithFrameTap(t.Tap))
+type Tracer struct {
+	Logger *log.Logger // the standard logger when nil
+	Path   string      // of the device, prefixing every trace
+	Vendor uint16      // as given to qmi.WithVendor
+}
+
+// prefix starts the lines of a frame, whichever its direction
+const prefix = "<<<<<< "
+
+// Tap logs the frame as raw bytes, then translated
+func (t *Tracer) Tap(direction qmi.Direction, frame []byte) {
+	raw, translated := t.Format(direction, frame)
+	if t.Logger == nil {
+		log.Print(raw)
+		log.Print(translated)
+		return
+	}
+	t.Logger.Print(raw)
+	t.Logger.Print(translated)
+}
+
+// Format renders the raw and the translated trace of the frame
+func (t *Tracer) Format(direction qmi.Direction, frame []byte) (raw string, translated string) {
+	action, kind := "received", direction.String()
+	if direction == qmi.DirectionRequest {
+		action = "sent"
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "[%s] %s message...\n", t.Path, action)
+	fmt.Fprintf(&b, "%sRAW:\n", prefix)
+	fmt.Fprintf(&b, "%s  length = %d\n", prefix, len(frame))
+	fmt.Fprintf(&b, "%s  data   = %s\n", prefix, hexString(frame))
+	raw = b.String()
+
+	b.Reset()
+	h, ok := parseHeader(frame)
+	if !ok {
+		fmt.Fprintf(&b, "[%s] %s invalid %s\n", t.Path, action, kind)
+		return raw, b.String()
+	}
+
+	info, known := qmi.LookupVendorMessage(t.Vendor, qmi.Service(h.service), h.messageID, direction)
+	vendor := "generic"
+	if known && info.Vendor != 0 {
+		vendor = fmt.Sprintf("vendor-specific (0x%04x)", info.Vendor)
+	}
+	fmt.Fprintf(&b, "[%s] %s %s %s (translated)...\n", t.Path, action, vendor, kind)
+
+	fmt.Fprintf(&b, "%sQMUX:\n", prefix)
+	fmt.Fprintf(&b, "%s  length  = %d\n", prefix, binary.LittleEndian.Uint16(frame[1:]))
+	fmt.Fprintf(&b, "%s  flags   = 0x%02x\n", prefix, frame[3])
+	fmt.Fprintf(&b, "%s  service = \"%s\"\n", prefix, serviceName(h.service))
+	fmt.Fprintf(&b, "%s  client  = %d\n", prefix, frame[5])
+	fmt.Fprintf(&b, "%sQMI:\n", prefix)
+	fmt.Fprintf(&b, "%s  flags       = \"%s\"\n", prefix, h.flagsString())
+	fmt.Fprintf(&b, "%s  transaction = %d\n", prefix, h.transaction)
+	fmt.Fprintf(&b, "%s  tlv_length  = %d\n", prefix, len(h.tlvs))
+
+	if !known {
+		// the generic layout of libqmi, for messages it does not know
+		fmt.Fprintf(&b, "%s  message     = (0x%04x)\n", prefix, h.messageID)
+		forEachTLV(h.tlvs, func(tag uint8, value []byte) {
+			fmt.Fprintf(&b, "%sTLV:\n", prefix)
+			fmt.Fprintf(&b, "%s  type   = 0x%02x\n", prefix, tag)
+			fmt.Fprintf(&b, "%s  length = %d\n", prefix, len(value))
+			fmt.Fprintf(&b, "%s  value  = %s\n", prefix, hexString(value))
+		})
+		return raw, b.String()
+	}
+
+	fmt.Fprintf(&b, "%s  message     = \"%s\" (0x%04x)\n", prefix, info.Title, h.messageID)
+	forEachTLV(h.tlvs, func(tag uint8, value []byte) {
+		fmt.Fprintf(&b, "%sTLV:\n", prefix)
+		tlv, ok := info.TLV(tag)
+		if !ok {
+			fmt.Fprintf(&b, "%s  type       = 0x%02x\n", prefix, tag)
+		} else {
+			fmt.Fprintf(&b, "%s  type       = \"%s\" (0x%02x)\n", prefix, tlvName(tlv), tag)
+		}
+		fmt.Fprintf(&b, "%s  length     = %d\n", prefix, len(value))
+		fmt.Fprintf(&b, "%s  value      = %s\n", prefix, hexString(value))
+		if s, ok := translate(info.Service, tlv, value); ok {
+			fmt.Fprintf(&b, "%s  translated = %s\n", prefix, s)
+		}
+	})
+	return raw, b.String()
+}
+
+type header struct {
+	service     uint8
+	ctl         bool
+	flags       uint8
+	transaction uint16
+	messageID   uint16
+	tlvs        []byte
+}
+
+// parseHeader splits a QMUX frame. CTL has a one byte transaction ID,
+// other services two.
+func parseHeader(frame []byte) (header, bool) {
+	if len(frame) < 12 || frame[0] != 0x01 {
+		return header{}, false
+	}
+	h := header{service: frame[4], flags: frame[6]}
+	h.ctl = qmi.Service(h.service) == qmi.QMI_SERVICE_CTL
+
+	off := 8
+	if h.ctl {
+		h.transaction = uint16(frame[7])
+	} else {
+		if len(frame) < 13 {
+			return header{}, false
+		}
+		h.transaction = binary.LittleEndian.Uint16(frame[7:])
+		off = 9
+	}
+	h.messageID = binary.LittleEndian.Uint16(frame[off:])
+	n := int(binary.LittleEndian.Uint16(frame[off+2:]))
+	if off+4+n > len(frame) {
+		return header{}, false
+	}
+	h.tlvs = frame[off+4 : off+4+n]
+	return h, true
+}
+
+func (h header) flagsString() string {
+	names := []string{"compound", "response", "indication"}
+	if h.ctl {
+		names = names[1:]
+	}
+	var set []string
+	for i, name := range names {
+		if h.flags&(1<<uint(i)) != 0 {
+			set = append(set, name)
+		}
+	}
+	if len(set) == 0 {
+		return "none"
+	}
+	return strings.Join(set, ", ")
+}
+
+// serviceName spells the service as libqmi does, "dms"
+func serviceName(service uint8) string {
+	if name := qmi.ServiceMap[qmi.Service(service)]; name != "" {
+		return strings.ToLower(strings.TrimPrefix(name, "QMI_SERVICE_"))
+	}
+	return "unknown"
+}
+
+// isResult tells the result TLV of responses
+func isResult(tlv qmi.TLVInfo) bool {
+	return tlv.Tag == 0x02 && tlv.Name == "Operation Result"
+}
+
+// tlvName is the name of the TLV in the data files. Common-refs are
+// named after the ref, libqmi names the result TLV "Result".
+func tlvName(tlv qmi.TLVInfo) string {
+	if isResult(tlv) {
+		return "Result"
+	}
+	return tlv.Name
+}
+
+// forEachTLV calls f for the TLVs of tlvs, up to a truncated one
+func forEachTLV(tlvs []byte, f func(tag uint8, value []byte)) {
+	for len(tlvs) >= 3 {
+		n := int(binary.LittleEndian.Uint16(tlvs[1:]))
+		if 3+n > len(tlvs) {
+			return
+		}
+		f(tlvs[0], tlvs[3:3+n])
+		tlvs = tlvs[3+n:]
+	}
+}
+
+// translate renders the value of a TLV of a scalar format, the result and
+// strings. qmicli spells enums and structs from its own data, which the
+// metadata lacks: they get no translation.
+func translate(svc qmi.Service, tlv qmi.TLVInfo, value []byte) (string, bool) {
+	if isResult(tlv) && len(value) == 4 {
+		if binary.LittleEndian.Uint16(value) == 0 {
+			return "SUCCESS", true
+		}
+		code := qmi.QMIError(binary.LittleEndian.Uint16(value[2:]))
+		if desc := qmi.ErrorDescription(svc, code); desc != "" {
+			return "FAILURE: " + desc, true
+		}
+		return fmt.Sprintf("FAILURE: %d", uint16(code)), true
+	}
+
+	switch tlv.Format {
+	case "guint8", "gint8":
+		if len(value) == 1 {
+			if tlv.Format == "gint8" {
+				return fmt.Sprint(int8(value[0])), true
+			}
+			return fmt.Sprint(value[0]), true
+		}
+	case "guint16", "gint16":
+		if len(value) == 2 {
+			v := binary.LittleEndian.Uint16(value)
+			if tlv.Format == "gint16" {
+				return fmt.Sprint(int16(v)), true
+			}
+			return fmt.Sprint(v), true
+		}
+	case "guint32", "gint32":
+		if len(value) == 4 {
+			v := binary.LittleEndian.Uint32(value)
+			if tlv.Format == "gint32" {
+				return fmt.Sprint(int32(v)), true
+			}
+			return fmt.Sprint(v), true
+		}
+	case "guint64", "gint64":
+		if len(value) == 8 {
+			v := binary.LittleEndian.Uint64(value)
+			if tlv.Format == "gint64" {
+				return fmt.Sprint(int64(v)), true
+			}
+			return fmt.Sprint(v), true
+		}
+	case "string":
+		for _, c := range value {
+			if c < 0x20 || c > 0x7e {
+				return "", false
+			}
+		}
+		return string(value), true
+	}
+	return "", false
+}
+
+// hexString spells bytes as libqmi does, 01:0F:00
+func hexString(b []byte) string {
+	var s strings.Builder
+	for i, c := range b {
+		if i > 0 {
+			s.WriteByte(':')
+		}
+		fmt.Fprintf(&s, "%02X", c)
+	}
+	return s.String()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
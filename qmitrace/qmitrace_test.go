@@ -0,0 +1,52 @@
+//go:build qmitrace
+// +build qmitrace
+
+package qmitrace
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+)
+
+// frames of testdata/identity.trace: DMS Get Manufacturer answered, the
+// allocation of its client ID, and a message of no data file
+var frames = []struct {
+	direction qmi.Direction
+	frame     string
+}{
+	{qmi.DirectionRequest, "010f0000000000022200040001010002"},
+	{qmi.DirectionResponse, "011700800000010222000c00010200020102040000000000"},
+	{qmi.DirectionRequest, "010c0000020100010021000000"},
+	{qmi.DirectionResponse, "011a0080020102010021000e0001040041434d4502040000000000"},
+	{qmi.DirectionResponse, "01170080020102020055000b0002040001000300100100ff"},
+}
+
+// TestFormat traces the frames in the layout of qmicli --verbose, as
+// captured in testdata/identity.trace
+func TestFormat(t *testing.T) {
+	want, err := ioutil.ReadFile("testdata/identity.trace")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tracer := &Tracer{Path: "/dev/cdc-wdm0"}
+	var got strings.Builder
+	for _, f := range frames {
+		frame, err := hex.DecodeString(f.frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw, translated := tracer.Format(f.direction, frame)
+		got.WriteString(raw)
+		got.WriteString(translated)
+	}
+	if got.String() != string(want) {
+		t.Errorf("traced\n%s\nwant\n%s", got.String(), want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
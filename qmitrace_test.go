@@ -0,0 +1,15 @@
+package qmigen
+
+import (
+	"testing"
+)
+
+// TestQmitrace runs the golden tests of qmitrace against the package
+// generated from testdata/data
+func TestQmitrace(t *testing.T) {
+	dir := generateFixture(t, Options{})
+	goTool(t, dir, nil, "vet", "-tags", "qmitrace", generatorModule+"/qmitrace")
+	goTool(t, dir, nil, "test", "-tags", "qmitrace", generatorModule+"/qmitrace")
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,165 @@
+package qmigen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+)
+
+// Registry holds what the generated files of the package share: common-ref
+// definitions, their payload sizes and the public-format enums. The one
+// loaded from qmi-common.json is frozen, every converted file layers its
+// own declarations on top of it and leaves no trace for the next file.
+type Registry struct {
+	parent *Registry
+	frozen bool
+
+	refs  map[string]map[string]interface{}
+	sizes map[string]int
+	enums map[string]*QMIEnum
+
+	// the generated file, type-checked with the files layered on it
+	output string
+	src    []byte
+}
+
+func NewRegistry(parent *Registry) *Registry {
+	return &Registry{
+		parent: parent,
+		refs:   map[string]map[string]interface{}{},
+		sizes:  map[string]int{},
+		enums:  map[string]*QMIEnum{},
+	}
+}
+
+// LoadRegistry reads the common-refs and enums of qmi-common.json,
+// generating its code in memory only
+func LoadRegistry(path string) (*Registry, error) {
+	gen, err := newGenerator(Options{})
+	if err != nil {
+		return nil, err
+	}
+	return gen.loadRegistry(path)
+}
+
+// loadRegistry declares the symbols of qmi-common.json in the run, which
+// the files layered on the registry must not collide with
+func (gen *generator) loadRegistry(path string) (*Registry, error) {
+	input, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := NewRegistry(nil)
+	defer func(prev *Registry, o Options) {
+		gen.reg = prev
+		gen.opts = o
+		gen.pendingEnums = nil
+		gen.pendingScales = nil
+	}(gen.reg, gen.opts)
+
+	// checked along with the files layered on it
+	o := gen.opts
+	o.Common = nil
+	o.SizeReport = nil
+	o.SkipTypeCheck = true
+	o.Output = "qmi-common.go"
+	o.Source = path
+
+	_, err = gen.generate(reg, input, o)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	reg.Freeze()
+	return reg, nil
+}
+
+// Freeze makes the registry read-only, to be shared by the files layered
+// on it
+func (r *Registry) Freeze() {
+	r.frozen = true
+}
+
+func (r *Registry) setSource(output string, src []byte) {
+	r.modify()
+	r.output = output
+	r.src = src
+}
+
+func (r *Registry) modify() {
+	if r.frozen {
+		panic("common registry modified after loading")
+	}
+}
+
+// Ref returns the raw definition of a common-ref
+func (r *Registry) Ref(name string) (map[string]interface{}, bool) {
+	for ; r != nil; r = r.parent {
+		if def, ok := r.refs[name]; ok {
+			return def, true
+		}
+	}
+	return nil, false
+}
+
+func (r *Registry) addRef(name string, def map[string]interface{}) {
+	r.modify()
+	r.refs[name] = def
+	delete(r.sizes, name)
+}
+
+// Size is the payload size of a generated common-ref TLV, -1 if variable
+func (r *Registry) Size(name string) (int, bool) {
+	for ; r != nil; r = r.parent {
+		if _, ok := r.refs[name]; ok {
+			n, ok := r.sizes[name]
+			return n, ok
+		}
+	}
+	return 0, false
+}
+
+func (r *Registry) setSize(name string, n int) {
+	r.modify()
+	r.sizes[name] = n
+}
+
+// Enum returns the enum declared for a public-format
+func (r *Registry) Enum(name string) *QMIEnum {
+	for ; r != nil; r = r.parent {
+		if enum, ok := r.enums[name]; ok {
+			return enum
+		}
+	}
+	return nil
+}
+
+func (r *Registry) addEnum(enum *QMIEnum) {
+	r.modify()
+	r.enums[enum.Name] = enum
+}
+
+// EnumValue finds the enum declaring a value name, those of the file first
+// and in the order of their names
+func (r *Registry) EnumValue(value string) (*QMIEnum, QMIEnumValue, bool) {
+	for ; r != nil; r = r.parent {
+		names := make([]string, 0, len(r.enums))
+		for name := range r.enums {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			enum := r.enums[name]
+			for _, v := range enum.Values {
+				if v.Name == value {
+					return enum, v, true
+				}
+			}
+		}
+	}
+	return nil, QMIEnumValue{}, false
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,86 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestRegistryOrder generates the service files on one registry forwards
+// and backwards and expects the same files
+func TestRegistryOrder(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs, err := filepath.Glob("testdata/data/qmi-service-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	generate := func(order []string) map[string][]byte {
+		dir := t.TempDir()
+		out := map[string][]byte{}
+		for _, input := range order {
+			output := filepath.Join(dir, strings.TrimSuffix(filepath.Base(input), ".json")+".go")
+			err := GenerateFile(output, input, Options{Generator: "qmigen", Common: common, SkipTypeCheck: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[filepath.Base(output)], err = ioutil.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return out
+	}
+	reversed := make([]string, len(inputs))
+	for i, input := range inputs {
+		reversed[len(inputs)-1-i] = input
+	}
+
+	forwards, backwards := generate(inputs), generate(reversed)
+	for name, src := range forwards {
+		if !bytes.Equal(src, backwards[name]) {
+			t.Errorf("%s depends on the order of generation", name)
+		}
+	}
+}
+
+// TestRegistryLayers generates two services declaring a common-ref of the
+// same name and different sizes, in both orders, and expects neither to
+// see the other's
+func TestRegistryLayers(t *testing.T) {
+	service := func(name, format string) string {
+		return `[
+  { "common-ref" : "Band", "name" : "Band", "id" : "0x01", "type" : "TLV", "since" : "1.0", "format" : "` + format + `" },
+  { "name" : "` + name + `", "type" : "Service" },
+  { "name" : "Get Band", "type" : "Message", "service" : "` + name + `", "id" : "0x0001", "since" : "1.0",
+    "output" : [ { "common-ref" : "Band" } ] }
+]`
+	}
+	common := NewRegistry(nil)
+	common.Freeze()
+	generate := func(name, format string) string {
+		src, err := Generate(strings.NewReader(service(name, format)), Options{Common: common})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(src)
+	}
+
+	nas, wds := generate("NAS", "guint8"), generate("WDS", "guint32")
+	if wds2, nas2 := generate("WDS", "guint32"), generate("NAS", "guint8"); nas != nas2 || wds != wds2 {
+		t.Error("the files depend on the order of generation")
+	}
+	if _, ok := common.Size("Band"); ok {
+		t.Error("a service file declared Band in the shared registry")
+	}
+	if !strings.Contains(nas, "uint8") || strings.Contains(nas, "uint32") {
+		t.Errorf("NAS Band is not a guint8:\n%s", nas)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,36 @@
+//go:build qmiruntime && qmioptions
+// +build qmiruntime,qmioptions
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestPresenceAccessors tells an optional TLV carried empty from an absent
+// one, and follows a value set by hand
+func TestPresenceAccessors(t *testing.T) {
+	// the IMEI 0x11 is present and empty, the ESN 0x10 absent
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 11 0000"))
+	msg := &DMSGetIDsOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imei, ok := msg.GetIMEI(); imei != "" || !ok {
+		t.Errorf("GetIMEI() = %q, %v, want an empty string present", imei, ok)
+	}
+	if esn, ok := msg.GetEsn(); esn != "" || ok {
+		t.Errorf("GetEsn() = %q, %v, want it absent", esn, ok)
+	}
+
+	meid := "A0000000000001"
+	msg.Meid = &meid
+	if got, ok := msg.GetMeid(); got != meid || !ok {
+		t.Errorf("GetMeid() = %q, %v, want %q present", got, ok, meid)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,186 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestArrayTLVs decodes hand-built TLVs of arrays and expects the same
+// bytes written back
+func TestArrayTLVs(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		cons  func() Message
+		tlvs  string
+		field string
+		want  interface{}
+	}{{
+		"array of structs",
+		TLVConstructors[QMI_SERVICE_WDS][0x2A],
+		// two profiles: a count, then type, index and a prefixed name each
+		"01 0c00 02 00 01 03 696e74 00 02 02 6d6d" +
+			" 02 0400 0000 0000",
+		"ProfileList",
+		[]struct {
+			ProfileType  uint8
+			ProfileIndex uint8
+			ProfileName  string
+		}{{0, 1, "int"}, {0, 2, "mm"}},
+	}, {
+		"empty array of structs",
+		TLVConstructors[QMI_SERVICE_WDS][0x2A],
+		"01 0100 00 02 0400 0000 0000",
+		"ProfileList",
+		[]struct {
+			ProfileType  uint8
+			ProfileIndex uint8
+			ProfileName  string
+		}{},
+	}, {
+		"one byte count",
+		TLVConstructors[QMI_SERVICE_NAS][0x21],
+		// an empty array TLV is written nevertheless
+		"02 0400 0000 0000 10 0b00 01 fa00 0100 01 04 41434d45 11 0200 0000",
+		"NetworkInformation",
+		[]struct {
+			MCC           uint16
+			MNC           uint16
+			NetworkStatus uint8
+			Description   string
+		}{{250, 1, 1, "ACME"}},
+	}, {
+		"two byte count",
+		TLVConstructors[QMI_SERVICE_NAS][0x21],
+		"02 0400 0000 0000 10 0100 00 11 0c00 0200 fa00 0100 08 fa00 0200 05",
+		"RadioAccessTechnology",
+		[]struct {
+			MCC            uint16
+			MNC            uint16
+			RadioInterface uint8
+		}{{250, 1, 8}, {250, 2, 5}},
+	}, {
+		"fixed-size arrays",
+		RequestConstructors[QMI_SERVICE_DMS][0x48],
+		// a count and an entry of 1 + 16 + 1 + 2 bytes, then two uint16
+		// without a count
+		"01 1500 01 01 000102030405060708090a0b0c0d0e0f 02 6f6b" +
+			" 10 0400 0100 0200",
+		"List",
+		[]struct {
+			Type     uint8
+			UniqueID [16]uint8
+			BuildID  string
+		}{{1, [16]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}, "ok"}},
+	}, {
+		"fixed-size array TLV",
+		RequestConstructors[QMI_SERVICE_DMS][0x48],
+		"01 0100 00 10 0400 0100 0200",
+		"BandPairs",
+		[2]uint16{1, 2},
+	}, {
+		"array of signed bytes in a struct",
+		RequestConstructors[QMI_SERVICE_NAS][0x02],
+		"10 0500 01 03 b5 c4 d3",
+		"SignalStrengthIndicator",
+		struct {
+			Report     bool
+			Thresholds []int8
+		}{true, []int8{-75, -60, -45}},
+	}, {
+		"array with a two byte count in a struct",
+		RequestConstructors[QMI_SERVICE_WMS][0x20],
+		"01 0600 06 0300 0a0b0c",
+		"RawMessageData",
+		struct {
+			Format  uint8
+			RawData []uint8
+		}{6, []uint8{10, 11, 12}},
+	}} {
+		tlvs, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		msg := test.cons()
+		err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		// reflect.Indirect sees through -optional-pointers
+		got := reflect.Indirect(reflect.ValueOf(msg).Elem().FieldByName(test.field))
+		if !reflect.DeepEqual(got.Interface(), test.want) {
+			t.Errorf("%s: decoded %+v, want %+v", test.name, got.Interface(), test.want)
+		}
+
+		var buf bytes.Buffer
+		err = msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tlvs) {
+			t.Errorf("%s:\n got % x\nwant % x", test.name, buf.Bytes(), tlvs)
+		}
+	}
+}
+
+// TestFixedArrayShort expects a fixed-size array TLV shorter than its
+// elements to fail
+func TestFixedArrayShort(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("01 0100 00 10 0200 0100"))
+	err := RequestConstructors[QMI_SERVICE_DMS][0x48]().TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err == nil {
+		t.Error("one of two elements accepted")
+	}
+}
+
+// TestStructArrayRoundTrip writes arrays of records with strings of
+// differing lengths and reads them back
+func TestStructArrayRoundTrip(t *testing.T) {
+	profiles := &WDSGetProfileListOutput{}
+	setField(profiles, "ProfileList", []struct {
+		ProfileType  uint8
+		ProfileIndex uint8
+		ProfileName  string
+	}{{0, 1, "internet"}, {1, 2, ""}, {0, 3, "ims"}})
+
+	scan := &NASNetworkScanOutput{}
+	setField(scan, "NetworkInformation", []struct {
+		MCC           uint16
+		MNC           uint16
+		NetworkStatus uint8
+		Description   string
+	}{{250, 1, 1, "MTS RUS"}, {250, 99, 2, "Beeline"}})
+
+	for _, test := range []struct {
+		msg   Message
+		field string
+	}{
+		{profiles, "ProfileList"},
+		{scan, "NetworkInformation"},
+	} {
+		msg := test.msg
+		var buf bytes.Buffer
+		err := msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Errorf("%T: %s", msg, err)
+			continue
+		}
+		got := reflect.New(reflect.TypeOf(msg).Elem()).Interface().(Message)
+		err = got.TLVsReadFrom(&buf)
+		if err != nil {
+			t.Errorf("%T: %s", msg, err)
+			continue
+		}
+		field := func(m Message) interface{} {
+			return reflect.ValueOf(m).Elem().FieldByName(test.field).Interface()
+		}
+		if !reflect.DeepEqual(field(got), field(msg)) {
+			t.Errorf("%T: read %+v, want %+v", msg, field(got), field(msg))
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
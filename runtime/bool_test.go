@@ -0,0 +1,48 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestBoolTLV reads any non-zero byte of a gboolean as true and writes
+// 0x00 or 0x01
+func TestBoolTLV(t *testing.T) {
+	for _, test := range []struct {
+		in, out string
+		want    bool
+	}{
+		{"10 0200 00 00", "10 0200 00 00", false},
+		{"10 0200 01 00", "10 0200 01 00", true},
+		{"10 0200 ff 00", "10 0200 01 00", true},
+		{"10 0200 80 00", "10 0200 01 00", true},
+	} {
+		in, _ := hex.DecodeString(stripSpaces(test.in))
+		msg := &NASSetEventReportInput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(in))
+		if err != nil {
+			t.Errorf("%s: %s", test.in, err)
+			continue
+		}
+		report, _ := messageField(msg, "SignalStrengthIndicator")
+		if got := report.FieldByName("Report").Bool(); got != test.want {
+			t.Errorf("%s: read %t", test.in, got)
+		}
+
+		var buf bytes.Buffer
+		err = msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, _ := hex.DecodeString(stripSpaces(test.out))
+		if !bytes.Equal(buf.Bytes(), out) {
+			t.Errorf("%s: wrote % x, want % x", test.in, buf.Bytes(), out)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,38 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// TestByteOrder expects leOrder, whichever file declares it for the host
+// and build tags, to lay out and read back integers as binary.LittleEndian
+func TestByteOrder(t *testing.T) {
+	for _, v := range []uint64{0, 1, 0x80, 0x0102030405060708, 0x8000000000000001, 1<<64 - 1} {
+		var got, want [8]byte
+
+		leOrder.PutUint16(got[:], uint16(v))
+		binary.LittleEndian.PutUint16(want[:], uint16(v))
+		if !bytes.Equal(got[:], want[:]) || leOrder.Uint16(want[:]) != uint16(v) {
+			t.Errorf("uint16 %#x: % x, want % x", uint16(v), got, want)
+		}
+
+		leOrder.PutUint32(got[:], uint32(v))
+		binary.LittleEndian.PutUint32(want[:], uint32(v))
+		if !bytes.Equal(got[:], want[:]) || leOrder.Uint32(want[:]) != uint32(v) {
+			t.Errorf("uint32 %#x: % x, want % x", uint32(v), got, want)
+		}
+
+		leOrder.PutUint64(got[:], v)
+		binary.LittleEndian.PutUint64(want[:], v)
+		if !bytes.Equal(got[:], want[:]) || leOrder.Uint64(want[:]) != v {
+			t.Errorf("uint64 %#x: % x, want % x", v, got, want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,192 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// identityModem answers DMS Get Manufacturer and Get IDs
+func identityModem(req Message) Message {
+	switch req.(type) {
+	case *DMSGetManufacturerInput:
+		return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+	case *DMSGetIDsInput:
+		resp := &DMSGetIDsOutput{}
+		setField(resp, "IMEI", "350000000000001")
+		return resp
+	}
+	return nil
+}
+
+// setField sets a field of msg, also with -optional-pointers
+func setField(msg Message, name string, v interface{}) {
+	f := reflect.ValueOf(msg).Elem().FieldByName(name)
+	if f.Kind() == reflect.Ptr {
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+	f.Set(reflect.ValueOf(v))
+}
+
+// record records a session of the identity queries with the modem
+// starting its client IDs after cids
+func record(t *testing.T, cids uint8, opts ...Option) *Cassette {
+	t.Helper()
+	c := &Cassette{}
+	m, f := newFakeModem(t, identityModem)
+	m.cids = cids
+	dev, err := NewDevice(f, "fake", append(opts, WithRecorder(c))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range []Message{&DMSGetManufacturerInput{}, &DMSGetIDsInput{}} {
+		_, err = dms.Send(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return c
+}
+
+func TestCassetteRoundTrip(t *testing.T) {
+	c := record(t, 0, WithTransactionSeed(0x100))
+	// Sync, Allocate CID and the two queries
+	if len(c.Interactions) != 4 {
+		t.Fatalf("%d interactions recorded, want 4", len(c.Interactions))
+	}
+	for i, it := range c.Interactions {
+		if it.Request == "" || it.Response == "" {
+			t.Errorf("interaction %d: %+v", i, it)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "session.json")
+	err := c.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCassette(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Interactions, c.Interactions) {
+		t.Errorf("loaded %+v\nsaved %+v", loaded.Interactions, c.Interactions)
+	}
+
+	// other transaction IDs than recorded
+	dev, err := loaded.Replay(WithTransactionSeed(7))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		// a request repeated gets the last response again
+		resp, err := dms.Send(&DMSGetManufacturerInput{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m := resp.(*DMSGetManufacturerOutput).Manufacturer; m != "ACME" {
+			t.Errorf("Manufacturer %q", m)
+		}
+	}
+	resp, err := dms.Send(&DMSGetIDsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if imei := reflect.Indirect(reflect.ValueOf(resp).Elem().FieldByName("IMEI")).String(); imei != "350000000000001" {
+		t.Errorf("IMEI %q", imei)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = dms.SendContext(ctx, &DMSGetModelInput{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("request never recorded: err = %v", err)
+	}
+}
+
+func TestCassetteNormalize(t *testing.T) {
+	a := record(t, 0, WithTransactionSeed(0x100))
+	b := record(t, 5, WithTransactionSeed(0x2000))
+	if reflect.DeepEqual(a.Interactions, b.Interactions) {
+		t.Fatal("recordings equal before Normalize")
+	}
+
+	for _, c := range []*Cassette{a, b} {
+		err := c.Normalize()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !reflect.DeepEqual(a.Interactions, b.Interactions) {
+		t.Errorf("normalized recordings differ:\n%+v\n%+v", a.Interactions, b.Interactions)
+	}
+
+	// a normalized recording still replays
+	dev, err := a.Replay()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dms.Send(&DMSGetManufacturerInput{}); err != nil {
+		t.Error(err)
+	}
+}
+
+// A test replays a session recorded from a modem instead of frames
+// assembled by hand: CTL Sync, Allocate CID and DMS Get Manufacturer.
+func ExampleCassette_Replay() {
+	c := &Cassette{Interactions: []Interaction{{
+		Request:  "010b00000000000127000000",
+		Response: "01120080000001012700070002040000000000",
+	}, {
+		Request:  "010f0000000000022200040001010002",
+		Response: "011700800000010222000c00010200020102040000000000",
+	}, {
+		Request:  "010c0000020100010021000000",
+		Response: "011a0080020102010021000e0001040041434d4502040000000000",
+	}}}
+
+	dev, err := c.Replay()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer dev.Close()
+
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	resp, err := dms.Send(&DMSGetManufacturerInput{})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(resp.(*DMSGetManufacturerOutput).Manufacturer)
+	// Output: ACME
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
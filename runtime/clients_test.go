@@ -0,0 +1,89 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+// TestClientCounters sends requests from several goroutines while others
+// take snapshots of the clients, and expects every request counted
+func TestClientCounters(t *testing.T) {
+	clock := fakeClock(t)
+	allocated := clock.Now()
+	dev, _ := openFake(t, identityModem)
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const senders, sends = 4, 25
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	snapshots := make(chan struct{})
+	go func() {
+		defer close(snapshots)
+		var last uint64
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			for _, info := range dev.Clients() {
+				if info.Service != QMI_SERVICE_DMS {
+					continue
+				}
+				if info.TxCount < last {
+					t.Errorf("TxCount went back from %d to %d", last, info.TxCount)
+				}
+				last = info.TxCount
+			}
+			dev.Stats()
+			runtime.Gosched()
+		}
+	}()
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < sends; j++ {
+				if _, err := dev.Send(&DMSGetIDsInput{}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(stop)
+	<-snapshots
+
+	if n := dms.TxCount(); n != senders*sends {
+		t.Errorf("TxCount %d, want %d", n, senders*sends)
+	}
+	var found bool
+	for _, info := range dev.Clients() {
+		if info.Service != QMI_SERVICE_DMS {
+			continue
+		}
+		found = true
+		if info.ClientID != dms.ClientID {
+			t.Errorf("client ID %d, want %d", info.ClientID, dms.ClientID)
+		}
+		if info.TxCount != senders*sends {
+			t.Errorf("snapshot TxCount %d, want %d", info.TxCount, senders*sends)
+		}
+		if !info.AllocatedAt.Equal(allocated) {
+			t.Errorf("allocated at %v, want %v", info.AllocatedAt, allocated)
+		}
+	}
+	if !found {
+		t.Error("no DMS client in the snapshot")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,153 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// clk is the clock of the runtime in tests: real time, unless a test takes
+// it over with fakeClock
+var clk = &testClock{}
+
+func TestMain(m *testing.M) {
+	timeNow = clk.Now
+	newTicker = clk.NewTicker
+	newTimer = clk.NewTimer
+	os.Exit(m.Run())
+}
+
+type testClock struct {
+	sync.Mutex
+	fake    bool
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	c       chan time.Time
+	period  time.Duration // 0 for a timer
+	next    time.Time
+	stopped bool
+}
+
+// fakeClock stops the clock of the runtime for the rest of t, until
+// advanced. Tests taking it over must not run in parallel.
+func fakeClock(t *testing.T) *testClock {
+	clk.Lock()
+	clk.fake = true
+	clk.now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	clk.tickers = nil
+	clk.Unlock()
+
+	t.Cleanup(func() {
+		clk.Lock()
+		clk.fake = false
+		clk.Unlock()
+	})
+	return clk
+}
+
+func (c *testClock) Now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	if !c.fake {
+		return time.Now()
+	}
+	return c.now
+}
+
+func (c *testClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	return c.start(d, d)
+}
+
+func (c *testClock) NewTimer(d time.Duration) (<-chan time.Time, func()) {
+	return c.start(d, 0)
+}
+
+// start runs a ticker of period, or a timer if 0, due after d
+func (c *testClock) start(d, period time.Duration) (<-chan time.Time, func()) {
+	c.Lock()
+	defer c.Unlock()
+	if !c.fake && period > 0 {
+		t := time.NewTicker(d)
+		return t.C, t.Stop
+	}
+	if !c.fake {
+		t := time.NewTimer(d)
+		return t.C, func() { t.Stop() }
+	}
+
+	ft := &fakeTicker{c: make(chan time.Time), period: period, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, ft)
+	return ft.c, func() {
+		c.Lock()
+		ft.stopped = true
+		c.Unlock()
+	}
+}
+
+// awaitTickers waits until n tickers are running
+func (c *testClock) awaitTickers(t *testing.T, n int) {
+	t.Helper()
+	c.await(t, "tickers", n, func(ft *fakeTicker) bool { return ft.period > 0 })
+}
+
+// awaitTimers waits until n timers are pending
+func (c *testClock) awaitTimers(t *testing.T, n int) {
+	t.Helper()
+	c.await(t, "timers", n, func(ft *fakeTicker) bool { return ft.period == 0 })
+}
+
+func (c *testClock) await(t *testing.T, what string, n int, match func(*fakeTicker) bool) {
+	t.Helper()
+	waitFor(t, what, func() bool {
+		c.Lock()
+		defer c.Unlock()
+		running := 0
+		for _, ft := range c.tickers {
+			if !ft.stopped && match(ft) {
+				running++
+			}
+		}
+		return running == n
+	})
+}
+
+// advance moves the clock on by d. Unlike those of package time, tickers
+// and timers due hand their tick over: once advance returns, the
+// goroutines waiting for them got it.
+func (c *testClock) advance(t *testing.T, d time.Duration) {
+	t.Helper()
+	c.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	var due []*fakeTicker
+	for _, ft := range c.tickers {
+		if ft.stopped || ft.next.After(now) {
+			continue
+		}
+		if ft.period == 0 {
+			ft.stopped = true
+		}
+		for ft.period > 0 && !ft.next.After(now) {
+			ft.next = ft.next.Add(ft.period)
+		}
+		due = append(due, ft)
+	}
+	c.Unlock()
+
+	for _, ft := range due {
+		select {
+		case ft.c <- now:
+		case <-time.After(time.Second):
+			t.Fatal("tick not taken")
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
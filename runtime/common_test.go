@@ -0,0 +1,139 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// fill sets every field reachable from v to a distinct non-zero value,
+// counting on from *seq
+func fill(v reflect.Value, seq *int) {
+	*seq++
+	switch v.Kind() {
+	case reflect.Ptr:
+		v.Set(reflect.New(v.Type().Elem()))
+		fill(v.Elem(), seq)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				fill(v.Field(i), seq)
+			}
+		}
+	case reflect.Slice:
+		v.Set(reflect.MakeSlice(v.Type(), 2, 2))
+		fill(v.Index(0), seq)
+		fill(v.Index(1), seq)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fill(v.Index(i), seq)
+		}
+	case reflect.String:
+		v.SetString(fmt.Sprintf("s%d", *seq))
+	case reflect.Bool:
+		v.SetBool(true)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(*seq % 100))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(uint64(*seq % 100))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(*seq) / 4)
+	}
+}
+
+func TestCommonTLVRoundTrip(t *testing.T) {
+	var names []string
+	for name := range CommonTLVConstructors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if len(names) < 2 {
+		t.Fatalf("common TLVs %v, want those of qmi-common.json", names)
+	}
+
+	for _, name := range names {
+		tlv := CommonTLVConstructors[name]()
+		seq := 0
+		fill(reflect.ValueOf(tlv).Elem(), &seq)
+
+		var buf bytes.Buffer
+		err := tlv.TLVWriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if buf.Len() != tlv.Size() {
+			t.Errorf("%s: wrote %d bytes, Size() = %d", name, buf.Len(), tlv.Size())
+		}
+		if l := int(buf.Bytes()[1]) | int(buf.Bytes()[2])<<8; l != buf.Len()-3 {
+			t.Errorf("%s: TLV length %d for a payload of %d bytes", name, l, buf.Len()-3)
+		}
+
+		got := CommonTLVConstructors[name]()
+		err = got.ReadFrom(&buf)
+		if err != nil {
+			t.Errorf("%s: %s", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tlv) {
+			t.Errorf("%s: read back %+v, want %+v", name, got, tlv)
+		}
+	}
+}
+
+// TestCommonRefInput writes a request with a common-ref TLV, the network
+// descriptor of qmi-common.json, and compares the frame
+func TestCommonRefInput(t *testing.T) {
+	req := NewNASInitiateNetworkRegisterInput(2)
+	req.MCC = 250
+	req.MNC = 99
+	req.RadioAccessTechnology = 8
+
+	buf, err := Marshal(&req, 3, 0x0102, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString(stripSpaces("01 1800 00 03 03 00 0201 2200 0c00" +
+		" 01 0100 02 10 0500 fa00 6300 08"))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("frame\n% x\nwant\n% x", buf.Bytes(), want)
+	}
+}
+
+// TestCommonRefOutput decodes a response with common-ref TLVs besides
+// Operation Result, one nested in another, and writes it back
+func TestCommonRefOutput(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 10 0500 fa00 0100 08" +
+		" 11 0100 01 12 0800 44332211 0100 0300"))
+	msg := &NASGetServingSystemOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QMIStructNetworkDescriptor{MCC: 250, MNC: 1, RadioAccessTechnology: 8}
+	if msg.QMIStructNetworkDescriptor != want {
+		t.Errorf("network %+v, want %+v", msg.QMIStructNetworkDescriptor, want)
+	}
+	// Serving Cell embeds Cell Identity
+	want_cell := QMIStructServingCell{QMIStructCellIdentity{CellID: 0x11223344, TAC: 1}, 3}
+	if msg.QMIStructServingCell != want_cell {
+		t.Errorf("serving cell %+v, want %+v", msg.QMIStructServingCell, want_cell)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), tlvs) {
+		t.Errorf("written as\n% x\nwant\n% x", buf.Bytes(), tlvs)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
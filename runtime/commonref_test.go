@@ -0,0 +1,68 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestCommonRefConflict expects the files of the generated package to agree
+// on their common-refs, and a file generated from another definition of one
+// to be reported, in either init order, keeping every device closed
+func TestCommonRefConflict(t *testing.T) {
+	if conflicts := RegistryConflicts(); len(conflicts) != 0 {
+		t.Fatalf("conflicts %v", conflicts)
+	}
+	def, ok := commonRefDefs["Operation Result"]
+	if !ok || def.file != "qmi-common.go" {
+		t.Fatalf("Operation Result defined by %+v", def)
+	}
+	defer func() {
+		registryConflicts = nil
+		delete(commonRefDefs, "Test Ref")
+	}()
+
+	// the same hash again, from a file initialized later
+	useCommonRef("Operation Result", def.hash, "qmi-service-new.go")
+	if conflicts := RegistryConflicts(); len(conflicts) != 0 {
+		t.Fatalf("conflicts %v", conflicts)
+	}
+
+	useCommonRef("Operation Result", "0123456789abcdef", "qmi-service-old.go")
+	// a use initialized before the definition
+	useCommonRef("Test Ref", "0123456789abcdef", "qmi-service-first.go")
+	defineCommonRef("Test Ref", "fedcba9876543210", "qmi-common.go")
+
+	want := []RegistryConflict{
+		{Registry: "common-ref", CommonRef: "Operation Result", Previous: "qmi-common.go", Overrider: "qmi-service-old.go"},
+		{Registry: "common-ref", CommonRef: "Test Ref", Previous: "qmi-common.go", Overrider: "qmi-service-first.go"},
+	}
+	conflicts := RegistryConflicts()
+	if len(conflicts) != len(want) || conflicts[0] != want[0] || conflicts[1] != want[1] {
+		t.Fatalf("conflicts %+v\nwant %+v", conflicts, want)
+	}
+	msg := `qmi-service-old.go was generated with another definition of common-ref "Operation Result" than qmi-common.go, regenerate qmi-service-old.go`
+	if conflicts[0].String() != msg {
+		t.Errorf("conflict %q\nwant %q", conflicts[0], msg)
+	}
+
+	var errs ErrRegistryConflicts
+	if err := VerifyRegistry(WithRegistryOverrides()); !errors.As(err, &errs) || len(errs) != 2 {
+		t.Errorf("VerifyRegistry: %v, want ErrRegistryConflicts", err)
+	}
+	// no later definition makes the TLVs fit, overrides or not
+	for _, opts := range [][]Option{nil, {WithRegistryOverrides()}} {
+		_, f := newFakeModem(t, nil)
+		dev, err := NewDevice(f, "fake", opts...)
+		if !errors.As(err, &errs) || len(errs) != 2 {
+			t.Errorf("NewDevice with %d options: %v, want ErrRegistryConflicts", len(opts), err)
+		}
+		if dev != nil {
+			dev.Close()
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,174 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// wdsFrame returns the client and transaction ID of the last request id
+// of WDS the modem read
+func wdsFrame(m *fakeModem, id uint16) (uint8, uint16, bool) {
+	m.Lock()
+	defer m.Unlock()
+	for i := len(m.frames) - 1; i >= 0; i-- {
+		frame := m.frames[i]
+		if Service(frame[4]) == QMI_SERVICE_WDS && binary.LittleEndian.Uint16(frame[9:]) == id {
+			return frame[5], binary.LittleEndian.Uint16(frame[7:]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// uint32Field is a uint32 field of msg, 0 if left out
+func uint32Field(msg Message, name string) uint32 {
+	v, ok := messageField(msg, name)
+	if !ok {
+		return 0
+	}
+	return uint32(v.Uint())
+}
+
+// connectModem leaves Start Network unanswered. With allocated set, the
+// session comes up before Abort arrives: Start Network is answered with
+// handle 0x1234 and Abort fails.
+type connectModem struct {
+	*fakeModem
+	allocated bool
+	started   chan struct{}
+}
+
+func (m *connectModem) handle(req Message) Message {
+	switch req.(type) {
+	case *WDSStartNetworkInput:
+		close(m.started)
+	case *WDSAbortInput:
+		if !m.allocated {
+			return &WDSAbortOutput{}
+		}
+		cid, txid, _ := wdsFrame(m.fakeModem, 0x20)
+		start := &WDSStartNetworkOutput{}
+		setField(start, "PacketDataHandle", uint32(0x1234))
+		m.send(start, cid, txid, false)
+
+		resp := &WDSAbortOutput{}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = QMI_PROTOCOL_ERROR_INVALID_TRANSACTION_ID
+		return resp
+	case *WDSStopNetworkInput:
+		return &WDSStopNetworkOutput{}
+	}
+	return nil
+}
+
+// connectCancelled cancels Connect once the modem has Start Network
+func connectCancelled(t *testing.T, allocated bool) (*ConnectionManager, *fakeModem, error) {
+	m := &connectModem{allocated: allocated, started: make(chan struct{})}
+	dev, modem := openFake(t, m.handle, WithLogger(log.New(ioutil.Discard, "", 0)))
+	m.fakeModem = modem
+	cm, err := NewConnectionManager(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-m.started
+		cancel()
+	}()
+	_, err = cm.Connect(ctx, "internet")
+	return cm, modem, err
+}
+
+func TestConnectCancelledBeforeHandle(t *testing.T) {
+	cm, modem, err := connectCancelled(t, false)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Connect: %v, want context.Canceled", err)
+	}
+
+	_, start, _ := wdsFrame(modem, 0x20)
+	var aborted []uint32
+	for _, req := range modem.received(QMI_SERVICE_WDS) {
+		switch req.(type) {
+		case *WDSAbortInput:
+			aborted = append(aborted, uint32Field(req, "TransactionID"))
+		case *WDSStopNetworkInput:
+			t.Error("Stop Network of an aborted Start Network")
+		}
+	}
+	if !reflect.DeepEqual(aborted, []uint32{uint32(start)}) {
+		t.Errorf("aborted transactions %v, want %d", aborted, start)
+	}
+	if s := cm.Sessions(); len(s) != 0 {
+		t.Errorf("sessions %v left", s)
+	}
+}
+
+func TestConnectCancelledAfterHandle(t *testing.T) {
+	cm, modem, err := connectCancelled(t, true)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("Connect: %v, want context.Canceled", err)
+	}
+	var abortErr *ErrAbortFailed
+	if errors.As(err, &abortErr) {
+		t.Errorf("stopping the session failed: %v", abortErr.Abort)
+	}
+
+	var stopped []uint32
+	for _, req := range modem.received(QMI_SERVICE_WDS) {
+		if _, ok := req.(*WDSStopNetworkInput); ok {
+			stopped = append(stopped, uint32Field(req, "PacketDataHandle"))
+		}
+	}
+	if !reflect.DeepEqual(stopped, []uint32{0x1234}) {
+		t.Errorf("stopped %x, want the late handle 1234", stopped)
+	}
+	if s := cm.Sessions(); len(s) != 0 {
+		t.Errorf("sessions %v left", s)
+	}
+}
+
+func TestConnectDisconnect(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *WDSStartNetworkInput:
+			resp := &WDSStartNetworkOutput{}
+			setField(resp, "PacketDataHandle", uint32(0x1234))
+			return resp
+		case *WDSStopNetworkInput:
+			return &WDSStopNetworkOutput{}
+		}
+		return nil
+	})
+	cm, err := NewConnectionManager(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	handle, err := cm.Connect(ctx, "")
+	if err != nil || handle != 0x1234 {
+		t.Fatalf("Connect: %x, %v", handle, err)
+	}
+	if s := cm.Sessions(); !reflect.DeepEqual(s, []uint32{0x1234}) {
+		t.Errorf("sessions %x", s)
+	}
+	err = cm.Disconnect(ctx, handle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := cm.Sessions(); len(s) != 0 {
+		t.Errorf("sessions %x after Disconnect", s)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,87 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"io/ioutil"
+	"log"
+	"testing"
+)
+
+// TestDuplicateResponse has the modem repeat a response before and after
+// its transaction ID is reused, and once the window has passed
+func TestDuplicateResponse(t *testing.T) {
+	clock := fakeClock(t)
+	answer := make(chan string, 1)
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetManufacturerInput); ok {
+			return &DMSGetManufacturerOutput{Manufacturer: <-answer}
+		}
+		return nil
+	}, WithLogger(log.New(ioutil.Discard, "", 0)))
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer <- "ACME"
+	if _, err := dms.Send(&DMSGetManufacturerInput{}); err != nil {
+		t.Fatal(err)
+	}
+	repeat := func() {
+		modem.send(&DMSGetManufacturerOutput{Manufacturer: "ACME"}, dms.ClientID, 1, false)
+	}
+
+	// no Send waits for txid 1
+	repeat()
+	waitFor(t, "the duplicate dropped", func() bool { return dev.Stats().DuplicateResponses == 1 })
+
+	// a Send reusing txid 1 waits for its own response
+	dms.Lock()
+	dms.TransactionID = 0
+	dms.Unlock()
+	done := make(chan *DMSGetManufacturerOutput, 1)
+	go func() {
+		resp, err := dms.Send(&DMSGetManufacturerInput{})
+		if err != nil {
+			t.Error(err)
+		}
+		m, _ := resp.(*DMSGetManufacturerOutput)
+		done <- m
+	}()
+	waitFor(t, "the request", func() bool { return len(modem.received(QMI_SERVICE_DMS)) == 2 })
+	repeat()
+	waitFor(t, "the duplicate dropped", func() bool { return dev.Stats().DuplicateResponses == 2 })
+	answer <- "ACME Corp"
+	if resp := <-done; resp == nil || resp.Manufacturer != "ACME Corp" {
+		t.Errorf("reused txid got %+v", resp)
+	}
+
+	// past the window, a repeat answers no request
+	clock.advance(t, DEFAULT_DUPLICATE_WINDOW+1)
+	repeat()
+	waitFor(t, "the unknown response", func() bool { return dev.Stats().UnknownResponses == 1 })
+	if n := dev.Stats().DuplicateResponses; n != 2 {
+		t.Errorf("%d duplicates, want 2", n)
+	}
+}
+
+// TestDuplicateWindowOff passes repeated responses on as unknown ones
+func TestDuplicateWindowOff(t *testing.T) {
+	dev, modem := openFake(t, identityModem, WithDuplicateWindow(0), WithLogger(log.New(ioutil.Discard, "", 0)))
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := dms.SendContext(context.Background(), &DMSGetManufacturerInput{}); err != nil {
+		t.Fatal(err)
+	}
+	modem.send(&DMSGetManufacturerOutput{Manufacturer: "ACME"}, dms.ClientID, 1, false)
+	waitFor(t, "the unknown response", func() bool { return dev.Stats().UnknownResponses == 1 })
+	if n := dev.Stats().DuplicateResponses; n != 0 {
+		t.Errorf("%d duplicates with the window off", n)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,122 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// profileListCopy defines WDS Get Profile List again under an ID the
+// generated registry lacks
+const profileListCopy = `[
+  { "name" : "WDS", "type" : "Service" },
+  { "name" : "Get Profile List Copy", "type" : "Message", "service" : "WDS", "id" : "0x5A2A",
+    "input"  : [ { "name" : "Profile Type", "id" : "0x11", "type" : "TLV", "format" : "guint8" } ],
+    "output" : [ { "common-ref" : "Operation Result" },
+                 { "name" : "Profile List", "id" : "0x01", "type" : "TLV", "format" : "array",
+                   "array-element" : { "format" : "sequence",
+                                       "contents" : [ { "name" : "Profile Type", "format" : "guint8" },
+                                                      { "name" : "Profile Index", "format" : "guint8" },
+                                                      { "name" : "Profile Name", "format" : "string" } ] } } ] }
+]`
+
+// TestDynamicDecode decodes a frame of the TLVs of a generated Get Profile
+// List Output against the loaded copy
+func TestDynamicDecode(t *testing.T) {
+	dev, _ := openFake(t, nil)
+	err := dev.LoadDefinitions(strings.NewReader(profileListCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static := &WDSGetProfileListOutput{}
+	setField(static, "ProfileList", []struct {
+		ProfileType  uint8
+		ProfileIndex uint8
+		ProfileName  string
+	}{{0, 1, "internet"}, {1, 2, "ims"}})
+	var tlvs bytes.Buffer
+	err = static.TLVsWriteTo(&tlvs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := Marshal(&rawMessage{QMI_SERVICE_WDS, 0x5A2A, tlvs.Bytes()}, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var msg Message
+	_, err = dev.decode(frame.Bytes(), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dynamic, ok := msg.(*DynamicMessage)
+	if !ok {
+		t.Fatalf("decoded %T", msg)
+	}
+	want := []interface{}{
+		map[string]interface{}{"Profile Type": uint8(0), "Profile Index": uint8(1), "Profile Name": "internet"},
+		map[string]interface{}{"Profile Type": uint8(1), "Profile Index": uint8(2), "Profile Name": "ims"},
+	}
+	if got := dynamic.Fields["Profile List"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Profile List %#v\nwant %#v", got, want)
+	}
+
+	// written back, the TLV is the generated one
+	var written bytes.Buffer
+	err = dynamic.TLVsWriteTo(&written)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want_tlv := findTag(bytes.NewBuffer(append([]byte(nil), tlvs.Bytes()...)), 0x01)
+	got_tlv := findTag(&written, 0x01)
+	if got_tlv == nil || !bytes.Equal(got_tlv.Bytes(), want_tlv.Bytes()) {
+		t.Errorf("Profile List written as % x, want % x", got_tlv, want_tlv)
+	}
+}
+
+// TestDynamicRequest builds a request of the loaded copy and expects the
+// TLVs of the generated input
+func TestDynamicRequest(t *testing.T) {
+	dev, _ := openFake(t, nil)
+	err := dev.LoadDefinitions(strings.NewReader(profileListCopy))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := dev.NewDynamic("WDS Get Profile List Copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.ServiceID() != QMI_SERVICE_WDS || req.MessageID() != 0x5A2A {
+		t.Errorf("request of %v %#x", req.ServiceID(), req.MessageID())
+	}
+	req.Fields["Profile Type"] = uint8(1)
+	var got bytes.Buffer
+	err = req.TLVsWriteTo(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	static := &WDSGetProfileListInput{}
+	setField(static, "ProfileType", uint8(1))
+	var want bytes.Buffer
+	err = static.TLVsWriteTo(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Errorf("request TLVs % x, want % x", got.Bytes(), want.Bytes())
+	}
+
+	_, err = dev.NewDynamic("WDS Get Profile List")
+	if err == nil {
+		t.Error("request of a message never loaded")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,72 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// TestStringEncoding writes the strings of WDS Start Network in each of
+// their encodings and reads them back
+func TestStringEncoding(t *testing.T) {
+	msg := &WDSStartNetworkInput{}
+	setField(msg, "APN", "internet")
+	setField(msg, "Username", "mts")
+	setField(msg, "Password", "mts")
+
+	var buf bytes.Buffer
+	err := msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlvs := buf.Bytes()
+	for _, test := range []struct {
+		tag     uint8
+		payload string
+	}{
+		{0x14, "696e7465726e6574"}, // none
+		{0x17, "6d747300"},         // nul-terminated
+		{0x18, "03 6d7473"},        // prefixed
+	} {
+		want, _ := hex.DecodeString(stripSpaces(test.payload))
+		got := findTag(bytes.NewBuffer(tlvs), test.tag)
+		if got == nil || !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("TLV %#02x: % x, want % x", test.tag, got, want)
+		}
+	}
+
+	read := &WDSStartNetworkInput{}
+	err = read.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"APN", "Username", "Password"} {
+		got, _ := messageField(read, name)
+		want, _ := messageField(msg, name)
+		if got.String() != want.String() {
+			t.Errorf("%s read as %q, want %q", name, got, want)
+		}
+	}
+}
+
+// TestStringNulMissing reads a nul-terminated string spanning its TLV
+// whether or not the modem sent the NUL
+func TestStringNulMissing(t *testing.T) {
+	for _, payload := range []string{"6d747300", "6d7473"} {
+		tlvs, _ := hex.DecodeString(fmt.Sprintf("17%02x00%s", len(payload)/2, payload))
+		msg := &WDSStartNetworkInput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := messageField(msg, "Username"); got.String() != "mts" {
+			t.Errorf("% x read as %q", payload, got)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
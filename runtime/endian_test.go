@@ -0,0 +1,43 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestBigEndianPort expects the SIP server port, a big endian TLV, in
+// network byte order on the wire
+func TestBigEndianPort(t *testing.T) {
+	msg := &WDSGetCurrentSettingsOutput{}
+	setField(msg, "SIPServerPort", uint16(5060))
+	setField(msg, "MTU", uint32(1500))
+
+	var buf bytes.Buffer
+	err := msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlvs := buf.Bytes()
+	if port := findTag(bytes.NewBuffer(tlvs), 0x30); port == nil || !bytes.Equal(port.Bytes(), []byte{0x13, 0xc4}) {
+		t.Errorf("port written as % x, want 13 c4", port)
+	}
+	// the others stay little endian
+	if mtu := findTag(bytes.NewBuffer(tlvs), 0x29); mtu == nil || !bytes.Equal(mtu.Bytes(), []byte{0xdc, 0x05, 0x00, 0x00}) {
+		t.Errorf("MTU written as % x, want dc 05 00 00", mtu)
+	}
+
+	got := &WDSGetCurrentSettingsOutput{}
+	err = got.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, ok := messageField(got, "SIPServerPort")
+	if !ok || port.Uint() != 5060 {
+		t.Errorf("port read as %v", port)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
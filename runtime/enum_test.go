@@ -0,0 +1,125 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// enumFuncs are AllX and ParseX of an enum, as Stringers
+type enumFuncs struct {
+	all   func() []fmt.Stringer
+	parse func(string) (fmt.Stringer, error)
+}
+
+// enums lists every generated enum, TestEnumsListed keeps it complete
+var enums = map[string]enumFuncs{
+	"QMIDmsOperatingMode": {
+		func() []fmt.Stringer {
+			var all []fmt.Stringer
+			for _, v := range AllQMIDmsOperatingMode() {
+				all = append(all, v)
+			}
+			return all
+		},
+		func(s string) (fmt.Stringer, error) { return ParseQMIDmsOperatingMode(s) },
+	},
+	"QMIWdsConnectionStatus": {
+		func() []fmt.Stringer {
+			var all []fmt.Stringer
+			for _, v := range AllQMIWdsConnectionStatus() {
+				all = append(all, v)
+			}
+			return all
+		},
+		func(s string) (fmt.Stringer, error) { return ParseQMIWdsConnectionStatus(s) },
+	},
+}
+
+// TestEnumsListed finds the enums among the generated files: types with
+// both AllX and ParseX
+func TestEnumsListed(t *testing.T) {
+	files, err := filepath.Glob("qmi-*.go")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("generated files: %v, %v", files, err)
+	}
+	funcs := map[string]bool{}
+	for _, file := range files {
+		f, err := parser.ParseFile(token.NewFileSet(), file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, decl := range f.Decls {
+			if fun, ok := decl.(*ast.FuncDecl); ok && fun.Recv == nil {
+				funcs[fun.Name.Name] = true
+			}
+		}
+	}
+
+	var found []string
+	for name := range funcs {
+		if strings.HasPrefix(name, "Parse") && funcs["All"+strings.TrimPrefix(name, "Parse")] {
+			found = append(found, strings.TrimPrefix(name, "Parse"))
+		}
+	}
+	sort.Strings(found)
+	for _, name := range found {
+		if _, ok := enums[name]; !ok {
+			t.Errorf("enum %s missing in enums", name)
+		}
+	}
+	if len(found) != len(enums) {
+		t.Errorf("generated enums %v, listed %d", found, len(enums))
+	}
+}
+
+func TestEnumRoundTrip(t *testing.T) {
+	for name, enum := range enums {
+		all := enum.all()
+		if len(all) == 0 {
+			t.Errorf("%s: no values", name)
+		}
+		for _, v := range all {
+			s := v.String()
+			if strings.HasPrefix(s, name+"(") {
+				t.Errorf("%s: value %s has no name", name, s)
+			}
+			for _, spelling := range []string{s, strings.ToUpper(s), strings.ToLower(s)} {
+				got, err := enum.parse(spelling)
+				if err != nil || got != v {
+					t.Errorf("%s: Parse(%q) = %v, %v", name, spelling, got, err)
+				}
+			}
+		}
+	}
+}
+
+func TestEnumParseError(t *testing.T) {
+	_, err := ParseQMIWdsConnectionStatus("dormant")
+	var invalid *ErrInvalidEnum
+	if !errors.As(err, &invalid) {
+		t.Fatalf("err = %v, want *ErrInvalidEnum", err)
+	}
+	want := `invalid QMIWdsConnectionStatus "dormant", expected one of: Disconnected, Connected, Suspended, Authenticating`
+	if err.Error() != want {
+		t.Errorf("err = %q\nwant %q", err, want)
+	}
+
+	if s := QMIWdsConnectionStatus(9).String(); s != "QMIWdsConnectionStatus(9)" {
+		t.Errorf("unknown value renders %q", s)
+	}
+	if QMIWdsConnectionStatus(9).IsValid() || !QMIWdsConnectionStatusConnected.IsValid() {
+		t.Error("IsValid")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,107 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// failingModem fails PIN verification and network starts, with the TLVs
+// telling why
+func failingModem(req Message) Message {
+	switch req.(type) {
+	case *DMSUIMVerifyPINInput:
+		resp := &DMSUIMVerifyPINOutput{}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = 0x000C // INCORRECT_PIN
+		setField(resp, "PINRetriesStatus", struct {
+			VerifyRetriesLeft  uint8
+			UnblockRetriesLeft uint8
+		}{2, 10})
+		return resp
+	case *WDSStartNetworkInput:
+		resp := &WDSStartNetworkOutput{}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = 0x000E // CALL_FAILED
+		setField(resp, "CallEndReason", uint16(1))
+		setField(resp, "VerboseCallEndReason", struct {
+			Type   uint16
+			Reason uint16
+		}{3, 1011})
+		return resp
+	}
+	return nil
+}
+
+func verifyPIN(dev *Device) (Message, error) {
+	resp, err := dev.DMSUIMVerifyPIN(NewDMSUIMVerifyPINInput(struct {
+		PINID uint8
+		PIN   string
+	}{1, "0000"}))
+	if resp == nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+func startNetwork(dev *Device) (Message, error) {
+	resp, err := dev.WDSStartNetwork(WDSStartNetworkInput{})
+	if resp == nil {
+		return nil, err
+	}
+	return resp, err
+}
+
+func TestFailedResponses(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		send  func(*Device) (Message, error)
+		code  QMIError
+		field string
+		want  interface{}
+	}{{
+		"PIN", verifyPIN, 0x000C,
+		"PINRetriesStatus", struct {
+			VerifyRetriesLeft  uint8
+			UnblockRetriesLeft uint8
+		}{2, 10},
+	}, {
+		"call", startNetwork, 0x000E,
+		"VerboseCallEndReason", struct {
+			Type   uint16
+			Reason uint16
+		}{3, 1011},
+	}} {
+		dev, _ := openFake(t, failingModem)
+		resp, err := test.send(dev)
+		if !errors.Is(err, test.code) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.code)
+		}
+		if resp != nil {
+			t.Errorf("%s: response %v returned without WithFailedResponses", test.name, resp)
+		}
+
+		dev, _ = openFake(t, failingModem, WithFailedResponses())
+		resp, err = test.send(dev)
+		if !errors.Is(err, test.code) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.code)
+		}
+		if resp == nil {
+			t.Errorf("%s: no response with WithFailedResponses", test.name)
+			continue
+		}
+		got, ok := messageField(resp, test.field)
+		if !ok {
+			t.Errorf("%s: %s absent", test.name, test.field)
+			continue
+		}
+		if !reflect.DeepEqual(got.Interface(), test.want) {
+			t.Errorf("%s: %s = %+v, want %+v", test.name, test.field, got.Interface(), test.want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,110 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyModem leaves the first n requests for the manufacturer unanswered
+func flakyModem(n int32) func(req Message) Message {
+	var seen int32
+	return func(req Message) Message {
+		if _, ok := req.(*DMSGetManufacturerInput); !ok {
+			return nil
+		}
+		if atomic.AddInt32(&seen, 1) <= n {
+			return nil
+		}
+		return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+	}
+}
+
+// TestFieldsRetry retries a request until answered, the fields of the
+// context tag the log line and the error of the failed attempt
+func TestFieldsRetry(t *testing.T) {
+	var logged bytes.Buffer
+	dev, _ := openFake(t, flakyModem(1), WithLogger(log.New(&logged, "", 0)))
+
+	ctx := ContextWithFields(context.Background(), "tenant", "t1")
+	ctx = ContextWithFields(ctx, "modem", "m0")
+	var errs []error
+	var resp Message
+	for attempt := 0; attempt < 3 && resp == nil; attempt++ {
+		actx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		var err error
+		resp, err = dev.SendContext(actx, &DMSGetManufacturerInput{})
+		cancel()
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if resp == nil || len(errs) != 1 {
+		t.Fatalf("response %v after errors %v", resp, errs)
+	}
+
+	var tagged *ErrWithFields
+	if !errors.As(errs[0], &tagged) || !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Fatalf("err = %#v", errs[0])
+	}
+	if want := []interface{}{"tenant", "t1", "modem", "m0"}; !reflect.DeepEqual(tagged.Fields, want) {
+		t.Errorf("fields %v, want %v", tagged.Fields, want)
+	}
+	if !strings.HasSuffix(errs[0].Error(), " [tenant=t1 modem=m0]") {
+		t.Errorf("err = %q", errs[0])
+	}
+	want := "dev fake: abandoned *qmi.DMSGetManufacturerInput txid 1: context deadline exceeded [tenant=t1 modem=m0]\n"
+	if logged.String() != want {
+		t.Errorf("logged %q\nwant %q", logged.String(), want)
+	}
+}
+
+// TestFieldsGather expects the errors of Gather items tagged too
+func TestFieldsGather(t *testing.T) {
+	dev, _ := openFake(t, flakyModem(1), WithLogger(log.New(ioutil.Discard, "", 0)))
+	ctx, cancel := context.WithTimeout(ContextWithFields(context.Background(), "tenant", "t1"), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := Gather(ctx, dev, GatherItem{Message: &DMSGetManufacturerInput{}})
+	var errs ErrGather
+	if !errors.As(err, &errs) {
+		t.Fatalf("err = %v", err)
+	}
+	if !strings.HasSuffix(errs[0].Error(), " [tenant=t1]") {
+		t.Errorf("item error %q", errs[0])
+	}
+}
+
+func TestFieldsNone(t *testing.T) {
+	var logged bytes.Buffer
+	dev, _ := openFake(t, nil, WithLogger(log.New(&logged, "", 0)))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := dev.SendContext(ctx, &DMSGetManufacturerInput{})
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %#v, want context.DeadlineExceeded as it is", err)
+	}
+	if strings.Contains(logged.String(), "[") {
+		t.Errorf("logged %q", logged.String())
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		FieldsFromContext(ctx)
+	})
+	if allocs != 0 {
+		t.Errorf("%v allocations without fields", allocs)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,68 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestFlagsString expects the names of the data file
+func TestFlagsString(t *testing.T) {
+	for _, test := range []struct {
+		v    QMIDmsBandCapability
+		want string
+	}{
+		{0, "0"},
+		{QMIDmsBandCapabilityGSMDcs1800, "Gsm Dcs 1800"},
+		{QMIDmsBandCapabilityGSMDcs1800 | QMIDmsBandCapabilityWCDMA2100, "Gsm Dcs 1800|Wcdma 2100"},
+		{QMIDmsBandCapabilityGSM900Extended | 0x1, "Gsm 900 Extended|0x1"},
+	} {
+		if s := test.v.String(); s != test.want {
+			t.Errorf("%#x: %q, want %q", uint64(test.v), s, test.want)
+		}
+	}
+}
+
+func TestFlagsHas(t *testing.T) {
+	v := QMIDmsBandCapabilityGSMDcs1800 | QMIDmsBandCapabilityWCDMA2100
+	for flag, want := range map[QMIDmsBandCapability]bool{
+		QMIDmsBandCapabilityGSMDcs1800:     true,
+		QMIDmsBandCapabilityGSM900Extended: false,
+		QMIDmsBandCapabilityWCDMA2100:      true,
+	} {
+		if v.Has(flag) != want {
+			t.Errorf("Has(%s) = %v", flag, !want)
+		}
+	}
+	if !v.IsValid() || (v | 0x1).IsValid() {
+		t.Error("IsValid does not tell unknown bits")
+	}
+}
+
+// TestFlagsTLV reads the band capability TLV as the guint64 of its format
+func TestFlagsTLV(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("01 0800 8000400000000000 02 0400 0000 0000"))
+	msg := &DMSGetBandCapabilitiesOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := QMIDmsBandCapabilityGSMDcs1800 | QMIDmsBandCapabilityWCDMA2100
+	if msg.BandCapability != want {
+		t.Errorf("read %s, want %s", msg.BandCapability, want)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), tlvs) {
+		t.Errorf("written as % x, want % x", buf.Bytes(), tlvs)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
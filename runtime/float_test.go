@@ -0,0 +1,49 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestFloatTLVs writes gdouble and gfloat TLVs as IEEE-754 little endian
+// and reads them back
+func TestFloatTLVs(t *testing.T) {
+	ind := &LOCPositionReportIndication{}
+	setField(ind, "Latitude", 55.75)
+	setField(ind, "Longitude", -37.625)
+	setField(ind, "HorizontalUncertaintyCircular", float32(12.5))
+
+	var buf bytes.Buffer
+	err := ind.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 55.75 is 0x404be00000000000, -37.625 0xc042d00000000000 and 12.5
+	// 0x41480000
+	want, _ := hex.DecodeString(stripSpaces("10 0800 0000 0000 00e0 4b40" +
+		" 11 0800 0000 0000 00d0 42c0 12 0400 0000 4841"))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("\n got % x\nwant % x", buf.Bytes(), want)
+	}
+
+	read := &LOCPositionReportIndication{}
+	err = read.TLVsReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, v := range map[string]interface{}{
+		"Latitude":                      55.75,
+		"Longitude":                     -37.625,
+		"HorizontalUncertaintyCircular": float32(12.5),
+	} {
+		if got := indirect(read, name); got != v {
+			t.Errorf("%s = %v, want %v", name, got, v)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
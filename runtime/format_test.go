@@ -0,0 +1,57 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import "testing"
+
+// TestMessageString formats a hand-built DMS Get IDs response: the type,
+// service and ID, then a field per line with the embedded Operation Result
+// indented, the IMEI masked, and the MEID the modem left out absent
+func TestMessageString(t *testing.T) {
+	msg := &DMSGetIDsOutput{}
+	msg.QMIStructOperationResult = QMIStructOperationResult{ErrorStatus: 1, ErrorCode: 0x1a}
+	setField(msg, "Esn", "80abcdef")
+	setField(msg, "IMEI", "350000000000001")
+
+	want := "DMSGetIDsOutput (Service QMI_SERVICE_DMS, message 0x0025)\n" +
+		"  QMIStructOperationResult:\n" +
+		"    ErrorStatus: 1\n" +
+		"    ErrorCode: 26\n" +
+		"  Esn: 80abcdef\n" +
+		"  IMEI: ***0001\n" +
+		"  Meid: (absent)"
+	if got := msg.String(); got != want {
+		t.Errorf("String()\n%s\nwant\n%s", got, want)
+	}
+
+	if got, want := (&DMSGetIDsInput{}).String(), "DMSGetIDsInput (Service QMI_SERVICE_DMS, message 0x0025)"; got != want {
+		t.Errorf("String() of the input %q, want %q", got, want)
+	}
+
+	// records of an array indent under their index
+	versions := &CTLGetVersionInfoOutput{}
+	setField(versions, "ServiceList", []struct {
+		Service      uint8
+		MajorVersion uint16
+		MinorVersion uint16
+	}{{0, 1, 5}, {2, 1, 14}})
+	want = "CTLGetVersionInfoOutput (Service QMI_SERVICE_CTL, message 0x0021)\n" +
+		"  QMIStructOperationResult:\n" +
+		"    ErrorStatus: 0\n" +
+		"    ErrorCode: 0\n" +
+		"  ServiceList:\n" +
+		"    [0]:\n" +
+		"      Service: 0\n" +
+		"      MajorVersion: 1\n" +
+		"      MinorVersion: 5\n" +
+		"    [1]:\n" +
+		"      Service: 2\n" +
+		"      MajorVersion: 1\n" +
+		"      MinorVersion: 14"
+	if got := versions.String(); got != want {
+		t.Errorf("String()\n%s\nwant\n%s", got, want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
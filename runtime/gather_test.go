@@ -0,0 +1,112 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestGatherConcurrent expects requests to different services in flight
+// at once and those to one service one after another
+func TestGatherConcurrent(t *testing.T) {
+	dev, modem := openFake(t, nil)
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nas, err := dev.GetService(QMI_SERVICE_NAS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		resps []Message
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		resps, err := Gather(context.Background(), dev,
+			GatherItem{Message: &DMSGetManufacturerInput{}},
+			GatherItem{Message: &NASGetServingSystemInput{}},
+			GatherItem{Name: "DMSGetModel"},
+		)
+		done <- result{resps, err}
+	}()
+
+	// nothing is answered yet: NAS does not wait for DMS
+	waitFor(t, "DMS and NAS requests", func() bool {
+		return len(modem.received(QMI_SERVICE_DMS)) == 1 && len(modem.received(QMI_SERVICE_NAS)) == 1
+	})
+	time.Sleep(20 * time.Millisecond)
+	if n := len(modem.received(QMI_SERVICE_DMS)); n != 1 {
+		t.Fatalf("%d DMS requests in flight at once", n)
+	}
+
+	modem.send(&NASGetServingSystemOutput{}, nas.ClientID, 1, false)
+	modem.send(&DMSGetManufacturerOutput{Manufacturer: "ACME"}, dms.ClientID, 1, false)
+	waitFor(t, "second DMS request", func() bool { return len(modem.received(QMI_SERVICE_DMS)) == 2 })
+	modem.send(&DMSGetModelOutput{Model: "M1"}, dms.ClientID, 2, false)
+
+	select {
+	case r := <-done:
+		if r.err != nil {
+			t.Fatal(r.err)
+		}
+		if len(r.resps) != 3 {
+			t.Fatalf("%d responses", len(r.resps))
+		}
+		if m, ok := r.resps[0].(*DMSGetManufacturerOutput); !ok || m.Manufacturer != "ACME" {
+			t.Errorf("#0: %v", r.resps[0])
+		}
+		if _, ok := r.resps[1].(*NASGetServingSystemOutput); !ok {
+			t.Errorf("#1: %v", r.resps[1])
+		}
+		if m, ok := r.resps[2].(*DMSGetModelOutput); !ok || m.Model != "M1" {
+			t.Errorf("#2: %v", r.resps[2])
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Gather did not return")
+	}
+}
+
+// TestGatherPartial expects the errors of failed items by index and the
+// responses of the others
+func TestGatherPartial(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *DMSGetManufacturerInput:
+			return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+		case *DMSGetModelInput:
+			resp := &DMSGetModelOutput{}
+			resp.ErrorStatus = 1
+			resp.ErrorCode = 0x0003
+			return resp
+		}
+		return nil
+	})
+
+	resps, err := Gather(context.Background(), dev,
+		GatherItem{Message: &DMSGetModelInput{}},
+		GatherItem{Message: &DMSGetManufacturerInput{}},
+		GatherItem{Name: "DMSGetNothing"},
+	)
+	var errs ErrGather
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("err = %v, want ErrGather of 3", err)
+	}
+	if errs[0] != QMIError(3) || errs[1] != nil || errs[2] == nil {
+		t.Errorf("errors %v", []error(errs))
+	}
+	if m, ok := resps[1].(*DMSGetManufacturerOutput); !ok || m.Manufacturer != "ACME" {
+		t.Errorf("#1: %v", resps[1])
+	}
+	if resps[0] != nil || resps[2] != nil {
+		t.Errorf("responses of failed items: %v", resps)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
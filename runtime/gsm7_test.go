@@ -0,0 +1,81 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestGSM7 decodes a field of the GSM default alphabet, the extension
+// table included, and writes it back
+func TestGSM7(t *testing.T) {
+	// @ £ $ € A, the euro sign escaped
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 13 0600 00 01 02 1b65 41"))
+	msg := &NASGetOperatorNameOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := indirect(msg, "OperatorStringName"); got != "@£$€A" {
+		t.Errorf("decoded %q", got)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findTag(&buf, 0x13); got == nil || !bytes.Equal(got.Bytes(), tlvs[10:]) {
+		t.Errorf("written %v, want % x", got, tlvs[10:])
+	}
+}
+
+func TestGSM7Alphabet(t *testing.T) {
+	for _, test := range []struct {
+		septets, utf8 string
+	}{
+		{"Hello", "Hello"},
+		{"\x00\x11\x1b\x3c\x1b\x3e", "@_[]"},
+		// unknown extensions read as the default character, a dangling
+		// escape is dropped
+		{"\x1b\x41\x1b", "A"},
+		// not septets, left to the string policy
+		{"A\xff", "A\xff"},
+	} {
+		if got := decodeGSM7(test.septets); got != test.utf8 {
+			t.Errorf("decodeGSM7(%q) = %q, want %q", test.septets, got, test.utf8)
+		}
+	}
+
+	if got := encodeGSM7("{€}ж"); got != "\x1b\x28\x1b\x65\x1b\x29?" {
+		t.Errorf("encodeGSM7 = %q", got)
+	}
+}
+
+// TestStringPolicies runs each policy on valid strings and invalid ones
+func TestStringPolicies(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		policy func(string) (string, error)
+		in     string
+		want   string
+		err    error
+	}{
+		{"replace", replaceInvalid, "MTS\xff\xfeRUS", "MTS�RUS", nil},
+		{"replace", replaceInvalid, "Билайн", "Билайн", nil},
+		{"escape", escapeInvalid, "MTS\xff\xfeRUS", `MTS\xff\xfeRUS`, nil},
+		{"escape", escapeInvalid, "Билайн", "Билайн", nil},
+		{"strict", rejectInvalid, "MTS\xffRUS", "", ErrInvalidUTF8(3)},
+		{"strict", rejectInvalid, "Билайн", "Билайн", nil},
+	} {
+		got, err := test.policy(test.in)
+		if got != test.want || err != test.err {
+			t.Errorf("%s(%q) = %q, %v, want %q, %v", test.name, test.in, got, err, test.want, test.err)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
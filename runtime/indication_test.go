@@ -0,0 +1,159 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"encoding/hex"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// TestIndicationResult sends an indication with a failed result TLV to a
+// client awaiting a response: subscribers get it as decoded, the request
+// does not take it for its response
+func TestIndicationResult(t *testing.T) {
+	dev, modem := openFake(t, nil)
+	uim, err := dev.GetService(QMI_SERVICE_UIM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inds, cancel := dev.Subscribe(QMI_SERVICE_UIM, 0x0020)
+	defer cancel()
+
+	ctx, cancel_send := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel_send()
+	done := make(chan error, 1)
+	go func() {
+		_, err := uim.SendContext(ctx, &UIMReadTransparentInput{})
+		done <- err
+	}()
+	waitFor(t, "Read Transparent", func() bool { return len(modem.received(QMI_SERVICE_UIM)) == 1 })
+
+	ind := &UIMReadTransparentIndication{}
+	ind.ErrorStatus = 1
+	ind.ErrorCode = 0x0010 // NOT_PROVISIONED
+	setField(ind, "ResponseInIndication", uint32(0x55))
+	setField(ind, "ReadResult", []uint8{0x6a, 0x82})
+	modem.send(ind, uim.ClientID, 0, true)
+
+	select {
+	case msg := <-inds:
+		got, ok := msg.(*UIMReadTransparentIndication)
+		if !ok {
+			t.Fatalf("delivered %T", msg)
+		}
+		if got.ErrorStatus != 1 || got.ErrorCode != 0x0010 {
+			t.Errorf("result %+v, want the failure as sent", got.QMIStructOperationResult)
+		}
+		result, _ := messageField(got, "ReadResult")
+		if !reflect.DeepEqual(result.Interface(), []uint8{0x6a, 0x82}) {
+			t.Errorf("read result %v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("indication not delivered")
+	}
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("request ended with %v, want context.DeadlineExceeded", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SendContext did not return")
+	}
+}
+
+// TestPacketServiceStatusIndication decodes a WDS Packet Service Status
+// indication captured from a modem, not the response sharing its ID
+func TestPacketServiceStatusIndication(t *testing.T) {
+	// client 5, connected and to be reconfigured
+	frame, _ := hex.DecodeString(stripSpaces("01 1100 80 01 05 04 0000 2200 0500 01 0200 02 01"))
+
+	var msg Message
+	cid, err := Unmarshal(frame, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != 0x05 {
+		t.Errorf("cid = %#x, want 5", cid)
+	}
+	ind, ok := msg.(*WDSPacketServiceStatusIndication)
+	if !ok {
+		t.Fatalf("decoded %T", msg)
+	}
+	if ind.ServiceID() != QMI_SERVICE_WDS || ind.IndicationID() != 0x0022 {
+		t.Errorf("service %s, indication %#04x", ind.ServiceID(), ind.IndicationID())
+	}
+	if ind.ConnectionStatus.Status != 2 || !ind.ConnectionStatus.ReconfigurationRequired {
+		t.Errorf("connection status %+v", ind.ConnectionStatus)
+	}
+}
+
+// TestIndicationIDs names an indication by its constant without decoding it
+func TestIndicationIDs(t *testing.T) {
+	ind := &WDSPacketServiceStatusIndication{}
+	if ind.IndicationID() != QMI_INDICATION_WDS_PACKET_SERVICE_STATUS {
+		t.Errorf("indication ID %#04x", ind.IndicationID())
+	}
+	if name := WDSIndicationMap[QMI_INDICATION_WDS_PACKET_SERVICE_STATUS]; name != "Packet Service Status" {
+		t.Errorf("name %q", name)
+	}
+}
+
+// TestEventReportIndication decodes a WDS Event Report of optional
+// scalars, a common-ref, a struct and an array of structs, all of them or
+// the first alone
+func TestEventReportIndication(t *testing.T) {
+	type rate = struct {
+		TxRateBps uint32
+		RxRateBps uint32
+	}
+	type bearers = []struct {
+		RadioAccessTechnology uint8
+		BearerTechnology      uint32
+	}
+	full, _ := hex.DecodeString(stripSpaces("01 3e00 80 01 05 04 0000 0100 3200" +
+		" 10 0400 e8030000 11 0400 d0070000 12 0800 39300000 0100 0500" +
+		" 16 0800 00e1f505 00c2eb0b 2a 0b00 02 08 0b000000 09 0c000000"))
+	var msg Message
+	if _, err := Unmarshal(full, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*WDSEventReportIndication); !ok {
+		t.Fatalf("decoded %T", msg)
+	}
+	for _, test := range []struct {
+		field string
+		want  interface{}
+	}{
+		{"TxPacketsOk", uint32(1000)},
+		{"RxPacketsOk", uint32(2000)},
+		{"CellID", uint32(12345)},
+		{"TAC", uint16(1)},
+		{"TimingAdvance", uint16(5)},
+		{"ChannelRate", rate{100000000, 200000000}},
+		{"ExtendedDataBearerTechnologies", bearers{{8, 11}, {9, 12}}},
+	} {
+		if got := indirect(msg, test.field); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s = %v, want %v", test.field, got, test.want)
+		}
+	}
+
+	partial, _ := hex.DecodeString(stripSpaces("01 1300 80 01 05 04 0000 0100 0700 10 0400 e8030000"))
+	if _, err := Unmarshal(partial, &msg); err != nil {
+		t.Fatal(err)
+	}
+	if got := indirect(msg, "TxPacketsOk"); got != uint32(1000) {
+		t.Errorf("TxPacketsOk = %v", got)
+	}
+	for _, field := range []string{"RxPacketsOk", "ChannelRate", "ExtendedDataBearerTechnologies"} {
+		if got := indirect(msg, field); got != nil && !reflect.ValueOf(got).IsZero() {
+			t.Errorf("%s = %v, want it absent", field, got)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,158 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// inflightOutputs answers the requests the WithMaxInFlight tests leave to
+// the test
+var inflightOutputs = map[Service]func() Message{
+	QMI_SERVICE_NAS: func() Message { return &NASGetOperatorNameOutput{} },
+	QMI_SERVICE_DMS: func() Message { return &DMSGetManufacturerOutput{} },
+	QMI_SERVICE_WDS: func() Message { return &WDSGetPacketServiceStatusOutput{} },
+}
+
+// held returns the services of the requests the modem read but CTL, in
+// order
+func (m *fakeModem) held() []Service {
+	m.Lock()
+	defer m.Unlock()
+	var svcs []Service
+	for _, req := range m.requests {
+		if req.ServiceID() != QMI_SERVICE_CTL {
+			svcs = append(svcs, req.ServiceID())
+		}
+	}
+	return svcs
+}
+
+// answerLast answers the last request the modem read but CTL
+func (m *fakeModem) answerLast(t *testing.T) {
+	t.Helper()
+	m.Lock()
+	var frame []byte
+	for _, f := range m.frames {
+		if Service(f[4]) != QMI_SERVICE_CTL {
+			frame = f
+		}
+	}
+	m.Unlock()
+	svc, cid, _, _, err := parseFrame(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.send(inflightOutputs[svc](), uint8(cid), uint16(cid>>8), false)
+}
+
+// queued waits until n sends to svc are queued for a slot
+func queued(t *testing.T, dev *Device, svc Service, n int) {
+	t.Helper()
+	waitFor(t, svc.String()+" sends queued", func() bool {
+		return dev.Stats().Queued[svc] == n
+	})
+}
+
+// TestMaxInFlightFairness keeps a slot busy while NAS queues three sends
+// and DMS and WDS one each a second later: slots go round-robin across
+// services, not in queueing order, and the time queued is taken from the
+// clock of the runtime
+func TestMaxInFlightFairness(t *testing.T) {
+	clk := fakeClock(t)
+	dev, modem := openFake(t, nil, WithMaxInFlight(1))
+	ctx := context.Background()
+
+	var done []<-chan error
+	done = append(done, sendAsync(ctx, dev, &NASGetOperatorNameInput{}))
+	waitFor(t, "first request", func() bool { return len(modem.held()) == 1 })
+	for i := 1; i <= 3; i++ {
+		done = append(done, sendAsync(ctx, dev, &NASGetOperatorNameInput{}))
+		queued(t, dev, QMI_SERVICE_NAS, i)
+	}
+	clk.advance(t, time.Second)
+	done = append(done, sendAsync(ctx, dev, &DMSGetManufacturerInput{}))
+	queued(t, dev, QMI_SERVICE_DMS, 1)
+	done = append(done, sendAsync(ctx, dev, &WDSGetPacketServiceStatusInput{}))
+	queued(t, dev, QMI_SERVICE_WDS, 1)
+
+	stats := dev.Stats()
+	if stats.InFlight != 1 || len(modem.held()) != 1 {
+		t.Fatalf("%d in flight, modem read %d requests", stats.InFlight, len(modem.held()))
+	}
+
+	for n := 2; n <= 6; n++ {
+		clk.advance(t, time.Second)
+		modem.answerLast(t)
+		waitFor(t, "next request", func() bool { return len(modem.held()) == n })
+	}
+	clk.advance(t, time.Second)
+	modem.answerLast(t)
+	for _, d := range done {
+		returned(t, "send", d, nil)
+	}
+
+	want := []Service{QMI_SERVICE_NAS, QMI_SERVICE_NAS, QMI_SERVICE_DMS, QMI_SERVICE_WDS, QMI_SERVICE_NAS, QMI_SERVICE_NAS}
+	got := modem.held()
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("admitted %v, want %v", got, want)
+		}
+	}
+
+	// queued at 0s (NAS) and 1s, admitted a second apart from 2s:
+	// NAS 2s, DMS 2s, WDS 3s, NAS 5s and 6s
+	stats = dev.Stats()
+	if stats.Admitted != 5 || stats.AdmissionWait != 18*time.Second {
+		t.Errorf("admitted %d after %s", stats.Admitted, stats.AdmissionWait)
+	}
+	if stats.InFlight != 0 || len(stats.Queued) != 0 {
+		t.Errorf("%d in flight, queued %v", stats.InFlight, stats.Queued)
+	}
+}
+
+// TestMaxInFlightCancel expects a send whose context ends while queued to
+// leave the queue, the slot going to the next one, and CTL never to queue
+func TestMaxInFlightCancel(t *testing.T) {
+	fakeClock(t)
+	dev, modem := openFake(t, nil, WithMaxInFlight(1))
+
+	hold := sendAsync(context.Background(), dev, &NASGetOperatorNameInput{})
+	waitFor(t, "first request", func() bool { return len(modem.held()) == 1 })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := sendAsync(ctx, dev, &DMSGetManufacturerInput{})
+	queued(t, dev, QMI_SERVICE_DMS, 1)
+	next := sendAsync(context.Background(), dev, &WDSGetPacketServiceStatusInput{})
+	queued(t, dev, QMI_SERVICE_WDS, 1)
+
+	// allocating the client IDs above went through CTL with the slot taken
+	_, err := dev.SendContext(context.Background(), &CTLSyncInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	returned(t, "cancelled send", cancelled, context.Canceled)
+	queued(t, dev, QMI_SERVICE_DMS, 0)
+	pending(t, "queued send", next)
+
+	modem.answerLast(t)
+	returned(t, "first send", hold, nil)
+	waitFor(t, "WDS request", func() bool { return len(modem.held()) == 2 })
+	modem.answerLast(t)
+	returned(t, "queued send", next, nil)
+
+	if got := modem.held(); got[1] != QMI_SERVICE_WDS {
+		t.Errorf("admitted %v", got)
+	}
+	stats := dev.Stats()
+	if stats.Admitted != 1 || stats.InFlight != 0 || len(stats.Queued) != 0 {
+		t.Errorf("admitted %d, %d in flight, queued %v", stats.Admitted, stats.InFlight, stats.Queued)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
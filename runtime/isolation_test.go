@@ -0,0 +1,104 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolationModem answers Get Manufacturer with a duplicate TLV, Get
+// Operator Name, and Get IDs once release is closed, if not nil
+func isolationModem(release chan struct{}) func(req Message) Message {
+	manufacturer, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 01 0400 41434d45 01 0400 41434d45"))
+	return func(req Message) Message {
+		switch req.(type) {
+		case *DMSGetManufacturerInput:
+			return &rawMessage{QMI_SERVICE_DMS, 0x21, manufacturer}
+		case *NASGetOperatorNameInput:
+			return &NASGetOperatorNameOutput{}
+		case *DMSGetIDsInput:
+			if release == nil {
+				return nil
+			}
+			<-release
+			return identityModem(req)
+		}
+		return nil
+	}
+}
+
+// TestDeviceIsolation opens two devices with different options and
+// expects none of them to reach the other, nor a close of one to disturb
+// a transaction of the other
+func TestDeviceIsolation(t *testing.T) {
+	var logA, logB bytes.Buffer
+	a, _ := openFake(t, isolationModem(nil),
+		WithLogger(log.New(&logA, "", 0)),
+		WithStrictTLVs(),
+		WithRateLimit(QMI_SERVICE_NAS, Rate{Interval: time.Hour, Burst: 1}),
+		WithMessageTimeout(QMI_SERVICE_DMS, 0x25, 30*time.Millisecond))
+	release := make(chan struct{})
+	b, modemB := openFake(t, isolationModem(release), WithLogger(log.New(&logB, "", 0)))
+
+	// strict TLVs and the duplicates counted
+	if _, err := a.Send(&DMSGetManufacturerInput{}); err == nil {
+		t.Error("strict device accepted a duplicate TLV")
+	}
+	if _, err := b.Send(&DMSGetManufacturerInput{}); err != nil {
+		t.Errorf("lenient device: %s", err)
+	}
+	if na, nb := a.Stats().DuplicateTLVs, b.Stats().DuplicateTLVs; na != 0 || nb != 1 {
+		t.Errorf("duplicate TLVs %d and %d, want 0 and 1", na, nb)
+	}
+
+	// rate limits
+	for i := 0; i < 3; i++ {
+		if _, err := b.Send(&NASGetOperatorNameInput{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err := a.SendContext(ctx, &NASGetOperatorNameInput{}); err != nil {
+		t.Fatal(err)
+	}
+	pending(t, "rate limited request", sendAsync(ctx, a, &NASGetOperatorNameInput{}))
+	cancel()
+
+	// message timeouts and loggers: b waits for Get IDs past the timeout
+	// of a
+	done := sendAsync(context.Background(), b, &DMSGetIDsInput{})
+	waitFor(t, "Get IDs", func() bool { return len(modemB.received(QMI_SERVICE_DMS)) == 2 })
+	if _, err := a.Send(&DMSGetIDsInput{}); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	pending(t, "Get IDs", done)
+	if !strings.Contains(logA.String(), "abandoned *qmi.DMSGetIDsInput") {
+		t.Errorf("log of a:\n%s", logA.String())
+	}
+
+	// closing a leaves the transaction of b be
+	a.Close()
+	pending(t, "Get IDs", done)
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Get IDs: %s", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get IDs did not return")
+	}
+	if strings.Contains(logB.String(), "abandoned") {
+		t.Errorf("log of b:\n%s", logB.String())
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,70 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestLatencySplit holds a response in the modem, then in the reader, on
+// a fake clock: the modem and dispatch latencies must add up to the time
+// Send took
+func TestLatencySplit(t *testing.T) {
+	clock := fakeClock(t)
+	release := make(chan struct{})
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetIDsInput); ok {
+			<-release
+		}
+		return identityModem(req)
+	}, WithFrameTap(func(direction Direction, frame []byte) {
+		// the frame is read, not yet handed to Send
+		if direction == DirectionResponse && isGetIDs(frame) {
+			clock.advance(t, 200*time.Millisecond)
+		}
+	}))
+	if _, err := dev.GetService(QMI_SERVICE_DMS); err != nil {
+		t.Fatal(err)
+	}
+
+	before := dev.Stats()
+	start := clock.Now()
+	done := sendAsync(context.Background(), dev, &DMSGetIDsInput{})
+	waitFor(t, "Get IDs", func() bool { return len(modem.received(QMI_SERVICE_DMS)) == 1 })
+	pending(t, "Get IDs", done)
+	clock.advance(t, 300*time.Millisecond)
+	close(release)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get IDs did not return")
+	}
+	total := clock.Now().Sub(start)
+
+	after := dev.Stats()
+	if n := after.Responses - before.Responses; n != 1 {
+		t.Fatalf("%d responses measured", n)
+	}
+	modem_latency := after.ModemLatency - before.ModemLatency
+	dispatch_latency := after.DispatchLatency - before.DispatchLatency
+	if modem_latency != 300*time.Millisecond || dispatch_latency != 200*time.Millisecond {
+		t.Errorf("modem %v, dispatch %v, want 300ms and 200ms", modem_latency, dispatch_latency)
+	}
+	if modem_latency+dispatch_latency != total {
+		t.Errorf("modem %v and dispatch %v, total %v", modem_latency, dispatch_latency, total)
+	}
+}
+
+// isGetIDs tells a DMS Get IDs frame
+func isGetIDs(frame []byte) bool {
+	svc, _, msgid, _, err := parseFrame(frame)
+	return err == nil && svc == QMI_SERVICE_DMS && msgid == 0x25
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
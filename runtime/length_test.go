@@ -0,0 +1,70 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"testing"
+)
+
+// TestTLVLengths writes requests of fixed-size TLVs and compares them with
+// hand-computed lengths: the payload alone, no framing
+func TestTLVLengths(t *testing.T) {
+	mode := NewDMSSetOperatingModeInput(3)
+
+	register := NewNASInitiateNetworkRegisterInput(1)
+	register.QMIStructNetworkDescriptor = QMIStructNetworkDescriptor{MCC: 250, MNC: 99, RadioAccessTechnology: 8}
+
+	exact := NewDMSSetTimeInput([]byte{1, 2, 3, 4, 5, 6})
+	setField(&exact, "TimeReferenceType", uint32(1))
+	short := NewDMSSetTimeInput([]byte{1, 2})
+	setField(&short, "TimeReferenceType", uint32(0))
+	long := NewDMSSetTimeInput([]byte{1, 2, 3, 4, 5, 6, 7, 8})
+	setField(&long, "TimeReferenceType", uint32(0))
+
+	for _, test := range []struct {
+		name string
+		msg  Message
+		tlvs string
+	}{
+		// a guint8
+		{"scalar", &mode, "01 0100 03"},
+		// two guint16 and a guint8
+		{"struct", &register, "01 0100 01 10 0500 fa00 6300 08"},
+		// 6 bytes, then a guint32
+		{"guint-sized", &exact, "01 0600 010203040506 10 0400 01000000"},
+		{"guint-sized padded", &short, "01 0600 010200000000 10 0400 00000000"},
+		{"guint-sized truncated", &long, "01 0600 010203040506 10 0400 00000000"},
+	} {
+		want, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		var buf bytes.Buffer
+		err := test.msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s:\n got % x\nwant % x", test.name, buf.Bytes(), want)
+		}
+
+		// the message length of the frame counts the TLVs, the QMUX
+		// length the whole frame but the marker
+		frame, err := Marshal(test.msg, 1, 2, 0)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		b := frame.Bytes()
+		if n := int(binary.LittleEndian.Uint16(b[1:])); n != len(b)-1 {
+			t.Errorf("%s: QMUX length %d, want %d", test.name, n, len(b)-1)
+		}
+		if n := int(binary.LittleEndian.Uint16(b[11:])); n != len(want) {
+			t.Errorf("%s: message length %d, want %d", test.name, n, len(want))
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
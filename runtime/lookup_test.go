@@ -0,0 +1,86 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"reflect"
+	"testing"
+)
+
+// TestLookupMessage looks up messages of the generated package by their
+// wire identifiers, in every direction
+func TestLookupMessage(t *testing.T) {
+	info, ok := LookupMessage(QMI_SERVICE_DMS, 0x0025, DirectionResponse)
+	if !ok {
+		t.Fatal("no DMS Get IDs response")
+	}
+	want := MessageInfo{
+		Service:   QMI_SERVICE_DMS,
+		MessageID: 0x0025,
+		Direction: DirectionResponse,
+		Name:      "DMSGetIDsOutput",
+		Title:     "Get IDs",
+		TLVs: []TLVInfo{
+			{0x02, "Operation Result", "sequence"},
+			{0x10, "Esn", "string"},
+			{0x11, "Imei", "string"},
+			{0x12, "Meid", "string"},
+		},
+	}
+	if !reflect.DeepEqual(info, want) {
+		t.Errorf("DMS Get IDs response: %+v\nwant %+v", info, want)
+	}
+	if tlv, ok := info.TLV(0x11); !ok || tlv.Name != "Imei" {
+		t.Errorf("TLV 0x11: %+v, %v", tlv, ok)
+	}
+	if _, ok := info.TLV(0x13); ok {
+		t.Error("TLV 0x13 of DMS Get IDs found")
+	}
+
+	for _, c := range []struct {
+		svc       Service
+		id        uint16
+		direction Direction
+		name      string
+		tlvs      int
+	}{
+		{QMI_SERVICE_DMS, 0x0025, DirectionRequest, "DMSGetIDsInput", 0},
+		{QMI_SERVICE_CTL, 0x0022, DirectionRequest, "CTLAllocateCIDInput", 1},
+		{QMI_SERVICE_WDS, 0x0001, DirectionIndication, "WDSEventReportIndication", 5},
+	} {
+		info, ok := LookupMessage(c.svc, c.id, c.direction)
+		if !ok || info.Name != c.name || len(info.TLVs) != c.tlvs {
+			t.Errorf("%s %04x: %+v, %v", c.svc, c.id, info, ok)
+		}
+	}
+
+	// the vendor message shadows the standard one of its ID, for its
+	// vendor only
+	info, ok = LookupVendorMessage(0x1BC7, QMI_SERVICE_DMS, 0x0045, DirectionResponse)
+	if !ok || info.Name != "DMSTelitGetFirmwareInfoOutput" || info.Vendor != 0x1BC7 {
+		t.Errorf("Telit 0x0045: %+v, %v", info, ok)
+	}
+	for _, vendor := range []uint16{0, 0x05C6} {
+		info, ok = LookupVendorMessage(vendor, QMI_SERVICE_DMS, 0x0045, DirectionResponse)
+		if !ok || info.Name != "DMSGetBandCapabilitiesOutput" {
+			t.Errorf("vendor %04x 0x0045: %+v, %v", vendor, info, ok)
+		}
+	}
+
+	for _, c := range []struct {
+		svc       Service
+		id        uint16
+		direction Direction
+	}{
+		{QMI_SERVICE_DMS, 0x7fff, DirectionResponse},
+		{QMI_SERVICE_DMS, 0x0025, DirectionIndication},
+		{Service(0xee), 0x0025, DirectionResponse},
+	} {
+		if info, ok := LookupMessage(c.svc, c.id, c.direction); ok {
+			t.Errorf("%s %04x %v found: %+v", c.svc, c.id, c.direction, info)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,87 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// currentSettings is WDS Get Current Settings with all of its TLVs, nine
+// integers counting those of Operation Result: the message the marshal
+// benchmarks take, as a polling loop would see it
+func currentSettings() *WDSGetCurrentSettingsOutput {
+	msg := &WDSGetCurrentSettingsOutput{}
+	msg.QMIStructOperationResult = QMIStructOperationResult{ErrorStatus: 1, ErrorCode: 0x1a}
+	setField(msg, "PrimaryIPv4DNSAddress", uint32(0x08080808))
+	setField(msg, "SecondaryIPv4DNSAddress", uint32(0x08080404))
+	setField(msg, "IPv4Address", uint32(0x0a000002))
+	setField(msg, "IPv4GatewayAddress", uint32(0x0a000001))
+	setField(msg, "IPv4GatewaySubnetMask", uint32(0xffffff00))
+	setField(msg, "MTU", uint32(1500))
+	setField(msg, "SIPServerPort", uint16(5060))
+	return msg
+}
+
+// TestCurrentSettingsTLVs expects the same TLVs of the benchmark message
+// whichever way the integers are encoded, and reads them back
+func TestCurrentSettingsTLVs(t *testing.T) {
+	var buf bytes.Buffer
+	err := currentSettings().TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString(stripSpaces("02 0400 0100 1a00" +
+		" 15 0400 08080808 16 0400 04040808 1e 0400 0200000a" +
+		" 20 0400 0100000a 21 0400 00ffffff 29 0400 dc050000 30 0200 13c4"))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("TLVs\n% x\nwant\n% x", buf.Bytes(), want)
+	}
+
+	got := &WDSGetCurrentSettingsOutput{}
+	err = got.TLVsReadFrom(bytes.NewBuffer(want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// raw TLVs and presence, retained with qmioptions, are no fields
+	if got.String() != currentSettings().String() {
+		t.Errorf("read back %s\nwant %s", got, currentSettings())
+	}
+}
+
+// BenchmarkTLVsWriteTo and BenchmarkTLVsReadFrom compare the encoding of
+// integers through binary.Write and binary.Read with that of
+// -direct-encoding, in packages generated with and without it
+func BenchmarkTLVsWriteTo(b *testing.B) {
+	msg := currentSettings()
+	var buf bytes.Buffer
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		err := msg.TLVsWriteTo(&buf)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkTLVsReadFrom(b *testing.B) {
+	var buf bytes.Buffer
+	err := currentSettings().TLVsWriteTo(&buf)
+	if err != nil {
+		b.Fatal(err)
+	}
+	tlvs := buf.Bytes()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		msg := &WDSGetCurrentSettingsOutput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,218 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+// The tests of the runtime build with the package qmigen generates from
+// testdata/data, see TestRuntime of the qmigen package.
+package qmi
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// fakeModem is the modem end of a socketpair a Device runs over. It
+// answers requests with the result of handle, a nil result leaves them
+// unanswered. CTL Sync, Allocate CID and Release CID succeed unless handle
+// answers them.
+type fakeModem struct {
+	t      *testing.T
+	f      *os.File
+	handle func(req Message) Message
+
+	reading sync.Mutex // held while stalled
+
+	sync.Mutex
+	requests []Message
+	frames   [][]byte
+	cids     uint8
+}
+
+// socketPair returns both ends of a SOCK_SEQPACKET socketpair, which keeps
+// frame boundaries like cdc-wdm. They are non-blocking, so deadlines apply.
+func socketPair(t *testing.T) (*os.File, *os.File) {
+	t.Helper()
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		syscall.SetNonblock(fd, true)
+	}
+	return os.NewFile(uintptr(fds[0]), "device"), os.NewFile(uintptr(fds[1]), "modem")
+}
+
+// newFakeModem serves handle and returns the device end of its transport
+func newFakeModem(t *testing.T, handle func(req Message) Message) (*fakeModem, *os.File) {
+	t.Helper()
+	f, modem_f := socketPair(t)
+	m := &fakeModem{t: t, f: modem_f, handle: handle}
+	t.Cleanup(func() { modem_f.Close() })
+	go m.serve()
+	return m, f
+}
+
+// openFake returns a Device synchronized with a fakeModem serving handle
+func openFake(t *testing.T, handle func(req Message) Message, opts ...Option) (*Device, *fakeModem) {
+	t.Helper()
+	m, f := newFakeModem(t, handle)
+	dev, err := NewDevice(f, "fake", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dev.Close() })
+	return dev, m
+}
+
+func (m *fakeModem) serve() {
+	buf := make([]byte, QMUX_MAX_FRAME_SIZE)
+	for {
+		m.reading.Lock()
+		m.reading.Unlock()
+		n, err := m.f.Read(buf)
+		if err != nil {
+			return
+		}
+		frame := append([]byte(nil), buf[:n]...)
+
+		svc, cid, msgid, tlvs, err := parseFrame(frame)
+		if err != nil {
+			m.t.Errorf("modem: %s", err)
+			continue
+		}
+		cons, ok := RequestConstructors[svc][msgid]
+		if !ok {
+			m.t.Errorf("modem: unexpected request %s %04x", svc, msgid)
+			continue
+		}
+		req := cons()
+		err = req.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			m.t.Errorf("modem: %T: %s", req, err)
+			continue
+		}
+
+		m.Lock()
+		m.requests = append(m.requests, req)
+		m.frames = append(m.frames, frame)
+		m.Unlock()
+
+		resp := m.answer(req)
+		if resp != nil {
+			m.send(resp, uint8(cid), uint16(cid>>8), false)
+		}
+	}
+}
+
+func (m *fakeModem) answer(req Message) Message {
+	if m.handle != nil {
+		if resp := m.handle(req); resp != nil {
+			return resp
+		}
+	}
+
+	switch req := req.(type) {
+	case *CTLSyncInput:
+		return &CTLSyncOutput{}
+	case *CTLAllocateCIDInput:
+		resp := &CTLAllocateCIDOutput{}
+		m.Lock()
+		m.cids++
+		resp.AllocationInfo.Service = req.Service
+		resp.AllocationInfo.CID = m.cids
+		m.Unlock()
+		return resp
+	case *CTLReleaseCIDInput:
+		resp := &CTLReleaseCIDOutput{}
+		resp.ReleaseInfo.Service = req.ReleaseInfo.Service
+		resp.ReleaseInfo.CID = req.ReleaseInfo.CID
+		return resp
+	}
+	return nil
+}
+
+// send writes msg as sent by the modem to client cid, as a response to
+// txid or as an indication
+func (m *fakeModem) send(msg Message, cid uint8, txid uint16, indication bool) {
+	buf, err := Marshal(msg, cid, txid, 0)
+	if err != nil {
+		m.t.Errorf("modem: %T: %s", msg, err)
+		return
+	}
+	b := buf.Bytes()
+	b[3] = 0x80
+	switch {
+	case msg.ServiceID() == QMI_SERVICE_CTL && indication:
+		b[6] = 0x02
+	case msg.ServiceID() == QMI_SERVICE_CTL:
+		b[6] = 0x01
+	case indication:
+		b[6] = 0x04
+	default:
+		b[6] = 0x02
+	}
+	m.write(b)
+}
+
+// stall stops the modem reading after the frame it waits for, until
+// resume is called
+func (m *fakeModem) stall() (resume func()) {
+	m.reading.Lock()
+	return m.reading.Unlock
+}
+
+// rawMessage is a message of arbitrary TLVs, for the modem to send what
+// the generated types cannot
+type rawMessage struct {
+	svc  Service
+	id   uint16
+	tlvs []byte
+}
+
+func (m *rawMessage) ServiceID() Service                 { return m.svc }
+func (m *rawMessage) MessageID() uint16                  { return m.id }
+func (m *rawMessage) TLVsReadFrom(r *bytes.Buffer) error { return nil }
+
+func (m *rawMessage) TLVsWriteTo(w io.Writer) error {
+	_, err := w.Write(m.tlvs)
+	return err
+}
+
+// write sends a raw frame to the device, if still open
+func (m *fakeModem) write(b []byte) {
+	_, err := m.f.Write(b)
+	if err != nil && !errors.Is(err, syscall.EPIPE) {
+		m.t.Errorf("modem: %s", err)
+	}
+}
+
+// received returns the requests for service svc read so far
+func (m *fakeModem) received(svc Service) []Message {
+	m.Lock()
+	defer m.Unlock()
+	var reqs []Message
+	for _, req := range m.requests {
+		if req.ServiceID() == svc {
+			reqs = append(reqs, req)
+		}
+	}
+	return reqs
+}
+
+// waitFor polls cond until it holds or a second passed
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	for end := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(end) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,49 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestMessageNames names messages and indications of a service by ID, and
+// gives other IDs in hex
+func TestMessageNames(t *testing.T) {
+	for _, test := range []struct {
+		name, want string
+	}{
+		{DMSMessageName(0x0025), "DMS Get IDs"},
+		{DMSMessageName(0x004b), "DMS Set Time"},
+		{DMSMessageName(0x007f), "DMS 0x7f"},
+		{WDSMessageName(0x0001), "WDS Event Report"},
+		{LOCMessageName(0x0024), "LOC Position Report"},
+	} {
+		if test.name != test.want {
+			t.Errorf("%q, want %q", test.name, test.want)
+		}
+	}
+}
+
+// TestBadMessageName expects ErrBadMessage to name a response which is
+// only generated as an indication, and not an unknown one
+func TestBadMessageName(t *testing.T) {
+	for _, test := range []struct {
+		frame string
+		want  string
+	}{
+		// WDS Event Report, as a response
+		{"01 0c00 80 01 02 02 0403 0100 0000", "unexpected MessageID: 1 (WDS Event Report)"},
+		{"01 0c00 80 01 02 02 0403 7f00 0000", "unexpected MessageID: 7f"},
+	} {
+		frame, _ := hex.DecodeString(stripSpaces(test.frame))
+		var msg Message
+		_, err := Unmarshal(frame, &msg)
+		if _, ok := err.(ErrBadMessage); !ok || err.Error() != test.want {
+			t.Errorf("%s: err = %v, want %s", test.frame, err, test.want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,73 @@
+//go:build qmiruntime && qmioptions
+// +build qmiruntime,qmioptions
+
+// The tests of generator options, run in the package generated with them,
+// see TestRuntime of the qmigen package.
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/tlv"
+)
+
+func TestRawTLVs(t *testing.T) {
+	tlvs, _ := (&tlv.Builder{}).
+		Add(0x02, []byte{0, 0, 0, 0}).
+		AddString(0x11, "490154203237518", false).
+		Add(0x30, []byte{0xaa, 0xbb}).
+		Bytes()
+	dev, _ := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetIDsInput); !ok {
+			return nil
+		}
+		return &rawMessage{QMI_SERVICE_DMS, 0x0025, tlvs}
+	})
+
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dms.Send(&DMSGetIDsInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := resp.(*DMSGetIDsOutput)
+	if ids.IMEI == nil || *ids.IMEI != "490154203237518" {
+		t.Errorf("IMEI = %v", ids.IMEI)
+	}
+
+	for _, test := range []struct {
+		tag  uint8
+		want []byte
+	}{
+		{0x02, []byte{0, 0, 0, 0}},
+		{0x11, []byte("490154203237518")},
+		{0x30, []byte{0xaa, 0xbb}}, // unknown to the data files
+		{0x12, nil},
+	} {
+		got, ok := ids.TLV(test.tag)
+		if ok != (test.want != nil) || !bytes.Equal(got, test.want) {
+			t.Errorf("TLV(%#02x) = % x, %t, want % x", test.tag, got, ok, test.want)
+		}
+	}
+}
+
+// TestInternalProxyOpen encodes CTL Internal Proxy Open, generated with
+// -internal, as libqmi sends it to qmi-proxy
+func TestInternalProxyOpen(t *testing.T) {
+	req := NewCTLInternalProxyOpenInput("/dev/cdc-wdm0")
+	buf, err := Marshal(&req, 0, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, _ := hex.DecodeString(stripSpaces("01 1b00 00 00 00 00 01 00ff 1000" +
+		" 01 0d00 2f6465762f6364632d77646d30"))
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Errorf("frame\n% x\nwant\n% x", buf.Bytes(), want)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,62 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestTLVOrder sends requests whose data files list their TLVs out of
+// order and compares the frames
+func TestTLVOrder(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *NASSetSystemSelectionPreferenceInput:
+			return &NASSetSystemSelectionPreferenceOutput{}
+		case *NASSetTechnologyPreferenceInput:
+			return &NASSetTechnologyPreferenceOutput{}
+		}
+		return nil
+	})
+	nas, err := dev.GetService(QMI_SERVICE_NAS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	selection := &NASSetSystemSelectionPreferenceInput{}
+	setField(selection, "ModePreference", uint16(0x0018))
+	setField(selection, "ChangeDuration", uint8(1))
+	setField(selection, "EmergencyMode", false)
+	technology := &NASSetTechnologyPreferenceInput{}
+	setField(technology, "Current", uint16(0x0008))
+	setField(technology, "Duration", uint8(0))
+
+	for _, test := range []struct {
+		req  Message
+		tlvs string
+	}{
+		// declared as 0x11, 0x17, 0x10
+		{selection, "10 0100 00 11 0200 1800 17 0100 01"},
+		// declared as 0x10, 0x01 and kept so
+		{technology, "10 0100 00 01 0200 0800"},
+	} {
+		_, err = nas.Send(test.req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		modem.Lock()
+		frame := modem.frames[len(modem.frames)-1]
+		modem.Unlock()
+		want, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		// the TLVs follow the QMUX header, the transaction ID, the
+		// message ID and the length of the TLVs
+		if got := frame[6+1+2+2+2:]; !bytes.Equal(got, want) {
+			t.Errorf("%T: TLVs\n% x\nwant\n% x", test.req, got, want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
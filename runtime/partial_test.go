@@ -0,0 +1,102 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// operatorName TLVs: a Service Provider Name, PLMN Names and an Operator
+// Code, any of them replaced by a corrupt one
+func operatorName(spn, plmn, code string) []byte {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000" + spn + plmn + code))
+	return tlvs
+}
+
+const (
+	goodSPN  = " 10 0600 01 03 4d5453 02"
+	goodPLMN = " 11 0500 01 03 4d5453"
+	goodCode = " 12 0600 323530 303100"
+)
+
+var (
+	spn = struct {
+		NameDisplayCondition uint8
+		Name                 string
+		NameSource           uint8
+	}{1, "MTS", 2}
+	plmn = []string{"MTS"}
+)
+
+// indirect returns field name of msg, nil if left out
+func indirect(msg Message, name string) interface{} {
+	v, ok := messageField(msg, name)
+	if !ok {
+		return nil
+	}
+	return v.Interface()
+}
+
+func TestPartialDecode(t *testing.T) {
+	for _, test := range []struct {
+		tlvs    []byte
+		tag     uint8
+		name    string
+		decoded []string // fields expected to survive
+	}{
+		// the string runs past the TLV
+		{operatorName(" 10 0400 01 05 4d54", goodPLMN, goodCode), 0x10, "Service Provider Name", nil},
+		// two names counted, one present
+		{operatorName(goodSPN, " 11 0500 02 03 4d5453", goodCode), 0x11, "PLMN Names", []string{"ServiceProviderName"}},
+		// a fixed-size string cut short
+		{operatorName(goodSPN, goodPLMN, " 12 0400 323530 30"), 0x12, "Operator Code", []string{"ServiceProviderName", "PLMNNames"}},
+	} {
+		msg := &NASGetOperatorNameOutput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(test.tlvs))
+		var partial *PartialDecodeError
+		if !errors.As(err, &partial) || partial.Tag != test.tag || partial.Name != test.name {
+			t.Errorf("TLV %02x: err = %#v", test.tag, err)
+			continue
+		}
+		if msg.ErrorStatus != 0 {
+			t.Errorf("TLV %02x: operation result lost", test.tag)
+		}
+		want := map[string]interface{}{"ServiceProviderName": spn, "PLMNNames": plmn}
+		for _, field := range test.decoded {
+			if got := indirect(msg, field); !reflect.DeepEqual(got, want[field]) {
+				t.Errorf("TLV %02x: %s = %+v, want %+v", test.tag, field, got, want[field])
+			}
+		}
+	}
+}
+
+// TestPartialSend expects Send to return the partially decoded response
+// along with the error
+func TestPartialSend(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		if _, ok := req.(*NASGetOperatorNameInput); ok {
+			return &rawMessage{QMI_SERVICE_NAS, 0x39, operatorName(goodSPN, " 11 0500 02 03 4d5453", goodCode)}
+		}
+		return nil
+	})
+
+	resp, err := dev.Send(&NASGetOperatorNameInput{})
+	var partial *PartialDecodeError
+	if !errors.As(err, &partial) || partial.Tag != 0x11 {
+		t.Fatalf("err = %#v, want a PartialDecodeError of TLV 11", err)
+	}
+	msg, ok := resp.(*NASGetOperatorNameOutput)
+	if !ok {
+		t.Fatalf("response %#v", resp)
+	}
+	if got := indirect(msg, "ServiceProviderName"); !reflect.DeepEqual(got, spn) {
+		t.Errorf("ServiceProviderName = %+v", got)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,50 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactString(t *testing.T) {
+	for s, want := range map[string]string{
+		"":                "***",
+		"1234567":         "***",
+		"12345678":        "***5678",
+		"350000000000001": "***0001",
+	} {
+		if got := redactString(s); got != want {
+			t.Errorf("redactString(%q) = %q, want %q", s, got, want)
+		}
+	}
+}
+
+// TestPersonalString expects the IMEI, personal info, masked in String()
+// and left as is in the message
+func TestPersonalString(t *testing.T) {
+	msg := &DMSGetIDsOutput{}
+	setField(msg, "Esn", "80abcdef")
+	setField(msg, "IMEI", "350000000000001")
+
+	s := msg.String()
+	if strings.Contains(s, "350000000000001") || !strings.Contains(s, "***0001") {
+		t.Errorf("IMEI not masked:\n%s", s)
+	}
+	// the ESN is not personal info
+	if !strings.Contains(s, "80abcdef") {
+		t.Errorf("ESN masked:\n%s", s)
+	}
+	if imei, _ := messageField(msg, "IMEI"); imei.String() != "350000000000001" {
+		t.Errorf("IMEI %q after String()", imei)
+	}
+
+	// an unset optional TLV has nothing to mask
+	msg = &DMSGetIDsOutput{}
+	if s := msg.String(); strings.Contains(s, "0001") {
+		t.Errorf("empty message:\n%s", s)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
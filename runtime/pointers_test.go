@@ -0,0 +1,48 @@
+//go:build qmiruntime && qmioptions
+// +build qmiruntime,qmioptions
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestOptionalPointers tells an absent optional TLV from one carrying a
+// zero value, in both directions
+func TestOptionalPointers(t *testing.T) {
+	// the IMEI 0x11 is present and empty, the ESN 0x10 absent
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 11 0000"))
+	msg := &DMSGetIDsOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.IMEI == nil || *msg.IMEI != "" {
+		t.Errorf("IMEI %v, want an empty string", msg.IMEI)
+	}
+	if msg.Esn != nil || msg.Meid != nil {
+		t.Errorf("ESN %v, MEID %v, want nil", msg.Esn, msg.Meid)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), tlvs) {
+		t.Errorf("written as % x, want % x", buf.Bytes(), tlvs)
+	}
+}
+
+// TestMandatoryMissing fails a response lacking a TLV below 0x10
+func TestMandatoryMissing(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000"))
+	err := (&DMSGetManufacturerOutput{}).TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err == nil || err.Error() != "cannot find tag 1" {
+		t.Errorf("err = %v, want cannot find tag 1", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
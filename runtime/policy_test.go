@@ -0,0 +1,30 @@
+//go:build qmiruntime && qmioptions
+// +build qmiruntime,qmioptions
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestStringPolicyReplace decodes invalid UTF-8 in a plain string and in a
+// GSM one, the options package is generated with -string-policy replace
+func TestStringPolicyReplace(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 11 0600 01 04 4d5453ff 13 0200 41ff"))
+	msg := &NASGetOperatorNameOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := indirect(msg, "PLMNNames"); !reflect.DeepEqual(got, []string{"MTS�"}) {
+		t.Errorf("PLMN names %q", got)
+	}
+	if got := indirect(msg, "OperatorStringName"); got != "A�" {
+		t.Errorf("operator string name %q", got)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,53 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestPrerequisite decodes a sequence whose power is only present when the
+// radio is tuned, in both cases, and expects the same bytes written back
+func TestPrerequisite(t *testing.T) {
+	type rxInfo = struct {
+		IsRadioTuned uint8
+		Power        int32
+		ECIO         int32
+	}
+	for _, test := range []struct {
+		name string
+		tlvs string
+		want rxInfo
+	}{
+		{"tuned", "02 0400 0000 0000 10 0900 01 c4ffffff f6ffffff", rxInfo{1, -60, -10}},
+		{"not tuned", "02 0400 0000 0000 10 0500 00 f6ffffff", rxInfo{0, 0, -10}},
+	} {
+		tlvs, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		msg := &NASGetTxRxInfoOutput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		got := reflect.Indirect(reflect.ValueOf(msg).Elem().FieldByName("RxChain0Info")).Interface()
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: decoded %+v, want %+v", test.name, got, test.want)
+		}
+
+		var buf bytes.Buffer
+		err = msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if !bytes.Equal(buf.Bytes(), tlvs) {
+			t.Errorf("%s:\n got % x\nwant % x", test.name, buf.Bytes(), tlvs)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,27 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import "testing"
+
+// TestTLVSet records tags across the words of the set, 0x00 and 0xff
+// included, and nothing else
+func TestTLVSet(t *testing.T) {
+	var s tlvSet
+	tags := []uint8{0x00, 0x10, 0x3f, 0x40, 0x80, 0xc1, 0xff}
+	for _, tag := range tags {
+		s.add(tag)
+	}
+	for tag := 0; tag < 256; tag++ {
+		want := false
+		for _, added := range tags {
+			want = want || uint8(tag) == added
+		}
+		if s.has(uint8(tag)) != want {
+			t.Errorf("has(%#x) = %v", tag, !want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,4508 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+// Package qmi here is the runtime qmigen appends to every generated
+// qmi-common.go. It refers to declarations generated from the data files,
+// so it only compiles as part of that file: edit it here, then run
+// go generate to refresh footer.go
+package qmi
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/tlv"
+)
+
+type QMIService interface {
+	ServiceID() Service
+}
+
+type QMIOperation interface {
+	OperationResult() QMIStructOperationResult
+}
+
+type Message interface {
+	ServiceID() Service
+	MessageID() uint16
+	TLVsWriteTo(io.Writer) error
+	TLVsReadFrom(*bytes.Buffer) error
+}
+
+type Device struct {
+	f      *os.File
+	name   string
+	reopen func() (*os.File, error) // the device file anew, for Reconnect
+
+	maxWriteSize int
+
+	ch      map[uint32]chan Message
+	reset   chan struct{} // closed by Reconnect, failing the waits on ch
+	clients map[Service]*Client
+
+	events  chan Event
+	longOps int
+
+	writeSlots chan struct{}
+	writeTurn  chan struct{} // held by the write using the deadline
+	stats      Stats
+
+	recorder *Cassette
+	tap      func(Direction, []byte)
+
+	subs     map[uint32][]chan Message
+	flows    map[uint32]*TokenFlow
+	aborters map[uint32]Aborter
+
+	limiters    map[Service]*limiter
+	defaultRate *Rate
+	admission   *admission
+
+	failedResponses   bool
+	strictTLVs        bool
+	strictEnums       bool
+	registryOverrides bool
+	vendor            uint16
+	txSeed            uint16
+
+	logger   *log.Logger
+	timeouts map[uint32]time.Duration
+	watchdog *WatchdogConfig // started once CTL is synchronized
+
+	inflight      int // transactions in client.SendContext
+	shuttingDown  bool
+	shutdownHooks []deviceHook
+	rearmHooks    []deviceHook
+	rearmed       map[string]Message // last sent by sendRearmed, by hook
+
+	readAt map[uint32]time.Time // responses handed to a waiting Send
+
+	dupWindow time.Duration
+	completed map[responseKey]time.Time // responses handed to a waiting Send
+
+	dynamic      map[uint32]*dynamicDef
+	dynamicNames map[string]*dynamicDef
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	err    error
+
+	sync.Mutex
+}
+
+type Service uint8
+
+func (s Service) String() string {
+	if desc := ServiceMap[s]; desc != "" {
+		return fmt.Sprintf("Service %s", desc)
+	} else {
+		return fmt.Sprintf("Unknown service %x", uint8(s))
+	}
+}
+
+// Duplicate TLVs in a received frame are handled as follows: tags a message
+// declares repeatable are decoded from every instance, in frame order. For
+// any other tag the first instance wins; a Device counts the rest in
+// Stats.DuplicateTLVs or, with WithStrictTLVs, fails the decode with
+// ErrDuplicateTLV.
+
+// findTag returns the payload of the first instance of tag
+func findTag(r *bytes.Buffer, tag uint8) *bytes.Buffer {
+	p := tlv.NewParser(r.Bytes())
+	for p.Next() {
+		if p.Tag() == tag {
+			return bytes.NewBuffer(append([]byte(nil), p.Payload()...))
+		}
+	}
+
+	return nil
+}
+
+// findTags returns payloads of every instance of a repeatable tag
+func findTags(r *bytes.Buffer, tag uint8) []*bytes.Buffer {
+	var bufs []*bytes.Buffer
+	p := tlv.NewParser(r.Bytes())
+	for p.Next() {
+		if p.Tag() == tag {
+			bufs = append(bufs, bytes.NewBuffer(append([]byte(nil), p.Payload()...)))
+		}
+	}
+
+	return bufs
+}
+
+// indexTLVs splits a TLV buffer into payloads keyed by tag, first instance
+// of a duplicate tag wins
+func indexTLVs(r *bytes.Buffer) RawTLVs {
+	raw := RawTLVs{}
+	p := tlv.NewParser(r.Bytes())
+	for p.Next() {
+		if _, ok := raw[p.Tag()]; ok {
+			continue
+		}
+		// the reader reuses its buffer, so payloads are copied
+		raw[p.Tag()] = append([]byte(nil), p.Payload()...)
+	}
+	return raw
+}
+
+// ErrDuplicateTLV is a tag received more than once in a message which
+// does not declare it repeatable
+type ErrDuplicateTLV uint8
+
+func (e ErrDuplicateTLV) Error() string {
+	return fmt.Sprintf("duplicate TLV %#04x", uint8(e))
+}
+
+// repeatableTLVs is implemented by messages with repeatable tags
+type repeatableTLVs interface {
+	RepeatableTLVs() []uint8
+}
+
+// duplicateTLVs lists tags of tlvs received more than once which m does
+// not declare repeatable, in frame order
+func duplicateTLVs(tlvs []byte, m Message) []uint8 {
+	repeatable := map[uint8]bool{}
+	if rm, ok := m.(repeatableTLVs); ok {
+		for _, tag := range rm.RepeatableTLVs() {
+			repeatable[tag] = true
+		}
+	}
+
+	var dups []uint8
+	seen := map[uint8]int{}
+	p := tlv.NewParser(tlvs)
+	for p.Next() {
+		tag := p.Tag()
+		seen[tag]++
+		if seen[tag] == 2 && !repeatable[tag] {
+			dups = append(dups, tag)
+		}
+	}
+	return dups
+}
+
+// checkTLVs reports a malformed TLV in r, such as one whose length runs
+// past the end of the message. Lookups skip it, so decoders call this last
+// rather than leaving its fields zeroed without notice.
+func checkTLVs(r *bytes.Buffer) error {
+	p := tlv.NewParser(r.Bytes())
+	for p.Next() {
+	}
+	return p.Err()
+}
+
+// readString reads a string of n bytes, failing with io.ErrUnexpectedEOF
+// if b holds fewer
+func readString(b *bytes.Buffer, n int) (string, error) {
+	if b.Len() < n {
+		return "", io.ErrUnexpectedEOF
+	}
+	return string(b.Next(n)), nil
+}
+
+// readFixedString reads a NUL padded string of n bytes
+func readFixedString(b *bytes.Buffer, n int) (string, error) {
+	s, err := readString(b, n)
+	return strings.TrimRight(s, "\x00"), err
+}
+
+// ErrInvalidUTF8 is a string from the modem which is not valid UTF-8, with
+// the offset of the first bad byte, as decoded with -string-policy=strict
+type ErrInvalidUTF8 int
+
+func (e ErrInvalidUTF8) Error() string {
+	return fmt.Sprintf("invalid UTF-8 at byte %d", int(e))
+}
+
+// Operator names and other modem strings are not always UTF-8. Decoders
+// generated with -string-policy pass them through one of these.
+
+// replaceInvalid replaces each run of invalid UTF-8 in s with U+FFFD
+func replaceInvalid(s string) (string, error) {
+	return strings.ToValidUTF8(s, "\uFFFD"), nil
+}
+
+// escapeInvalid writes the bytes of s which are not UTF-8 as \xNN
+func escapeInvalid(s string) (string, error) {
+	if utf8.ValidString(s) {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			fmt.Fprintf(&b, "\\x%02x", s[i])
+		} else {
+			b.WriteString(s[i : i+n])
+		}
+		i += n
+	}
+	return b.String(), nil
+}
+
+// rejectInvalid fails on strings which are not UTF-8
+func rejectInvalid(s string) (string, error) {
+	for i := 0; i < len(s); {
+		r, n := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && n == 1 {
+			return "", ErrInvalidUTF8(i)
+		}
+		i += n
+	}
+	return s, nil
+}
+
+// gsm7Alphabet is the GSM 03.38 default alphabet by septet, 0x1b escapes
+// to gsm7Extension
+var gsm7Alphabet = []rune("@£$¥èéùìòÇ\nØø\rÅåΔ_ΦΓΛΩΠΨΣΘΞ\x1bÆæßÉ !\"#¤%&'()*+,-./0123456789:;<=>?" +
+	"¡ABCDEFGHIJKLMNOPQRSTUVWXYZÄÖÑÜ§¿abcdefghijklmnopqrstuvwxyzäöñüà")
+
+var gsm7Extension = map[byte]rune{
+	0x0a: '\f', 0x14: '^', 0x28: '{', 0x29: '}', 0x2f: '\\',
+	0x3c: '[', 0x3d: '~', 0x3e: ']', 0x40: '|', 0x65: '€',
+}
+
+// gsm7Septets encodes runes back, extension ones as two septets
+var gsm7Septets = func() map[rune]string {
+	septets := map[rune]string{}
+	for i, r := range gsm7Alphabet {
+		if i != 0x1b {
+			septets[r] = string([]byte{byte(i)})
+		}
+	}
+	for c, r := range gsm7Extension {
+		septets[r] = string([]byte{0x1b, c})
+	}
+	return septets
+}()
+
+// decodeGSM7 converts a string of the GSM default alphabet, a septet per
+// byte, to UTF-8 for fields with public-format "gsm7". Bytes above 0x7f
+// are not septets and are kept for the string policy to deal with.
+func decodeGSM7(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 0x80:
+			b.WriteByte(c)
+		case c != 0x1b:
+			b.WriteRune(gsm7Alphabet[c])
+		case i+1 == len(s):
+			// a dangling escape
+		default:
+			i++
+			if r, ok := gsm7Extension[s[i]]; ok {
+				b.WriteRune(r)
+			} else if s[i] < 0x80 {
+				// unknown extensions read as the default character
+				b.WriteRune(gsm7Alphabet[s[i]])
+			} else {
+				b.WriteByte(s[i])
+			}
+		}
+	}
+	return b.String()
+}
+
+// encodeGSM7 is the reverse of decodeGSM7, characters out of the alphabet
+// are sent as '?'
+func encodeGSM7(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		septets, ok := gsm7Septets[r]
+		if !ok {
+			septets = "?"
+		}
+		b.WriteString(septets)
+	}
+	return b.String()
+}
+
+// RawTLVs retains undecoded TLV payloads of a received message
+type RawTLVs map[uint8][]byte
+
+func (raw RawTLVs) TLV(tag uint8) ([]byte, bool) {
+	b, ok := raw[tag]
+	return b, ok
+}
+
+// tlvSet records the optional TLVs a decoded message carried, by tag, for
+// String() and its presence accessors
+type tlvSet [4]uint64
+
+func (s *tlvSet) add(tag uint8) {
+	s[tag/64] |= 1 << (tag % 64)
+}
+
+func (s tlvSet) has(tag uint8) bool {
+	return s[tag/64]&(1<<(tag%64)) != 0
+}
+
+// redactString masks personal info in String() output, keeping the last 4
+// characters of values long enough for them not to identify anybody
+func redactString(s string) string {
+	if len(s) < 8 {
+		return "***"
+	}
+	return "***" + s[len(s)-4:]
+}
+
+// formatUnits prints the value of a scaled field in String() output in
+// plain decimals, %v has 1.23456e+07 for 12345600
+func formatUnits(f float64, unit string) string {
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	if unit == "" {
+		return s
+	}
+	return s + " " + unit
+}
+
+// recorder is an output recording the optional TLVs it carried in its
+// present field, which recordedTLVs gives along with their fields by tag
+type recorder interface {
+	recordedTLVs() (tlvSet, map[string]uint8)
+}
+
+// formatMessage prints a message for String(): its type, service and ID,
+// then a line per field with nested structs and their slices indented.
+// Optional TLVs the message lacked print as (absent): nil pointers, or
+// zero fields msg.present does not record.
+func formatMessage(m Message) string {
+	v := reflect.ValueOf(m).Elem()
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%v, message 0x%04x)", v.Type().Name(), m.ServiceID(), m.MessageID())
+	var present tlvSet
+	var tags map[string]uint8
+	if r, ok := m.(recorder); ok {
+		present, tags = r.recordedTLVs()
+	}
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || field.Name == "RawTLVs" {
+			continue
+		}
+		if tag, ok := tags[field.Name]; ok && !present.has(tag) && v.Field(i).IsZero() {
+			b.WriteString("\n  " + field.Name + ": (absent)")
+			continue
+		}
+		formatValue(&b, field.Name, v.Field(i), 1)
+	}
+	return b.String()
+}
+
+func formatStruct(b *strings.Builder, v reflect.Value, depth int) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || field.Name == "RawTLVs" {
+			continue
+		}
+		formatValue(b, field.Name, v.Field(i), depth)
+	}
+}
+
+var stringerType = reflect.TypeOf((*fmt.Stringer)(nil)).Elem()
+
+func formatValue(b *strings.Builder, name string, v reflect.Value, depth int) {
+	b.WriteString("\n" + strings.Repeat("  ", depth) + name + ":")
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			b.WriteString(" (absent)")
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch {
+	case v.Type().Implements(stringerType):
+		fmt.Fprintf(b, " %v", v.Interface())
+	case v.Kind() == reflect.Struct:
+		formatStruct(b, v, depth+1)
+	case v.Kind() == reflect.Slice && v.Type().Elem() == reflect.TypeOf(byte(0)) && v.Len() > 0:
+		fmt.Fprintf(b, " % x", v.Bytes())
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < v.Len(); i++ {
+			formatValue(b, fmt.Sprintf("[%d]", i), v.Index(i), depth+1)
+		}
+	default:
+		fmt.Fprintf(b, " %v", v.Interface())
+	}
+}
+
+type Client struct {
+	Device        *Device
+	ClientID      uint8
+	Service       Service
+	TransactionID uint16
+
+	allocatedAt time.Time
+	txCount     uint64
+
+	sync.Mutex
+}
+
+// AllocatedAt is when the client ID was allocated or adopted
+func (client *Client) AllocatedAt() time.Time {
+	client.Lock()
+	defer client.Unlock()
+	return client.allocatedAt
+}
+
+// TxCount is the number of requests sent by the client
+func (client *Client) TxCount() uint64 {
+	client.Lock()
+	defer client.Unlock()
+	return client.txCount
+}
+
+// ClientInfo is a snapshot of a Client for monitoring
+type ClientInfo struct {
+	Service     Service
+	ClientID    uint8
+	AllocatedAt time.Time
+	TxCount     uint64
+}
+
+// Clients returns a snapshot of the allocated clients ordered by service
+func (dev *Device) Clients() []ClientInfo {
+	dev.Lock()
+	clients := make([]*Client, 0, len(dev.clients))
+	for svc := 0; svc <= 0xff; svc++ {
+		if client, ok := dev.clients[Service(svc)]; ok {
+			clients = append(clients, client)
+		}
+	}
+	dev.Unlock()
+
+	infos := make([]ClientInfo, len(clients))
+	for i, client := range clients {
+		client.Lock()
+		infos[i] = ClientInfo{
+			Service:     client.Service,
+			ClientID:    client.ClientID,
+			AllocatedAt: client.allocatedAt,
+			TxCount:     client.txCount,
+		}
+		client.Unlock()
+	}
+	return infos
+}
+
+type Option func(*Device)
+
+func Open(name string, opts ...Option) (*Device, error) {
+	f, err := openDevice(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDevice(f, name, opts...)
+}
+
+func openDevice(name string) (*os.File, error) {
+	return os.OpenFile(name, os.O_RDWR|os.O_EXCL|syscall.O_NOCTTY, 0600)
+}
+
+// NewDevice runs QMI over an already opened file, which must preserve
+// frame boundaries on read. It fails with ErrRegistryConflicts, closing
+// the file, if RegistryConflicts is not empty and the overrides are not
+// allowed with WithRegistryOverrides, or lists a common-ref.
+func NewDevice(f *os.File, name string, opts ...Option) (*Device, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	dev := &Device{
+		f:            f,
+		name:         name,
+		reopen:       func() (*os.File, error) { return openDevice(name) },
+		maxWriteSize: detectMaxWriteSize(name),
+		ctx:          ctx,
+		cancel:       cancel,
+		ch:           make(map[uint32]chan Message),
+		reset:        make(chan struct{}),
+		clients:      make(map[Service]*Client),
+		events:       make(chan Event, 16),
+		writeSlots:   make(chan struct{}, MAX_PENDING_WRITES),
+		writeTurn:    make(chan struct{}, 1),
+		dupWindow:    DEFAULT_DUPLICATE_WINDOW,
+	}
+
+	for _, register := range tokenFlows {
+		register(dev)
+	}
+	for _, opt := range opts {
+		opt(dev)
+	}
+
+	dev.clients[QMI_SERVICE_CTL] = &Client{
+		Device:        dev,
+		ClientID:      0,
+		Service:       QMI_SERVICE_CTL,
+		TransactionID: dev.txSeed,
+		allocatedAt:   timeNow(),
+	}
+
+	if refused := refusedConflicts(dev.registryOverrides); len(refused) > 0 {
+		cancel()
+		f.Close()
+		return nil, refused
+	}
+
+	go dev.reader(f)
+
+	ctl, _ := dev.GetService(QMI_SERVICE_CTL)
+	_, err := ctl.Send(&CTLSyncInput{})
+	if err != nil {
+		// stops the reader, f is the caller's no more
+		dev.Close()
+		return nil, err
+	}
+
+	if dev.watchdog != nil {
+		go dev.runWatchdog(*dev.watchdog)
+	}
+	return dev, nil
+}
+
+// Reconnect reopens the underlying device file and resynchronizes CTL.
+// Previously allocated clients are dropped and get reallocated on demand.
+// Subscriptions stay, each receives a ResetEvent in place of the
+// indications lost meanwhile; the OnRearm hooks then restore the modem
+// side reporting. Hooks which fail are reported in ErrRearm.
+func (dev *Device) Reconnect() error {
+	f, err := dev.reopen()
+	if err != nil {
+		return err
+	}
+
+	dev.Lock()
+	if dev.f == nil {
+		dev.Unlock()
+		f.Close()
+		return ErrAlreadyClosed(dev.name)
+	}
+	old := dev.f
+	dev.f = f
+	// the old transport takes the pending responses with it
+	close(dev.reset)
+	dev.reset = make(chan struct{})
+	dev.ch = make(map[uint32]chan Message)
+	dev.readAt = nil
+	// transaction IDs start over, so do legitimate repeats of responses
+	dev.completed = nil
+	dev.clients = map[Service]*Client{
+		QMI_SERVICE_CTL: &Client{
+			Device:        dev,
+			ClientID:      0,
+			Service:       QMI_SERVICE_CTL,
+			TransactionID: dev.txSeed,
+			allocatedAt:   timeNow(),
+		},
+	}
+	// before the new reader starts, so that the event separates the
+	// indications of both transports
+	dropped := dev.deliverReset(timeNow())
+	hooks := dev.rearmHooks
+	dev.Unlock()
+
+	for i := 0; i < dropped; i++ {
+		dev.logf(dev.ctx, "dev %s: dropped ResetEvent", dev.name)
+	}
+
+	old.Close()
+	go dev.reader(f)
+
+	ctl, _ := dev.GetService(QMI_SERVICE_CTL)
+	_, err = ctl.Send(&CTLSyncInput{})
+	if err != nil {
+		return err
+	}
+
+	var failed ErrRearm
+	for _, hook := range hooks {
+		err := hook.f(dev.ctx)
+		if err != nil {
+			failed = append(failed, RearmError{hook.name, err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// OnRearm registers the re-arm of modem side state which a new transport
+// loses, such as reporting enabled with NAS Register Indications or WMS
+// Set Event Report. Reconnect runs the hooks in order of registration once
+// CTL is resynchronized, they may send.
+func (dev *Device) OnRearm(name string, f func(ctx context.Context) error) {
+	dev.Lock()
+	dev.rearmHooks = append(dev.rearmHooks, deviceHook{name, f})
+	dev.Unlock()
+}
+
+// sendRearmed sends m and, from an OnRearm hook named name, sends it again
+// after every Reconnect: the configuration of event reports and the like,
+// which the modem forgets with the transport. The hook sends the m last
+// sent under name.
+func (dev *Device) sendRearmed(ctx context.Context, name string, m Message) error {
+	_, err := dev.SendContext(ctx, m)
+	if err != nil {
+		return err
+	}
+
+	dev.Lock()
+	defer dev.Unlock()
+	if _, ok := dev.rearmed[name]; !ok {
+		dev.rearmHooks = append(dev.rearmHooks, deviceHook{name, func(ctx context.Context) error {
+			dev.Lock()
+			m := dev.rearmed[name]
+			dev.Unlock()
+			_, err := dev.SendContext(ctx, m)
+			return err
+		}})
+	}
+	if dev.rearmed == nil {
+		dev.rearmed = map[string]Message{}
+	}
+	dev.rearmed[name] = m
+	return nil
+}
+
+type RearmError struct {
+	Hook string
+	Err  error
+}
+
+// ErrRearm lists the OnRearm hooks which failed after Reconnect, the
+// device is usable regardless
+type ErrRearm []RearmError
+
+func (e ErrRearm) Error() string {
+	hooks := make([]string, len(e))
+	for i, hook := range e {
+		hooks[i] = fmt.Sprintf("%s: %s", hook.Hook, hook.Err)
+	}
+	return "re-arm incomplete: " + strings.Join(hooks, "; ")
+}
+
+// ResetEvent is delivered in-band to a subscription when Reconnect
+// replaced the transport: indications may have been lost, and those the
+// modem sends only when asked to resume after the OnRearm hooks.
+type ResetEvent struct {
+	Service Service
+	ID      uint16 // of the subscribed indication
+	At      time.Time
+}
+
+func (e *ResetEvent) ServiceID() Service                 { return e.Service }
+func (e *ResetEvent) MessageID() uint16                  { return e.ID }
+func (e *ResetEvent) TLVsWriteTo(w io.Writer) error      { return nil }
+func (e *ResetEvent) TLVsReadFrom(r *bytes.Buffer) error { return nil }
+
+// deliverReset sends a ResetEvent to every subscription, under the lock.
+// Returns the number of subscriptions too full to take it.
+func (dev *Device) deliverReset(at time.Time) int {
+	var dropped int
+	for key, subs := range dev.subs {
+		ev := &ResetEvent{Service(key >> 16), uint16(key), at}
+		for _, ch := range subs {
+			select {
+			case ch <- ev:
+			default:
+				dropped++
+			}
+		}
+	}
+	return dropped
+}
+
+// ErrReset fails the Sends awaiting a response and the waits for an
+// indication which a Reconnect of the device lost
+type ErrReset string
+
+func (e ErrReset) Error() string {
+	return fmt.Sprintf("device %s was reset", string(e))
+}
+
+type Event interface{}
+
+// Events returns the stream of asynchronous device events. Events are
+// dropped if nobody keeps up with the stream.
+func (dev *Device) Events() <-chan Event {
+	return dev.events
+}
+
+func (dev *Device) emit(ev Event) {
+	select {
+	case dev.events <- ev:
+	default:
+	}
+}
+
+const DEFAULT_MESSAGE_TIMEOUT = 10 * time.Second
+
+// Messages which legitimately take longer than DEFAULT_MESSAGE_TIMEOUT.
+// These are defaults for every Device, to be set up before any is opened;
+// use WithMessageTimeout to override them for one device.
+var MessageTimeouts = map[Service]map[uint16]time.Duration{
+	QMI_SERVICE_NAS: {
+		0x0021: 3 * time.Minute, // Network Scan
+	},
+	QMI_SERVICE_WDS: {
+		0x0020: time.Minute, // Start Network
+	},
+}
+
+func MessageTimeout(m Message) time.Duration {
+	if d, ok := MessageTimeouts[m.ServiceID()][m.MessageID()]; ok {
+		return d
+	}
+	return DEFAULT_MESSAGE_TIMEOUT
+}
+
+// WithMessageTimeout sets the timeout of Send for one message on this
+// device, overriding MessageTimeouts
+func WithMessageTimeout(svc Service, id uint16, d time.Duration) Option {
+	return func(dev *Device) {
+		if dev.timeouts == nil {
+			dev.timeouts = make(map[uint32]time.Duration)
+		}
+		dev.timeouts[messageKey(svc, id)] = d
+	}
+}
+
+func (dev *Device) messageTimeout(m Message) time.Duration {
+	if d, ok := dev.timeouts[messageKey(m.ServiceID(), m.MessageID())]; ok {
+		return d
+	}
+	return MessageTimeout(m)
+}
+
+// Ping checks that the modem still answers requests.
+func (dev *Device) Ping(ctx context.Context) error {
+	ctl, err := dev.GetService(QMI_SERVICE_CTL)
+	if err != nil {
+		return err
+	}
+	_, err = ctl.SendContext(ctx, &CTLGetVersionInfoInput{})
+	return err
+}
+
+type WatchdogConfig struct {
+	Interval  time.Duration
+	Threshold int
+
+	// Called after Threshold consecutive failed pings
+	OnWedged func(*WedgedEvent)
+	// Recovery action, e.g. (*Device).Reconnect
+	Recover func(*Device) error
+}
+
+type WedgedEvent struct {
+	Device   string
+	Failures int
+	Err      error
+}
+
+// WithWatchdog pings the modem every cfg.Interval once the device is
+// open, see WatchdogConfig
+func WithWatchdog(cfg WatchdogConfig) Option {
+	return func(dev *Device) {
+		if cfg.Interval <= 0 {
+			cfg.Interval = DEFAULT_MESSAGE_TIMEOUT
+		}
+		if cfg.Threshold <= 0 {
+			cfg.Threshold = 1
+		}
+		dev.watchdog = &cfg
+	}
+}
+
+func (dev *Device) runWatchdog(cfg WatchdogConfig) {
+	tick, stop := newTicker(cfg.Interval)
+	defer stop()
+
+	failures := 0
+	for {
+		select {
+		case <-dev.ctx.Done():
+			return
+		case <-tick:
+		}
+
+		dev.Lock()
+		busy := dev.longOps > 0
+		dev.Unlock()
+		if busy {
+			failures = 0
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(dev.ctx, cfg.Interval)
+		err := dev.Ping(ctx)
+		cancel()
+		if err == nil {
+			failures = 0
+			continue
+		}
+
+		failures++
+		if failures < cfg.Threshold {
+			continue
+		}
+
+		ev := &WedgedEvent{
+			Device:   dev.name,
+			Failures: failures,
+			Err:      err,
+		}
+		dev.emit(ev)
+		if cfg.OnWedged != nil {
+			cfg.OnWedged(ev)
+		}
+		if cfg.Recover != nil {
+			err = cfg.Recover(dev)
+			if err != nil {
+				dev.logf(dev.ctx, "dev %s: recovery failed: %s", dev.name, err)
+			}
+		}
+		failures = 0
+	}
+}
+
+// Linux cdc-wdm, include/linux/usb/cdc.h: USB_CDC_DMM_TYPE
+const USB_CDC_DMM_TYPE = 0x14
+
+// Buffer size qmi_wwan registers cdc-wdm with
+const DEFAULT_MAX_WRITE_SIZE = 4096
+
+// QMUX length field is uint16 and does not include the marker byte
+const QMUX_MAX_FRAME_SIZE = 0xffff + 1
+
+// detectMaxWriteSize looks up wMaxCommand of the CDC Device Management
+// functional descriptor of the USB device backing a cdc-wdm node.
+func detectMaxWriteSize(name string) int {
+	desc, err := ioutil.ReadFile(filepath.Join(
+		"/sys/class/usbmisc", filepath.Base(name), "device", "..", "descriptors",
+	))
+	if err != nil {
+		return DEFAULT_MAX_WRITE_SIZE
+	}
+
+	for i := 0; i+1 < len(desc); {
+		l := int(desc[i])
+		if l == 0 || i+l > len(desc) {
+			break
+		}
+		if l >= 7 && desc[i+1] == 0x24 && desc[i+2] == USB_CDC_DMM_TYPE {
+			if n := int(binary.LittleEndian.Uint16(desc[i+5:])); n > 0 {
+				return n
+			}
+		}
+		i += l
+	}
+
+	return DEFAULT_MAX_WRITE_SIZE
+}
+
+// SetMaxWriteSize overrides the maximum frame size accepted by the device.
+func (dev *Device) SetMaxWriteSize(n int) {
+	dev.Lock()
+	dev.maxWriteSize = n
+	dev.Unlock()
+}
+
+func (dev *Device) MaxWriteSize() int {
+	dev.Lock()
+	defer dev.Unlock()
+	return dev.maxWriteSize
+}
+
+type ErrFrameTooLarge struct {
+	Service   Service
+	MessageID uint16
+	Size      int
+	Limit     int
+}
+
+func (e *ErrFrameTooLarge) Error() string {
+	return fmt.Sprintf(
+		"%s message %x: frame size %d exceeds limit %d",
+		e.Service, e.MessageID, e.Size, e.Limit,
+	)
+}
+
+type ErrAlreadyClosed string
+
+func (e ErrAlreadyClosed) Error() string {
+	return fmt.Sprintf("device %s is already closed", string(e))
+}
+
+// The constructor registries below are filled by init() (or Register*
+// with -explicit-register) and shared by every Device; they must not be
+// changed once devices are open. Runtime state such as options, clients,
+// limiters and dynamic definitions lives on Device.
+
+// RegistryConflict is a registration of a constructor for a service and
+// ID which already had one of another type. The later registration wins.
+// For the "common-ref" registry it is a file of the package, Overrider,
+// generated with another definition of CommonRef than Previous: their TLVs
+// would be mis-sized, so nothing wins.
+type RegistryConflict struct {
+	Registry  string // "message", "indication", "request" or "common-ref"
+	Service   Service
+	ID        uint16
+	Vendor    uint16
+	CommonRef string
+	Previous  string
+	Overrider string
+}
+
+func (c RegistryConflict) String() string {
+	if c.Registry == "common-ref" {
+		return fmt.Sprintf("%s was generated with another definition of common-ref %q than %s, regenerate %s", c.Overrider, c.CommonRef, c.Previous, c.Overrider)
+	}
+	if c.Vendor != 0 {
+		return fmt.Sprintf("%s %s %x vendor %04x: %s overrides %s", c.Registry, c.Service, c.ID, c.Vendor, c.Overrider, c.Previous)
+	}
+	return fmt.Sprintf("%s %s %x: %s overrides %s", c.Registry, c.Service, c.ID, c.Overrider, c.Previous)
+}
+
+var registryConflicts []RegistryConflict
+
+// RegistryConflicts lists the constructors overridden so far
+func RegistryConflicts() []RegistryConflict {
+	return append([]RegistryConflict(nil), registryConflicts...)
+}
+
+// refusedConflicts are the conflicts devices refuse to open with: all of
+// them unless overrides are allowed, those of common-refs regardless
+func refusedConflicts(overrides bool) ErrRegistryConflicts {
+	var refused ErrRegistryConflicts
+	for _, c := range registryConflicts {
+		if !overrides || c.Registry == "common-ref" {
+			refused = append(refused, c)
+		}
+	}
+	return refused
+}
+
+type ErrRegistryConflicts []RegistryConflict
+
+func (e ErrRegistryConflicts) Error() string {
+	conflicts := make([]string, len(e))
+	for i, c := range e {
+		conflicts[i] = c.String()
+	}
+	return "conflicting registrations: " + strings.Join(conflicts, ", ")
+}
+
+// register adds the constructor to the registry, recording a conflict
+// instead of failing in init() when another type is already there
+func register(registry string, constructors map[Service]map[uint16]func() Message, f func() Message) {
+	m := f()
+	msgs, ok := constructors[m.ServiceID()]
+	if !ok {
+		msgs = make(map[uint16]func() Message)
+		constructors[m.ServiceID()] = msgs
+	}
+	if prev, ok := msgs[m.MessageID()]; ok {
+		previous := fmt.Sprintf("%T", prev())
+		overrider := fmt.Sprintf("%T", m)
+		if previous != overrider {
+			registryConflicts = append(registryConflicts, RegistryConflict{
+				Registry:  registry,
+				Service:   m.ServiceID(),
+				ID:        m.MessageID(),
+				Vendor:    messageVendor(m),
+				Previous:  previous,
+				Overrider: overrider,
+			})
+		}
+	}
+	msgs[m.MessageID()] = f
+}
+
+// VendorMessage is implemented by messages specific to a modem vendor
+type VendorMessage interface {
+	MessageVendor() uint16
+}
+
+// messageVendor returns the vendor ID of m, 0 for standard messages
+func messageVendor(m Message) uint16 {
+	if vm, ok := m.(VendorMessage); ok {
+		return vm.MessageVendor()
+	}
+	return 0
+}
+
+// UnansweredMessage is implemented by requests the modem never responds
+// to, like some acknowledgments: Send returns once they are written
+type UnansweredMessage interface {
+	NoResponse() bool
+}
+
+// expectsResponse tells whether Send waits for a response to m
+func expectsResponse(m Message) bool {
+	um, ok := m.(UnansweredMessage)
+	return !ok || !um.NoResponse()
+}
+
+// vendorRegistry returns the constructors of the vendor, creating them
+func vendorRegistry(registries map[uint16]map[Service]map[uint16]func() Message, vendor uint16) map[Service]map[uint16]func() Message {
+	constructors, ok := registries[vendor]
+	if !ok {
+		constructors = map[Service]map[uint16]func() Message{}
+		registries[vendor] = constructors
+	}
+	return constructors
+}
+
+var TLVConstructors = map[Service]map[uint16]func() Message{}
+
+// VendorConstructors and VendorRequestConstructors hold vendor specific
+// messages by vendor ID, apart from the standard ones sharing their IDs
+var VendorConstructors = map[uint16]map[Service]map[uint16]func() Message{}
+var VendorRequestConstructors = map[uint16]map[Service]map[uint16]func() Message{}
+
+func registerMessage(f func() Message) {
+	if vendor := messageVendor(f()); vendor != 0 {
+		register("message", vendorRegistry(VendorConstructors, vendor), f)
+		return
+	}
+	register("message", TLVConstructors, f)
+}
+
+// IndicationConstructors build unsolicited messages by service and
+// indication ID, which may coincide with a message ID of the service
+var IndicationConstructors = map[Service]map[uint16]func() Message{}
+
+func registerIndication(f func() Message) {
+	register("indication", IndicationConstructors, f)
+}
+
+// RequestConstructors build empty requests of registered messages, to
+// send them by name
+var RequestConstructors = map[Service]map[uint16]func() Message{}
+
+func registerRequest(f func() Message) {
+	if vendor := messageVendor(f()); vendor != 0 {
+		register("request", vendorRegistry(VendorRequestConstructors, vendor), f)
+		return
+	}
+	register("request", RequestConstructors, f)
+}
+
+// NewRequest returns an empty request of a registered message named as in
+// Manifest, with or without the Output suffix: "CTLGetVersionInfo"
+func NewRequest(name string) (Message, error) {
+	name = strings.TrimSuffix(name, "Output")
+	for _, m := range Manifest {
+		if strings.TrimSuffix(m.Name, "Output") != name {
+			continue
+		}
+		constructors := RequestConstructors
+		if m.Vendor != 0 {
+			constructors = VendorRequestConstructors[m.Vendor]
+		}
+		f, ok := constructors[m.Service][m.MessageID]
+		if !ok {
+			return nil, ErrUnregistered{m}
+		}
+		return f(), nil
+	}
+	return nil, fmt.Errorf("unknown message %q", name)
+}
+
+// CommandSet is the command line surface of a service: a request which
+// needs no input by command name, "get-ids"
+type CommandSet interface {
+	Service() Service
+	Commands() map[string]func() Message
+}
+
+var commandSets = map[Service]CommandSet{}
+
+// RegisterCommands adds the commands of a service to CommandSets. The
+// generated Register<Service>Commands call it, so a command line tool
+// links the services it registers and no others.
+func RegisterCommands(cs CommandSet) {
+	commandSets[cs.Service()] = cs
+}
+
+// CommandSets returns the registered command sets ordered by service
+func CommandSets() []CommandSet {
+	sets := make([]CommandSet, 0, len(commandSets))
+	for _, cs := range commandSets {
+		sets = append(sets, cs)
+	}
+	sort.Slice(sets, func(i, j int) bool { return sets[i].Service() < sets[j].Service() })
+	return sets
+}
+
+type ManifestEntry struct {
+	Service   Service
+	MessageID uint16
+	Vendor    uint16 // 0 for standard messages
+	Name      string
+}
+
+// Manifest lists every message generated into the package, whether or not
+// it is registered
+var Manifest []ManifestEntry
+
+func declareMessage(svc Service, id uint16, name string) {
+	Manifest = append(Manifest, ManifestEntry{svc, id, 0, name})
+}
+
+func declareVendorMessage(svc Service, id uint16, vendor uint16, name string) {
+	Manifest = append(Manifest, ManifestEntry{svc, id, vendor, name})
+}
+
+type serviceNames struct {
+	service string // "DMS"
+	names   map[uint16]string
+}
+
+// messageNames are the generated XMessageNames by service, for errors
+var messageNames = map[Service]serviceNames{}
+
+func declareMessageNames(svc Service, service string, names map[uint16]string) {
+	messageNames[svc] = serviceNames{service, names}
+}
+
+// messageName is the XMessageName of every service: "DMS Get IDs", or
+// "DMS 0x4b" when the ID is unknown
+func messageName(service string, names map[uint16]string, id uint16) string {
+	if name, ok := names[id]; ok {
+		return service + " " + name
+	}
+	return fmt.Sprintf("%s %#x", service, id)
+}
+
+// Direction tells requests, responses and indications sharing an ID apart
+type Direction uint8
+
+const (
+	DirectionRequest Direction = iota
+	DirectionResponse
+	DirectionIndication
+)
+
+func (d Direction) String() string {
+	switch d {
+	case DirectionRequest:
+		return "request"
+	case DirectionResponse:
+		return "response"
+	case DirectionIndication:
+		return "indication"
+	}
+	return fmt.Sprintf("Direction(%d)", uint8(d))
+}
+
+// TLVInfo describes a TLV of a message as the data files do: Format is
+// "guint8", "struct", "array" and so on
+type TLVInfo struct {
+	Tag    uint8
+	Name   string
+	Format string
+}
+
+// MessageInfo describes a registered message by its wire identifiers, for
+// tools dumping frames they do not decode
+type MessageInfo struct {
+	Service   Service
+	MessageID uint16
+	Vendor    uint16 // 0 for standard messages
+	Direction Direction
+	Name      string // Go type: "CTLAllocateCIDInput"
+	Title     string // as in the data files: "Allocate CID"
+	TLVs      []TLVInfo
+}
+
+// TLV returns the description of the TLV tagged tag
+func (mi MessageInfo) TLV(tag uint8) (TLVInfo, bool) {
+	for _, tlv := range mi.TLVs {
+		if tlv.Tag == tag {
+			return tlv, true
+		}
+	}
+	return TLVInfo{}, false
+}
+
+type messageInfoKey struct {
+	vendor    uint16
+	service   Service
+	id        uint16
+	direction Direction
+}
+
+var messageInfos = map[messageInfoKey]MessageInfo{}
+
+func registerMessageInfo(info MessageInfo) {
+	messageInfos[messageInfoKey{info.Vendor, info.Service, info.MessageID, info.Direction}] = info
+}
+
+// LookupMessage describes the registered standard message of svc with the
+// ID, in the direction
+func LookupMessage(svc Service, id uint16, direction Direction) (MessageInfo, bool) {
+	info, ok := messageInfos[messageInfoKey{0, svc, id, direction}]
+	return info, ok
+}
+
+// LookupVendorMessage prefers the message specific to the vendor, falling
+// back to the standard one like decoding does
+func LookupVendorMessage(vendor uint16, svc Service, id uint16, direction Direction) (MessageInfo, bool) {
+	if vendor != 0 && direction != DirectionIndication {
+		if info, ok := messageInfos[messageInfoKey{vendor, svc, id, direction}]; ok {
+			return info, true
+		}
+	}
+	return LookupMessage(svc, id, direction)
+}
+
+type ErrUnregistered []ManifestEntry
+
+func (e ErrUnregistered) Error() string {
+	names := make([]string, len(e))
+	for i, m := range e {
+		names[i] = fmt.Sprintf("%s (%s %x)", m.Name, m.Service, m.MessageID)
+	}
+	return "messages are not registered: " + strings.Join(names, ", ")
+}
+
+// VerifyRegistry reports conflicting registrations, unless allowed with
+// WithRegistryOverrides, files generated from different common-refs, and
+// generated messages which have no constructor in TLVConstructors and thus
+// fail to decode with ErrBadMessage. With -explicit-register only the
+// messages registered so far are expected to pass.
+func VerifyRegistry(opts ...Option) error {
+	dev := &Device{}
+	for _, opt := range opts {
+		opt(dev)
+	}
+	if refused := refusedConflicts(dev.registryOverrides); len(refused) > 0 {
+		return refused
+	}
+
+	var missing ErrUnregistered
+	for _, m := range Manifest {
+		constructors := TLVConstructors
+		if m.Vendor != 0 {
+			constructors = VendorConstructors[m.Vendor]
+		}
+		if _, ok := constructors[m.Service][m.MessageID]; !ok {
+			missing = append(missing, m)
+		}
+	}
+	if len(missing) > 0 {
+		return missing
+	}
+	return nil
+}
+
+type CommonTLV interface {
+	ReadFrom(*bytes.Buffer) error
+	TLVWriteTo(io.Writer) error
+	Size() int
+}
+
+var CommonTLVConstructors = map[string]func() CommonTLV{}
+
+func registerCommonTLV(name string, f func() CommonTLV) {
+	CommonTLVConstructors[name] = f
+}
+
+// commonRefLayout is the hash of a common-ref definition a generated file
+// was generated with
+type commonRefLayout struct {
+	hash string
+	file string
+}
+
+// The common-refs defined by the generated files, and those relied upon
+// before the file defining them was initialized
+var commonRefDefs = map[string]commonRefLayout{}
+var commonRefUses = map[string][]commonRefLayout{}
+
+func defineCommonRef(name, hash, file string) {
+	commonRefDefs[name] = commonRefLayout{hash, file}
+	for _, use := range commonRefUses[name] {
+		checkCommonRef(name, use)
+	}
+	delete(commonRefUses, name)
+}
+
+func useCommonRef(name, hash, file string) {
+	use := commonRefLayout{hash, file}
+	if _, ok := commonRefDefs[name]; !ok {
+		commonRefUses[name] = append(commonRefUses[name], use)
+		return
+	}
+	checkCommonRef(name, use)
+}
+
+// checkCommonRef records a conflict when files of the package were
+// generated from different definitions of a common-ref, which devices
+// refuse to open with
+func checkCommonRef(name string, use commonRefLayout) {
+	def := commonRefDefs[name]
+	if use.hash != def.hash {
+		registryConflicts = append(registryConflicts, RegistryConflict{
+			Registry:  "common-ref",
+			CommonRef: name,
+			Previous:  def.file,
+			Overrider: use.file,
+		})
+	}
+}
+
+type ErrInvalidEnum struct {
+	Type  string
+	Value string
+	Valid []string
+}
+
+func (e *ErrInvalidEnum) Error() string {
+	return fmt.Sprintf(
+		"invalid %s %q, expected one of: %s",
+		e.Type, e.Value, strings.Join(e.Valid, ", "),
+	)
+}
+
+// parseEnum finds s among names of enum typ case-insensitively
+func parseEnum(typ string, s string, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(name, s) {
+			return i, nil
+		}
+	}
+	return 0, &ErrInvalidEnum{
+		Type:  typ,
+		Value: s,
+		Valid: names,
+	}
+}
+
+type ErrBadMarker byte
+
+func (e ErrBadMarker) Error() string {
+	return fmt.Sprintf("bad marker: %x != 1", byte(e))
+}
+
+type ErrBadService Service
+
+func (e ErrBadService) Error() string {
+	return fmt.Sprintf("unexpected ServiceID: %x", byte(e))
+}
+
+// PartialDecodeError is returned along with a message whose TLVs were
+// decoded up to the failing one. With WithStrictEnums it also lists the
+// enum fields holding values the data files do not define; Err is nil
+// when the message decoded otherwise intact.
+type PartialDecodeError struct {
+	Tag      uint8
+	Name     string
+	Err      error
+	Warnings []UnknownEnumValue
+}
+
+func (e *PartialDecodeError) Error() string {
+	if e.Err == nil {
+		warnings := make([]string, len(e.Warnings))
+		for i, w := range e.Warnings {
+			warnings[i] = w.String()
+		}
+		return fmt.Sprintf("decode: unknown enum values %s", strings.Join(warnings, ", "))
+	}
+	if e.Name == "" {
+		return fmt.Sprintf("decode: %s", e.Err)
+	}
+	return fmt.Sprintf("decode TLV %#04x (%s): %s", e.Tag, e.Name, e.Err)
+}
+
+func (e *PartialDecodeError) Unwrap() error {
+	return e.Err
+}
+
+func decodeTLV(tag uint8, name string, read func() error) error {
+	err := read()
+	if err != nil {
+		return &PartialDecodeError{Tag: tag, Name: name, Err: err}
+	}
+	return nil
+}
+
+// Enum is implemented by the generated enum and flag types
+type Enum interface {
+	fmt.Stringer
+	IsValid() bool
+}
+
+// UnknownEnumValue is an enum field of a decoded message holding a value
+// outside the defined set, the raw value is kept
+type UnknownEnumValue struct {
+	Field string // e.g. "Info[1].Mode"
+	Value Enum
+}
+
+func (u UnknownEnumValue) String() string {
+	return fmt.Sprintf("%s=%s", u.Field, u.Value)
+}
+
+// unknownEnums lists the enum fields of m, nested ones included, holding
+// values IsValid rejects
+func unknownEnums(m Message) []UnknownEnumValue {
+	var unknown []UnknownEnumValue
+	walkEnums(reflect.ValueOf(m), "", &unknown)
+	return unknown
+}
+
+func walkEnums(v reflect.Value, path string, unknown *[]UnknownEnumValue) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			walkEnums(v.Elem(), path, unknown)
+		}
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			p := path
+			if !field.Anonymous && path == "" {
+				p = field.Name
+			} else if !field.Anonymous {
+				p = path + "." + field.Name
+			}
+			walkEnums(v.Field(i), p, unknown)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			walkEnums(v.Index(i), fmt.Sprintf("%s[%d]", path, i), unknown)
+		}
+	default:
+		if e, ok := v.Interface().(Enum); ok && !e.IsValid() {
+			*unknown = append(*unknown, UnknownEnumValue{Field: path, Value: e})
+		}
+	}
+}
+
+// partialMessage carries a PartialDecodeError to the waiting SendContext
+type partialMessage struct {
+	Message
+	err error
+}
+
+// ErrBadMessage is an ID without a registered message of the service
+type ErrBadMessage struct {
+	Service   Service
+	MessageID uint16
+}
+
+// Error names the message when it is generated, though not registered
+func (e ErrBadMessage) Error() string {
+	if sn, ok := messageNames[e.Service]; ok {
+		if name, ok := sn.names[e.MessageID]; ok {
+			return fmt.Sprintf("unexpected MessageID: %x (%s %s)", e.MessageID, sn.service, name)
+		}
+	}
+	return fmt.Sprintf("unexpected MessageID: %x", e.MessageID)
+}
+
+// parseFrame splits a QMUX frame into its addressing and TLV area
+func parseFrame(buf []byte) (svcid Service, cid uint32, msgid uint16, tlvs []byte, err error) {
+	if len(buf) < 12 {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	if buf[0] != 1 {
+		err = ErrBadMarker(buf[0])
+		return
+	}
+
+	qmuxlen := binary.LittleEndian.Uint16(buf[1:3])
+	if qmuxlen > uint16(len(buf)-1) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	buf = buf[0 : qmuxlen+1]
+
+	svcid = Service(buf[4])
+
+	var is_normal_svc int
+	var txid uint16
+	if svcid == QMI_SERVICE_CTL {
+		is_normal_svc = 0
+		txid = uint16(buf[7])
+	} else {
+		if len(buf) < 13 {
+			err = io.ErrUnexpectedEOF
+			return
+		}
+		is_normal_svc = 1
+		txid = binary.LittleEndian.Uint16(buf[7:9])
+	}
+
+	msgid = binary.LittleEndian.Uint16(buf[8+is_normal_svc:])
+	tlvlen := int(binary.LittleEndian.Uint16(buf[10+is_normal_svc:]))
+	if 12+is_normal_svc+tlvlen > len(buf) {
+		err = io.ErrUnexpectedEOF
+		return
+	}
+
+	cid = uint32(buf[5]) | uint32(txid)<<8
+	tlvs = buf[12+is_normal_svc : 12+is_normal_svc+tlvlen]
+	return
+}
+
+// isIndication tells unsolicited messages from responses by the SDU flags
+func isIndication(buf []byte) bool {
+	if len(buf) < 7 {
+		return false
+	}
+	if Service(buf[4]) == QMI_SERVICE_CTL {
+		return buf[6]&0x02 != 0
+	}
+	return buf[6]&0x04 != 0
+}
+
+// decodeMessage reads tlvs into result and stores it in dst. The message
+// is usable up to the failing TLV, if any.
+func decodeMessage(result Message, tlvs []byte, dst *Message) error {
+	err := result.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	*dst = result
+
+	if err != nil {
+		if _, ok := err.(*PartialDecodeError); !ok {
+			err = &PartialDecodeError{Err: err}
+		}
+	}
+	return err
+}
+
+func Unmarshal(buf []byte, dst *Message) (uint32, error) {
+	return UnmarshalVendor(buf, dst, 0)
+}
+
+// UnmarshalVendor is Unmarshal preferring messages specific to the vendor,
+// if not 0, over the standard ones sharing their IDs
+func UnmarshalVendor(buf []byte, dst *Message, vendor uint16) (uint32, error) {
+	svcid, cid, msgid, tlvs, err := parseFrame(buf)
+	if err != nil {
+		return 0, err
+	}
+
+	// indications without a generated type decode as the response
+	// sharing their ID, if any
+	if isIndication(buf) {
+		if cons, ok := IndicationConstructors[svcid][msgid]; ok {
+			return cid, decodeMessage(cons(), tlvs, dst)
+		}
+	}
+
+	if cons, ok := VendorConstructors[vendor][svcid][msgid]; ok && vendor != 0 {
+		return cid, decodeMessage(cons(), tlvs, dst)
+	}
+
+	msgs, ok := TLVConstructors[svcid]
+	if !ok {
+		return 0, ErrBadService(svcid)
+	}
+
+	cons, ok := msgs[msgid]
+	if !ok {
+		return 0, ErrBadMessage{svcid, msgid}
+	}
+
+	return cid, decodeMessage(cons(), tlvs, dst)
+}
+
+// Marshal builds a QMUX frame for m. Frames larger than limit (or than
+// the QMUX length field can describe) are rejected with ErrFrameTooLarge.
+func Marshal(m Message, clientID uint8, txid uint16, limit int) (*bytes.Buffer, error) {
+	svc := m.ServiceID()
+	var is_normal_svc int
+	if svc != QMI_SERVICE_CTL {
+		is_normal_svc = 1
+	}
+	tlv_buf := &bytes.Buffer{}
+	err := m.TLVsWriteTo(tlv_buf)
+	if err != nil {
+		return nil, err
+	}
+
+	size := tlv_buf.Len() + 12 + is_normal_svc
+	if limit <= 0 || limit > QMUX_MAX_FRAME_SIZE {
+		limit = QMUX_MAX_FRAME_SIZE
+	}
+	if size > limit {
+		return nil, &ErrFrameTooLarge{
+			Service:   svc,
+			MessageID: m.MessageID(),
+			Size:      size,
+			Limit:     limit,
+		}
+	}
+
+	buf := &bytes.Buffer{}
+	buf.Grow(size)
+	buf.Write([]byte{1}) // marker
+	binary.Write(buf, binary.LittleEndian, uint16(size-1))
+	buf.Write([]byte{0, uint8(svc), clientID, 0})
+
+	if svc != QMI_SERVICE_CTL {
+		binary.Write(buf, binary.LittleEndian, txid)
+	} else {
+		buf.Write([]byte{uint8(txid & 0xff)})
+	}
+	binary.Write(buf, binary.LittleEndian, m.MessageID())
+	binary.Write(buf, binary.LittleEndian, uint16(tlv_buf.Len()))
+
+	_, err = tlv_buf.WriteTo(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+type Stats struct {
+	WriteStalls uint64
+
+	Throttled    uint64        // sends delayed by a rate limit
+	ThrottleWait time.Duration // total time spent waiting for them
+
+	DuplicateTLVs      uint64 // ignored instances of non-repeatable tags
+	DuplicateResponses uint64 // response frames the modem delivered twice
+	UnknownResponses   uint64 // responses to no pending Send, late or unasked
+
+	// with WithMaxInFlight: transactions holding a slot, and sends
+	// waiting for one by service
+	InFlight      int
+	Queued        map[Service]int
+	Admitted      uint64        // sends let through after queueing
+	AdmissionWait time.Duration // total time they spent queued
+
+	// Round trips of answered requests, on the monotonic clock, split at
+	// the moment the response was read off the device: the modem's share
+	// and our own dispatch
+	Responses       uint64
+	ModemLatency    time.Duration // total from write to reading the frame
+	DispatchLatency time.Duration // total from reading the frame to Send
+
+	Clients []ClientInfo
+}
+
+func (dev *Device) Stats() Stats {
+	dev.Lock()
+	stats := dev.stats
+	if a := dev.admission; a != nil {
+		stats.InFlight = a.inflight
+		stats.Queued = make(map[Service]int, len(a.queues))
+		for svc, q := range a.queues {
+			stats.Queued[svc] = len(q)
+		}
+	}
+	dev.Unlock()
+
+	stats.Clients = dev.Clients()
+	return stats
+}
+
+// unregister drops the response channel ch of cid, unless a Reconnect
+// replaced it meanwhile, dev must be locked
+func (dev *Device) unregister(cid uint32, ch chan Message) {
+	if dev.ch[cid] != ch {
+		return
+	}
+	delete(dev.ch, cid)
+	delete(dev.readAt, cid)
+}
+
+// observeLatency accounts a response read at dev.readAt[cid] to a request
+// sent at sent and received by Send at done, dev must be locked
+func (dev *Device) observeLatency(cid uint32, sent, done time.Time) {
+	read, ok := dev.readAt[cid]
+	if !ok {
+		return
+	}
+	delete(dev.readAt, cid)
+
+	dev.stats.Responses++
+	dev.stats.ModemLatency += read.Sub(sent)
+	dev.stats.DispatchLatency += done.Sub(read)
+}
+
+const DEFAULT_DUPLICATE_WINDOW = 2 * time.Second
+
+// responseKey identifies a response frame by the client and message it
+// answers and a hash of its bytes, transaction ID included
+type responseKey struct {
+	service Service
+	client  uint8
+	id      uint16
+	sum     uint64
+}
+
+func newResponseKey(msg Message, frame []byte) responseKey {
+	h := fnv.New64a()
+	h.Write(frame)
+	return responseKey{msg.ServiceID(), frame[5], msg.MessageID(), h.Sum64()}
+}
+
+// duplicateResponse tells a repeat of a response handed to a waiting Send
+// within the last dev.dupWindow, dev must be locked
+func (dev *Device) duplicateResponse(key responseKey, now time.Time) bool {
+	for k, at := range dev.completed {
+		if now.Sub(at) > dev.dupWindow {
+			delete(dev.completed, k)
+		}
+	}
+
+	_, ok := dev.completed[key]
+	if ok {
+		dev.stats.DuplicateResponses++
+	}
+	return ok
+}
+
+// completeResponse remembers a response handed to a waiting Send, dev
+// must be locked
+func (dev *Device) completeResponse(key responseKey, now time.Time) {
+	if dev.dupWindow <= 0 {
+		return
+	}
+	if dev.completed == nil {
+		dev.completed = make(map[responseKey]time.Time)
+	}
+	dev.completed[key] = now
+}
+
+func (dev *Device) writeStalled() {
+	dev.Lock()
+	dev.stats.WriteStalls++
+	dev.Unlock()
+}
+
+// Rate allows Burst requests at once and one more every Interval
+type Rate struct {
+	Interval time.Duration
+	Burst    int
+}
+
+// limiter is a token bucket
+type limiter struct {
+	rate   Rate
+	tokens float64
+	last   time.Time
+	sync.Mutex
+}
+
+// reserve takes a token and returns how long to wait until it is due
+func (l *limiter) reserve(now time.Time) time.Duration {
+	l.Lock()
+	defer l.Unlock()
+
+	burst := float64(l.rate.Burst)
+	if burst < 1 {
+		burst = 1
+	}
+	if l.last.IsZero() {
+		l.tokens = burst
+	} else {
+		l.tokens += float64(now.Sub(l.last)) / float64(l.rate.Interval)
+		if l.tokens > burst {
+			l.tokens = burst
+		}
+	}
+	l.last = now
+
+	l.tokens--
+	if l.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-l.tokens * float64(l.rate.Interval))
+}
+
+// unreserve returns a token taken by a send which gave up waiting
+func (l *limiter) unreserve() {
+	l.Lock()
+	l.tokens++
+	l.Unlock()
+}
+
+// WithFailedResponses makes Send return the decoded response along with
+// the QMIError of a failed operation, e.g. to read the PIN retries left
+// after INCORRECT_PIN. Callers must check err first: the response is then
+// non-nil even though the operation failed.
+func WithFailedResponses() Option {
+	return func(dev *Device) {
+		dev.failedResponses = true
+	}
+}
+
+// WithRegistryOverrides lets the device open when constructors were
+// registered over others of the same service and ID, see RegistryConflicts.
+// Files generated from different common-refs are refused regardless.
+func WithRegistryOverrides() Option {
+	return func(dev *Device) {
+		dev.registryOverrides = true
+	}
+}
+
+// WithTransactionSeed makes the transaction IDs of every client count up
+// from seed+1, for tests which compare frames byte by byte
+func WithTransactionSeed(seed uint16) Option {
+	return func(dev *Device) {
+		dev.txSeed = seed
+	}
+}
+
+// WithVendor decodes responses with the messages specific to the modem
+// vendor where they share IDs with standard ones
+func WithVendor(vendor uint16) Option {
+	return func(dev *Device) {
+		dev.vendor = vendor
+	}
+}
+
+// WithDuplicateWindow sets how long a response handed to a Send is
+// remembered to drop repeated deliveries of the same frame, 0 turns the
+// check off. The default is DEFAULT_DUPLICATE_WINDOW.
+func WithDuplicateWindow(d time.Duration) Option {
+	return func(dev *Device) {
+		dev.dupWindow = d
+	}
+}
+
+// WithStrictTLVs makes responses repeating a non-repeatable tag fail with
+// ErrDuplicateTLV instead of keeping the first instance
+func WithStrictTLVs() Option {
+	return func(dev *Device) {
+		dev.strictTLVs = true
+	}
+}
+
+// WithStrictEnums reports enum fields of decoded messages holding values
+// the data files do not define: the message is delivered as decoded, along
+// with a PartialDecodeError listing them in Warnings
+func WithStrictEnums() Option {
+	return func(dev *Device) {
+		dev.strictEnums = true
+	}
+}
+
+// WithRateLimit paces requests to svc. CTL requests are never limited.
+func WithRateLimit(svc Service, r Rate) Option {
+	return func(dev *Device) {
+		if dev.limiters == nil {
+			dev.limiters = make(map[Service]*limiter)
+		}
+		dev.limiters[svc] = &limiter{rate: r}
+	}
+}
+
+// WithDefaultRateLimit paces requests to services without a WithRateLimit
+func WithDefaultRateLimit(r Rate) Option {
+	return func(dev *Device) {
+		dev.defaultRate = &r
+	}
+}
+
+// The clock of the runtime, which tests replace. Tickers and timers are
+// returned as their channel and Stop.
+var (
+	timeNow   = time.Now
+	newTicker = func(d time.Duration) (<-chan time.Time, func()) {
+		t := time.NewTicker(d)
+		return t.C, t.Stop
+	}
+	newTimer = func(d time.Duration) (<-chan time.Time, func()) {
+		t := time.NewTimer(d)
+		return t.C, func() { t.Stop() }
+	}
+)
+
+// throttle waits until a request to svc is allowed or ctx is done
+func (dev *Device) throttle(ctx context.Context, svc Service) error {
+	if svc == QMI_SERVICE_CTL {
+		return nil
+	}
+
+	dev.Lock()
+	l := dev.limiters[svc]
+	if l == nil && dev.defaultRate != nil {
+		if dev.limiters == nil {
+			dev.limiters = make(map[Service]*limiter)
+		}
+		l = &limiter{rate: *dev.defaultRate}
+		dev.limiters[svc] = l
+	}
+	dev.Unlock()
+	if l == nil || l.rate.Interval <= 0 {
+		return nil
+	}
+
+	wait := l.reserve(timeNow())
+	if wait <= 0 {
+		return nil
+	}
+
+	dev.Lock()
+	dev.stats.Throttled++
+	dev.stats.ThrottleWait += wait
+	dev.Unlock()
+
+	timer, stop := newTimer(wait)
+	defer stop()
+
+	select {
+	case <-timer:
+		return nil
+	case <-ctx.Done():
+		l.unreserve()
+		return ctx.Err()
+	}
+}
+
+// WithMaxInFlight allows at most n transactions outstanding at once. Sends
+// over the limit queue per service and are admitted round-robin across
+// services, so a chatty one cannot starve the rest. CTL requests are never
+// limited.
+func WithMaxInFlight(n int) Option {
+	return func(dev *Device) {
+		if n <= 0 {
+			dev.admission = nil
+			return
+		}
+		dev.admission = &admission{
+			max:    n,
+			queues: make(map[Service][]chan struct{}),
+		}
+	}
+}
+
+// admission hands out the slots of WithMaxInFlight, guarded by the Device
+// lock. ready channels are closed on admission.
+type admission struct {
+	max      int
+	inflight int
+
+	queues map[Service][]chan struct{}
+	order  []Service // services with queued sends, in round-robin order
+	next   int
+}
+
+// admitNext passes free slots to the queued sends, a service at a time
+func (a *admission) admitNext() {
+	for a.inflight < a.max && len(a.order) > 0 {
+		if a.next >= len(a.order) {
+			a.next = 0
+		}
+		svc := a.order[a.next]
+		q := a.queues[svc]
+		close(q[0])
+		a.inflight++
+
+		if len(q) == 1 {
+			delete(a.queues, svc)
+			a.order = append(a.order[:a.next], a.order[a.next+1:]...)
+		} else {
+			a.queues[svc] = q[1:]
+			a.next++
+		}
+	}
+}
+
+// remove drops a queued send which gave up, false if it was admitted
+// meanwhile
+func (a *admission) remove(svc Service, ready chan struct{}) bool {
+	q := a.queues[svc]
+	for i, r := range q {
+		if r != ready {
+			continue
+		}
+		if len(q) > 1 {
+			a.queues[svc] = append(q[:i:i], q[i+1:]...)
+			return true
+		}
+		delete(a.queues, svc)
+		for j, o := range a.order {
+			if o != svc {
+				continue
+			}
+			a.order = append(a.order[:j], a.order[j+1:]...)
+			if a.next > j {
+				a.next--
+			}
+			break
+		}
+		return true
+	}
+	return false
+}
+
+// admit waits for a WithMaxInFlight slot for a request to svc, the
+// returned func gives it back
+func (dev *Device) admit(ctx context.Context, svc Service) (func(), error) {
+	dev.Lock()
+	a := dev.admission
+	if a == nil || svc == QMI_SERVICE_CTL {
+		dev.Unlock()
+		return func() {}, nil
+	}
+
+	release := func() {
+		dev.Lock()
+		a.inflight--
+		a.admitNext()
+		dev.Unlock()
+	}
+
+	if a.inflight < a.max && len(a.order) == 0 {
+		a.inflight++
+		dev.Unlock()
+		return release, nil
+	}
+
+	ready := make(chan struct{})
+	if _, ok := a.queues[svc]; !ok {
+		a.order = append(a.order, svc)
+	}
+	a.queues[svc] = append(a.queues[svc], ready)
+	queued_at := timeNow()
+	dev.Unlock()
+
+	select {
+	case <-ready:
+		wait := timeNow().Sub(queued_at)
+		dev.Lock()
+		dev.stats.Admitted++
+		dev.stats.AdmissionWait += wait
+		dev.Unlock()
+		return release, nil
+	case <-ctx.Done():
+		dev.Lock()
+		queued := a.remove(svc, ready)
+		dev.Unlock()
+		if !queued {
+			release()
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// Writes that may be stuck on a stalled endpoint at the same time
+const MAX_PENDING_WRITES = 16
+
+// write sends a frame to the device, giving up once ctx is done. The write
+// deadline is used where the file supports it; otherwise the write runs in
+// a separate goroutine which is left behind if ctx expires. The deadline is
+// the file's rather than the write's, so writes take turns and clear it
+// when done.
+func (dev *Device) write(ctx context.Context, b []byte) error {
+	dev.Lock()
+	f := dev.f
+	dev.Unlock()
+	if f == nil {
+		return ErrAlreadyClosed(dev.name)
+	}
+
+	if dev.recorder != nil {
+		dev.recorder.request(b)
+	}
+	if dev.tap != nil {
+		dev.tap(DirectionRequest, b)
+	}
+
+	deadline, ok := ctx.Deadline()
+	if ok {
+		select {
+		case dev.writeTurn <- struct{}{}:
+		case <-ctx.Done():
+			dev.writeStalled()
+			return ctx.Err()
+		}
+		if f.SetWriteDeadline(deadline) != nil {
+			<-dev.writeTurn
+			ok = false
+		}
+	}
+	if ok {
+		_, err := f.Write(b)
+		f.SetWriteDeadline(time.Time{})
+		<-dev.writeTurn
+		if os.IsTimeout(err) {
+			dev.writeStalled()
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// the deadline is the context's, whose timer may
+			// not have fired yet
+			return context.DeadlineExceeded
+		}
+		return err
+	}
+
+	select {
+	case dev.writeSlots <- struct{}{}:
+	case <-ctx.Done():
+		dev.writeStalled()
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		// not to run into the deadline of another write
+		dev.writeTurn <- struct{}{}
+		_, err := f.Write(b)
+		<-dev.writeTurn
+		<-dev.writeSlots
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		dev.writeStalled()
+		return ctx.Err()
+	}
+}
+
+// Interaction is a recorded request frame and the response to it
+type Interaction struct {
+	Request  string
+	Response string // empty for requests never answered
+}
+
+// Cassette records QMI interactions of a real device and replays them
+// to a Device under test. Requests are matched ignoring client and
+// transaction IDs; Normalize makes recordings comparable.
+type Cassette struct {
+	Interactions []Interaction
+
+	pending map[uint32][]byte
+	used    map[int]bool
+
+	sync.Mutex
+}
+
+func WithRecorder(c *Cassette) Option {
+	return func(dev *Device) {
+		dev.recorder = c
+	}
+}
+
+func LoadCassette(path string) (*Cassette, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cassette{}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cassette) Save(path string) error {
+	c.Lock()
+	b, err := json.MarshalIndent(c, "", "\t")
+	c.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b, 0644)
+}
+
+// frameTxID returns the offset and width of the transaction ID
+func frameTxID(frame []byte) (int, int) {
+	if Service(frame[4]) == QMI_SERVICE_CTL {
+		return 7, 1
+	}
+	return 7, 2
+}
+
+func frameCID(frame []byte) uint32 {
+	off, width := frameTxID(frame)
+	txid := uint32(frame[off])
+	if width == 2 {
+		txid = uint32(binary.LittleEndian.Uint16(frame[off:]))
+	}
+	return uint32(frame[5]) | txid<<8
+}
+
+func normalizeFrame(frame []byte) string {
+	b := append([]byte(nil), frame...)
+	if len(b) >= 9 {
+		off, width := frameTxID(b)
+		b[5] = 0
+		for i := 0; i < width; i++ {
+			b[off+i] = 0
+		}
+	}
+	return hex.EncodeToString(b)
+}
+
+func (c *Cassette) request(frame []byte) {
+	if len(frame) < 9 {
+		return
+	}
+
+	c.Lock()
+	if c.pending == nil {
+		c.pending = make(map[uint32][]byte)
+	}
+	c.pending[frameCID(frame)] = append([]byte(nil), frame...)
+	c.Unlock()
+}
+
+// unanswered records a request sent without waiting for a response, which
+// replay then leaves unanswered too
+func (c *Cassette) unanswered(frame []byte) {
+	if len(frame) < 9 {
+		return
+	}
+
+	c.Lock()
+	delete(c.pending, frameCID(frame))
+	c.Interactions = append(c.Interactions, Interaction{
+		Request: hex.EncodeToString(frame),
+	})
+	c.Unlock()
+}
+
+func (c *Cassette) response(cid uint32, frame []byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	req, ok := c.pending[cid]
+	if !ok {
+		return
+	}
+	delete(c.pending, cid)
+
+	c.Interactions = append(c.Interactions, Interaction{
+		Request:  hex.EncodeToString(req),
+		Response: hex.EncodeToString(frame),
+	})
+}
+
+// Normalize rewrites client IDs, including those in CTL Allocate and
+// Release CID, and transaction IDs to sequences in order of appearance:
+// clients of a service count from 1, as do transactions of a client.
+// A request and its response keep sharing their IDs, so recordings of
+// the same session compare equal however the IDs were assigned.
+func (c *Cassette) Normalize() error {
+	c.Lock()
+	defer c.Unlock()
+
+	type client struct {
+		svc Service
+		cid uint8
+	}
+	type tx struct {
+		client
+		txid uint16
+	}
+	cids := map[client]uint8{}
+	nextCID := map[Service]uint8{}
+	txids := map[tx]uint16{}
+	nextTx := map[client]uint16{}
+
+	mapCID := func(svc Service, cid uint8) uint8 {
+		k := client{svc, cid}
+		if n, ok := cids[k]; ok {
+			return n
+		}
+		nextCID[svc]++
+		cids[k] = nextCID[svc]
+		return cids[k]
+	}
+
+	normalize := func(s string) (string, error) {
+		b, err := hex.DecodeString(s)
+		if err != nil {
+			return "", err
+		}
+		if len(b) < 12 {
+			return s, nil
+		}
+
+		svc := Service(b[4])
+		off, width := frameTxID(b)
+		if svc == QMI_SERVICE_CTL {
+			// Allocate CID response, Release CID request and response:
+			// TLV 0x01 holds the service and its client ID
+			msgid := binary.LittleEndian.Uint16(b[8:])
+			if msgid == 0x0022 || msgid == 0x0023 {
+				for i := 12; i+3 <= len(b); {
+					l := int(binary.LittleEndian.Uint16(b[i+1:]))
+					if b[i] == 0x01 && l == 2 && i+5 <= len(b) {
+						b[i+4] = mapCID(Service(b[i+3]), b[i+4])
+					}
+					i += 3 + l
+				}
+			}
+		} else {
+			b[5] = mapCID(svc, b[5])
+		}
+
+		var txid uint16
+		if width == 2 {
+			txid = binary.LittleEndian.Uint16(b[off:])
+		} else {
+			txid = uint16(b[off])
+		}
+		k := tx{client{svc, b[5]}, txid}
+		n, ok := txids[k]
+		if !ok {
+			nextTx[k.client]++
+			n = nextTx[k.client]
+			txids[k] = n
+		}
+		if width == 2 {
+			binary.LittleEndian.PutUint16(b[off:], n)
+		} else {
+			b[off] = uint8(n)
+		}
+
+		return hex.EncodeToString(b), nil
+	}
+
+	for i := range c.Interactions {
+		req, err := normalize(c.Interactions[i].Request)
+		if err != nil {
+			return fmt.Errorf("interaction %d: %w", i, err)
+		}
+		resp, err := normalize(c.Interactions[i].Response)
+		if err != nil {
+			return fmt.Errorf("interaction %d: %w", i, err)
+		}
+		c.Interactions[i].Request = req
+		c.Interactions[i].Response = resp
+	}
+	return nil
+}
+
+// lookup returns the first unused recorded response to req, falling back
+// to the last matching one; ok without a response when req was recorded
+// unanswered
+func (c *Cassette) lookup(req []byte) (resp []byte, ok bool) {
+	key := normalizeFrame(req)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.used == nil {
+		c.used = make(map[int]bool)
+	}
+
+	found := -1
+	for i, it := range c.Interactions {
+		b, err := hex.DecodeString(it.Request)
+		if err != nil || normalizeFrame(b) != key {
+			continue
+		}
+		found = i
+		if !c.used[i] {
+			break
+		}
+	}
+	if found < 0 {
+		return nil, false
+	}
+	c.used[found] = true
+
+	if c.Interactions[found].Response == "" {
+		return nil, true
+	}
+	resp, err := hex.DecodeString(c.Interactions[found].Response)
+	if err != nil || len(resp) < 9 {
+		return nil, false
+	}
+
+	off, width := frameTxID(resp)
+	resp[5] = req[5]
+	copy(resp[off:off+width], req[off:off+width])
+	return resp, true
+}
+
+// Serve answers requests read from f with recorded responses until f is
+// closed by the other side.
+func (c *Cassette) Serve(f *os.File) error {
+	defer f.Close()
+
+	buf := make([]byte, QMUX_MAX_FRAME_SIZE)
+	for {
+		n, err := f.Read(buf)
+		if err != nil {
+			return err
+		}
+
+		if n < 9 {
+			continue
+		}
+
+		resp, ok := c.lookup(buf[:n])
+		if !ok {
+			log.Printf("cassette: no recorded response for %x", buf[:n])
+		}
+		if resp == nil {
+			continue
+		}
+
+		_, err = f.Write(resp)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Replay opens a Device backed by the recorded interactions
+func (c *Cassette) Replay(opts ...Option) (*Device, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_SEQPACKET|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	modem := os.NewFile(uintptr(fds[1]), "cassette-modem")
+	go c.Serve(modem)
+
+	return NewDevice(os.NewFile(uintptr(fds[0]), "cassette"), "cassette", opts...)
+}
+
+func (dev *Device) reader(f *os.File) {
+	var msg Message
+	var cid uint32
+
+	buf := make([]byte, 2048)
+	offset := 0
+
+	for {
+		select {
+		case <-dev.ctx.Done():
+			return
+		default:
+		}
+
+		n, err := f.Read(buf[offset:])
+		read_at := timeNow()
+		if err != nil {
+			dev.Lock()
+			replaced := dev.f != f
+			if !replaced {
+				dev.err = err
+			}
+			dev.Unlock()
+			if replaced {
+				return
+			}
+			dev.Close()
+			return
+		}
+
+		if buf[offset] != 1 {
+			offset = 0
+		} else {
+			offset += n
+		}
+
+		cid, err = dev.unmarshal(buf[0:offset], &msg)
+		partial, _ := err.(*PartialDecodeError)
+		if partial != nil {
+			err = nil
+		}
+		if err == io.EOF {
+			continue
+		}
+		if dev.tap != nil {
+			direction := DirectionResponse
+			if isIndication(buf[0:offset]) {
+				direction = DirectionIndication
+			}
+			dev.tap(direction, buf[0:offset])
+		}
+		if err == nil {
+			if dev.recorder != nil {
+				dev.recorder.response(cid, buf[0:offset])
+			}
+
+			indication := isIndication(buf[0:offset])
+			var key responseKey
+			dev.Lock()
+			// some firmware delivers a response twice: the second
+			// copy would complete a later Send reusing the txid
+			duplicate := false
+			if !indication {
+				key = newResponseKey(msg, buf[0:offset])
+				duplicate = dev.duplicateResponse(key, read_at)
+			}
+			ch := dev.ch[cid]
+			if ch != nil && !indication && !duplicate {
+				if dev.readAt == nil {
+					dev.readAt = make(map[uint32]time.Time)
+				}
+				dev.readAt[cid] = read_at
+				dev.completeResponse(key, read_at)
+			}
+			unknown := ch == nil && !indication && !duplicate
+			if unknown {
+				dev.stats.UnknownResponses++
+			}
+			dev.Unlock()
+
+			switch {
+			case duplicate:
+				dev.logf(dev.ctx, "dev %s: dropped duplicate %T txid %d", dev.name, msg, cid>>8)
+			case ch == nil || indication:
+				if unknown {
+					dev.logf(dev.ctx, "dev %s: %T txid %d answers no pending request", dev.name, msg, cid>>8)
+				}
+				// delivered as decoded: a result TLV of an
+				// indication is an ordinary field, not an error
+				if partial != nil && len(partial.Warnings) > 0 {
+					dev.logf(dev.ctx, "dev %s: %T: %s", dev.name, msg, partial)
+				}
+				dev.dispatch(f, msg)
+			case partial != nil:
+				ch <- &partialMessage{msg, partial}
+			default:
+				ch <- msg
+			}
+		} else {
+			dev.logf(dev.ctx, "dev %s: unmarshal failed: %s", dev.name, err)
+		}
+
+		offset = 0
+	}
+}
+
+func (dev *Device) Close() error {
+	// under the lock, the reader tells Close from a failing file by
+	// dev.f
+	dev.Lock()
+	f := dev.f
+	if f == nil {
+		dev.Unlock()
+		return ErrAlreadyClosed(dev.name)
+	}
+	dev.f = nil
+	dev.clients = nil
+	dev.Unlock()
+
+	dev.cancel()
+	return f.Close()
+}
+
+type ErrShuttingDown string
+
+func (e ErrShuttingDown) Error() string {
+	return fmt.Sprintf("device %s is shutting down", string(e))
+}
+
+// ShutdownError is a step Shutdown could not finish
+type ShutdownError struct {
+	Step string
+	Err  error
+}
+
+type ErrShutdown []ShutdownError
+
+func (e ErrShutdown) Error() string {
+	steps := make([]string, len(e))
+	for i, step := range e {
+		steps[i] = fmt.Sprintf("%s: %s", step.Step, step.Err)
+	}
+	return "shutdown incomplete: " + strings.Join(steps, "; ")
+}
+
+type shutdownKey struct{}
+
+// deviceHook is the teardown or re-arm of a component
+type deviceHook struct {
+	name string
+	f    func(context.Context) error
+}
+
+// OnShutdown registers teardown of a component, such as a data session,
+// run by Shutdown after in-flight transactions. Hooks run in reverse
+// order of registration and may still send with the context they get.
+func (dev *Device) OnShutdown(name string, f func(ctx context.Context) error) {
+	dev.Lock()
+	dev.shutdownHooks = append(dev.shutdownHooks, deviceHook{name, f})
+	dev.Unlock()
+}
+
+// Shutdown stops the device in order: new sends fail with
+// ErrShuttingDown, in-flight transactions are waited for, OnShutdown
+// hooks run, client IDs are released, subscriptions are closed and the
+// watchdog stopped, and the device file is closed. Steps which fail or
+// are cut short by ctx are reported in ErrShutdown; the file is closed
+// regardless.
+func (dev *Device) Shutdown(ctx context.Context) error {
+	var failed ErrShutdown
+	ctx = context.WithValue(ctx, shutdownKey{}, true)
+
+	dev.Lock()
+	if dev.f == nil {
+		dev.Unlock()
+		return ErrAlreadyClosed(dev.name)
+	}
+	dev.shuttingDown = true
+	hooks := dev.shutdownHooks
+	dev.Unlock()
+
+	err := dev.drain(ctx)
+	if err != nil {
+		failed = append(failed, ShutdownError{"drain", err})
+	}
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		err := hooks[i].f(ctx)
+		if err != nil {
+			failed = append(failed, ShutdownError{hooks[i].name, err})
+		}
+	}
+
+	for _, c := range dev.Clients() {
+		if c.Service == QMI_SERVICE_CTL {
+			continue
+		}
+		err := dev.releaseClient(ctx, c.Service)
+		if err != nil {
+			failed = append(failed, ShutdownError{"release " + c.Service.String(), err})
+		}
+	}
+
+	dev.Lock()
+	for _, subs := range dev.subs {
+		for _, ch := range subs {
+			close(ch)
+		}
+	}
+	dev.subs = nil
+	dev.Unlock()
+	dev.cancel()
+
+	err = dev.Close()
+	if err != nil {
+		failed = append(failed, ShutdownError{"close", err})
+	}
+
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// drain waits for the transactions in flight
+func (dev *Device) drain(ctx context.Context) error {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		dev.Lock()
+		n := dev.inflight
+		dev.Unlock()
+		if n == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d transactions in flight: %w", n, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// releaseClient returns the client ID of svc to the modem
+func (dev *Device) releaseClient(ctx context.Context, svc Service) error {
+	dev.Lock()
+	client := dev.clients[svc]
+	dev.Unlock()
+	if client == nil {
+		return nil
+	}
+
+	ctl, _ := dev.GetService(QMI_SERVICE_CTL)
+	req := &CTLReleaseCIDInput{}
+	req.ReleaseInfo.Service = uint8(svc)
+	req.ReleaseInfo.CID = client.ClientID
+	_, err := ctl.SendContext(ctx, req)
+	if err != nil {
+		return err
+	}
+
+	dev.Lock()
+	delete(dev.clients, svc)
+	dev.Unlock()
+	return nil
+}
+
+func (dev *Device) GetService(service Service) (*Client, error) {
+	dev.Lock()
+	if dev.clients == nil {
+		dev.Unlock()
+		return nil, ErrAlreadyClosed(dev.name)
+	}
+	client, ok := dev.clients[service]
+	dev.Unlock()
+
+	if ok {
+		return client, nil
+	}
+
+	client = &Client{
+		Device:        dev,
+		Service:       service,
+		TransactionID: dev.txSeed,
+	}
+
+	ctl, _ := dev.GetService(QMI_SERVICE_CTL)
+	resp, err := ctl.Send(&CTLAllocateCIDInput{Service: uint8(service)})
+	if err != nil {
+		return nil, err
+	}
+
+	client.ClientID = resp.(*CTLAllocateCIDOutput).AllocationInfo.CID
+	client.allocatedAt = timeNow()
+
+	dev.Lock()
+	defer dev.Unlock()
+	if dev.clients == nil {
+		return nil, ErrAlreadyClosed(dev.name)
+	}
+	dev.clients[service] = client
+
+	return client, nil
+}
+
+// AdoptClient registers a client ID allocated by a previous process
+// instead of allocating a new one.
+func (dev *Device) AdoptClient(service Service, cid uint8) *Client {
+	client := &Client{
+		Device:      dev,
+		ClientID:    cid,
+		Service:     service,
+		allocatedAt: timeNow(),
+	}
+
+	dev.Lock()
+	if dev.clients != nil {
+		dev.clients[service] = client
+	}
+	dev.Unlock()
+
+	return client
+}
+
+// SessionState describes a data session which outlives the process
+type SessionState struct {
+	Device           string
+	APN              string
+	PacketDataHandle uint32
+	MuxID            uint8
+	Service          Service
+	ClientID         uint8
+}
+
+// SaveSessionState atomically replaces the state file at path
+func SaveSessionState(path string, st *SessionState) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// LoadSessionState returns nil if there is no state file at path
+func LoadSessionState(path string) (*SessionState, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	st := &SessionState{}
+	err = json.Unmarshal(b, st)
+	if err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func ClearSessionState(path string) error {
+	err := os.Remove(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// RestoreSession adopts the client of a persisted session. If verify
+// reports the session is gone, the state file is removed and nil is
+// returned, so the caller can tear down with the returned client or
+// start over.
+func (dev *Device) RestoreSession(path string, verify func(*Client, *SessionState) error) (*Client, *SessionState, error) {
+	st, err := LoadSessionState(path)
+	if err != nil || st == nil {
+		return nil, nil, err
+	}
+
+	if st.Device != dev.name {
+		return nil, nil, nil
+	}
+
+	client := dev.AdoptClient(st.Service, st.ClientID)
+	if verify != nil {
+		err = verify(client, st)
+		if err != nil {
+			return client, nil, ClearSessionState(path)
+		}
+	}
+
+	return client, st, nil
+}
+
+func messageKey(svc Service, id uint16) uint32 {
+	return uint32(svc)<<16 | uint32(id)
+}
+
+// Subscribe delivers unsolicited messages (indications) with the given
+// service and message ID to the returned channel until cancel is called.
+// The subscription survives Reconnect, which delivers a *ResetEvent.
+func (dev *Device) Subscribe(svc Service, id uint16) (<-chan Message, func()) {
+	ch := make(chan Message, 16)
+	key := messageKey(svc, id)
+
+	dev.Lock()
+	if dev.subs == nil {
+		dev.subs = make(map[uint32][]chan Message)
+	}
+	dev.subs[key] = append(dev.subs[key], ch)
+	dev.Unlock()
+
+	return ch, func() {
+		dev.Lock()
+		defer dev.Unlock()
+		subs := dev.subs[key]
+		for i, sub := range subs {
+			if sub == ch {
+				dev.subs[key] = append(subs[:i:i], subs[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// dispatch delivers an indication read from f, unless Reconnect replaced
+// f meanwhile: the subscriptions got their ResetEvent already.
+func (dev *Device) dispatch(f *os.File, msg Message) {
+	var dropped int
+
+	// under the lock, Shutdown closes the channels
+	dev.Lock()
+	if dev.f != f {
+		dev.Unlock()
+		return
+	}
+	for _, ch := range dev.subs[messageKey(msg.ServiceID(), msg.MessageID())] {
+		select {
+		case ch <- msg:
+		default:
+			dropped++
+		}
+	}
+	dev.Unlock()
+
+	for i := 0; i < dropped; i++ {
+		dev.logf(dev.ctx, "dev %s: dropped %T", dev.name, msg)
+	}
+}
+
+// TokenFlow describes a request which is acknowledged with a token and
+// answered later by an indication carrying the same token. The token is
+// taken from the response, or from the request when RequestToken is set:
+// some services echo a token chosen by the client. Tokens are compared
+// with reflect.DeepEqual.
+type TokenFlow struct {
+	IndicationID    uint16
+	RequestToken    func(Message) (interface{}, bool)
+	ResponseToken   func(Message) (interface{}, bool)
+	IndicationToken func(Message) (interface{}, bool)
+}
+
+// tokenFlows register the flows generated from the data files with every
+// new Device
+var tokenFlows []func(*Device)
+
+func addTokenFlows(register func(*Device)) {
+	tokenFlows = append(tokenFlows, register)
+}
+
+// tokenOf returns the token held in a field of a message, false for an
+// optional field left out
+func tokenOf(v interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, false
+		}
+		return rv.Elem().Interface(), true
+	}
+	return v, true
+}
+
+type ErrNoToken uint16
+
+func (e ErrNoToken) Error() string {
+	return fmt.Sprintf("no token in message %x or its response", uint16(e))
+}
+
+type ErrNoTokenFlow uint16
+
+func (e ErrNoTokenFlow) Error() string {
+	return fmt.Sprintf("no token flow registered for message %x", uint16(e))
+}
+
+func (dev *Device) RegisterTokenFlow(svc Service, requestID uint16, flow *TokenFlow) {
+	dev.Lock()
+	if dev.flows == nil {
+		dev.flows = make(map[uint32]*TokenFlow)
+	}
+	dev.flows[messageKey(svc, requestID)] = flow
+	dev.Unlock()
+}
+
+// SendAndWait sends m and returns the indication which completes it
+// according to the registered TokenFlow.
+func (dev *Device) SendAndWait(ctx context.Context, m Message) (Message, error) {
+	dev.Lock()
+	flow := dev.flows[messageKey(m.ServiceID(), m.MessageID())]
+	dev.Unlock()
+	if flow == nil {
+		return nil, ErrNoTokenFlow(m.MessageID())
+	}
+
+	// subscribe first, the indication may overtake the response
+	inds, cancel := dev.Subscribe(m.ServiceID(), flow.IndicationID)
+	defer cancel()
+
+	var token interface{}
+	ok := false
+	if flow.RequestToken != nil {
+		token, ok = flow.RequestToken(m)
+		if !ok {
+			return nil, ErrNoToken(m.MessageID())
+		}
+	}
+
+	resp, err := dev.SendContext(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+
+	if flow.RequestToken == nil {
+		token, ok = flow.ResponseToken(resp)
+		if !ok {
+			return nil, ErrNoToken(m.MessageID())
+		}
+	}
+
+	for {
+		select {
+		case ind := <-inds:
+			if _, ok := ind.(*ResetEvent); ok {
+				return nil, ErrReset(dev.name)
+			}
+			if t, ok := flow.IndicationToken(ind); ok && reflect.DeepEqual(t, token) {
+				return ind, nil
+			}
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (dev *Device) Send(m Message) (resp Message, err error) {
+	client, err := dev.GetService(m.ServiceID())
+	if err != nil {
+		return nil, err
+	}
+
+	return client.Send(m)
+}
+
+func (dev *Device) SendContext(ctx context.Context, m Message) (resp Message, err error) {
+	client, err := dev.GetService(m.ServiceID())
+	if err != nil {
+		return nil, err
+	}
+
+	return client.SendContext(ctx, m)
+}
+
+// GatherItem is a request of Gather: either Message, or the Name of a
+// generated message sent with empty input
+type GatherItem struct {
+	Message Message
+	Name    string
+}
+
+// ErrGather holds the errors of a Gather call by item index, nil for the
+// items which succeeded
+type ErrGather []error
+
+func (e ErrGather) Error() string {
+	var failed []string
+	for i, err := range e {
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("#%d: %s", i, err))
+		}
+	}
+	return fmt.Sprintf("%d of %d requests failed: %s", len(failed), len(e), strings.Join(failed, "; "))
+}
+
+// Gather sends independent requests concurrently and returns responses in
+// item order. Requests to the same service go one after another, different
+// services in parallel, subject to rate limits like SendContext. If any
+// item fails the error is ErrGather and the other responses are still
+// returned.
+func Gather(ctx context.Context, dev *Device, items ...GatherItem) ([]Message, error) {
+	reqs := make([]Message, len(items))
+	resps := make([]Message, len(items))
+	errs := make(ErrGather, len(items))
+
+	queues := map[Service][]int{}
+	var services []Service
+	for i, item := range items {
+		m := item.Message
+		if m == nil {
+			var err error
+			m, err = NewRequest(item.Name)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+		reqs[i] = m
+
+		svc := m.ServiceID()
+		if _, ok := queues[svc]; !ok {
+			services = append(services, svc)
+		}
+		queues[svc] = append(queues[svc], i)
+	}
+
+	// clients are allocated up front, concurrent GetService calls for one
+	// service would allocate a client ID each
+	var wg sync.WaitGroup
+	for _, svc := range services {
+		client, err := dev.GetService(svc)
+		if err != nil {
+			for _, i := range queues[svc] {
+				errs[i] = err
+			}
+			continue
+		}
+
+		wg.Add(1)
+		go func(client *Client, queue []int) {
+			defer wg.Done()
+			for _, i := range queue {
+				resps[i], errs[i] = client.SendContext(ctx, reqs[i])
+			}
+		}(client, queues[svc])
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return resps, errs
+		}
+	}
+	return resps, nil
+}
+
+// Aborter undoes a request abandoned by its context, e.g. sends WDS Abort
+// for a pending Start Network, or Stop Network if late carries a response
+// with a packet data handle. The late channel yields the response should
+// it still arrive.
+type Aborter func(client *Client, txid uint16, late <-chan Message) error
+
+// RegisterAborter makes SendContext run a before returning when a request
+// with the given id is cancelled, so no modem side state is left behind.
+func (dev *Device) RegisterAborter(svc Service, requestID uint16, a Aborter) {
+	dev.Lock()
+	if dev.aborters == nil {
+		dev.aborters = make(map[uint32]Aborter)
+	}
+	dev.aborters[messageKey(svc, requestID)] = a
+	dev.Unlock()
+}
+
+type ErrAbortFailed struct {
+	Err   error // why the request was abandoned
+	Abort error
+}
+
+func (e *ErrAbortFailed) Error() string {
+	return fmt.Sprintf("%s, abort failed: %s", e.Err, e.Abort)
+}
+
+func (e *ErrAbortFailed) Unwrap() error {
+	return e.Err
+}
+
+// messageField returns the field name of m, seeing through an optional
+// pointer, false if m has no such field or it was left out
+func messageField(m Message, name string) (reflect.Value, bool) {
+	if p, ok := m.(*partialMessage); ok {
+		m = p.Message
+	}
+	v := reflect.ValueOf(m)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return reflect.Value{}, false
+	}
+	f := v.Elem().FieldByName(name)
+	if f.Kind() == reflect.Ptr {
+		if f.IsNil() {
+			return reflect.Value{}, false
+		}
+		f = f.Elem()
+	}
+	return f, f.IsValid()
+}
+
+// setMessageField sets the field name of m to v, allocating an optional
+// pointer
+func setMessageField(m Message, name string, v interface{}) error {
+	f := reflect.ValueOf(m).Elem().FieldByName(name)
+	if !f.IsValid() {
+		return fmt.Errorf("%T has no field %s", m, name)
+	}
+	if f.Kind() == reflect.Ptr {
+		f.Set(reflect.New(f.Type().Elem()))
+		f = f.Elem()
+	}
+	rv := reflect.ValueOf(v)
+	if !rv.Type().ConvertibleTo(f.Type()) {
+		return fmt.Errorf("%T.%s is %s, not %T", m, name, f.Type(), v)
+	}
+	f.Set(rv.Convert(f.Type()))
+	return nil
+}
+
+// ConnectionManager starts and stops WDS data sessions of a device and
+// keeps track of them, so that none is left behind by a cancelled Connect.
+// The WDS messages are looked up by name, the package must be generated
+// with WDS Start Network, Stop Network and Abort.
+type ConnectionManager struct {
+	dev *Device
+
+	sync.Mutex
+	sessions map[uint32]bool // by packet data handle
+}
+
+// NewConnectionManager registers the aborter of WDS Start Network with
+// dev, and a Shutdown hook stopping the sessions left
+func NewConnectionManager(dev *Device) (*ConnectionManager, error) {
+	var start Message
+	for _, name := range []string{"WDSAbort", "WDSStopNetwork", "WDSStartNetwork"} {
+		m, err := NewRequest(name)
+		if err != nil {
+			return nil, err
+		}
+		start = m
+	}
+
+	cm := &ConnectionManager{dev: dev, sessions: map[uint32]bool{}}
+	dev.RegisterAborter(start.ServiceID(), start.MessageID(), cm.abortStart)
+	dev.OnShutdown("data sessions", cm.shutdown)
+	return cm, nil
+}
+
+// shutdown stops the sessions left when the device shuts down
+func (cm *ConnectionManager) shutdown(ctx context.Context) error {
+	var first error
+	for _, handle := range cm.Sessions() {
+		err := cm.Disconnect(ctx, handle)
+		if err != nil && first == nil {
+			first = fmt.Errorf("session %d: %w", handle, err)
+		}
+	}
+	return first
+}
+
+// Connect starts a data session with apn, that of the default profile if
+// empty, and returns its packet data handle. If ctx ends first, Start
+// Network is aborted, or the session stopped if the modem is past that.
+func (cm *ConnectionManager) Connect(ctx context.Context, apn string) (uint32, error) {
+	start, err := NewRequest("WDSStartNetwork")
+	if err != nil {
+		return 0, err
+	}
+	if apn != "" {
+		err = setMessageField(start, "APN", apn)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	resp, err := cm.dev.SendContext(ctx, start)
+	if err != nil {
+		return 0, err
+	}
+	handle, ok := messageField(resp, "PacketDataHandle")
+	if !ok {
+		return 0, fmt.Errorf("no packet data handle in %T", resp)
+	}
+
+	cm.Lock()
+	cm.sessions[uint32(handle.Uint())] = true
+	cm.Unlock()
+	return uint32(handle.Uint()), nil
+}
+
+// Disconnect stops the session of handle
+func (cm *ConnectionManager) Disconnect(ctx context.Context, handle uint32) error {
+	client, err := cm.dev.GetService(QMI_SERVICE_WDS)
+	if err != nil {
+		return err
+	}
+	return cm.stop(ctx, client, handle)
+}
+
+// Sessions returns the handles of the sessions started and not stopped
+func (cm *ConnectionManager) Sessions() []uint32 {
+	cm.Lock()
+	defer cm.Unlock()
+	handles := make([]uint32, 0, len(cm.sessions))
+	for handle := range cm.sessions {
+		handles = append(handles, handle)
+	}
+	sort.Slice(handles, func(i, j int) bool { return handles[i] < handles[j] })
+	return handles
+}
+
+func (cm *ConnectionManager) stop(ctx context.Context, client *Client, handle uint32) error {
+	stop, err := NewRequest("WDSStopNetwork")
+	if err != nil {
+		return err
+	}
+	err = setMessageField(stop, "PacketDataHandle", handle)
+	if err != nil {
+		return err
+	}
+	_, err = client.SendContext(ctx, stop)
+	if err != nil {
+		return err
+	}
+
+	cm.Lock()
+	delete(cm.sessions, handle)
+	cm.Unlock()
+	return nil
+}
+
+// abortStart is the Aborter of Start Network: WDS Abort of its
+// transaction, or Stop Network once the late response has a handle
+func (cm *ConnectionManager) abortStart(client *Client, txid uint16, late <-chan Message) error {
+	ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_MESSAGE_TIMEOUT)
+	defer cancel()
+
+	select {
+	case resp := <-late:
+		return cm.stopLate(ctx, client, resp)
+	default:
+	}
+
+	abort, err := NewRequest("WDSAbort")
+	if err != nil {
+		return err
+	}
+	err = setMessageField(abort, "TransactionID", txid)
+	if err != nil {
+		return err
+	}
+	_, err = client.SendContext(ctx, abort)
+	if err == nil {
+		return nil
+	}
+
+	// too late to abort, the session comes up
+	select {
+	case resp := <-late:
+		return cm.stopLate(ctx, client, resp)
+	case <-ctx.Done():
+		return err
+	}
+}
+
+// stopLate stops the session of a late Start Network response, if any
+func (cm *ConnectionManager) stopLate(ctx context.Context, client *Client, resp Message) error {
+	if p, ok := resp.(*partialMessage); ok {
+		resp = p.Message
+	}
+	if op, ok := resp.(QMIOperation); ok && op.OperationResult().ErrorStatus != 0 {
+		return nil
+	}
+	handle, ok := messageField(resp, "PacketDataHandle")
+	if !ok {
+		return nil
+	}
+	return cm.stop(ctx, client, uint32(handle.Uint()))
+}
+
+type fieldsKey struct{}
+
+// ContextWithFields attaches key/value pairs to ctx which are appended to
+// log lines and errors of transactions sent with it.
+func ContextWithFields(ctx context.Context, kv ...interface{}) context.Context {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	merged := make([]interface{}, 0, len(fields)+len(kv))
+	merged = append(merged, fields...)
+	merged = append(merged, kv...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// FieldsFromContext returns the pairs attached by ContextWithFields
+func FieldsFromContext(ctx context.Context) []interface{} {
+	fields, _ := ctx.Value(fieldsKey{}).([]interface{})
+	return fields
+}
+
+func formatFields(fields []interface{}) string {
+	var b strings.Builder
+	for i := 0; i < len(fields); i += 2 {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		if i+1 < len(fields) {
+			fmt.Fprintf(&b, "%v=%v", fields[i], fields[i+1])
+		} else {
+			fmt.Fprintf(&b, "%v=?", fields[i])
+		}
+	}
+	return b.String()
+}
+
+// Logf logs like log.Printf with the fields of ctx appended
+func Logf(ctx context.Context, format string, args ...interface{}) {
+	fields := FieldsFromContext(ctx)
+	if len(fields) == 0 {
+		log.Printf(format, args...)
+		return
+	}
+	log.Printf(format+" [%s]", append(args, formatFields(fields))...)
+}
+
+// WithFrameTap calls tap with every QMUX frame written to the device, as a
+// request, and read from it, for tracing. The frame is only valid during
+// the call, which holds up the reader.
+func WithFrameTap(tap func(direction Direction, frame []byte)) Option {
+	return func(dev *Device) {
+		dev.tap = tap
+	}
+}
+
+// WithLogger sends log lines of the device to l instead of the standard
+// logger
+func WithLogger(l *log.Logger) Option {
+	return func(dev *Device) {
+		dev.logger = l
+	}
+}
+
+// logf is Logf through the logger of the device
+func (dev *Device) logf(ctx context.Context, format string, args ...interface{}) {
+	if dev.logger == nil {
+		Logf(ctx, format, args...)
+		return
+	}
+	fields := FieldsFromContext(ctx)
+	if len(fields) == 0 {
+		dev.logger.Printf(format, args...)
+		return
+	}
+	dev.logger.Printf(format+" [%s]", append(args, formatFields(fields))...)
+}
+
+type ErrWithFields struct {
+	Err    error
+	Fields []interface{}
+}
+
+func (e *ErrWithFields) Error() string {
+	return fmt.Sprintf("%s [%s]", e.Err, formatFields(e.Fields))
+}
+
+func (e *ErrWithFields) Unwrap() error {
+	return e.Err
+}
+
+func (client *Client) Send(m Message) (resp Message, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Device.messageTimeout(m))
+	defer cancel()
+
+	return client.SendContext(ctx, m)
+}
+
+func (client *Client) SendContext(ctx context.Context, m Message) (resp Message, err error) {
+	defer func() {
+		if err == nil {
+			return
+		}
+		if fields := FieldsFromContext(ctx); len(fields) > 0 {
+			err = &ErrWithFields{Err: err, Fields: fields}
+		}
+	}()
+
+	// the response would decode as the standard message
+	if vendor := messageVendor(m); vendor != 0 && vendor != client.Device.vendor {
+		err = fmt.Errorf("%T is specific to vendor %04x, the device is opened for %04x", m, vendor, client.Device.vendor)
+		return
+	}
+
+	client.Device.Lock()
+	if client.Device.f == nil {
+		client.Device.Unlock()
+		err = ErrAlreadyClosed(client.Device.name)
+		return
+	}
+	if client.Device.shuttingDown && ctx.Value(shutdownKey{}) == nil {
+		client.Device.Unlock()
+		err = ErrShuttingDown(client.Device.name)
+		return
+	}
+	client.Device.inflight++
+	client.Device.Unlock()
+	defer func() {
+		client.Device.Lock()
+		client.Device.inflight--
+		client.Device.Unlock()
+	}()
+
+	err = client.Device.throttle(ctx, m.ServiceID())
+	if err != nil {
+		return
+	}
+
+	release, err := client.Device.admit(ctx, m.ServiceID())
+	if err != nil {
+		return
+	}
+	defer release()
+
+	client.Lock()
+	client.TransactionID += 1
+	// CTL frames carry an 8-bit transaction ID, and 0 is never used
+	if client.Service == QMI_SERVICE_CTL && client.TransactionID > 0xff || client.TransactionID == 0 {
+		client.TransactionID = 1
+	}
+	client.txCount++
+	txid := client.TransactionID
+	cid := uint32(client.ClientID) | uint32(txid)<<8
+	client.Unlock()
+
+	buf, err := Marshal(m, client.ClientID, txid, client.Device.MaxWriteSize())
+	if err != nil {
+		return
+	}
+
+	// nothing to wait for: a response arriving anyway is unknown
+	if !expectsResponse(m) {
+		err = client.Device.write(ctx, buf.Bytes())
+		if err == nil && client.Device.recorder != nil {
+			client.Device.recorder.unanswered(buf.Bytes())
+		}
+		return
+	}
+
+	client.Device.Lock()
+	ch_ := client.Device.ch[cid]
+	ch := make(chan Message, 1)
+	client.Device.ch[cid] = ch
+	reset := client.Device.reset
+	client.Device.Unlock()
+
+	if ch_ != nil {
+		panic(fmt.Sprintf(
+			"dev %s: race @ cid %x",
+			client.Device.name,
+			cid,
+		))
+	}
+
+	if client.Device.messageTimeout(m) > DEFAULT_MESSAGE_TIMEOUT {
+		client.Device.Lock()
+		client.Device.longOps++
+		client.Device.Unlock()
+
+		defer func() {
+			client.Device.Lock()
+			client.Device.longOps--
+			client.Device.Unlock()
+		}()
+	}
+
+	err = client.Device.write(ctx, buf.Bytes())
+	if err != nil {
+		client.Device.Lock()
+		client.Device.unregister(cid, ch)
+		client.Device.Unlock()
+		return
+	}
+	sent_at := timeNow()
+
+	select {
+	case resp = <-ch:
+	case <-reset:
+		err = ErrReset(client.Device.name)
+		return
+	case <-ctx.Done():
+		client.Device.Lock()
+		abort := client.Device.aborters[messageKey(m.ServiceID(), m.MessageID())]
+		if abort == nil {
+			client.Device.unregister(cid, ch)
+		}
+		client.Device.Unlock()
+
+		client.Device.logf(ctx, "dev %s: abandoned %T txid %d: %s", client.Device.name, m, txid, ctx.Err())
+		err = ctx.Err()
+		if abort == nil {
+			return
+		}
+
+		// ch stays registered so the aborter sees a late response
+		abort_err := abort(client, txid, ch)
+		client.Device.Lock()
+		client.Device.unregister(cid, ch)
+		client.Device.Unlock()
+		if abort_err != nil {
+			client.Device.logf(ctx, "dev %s: abort of %T txid %d failed: %s", client.Device.name, m, txid, abort_err)
+			err = &ErrAbortFailed{Err: err, Abort: abort_err}
+		}
+		return
+	}
+
+	client.Device.Lock()
+	close(ch)
+	if client.Device.ch[cid] == ch {
+		client.Device.observeLatency(cid, sent_at, timeNow())
+	}
+	client.Device.unregister(cid, ch)
+	client.Device.Unlock()
+
+	// a failed operation takes precedence over a decode error
+	if p, ok := resp.(*partialMessage); ok {
+		resp = p.Message
+		err = p.err
+	}
+
+	op, ok := resp.(QMIOperation)
+	if ok {
+		op_result := op.OperationResult()
+		if op_result.ErrorStatus != 0 {
+			if !client.Device.failedResponses {
+				resp = nil
+			}
+			code := QMIError(op_result.ErrorCode)
+			err = code
+			if _, ok := serviceErrors[m.ServiceID()][code]; ok {
+				err = ServiceError{m.ServiceID(), code}
+			}
+		}
+	}
+
+	return
+}
+
+// Interpreted messages, for iterating on definitions without regenerating
+// the package. Not intended for production use.
+
+// dynamicField is the runtime form of a TLV or field definition
+type dynamicField struct {
+	Name       string
+	Format     string
+	ID         uint8
+	Contents   []*dynamicField
+	Element    *dynamicField
+	SizePrefix string
+	FixedSize  int
+	Encoding   string // string-encoding
+}
+
+// stringEncoding mirrors the generator: strings in records and with a
+// size prefix are prefixed by default, others span the rest of the TLV
+func (f *dynamicField) stringEncoding(in_record bool) string {
+	if f.Encoding != "" {
+		return f.Encoding
+	}
+	if in_record || f.SizePrefix != "" {
+		return "prefixed"
+	}
+	return "none"
+}
+
+type dynamicDef struct {
+	Name    string
+	Service Service
+	ID      uint16
+	Input   []*dynamicField
+	Output  []*dynamicField
+}
+
+func parseDynamicField(m map[string]interface{}) (*dynamicField, error) {
+	f := &dynamicField{}
+	f.Name, _ = m["name"].(string)
+	f.Format, _ = m["format"].(string)
+	f.SizePrefix, _ = m["size-prefix-format"].(string)
+	f.Encoding, _ = m["string-encoding"].(string)
+	switch f.Encoding {
+	case "", "none", "prefixed", "nul-terminated":
+	default:
+		return nil, fmt.Errorf("field %q: string encoding %q is unsupported", f.Name, f.Encoding)
+	}
+	if f.Format == "" {
+		return nil, fmt.Errorf("field %q: common-ref and empty formats are not supported", f.Name)
+	}
+
+	if id, ok := m["id"].(string); ok {
+		var v uint64
+		_, err := fmt.Sscanf(id, "0x%x", &v)
+		if err != nil || v > 0xff {
+			return nil, fmt.Errorf("TLV %q: bad id %q", f.Name, id)
+		}
+		f.ID = uint8(v)
+	}
+
+	if n, ok := m["fixed-size"].(string); ok {
+		_, err := fmt.Sscanf(n, "%d", &f.FixedSize)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: bad fixed-size %q", f.Name, n)
+		}
+	}
+
+	if contents, ok := m["contents"].([]interface{}); ok {
+		for _, c := range contents {
+			cm, ok := c.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("field %q: contents must be objects", f.Name)
+			}
+			sub, err := parseDynamicField(cm)
+			if err != nil {
+				return nil, err
+			}
+			f.Contents = append(f.Contents, sub)
+		}
+	}
+
+	if elem, ok := m["array-element"].(map[string]interface{}); ok {
+		var err error
+		f.Element, err = parseDynamicField(elem)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func parseDynamicTLVs(l interface{}) ([]*dynamicField, error) {
+	items, _ := l.([]interface{})
+	var tlvs []*dynamicField
+	for _, item := range items {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("TLVs must be objects")
+		}
+		if _, ok := m["common-ref"]; ok {
+			// Operation Result and friends are skipped
+			continue
+		}
+		f, err := parseDynamicField(m)
+		if err != nil {
+			return nil, err
+		}
+		tlvs = append(tlvs, f)
+	}
+	return tlvs, nil
+}
+
+// LoadDefinitions interprets the messages of a service JSON data file
+// (plain JSON, without comments). Responses to them which have no
+// generated type decode into *DynamicMessage.
+func (dev *Device) LoadDefinitions(r io.Reader) error {
+	var entities []map[string]interface{}
+	err := json.NewDecoder(r).Decode(&entities)
+	if err != nil {
+		return err
+	}
+
+	services := map[string]Service{}
+	for svc, name := range ServiceMap {
+		services[strings.TrimPrefix(name, "QMI_SERVICE_")] = svc
+	}
+
+	defs := map[uint32]*dynamicDef{}
+	names := map[string]*dynamicDef{}
+	for _, e := range entities {
+		if e["type"] != "Message" {
+			continue
+		}
+
+		def := &dynamicDef{}
+		def.Name, _ = e["name"].(string)
+		svc, _ := e["service"].(string)
+		id, _ := e["id"].(string)
+
+		var ok bool
+		def.Service, ok = services[svc]
+		if !ok {
+			return fmt.Errorf("message %q: unknown service %q", def.Name, svc)
+		}
+		var v uint64
+		_, err = fmt.Sscanf(id, "0x%x", &v)
+		if err != nil || v > 0xffff {
+			return fmt.Errorf("message %q: bad id %q", def.Name, id)
+		}
+		def.ID = uint16(v)
+
+		def.Input, err = parseDynamicTLVs(e["input"])
+		if err != nil {
+			return fmt.Errorf("message %q: %w", def.Name, err)
+		}
+		def.Output, err = parseDynamicTLVs(e["output"])
+		if err != nil {
+			return fmt.Errorf("message %q: %w", def.Name, err)
+		}
+
+		defs[messageKey(def.Service, def.ID)] = def
+		names[svc+" "+def.Name] = def
+	}
+
+	dev.Lock()
+	if dev.dynamic == nil {
+		dev.dynamic = map[uint32]*dynamicDef{}
+		dev.dynamicNames = map[string]*dynamicDef{}
+	}
+	for k, def := range defs {
+		dev.dynamic[k] = def
+	}
+	for k, def := range names {
+		dev.dynamicNames[k] = def
+	}
+	dev.Unlock()
+
+	return nil
+}
+
+// DynamicMessage is a message of a definition loaded at runtime. Fields
+// are keyed by TLV name; sequences and structs are maps keyed by field
+// name and arrays are []interface{}.
+type DynamicMessage struct {
+	def    *dynamicDef
+	output bool
+	Fields map[string]interface{}
+}
+
+// NewDynamic returns an empty request for the loaded message named like
+// "WDS Get Profile List"
+func (dev *Device) NewDynamic(name string) (*DynamicMessage, error) {
+	dev.Lock()
+	def := dev.dynamicNames[name]
+	dev.Unlock()
+	if def == nil {
+		return nil, fmt.Errorf("no definition loaded for %q", name)
+	}
+	return &DynamicMessage{def: def, Fields: map[string]interface{}{}}, nil
+}
+
+func (m *DynamicMessage) ServiceID() Service {
+	return m.def.Service
+}
+
+func (m *DynamicMessage) MessageID() uint16 {
+	return m.def.ID
+}
+
+func (m *DynamicMessage) tlvs() []*dynamicField {
+	if m.output {
+		return m.def.Output
+	}
+	return m.def.Input
+}
+
+func (m *DynamicMessage) TLVsWriteTo(w io.Writer) error {
+	b := &tlv.Builder{}
+	for _, f := range m.tlvs() {
+		v, ok := m.Fields[f.Name]
+		if !ok {
+			continue
+		}
+		buf := &bytes.Buffer{}
+		err := f.encode(buf, v, false)
+		if err != nil {
+			return fmt.Errorf("TLV %q: %w", f.Name, err)
+		}
+		b.Add(f.ID, buf.Bytes())
+	}
+
+	tlvs, err := b.Bytes()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(tlvs)
+	return err
+}
+
+func (m *DynamicMessage) TLVsReadFrom(r *bytes.Buffer) error {
+	m.Fields = map[string]interface{}{}
+	for _, f := range m.tlvs() {
+		b := findTag(r, f.ID)
+		if b == nil {
+			continue
+		}
+		err := decodeTLV(f.ID, f.Name, func() (err error) {
+			m.Fields[f.Name], err = f.decode(b, false)
+			return
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return checkTLVs(r)
+}
+
+func dynamicSize(format string) int {
+	switch strings.TrimPrefix(format, "g") {
+	case "int8", "uint8", "byte", "boolean":
+		return 1
+	case "int16", "uint16":
+		return 2
+	case "int32", "uint32", "float":
+		return 4
+	case "int64", "uint64", "double":
+		return 8
+	}
+	return 0
+}
+
+func (f *dynamicField) readPrefix(b *bytes.Buffer) (int, error) {
+	if f.SizePrefix == "guint16" {
+		var n uint16
+		err := binary.Read(b, binary.LittleEndian, &n)
+		return int(n), err
+	}
+	var n uint8
+	err := binary.Read(b, binary.LittleEndian, &n)
+	return int(n), err
+}
+
+func (f *dynamicField) writePrefix(w *bytes.Buffer, n int) error {
+	if f.SizePrefix == "guint16" {
+		return binary.Write(w, binary.LittleEndian, uint16(n))
+	}
+	if n > 0xff {
+		return fmt.Errorf("%d elements do not fit the size prefix", n)
+	}
+	return binary.Write(w, binary.LittleEndian, uint8(n))
+}
+
+func (f *dynamicField) decode(b *bytes.Buffer, in_record bool) (interface{}, error) {
+	switch strings.TrimPrefix(f.Format, "g") {
+	case "uint8", "byte":
+		var v uint8
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "int8":
+		var v int8
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "boolean":
+		var v bool
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "uint16":
+		var v uint16
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "int16":
+		var v int16
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "uint32":
+		var v uint32
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "int32":
+		var v int32
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "uint64":
+		var v uint64
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "int64":
+		var v int64
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "float":
+		var v float32
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "double":
+		var v float64
+		err := binary.Read(b, binary.LittleEndian, &v)
+		return v, err
+	case "string":
+		if f.FixedSize > 0 {
+			return readFixedString(b, f.FixedSize)
+		}
+		switch f.stringEncoding(in_record) {
+		case "none":
+			return string(b.Next(b.Len())), nil
+		case "nul-terminated":
+			s, err := b.ReadString(0)
+			if err == io.EOF && !in_record {
+				// the terminator is optional at the end of the TLV
+				return s, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			return s[:len(s)-1], nil
+		}
+		n, err := f.readPrefix(b)
+		if err != nil {
+			return nil, err
+		}
+		return readString(b, n)
+	case "sequence", "struct":
+		v := map[string]interface{}{}
+		for _, sub := range f.Contents {
+			sv, err := sub.decode(b, true)
+			if err != nil {
+				return v, err
+			}
+			v[sub.Name] = sv
+		}
+		return v, nil
+	case "array":
+		n := f.FixedSize
+		if n == 0 {
+			var err error
+			n, err = f.readPrefix(b)
+			if err != nil {
+				return nil, err
+			}
+		}
+		v := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			ev, err := f.Element.decode(b, true)
+			if err != nil {
+				return v, err
+			}
+			v = append(v, ev)
+		}
+		return v, nil
+	}
+	return nil, fmt.Errorf("format %q is unsupported", f.Format)
+}
+
+// dynamicNumber converts what callers or encoding/json may hold
+func dynamicNumber(v interface{}) (int64, float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), float64(n), true
+	case int8:
+		return int64(n), float64(n), true
+	case int16:
+		return int64(n), float64(n), true
+	case int32:
+		return int64(n), float64(n), true
+	case int64:
+		return n, float64(n), true
+	case uint:
+		return int64(n), float64(n), true
+	case uint8:
+		return int64(n), float64(n), true
+	case uint16:
+		return int64(n), float64(n), true
+	case uint32:
+		return int64(n), float64(n), true
+	case uint64:
+		return int64(n), float64(n), true
+	case float32:
+		return int64(n), float64(n), true
+	case float64:
+		return int64(n), n, true
+	case bool:
+		if n {
+			return 1, 1, true
+		}
+		return 0, 0, true
+	}
+	return 0, 0, false
+}
+
+func (f *dynamicField) encode(w *bytes.Buffer, v interface{}, in_record bool) error {
+	format := strings.TrimPrefix(f.Format, "g")
+	if size := dynamicSize(f.Format); size > 0 {
+		i, fl, ok := dynamicNumber(v)
+		if !ok {
+			return fmt.Errorf("field %q: %T is not a number", f.Name, v)
+		}
+		var x interface{}
+		switch format {
+		case "float":
+			x = float32(fl)
+		case "double":
+			x = fl
+		case "boolean":
+			x = i != 0
+		default:
+			b := make([]byte, 8)
+			binary.LittleEndian.PutUint64(b, uint64(i))
+			_, err := w.Write(b[:size])
+			return err
+		}
+		return binary.Write(w, binary.LittleEndian, x)
+	}
+
+	switch format {
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("field %q: %T is not a string", f.Name, v)
+		}
+		if f.FixedSize > 0 {
+			b := make([]byte, f.FixedSize)
+			copy(b, s)
+			_, err := w.Write(b)
+			return err
+		}
+		switch f.stringEncoding(in_record) {
+		case "prefixed":
+			err := f.writePrefix(w, len(s))
+			if err != nil {
+				return err
+			}
+		case "nul-terminated":
+			s += "\x00"
+		}
+		_, err := w.WriteString(s)
+		return err
+	case "sequence", "struct":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %q: %T is not a map", f.Name, v)
+		}
+		for _, sub := range f.Contents {
+			sv, ok := m[sub.Name]
+			if !ok {
+				return fmt.Errorf("field %q: %q is missing", f.Name, sub.Name)
+			}
+			err := sub.encode(w, sv, true)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case "array":
+		l, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %q: %T is not a []interface{}", f.Name, v)
+		}
+		if f.FixedSize > 0 {
+			if len(l) != f.FixedSize {
+				return fmt.Errorf("field %q: %d elements instead of %d", f.Name, len(l), f.FixedSize)
+			}
+		} else {
+			err := f.writePrefix(w, len(l))
+			if err != nil {
+				return err
+			}
+		}
+		for _, ev := range l {
+			err := f.Element.encode(w, ev, true)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("format %q is unsupported", f.Format)
+}
+
+// unmarshal is Unmarshal falling back to definitions loaded at runtime
+func (dev *Device) unmarshal(buf []byte, dst *Message) (uint32, error) {
+	cid, err := dev.decode(buf, dst)
+	if *dst == nil {
+		return cid, err
+	}
+
+	_, _, _, tlvs, _ := parseFrame(buf)
+	dups := duplicateTLVs(tlvs, *dst)
+	if len(dups) > 0 && !dev.strictTLVs {
+		dev.Lock()
+		dev.stats.DuplicateTLVs += uint64(len(dups))
+		dev.Unlock()
+	} else if len(dups) > 0 && err == nil {
+		err = &PartialDecodeError{Tag: dups[0], Err: ErrDuplicateTLV(dups[0])}
+	}
+
+	if !dev.strictEnums {
+		return cid, err
+	}
+	unknown := unknownEnums(*dst)
+	if len(unknown) == 0 {
+		return cid, err
+	}
+	if err == nil {
+		err = &PartialDecodeError{}
+	}
+	if partial, ok := err.(*PartialDecodeError); ok {
+		partial.Warnings = unknown
+	}
+	return cid, err
+}
+
+// decode falls back to loaded definitions for messages unknown to the
+// generated registry
+func (dev *Device) decode(buf []byte, dst *Message) (uint32, error) {
+	cid, err := UnmarshalVendor(buf, dst, dev.vendor)
+	switch err.(type) {
+	case ErrBadService, ErrBadMessage:
+	default:
+		return cid, err
+	}
+
+	svcid, cid, msgid, tlvs, perr := parseFrame(buf)
+	if perr != nil {
+		return 0, perr
+	}
+
+	dev.Lock()
+	def := dev.dynamic[messageKey(svcid, msgid)]
+	dev.Unlock()
+	if def == nil {
+		return 0, err
+	}
+
+	return cid, decodeMessage(&DynamicMessage{def: def, output: true}, tlvs, dst)
+}
+
+// LM940 QMI Command Reference Guide, Section 3.2.1, Table 3-2; Section 4.1.3.3
+const (
+	QMI_RESULT_SUCCESS = 0
+	QMI_RESULT_FAILURE = 1
+)
+
+// LM940 QMI Command Reference Guide, Section 3.2.1, Table 3-3; Section 4.1.3.3
+type QMIError uint16
+
+const (
+	QMI_PROTOCOL_ERROR_NONE                    QMIError = 0
+	QMI_PROTOCOL_ERROR_MALFORMED_MESSAGE                = 1
+	QMI_PROTOCOL_ERROR_NO_MEMORY                        = 2
+	QMI_PROTOCOL_ERROR_INTERNAL                         = 3
+	QMI_PROTOCOL_ERROR_ABORTED                          = 4
+	QMI_PROTOCOL_ERROR_CLIENT_IDS_EXHAUSTED             = 5
+	QMI_PROTOCOL_ERROR_UNABORTABLE_TRANSACTION          = 6
+	QMI_PROTOCOL_ERROR_INVALID_CLIENT_ID                = 7
+	QMI_PROTOCOL_ERROR_NO_THRESHOLDS_PROVIDED           = 8
+	QMI_PROTOCOL_ERROR_INVALID_HANDLE                   = 9
+	QMI_PROTOCOL_ERROR_INVALID_PROFILE                  = 10
+	QMI_PROTOCOL_ERROR_INVALID_PIN_ID                   = 11
+	QMI_PROTOCOL_ERROR_INCORRECT_PIN                    = 12
+	QMI_PROTOCOL_ERROR_NO_NETWORK_FOUND                 = 13
+	QMI_PROTOCOL_ERROR_CALL_FAILED                      = 14
+	QMI_PROTOCOL_ERROR_OUT_OF_CALL                      = 15
+	QMI_PROTOCOL_ERROR_NOT_PROVISIONED                  = 16
+	QMI_PROTOCOL_ERROR_MISSING_ARGUMENT                 = 17
+	// 18: reserved
+	QMI_PROTOCOL_ERROR_ARGUMENT_TOO_LONG = 19
+	// 20: reserved
+	// 21: reserved
+	QMI_PROTOCOL_ERROR_INVALID_TRANSACTION_ID        = 22
+	QMI_PROTOCOL_ERROR_DEVICE_IN_USE                 = 23
+	QMI_PROTOCOL_ERROR_NETWORK_UNSUPPORTED           = 24
+	QMI_PROTOCOL_ERROR_DEVICE_UNSUPPORTED            = 25
+	QMI_PROTOCOL_ERROR_NO_EFFECT                     = 26
+	QMI_PROTOCOL_ERROR_NO_FREE_PROFILE               = 27
+	QMI_PROTOCOL_ERROR_INVALID_PDP_TYPE              = 28
+	QMI_PROTOCOL_ERROR_INVALID_TECHNOLOGY_PREFERENCE = 29
+	QMI_PROTOCOL_ERROR_INVALID_PROFILE_TYPE          = 30
+	QMI_PROTOCOL_ERROR_INVALID_SERVICE_TYPE          = 31
+	QMI_PROTOCOL_ERROR_INVALID_REGISTER_ACTION       = 32
+	QMI_PROTOCOL_ERROR_INVALID_PS_ATTACH_ACTION      = 33
+	QMI_PROTOCOL_ERROR_AUTHENTICATION_FAILED         = 34
+	QMI_PROTOCOL_ERROR_PIN_BLOCKED                   = 35
+	QMI_PROTOCOL_ERROR_PIN_ALWAYS_BLOCKED            = 36
+	QMI_PROTOCOL_ERROR_UIM_UNINITIALIZED             = 37
+	QMI_PROTOCOL_ERROR_MAXIMUM_QOS_REQUESTS_IN_USE   = 38
+	QMI_PROTOCOL_ERROR_INCORRECT_FLOW_FILTER         = 39
+	QMI_PROTOCOL_ERROR_NETWORK_QOS_UNAWARE           = 40
+	QMI_PROTOCOL_ERROR_INVALID_QOS_ID                = 41
+	QMI_PROTOCOL_ERROR_QOS_UNAVAILABLE               = 42
+	QMI_PROTOCOL_ERROR_FLOW_SUSPENDED                = 43
+	// 44: reserved
+	// 45: reserved
+	QMI_PROTOCOL_ERROR_GENERAL_ERROR                = 46
+	QMI_PROTOCOL_ERROR_UNKNOWN_ERROR                = 47
+	QMI_PROTOCOL_ERROR_INVALID_ARGUMENT             = 48
+	QMI_PROTOCOL_ERROR_INVALID_INDEX                = 49
+	QMI_PROTOCOL_ERROR_NO_ENTRY                     = 50
+	QMI_PROTOCOL_ERROR_DEVICE_STORAGE_FULL          = 51
+	QMI_PROTOCOL_ERROR_DEVICE_NOT_READY             = 52
+	QMI_PROTOCOL_ERROR_NETWORK_NOT_READY            = 53
+	QMI_PROTOCOL_ERROR_WMS_CAUSE_CODE               = 54
+	QMI_PROTOCOL_ERROR_WMS_MESSAGE_NOT_SENT         = 55
+	QMI_PROTOCOL_ERROR_WMS_MESSAGE_DELIVERY_FAILURE = 56
+	QMI_PROTOCOL_ERROR_WMS_INVALID_MESSAGE_ID       = 57
+	QMI_PROTOCOL_ERROR_WMS_ENCODING                 = 58
+	QMI_PROTOCOL_ERROR_AUTHENTICATION_LOCK          = 59
+	QMI_PROTOCOL_ERROR_INVALID_TRANSITION           = 60
+	// 61-64: reserved
+	QMI_PROTOCOL_ERROR_SESSION_INACTIVE        = 65
+	QMI_PROTOCOL_ERROR_SESSION_INVALID         = 66
+	QMI_PROTOCOL_ERROR_SESSION_OWNERSHIP       = 67
+	QMI_PROTOCOL_ERROR_INSUFFICIENT_RESOURCES  = 68
+	QMI_PROTOCOL_ERROR_DISABLED                = 69
+	QMI_PROTOCOL_ERROR_INVALID_OPERATION       = 70
+	QMI_PROTOCOL_ERROR_INVALID_QMI_COMMAND     = 71
+	QMI_PROTOCOL_ERROR_WMS_T_PDU_TYPE          = 72
+	QMI_PROTOCOL_ERROR_WMS_SMSC_ADDRESS        = 73
+	QMI_PROTOCOL_ERROR_INFORMATION_UNAVAILABLE = 74
+	QMI_PROTOCOL_ERROR_SEGMENT_TOO_LONG        = 75
+	QMI_PROTOCOL_ERROR_SEGMENT_ORDER           = 76
+	QMI_PROTOCOL_ERROR_BUNDLING_NOT_SUPPORTED  = 77
+	QMI_PROTOCOL_ERROR_POLICY_MISMATCH         = 79
+	QMI_PROTOCOL_ERROR_SIM_FILE_NOT_FOUND      = 80
+	QMI_PROTOCOL_ERROR_EXTENDED_INTERNAL       = 81
+	QMI_PROTOCOL_ERROR_ACCESS_DENIED           = 82
+	QMI_PROTOCOL_ERROR_HARDWARE_RESTRICTED     = 83
+	QMI_PROTOCOL_ERROR_ACK_NOT_SENT            = 84
+	QMI_PROTOCOL_ERROR_INJECT_TIMEOUT          = 85
+	// 86-89: reserved
+	QMI_PROTOCOL_ERROR_INCOMPATIBLE_STATE       = 90
+	QMI_PROTOCOL_ERROR_FDN_RESTRICT             = 91
+	QMI_PROTOCOL_ERROR_SUPS_FAILURE_CASE        = 92
+	QMI_PROTOCOL_ERROR_NO_RADIO                 = 93
+	QMI_PROTOCOL_ERROR_NOT_SUPPORTED            = 94
+	QMI_PROTOCOL_ERROR_NO_SUBSCRIPTION          = 95
+	QMI_PROTOCOL_ERROR_CARD_CALL_CONTROL_FAILED = 96
+	QMI_PROTOCOL_ERROR_NETWORK_ABORTED          = 97
+	QMI_PROTOCOL_ERROR_MSG_BLOCKED              = 98
+	// 99: reserved
+	QMI_PROTOCOL_ERROR_INVALID_SESSION_TYPE      = 100
+	QMI_PROTOCOL_ERROR_INVALID_PB_TYPE           = 101
+	QMI_PROTOCOL_ERROR_NO_SIM                    = 102
+	QMI_PROTOCOL_ERROR_PB_NOT_READY              = 103
+	QMI_PROTOCOL_ERROR_PIN_RESTRICTION           = 104
+	QMI_PROTOCOL_ERROR_PIN2_RESTRICTION          = 105
+	QMI_PROTOCOL_ERROR_PUK_RESTRICTION           = 106
+	QMI_PROTOCOL_ERROR_PUK2_RESTRICTION          = 107
+	QMI_PROTOCOL_ERROR_PB_ACCESS_RESTRICTED      = 108
+	QMI_PROTOCOL_ERROR_PB_TEXT_TOO_LONG          = 109
+	QMI_PROTOCOL_ERROR_PB_NUMBER_TOO_LONG        = 110
+	QMI_PROTOCOL_ERROR_PB_HIDDEN_KEY_RESTRICTION = 111
+
+	QMI_PROTOCOL_ERROR_CAT_EVENT_REGISTRATION_FAILED = 0xF001
+	QMI_PROTOCOL_ERROR_CAT_INVALID_TERMINAL_RESPONSE = 0xF002
+	QMI_PROTOCOL_ERROR_CAT_INVALID_ENVELOPE_COMMAND  = 0xF003
+	QMI_PROTOCOL_ERROR_CAT_ENVELOPE_COMMAND_BUSY     = 0xF004
+	QMI_PROTOCOL_ERROR_CAT_ENVELOPE_COMMAND_FAILED   = 0xF005
+)
+
+var QMIErrorDescription = map[QMIError]string{
+	QMI_PROTOCOL_ERROR_NONE:                          "No error",
+	QMI_PROTOCOL_ERROR_MALFORMED_MESSAGE:             "Malformed message",
+	QMI_PROTOCOL_ERROR_NO_MEMORY:                     "No memory",
+	QMI_PROTOCOL_ERROR_INTERNAL:                      "Internal",
+	QMI_PROTOCOL_ERROR_ABORTED:                       "Aborted",
+	QMI_PROTOCOL_ERROR_CLIENT_IDS_EXHAUSTED:          "Client IDs exhausted",
+	QMI_PROTOCOL_ERROR_UNABORTABLE_TRANSACTION:       "Unabortable transaction",
+	QMI_PROTOCOL_ERROR_INVALID_CLIENT_ID:             "Invalid client ID",
+	QMI_PROTOCOL_ERROR_NO_THRESHOLDS_PROVIDED:        "No thresholds provided",
+	QMI_PROTOCOL_ERROR_INVALID_HANDLE:                "Invalid handle",
+	QMI_PROTOCOL_ERROR_INVALID_PROFILE:               "Invalid profile",
+	QMI_PROTOCOL_ERROR_INVALID_PIN_ID:                "Invalid PIN ID",
+	QMI_PROTOCOL_ERROR_INCORRECT_PIN:                 "Incorrect PIN",
+	QMI_PROTOCOL_ERROR_NO_NETWORK_FOUND:              "No network found",
+	QMI_PROTOCOL_ERROR_CALL_FAILED:                   "Call failed",
+	QMI_PROTOCOL_ERROR_OUT_OF_CALL:                   "Out of call",
+	QMI_PROTOCOL_ERROR_NOT_PROVISIONED:               "Not provisioned",
+	QMI_PROTOCOL_ERROR_MISSING_ARGUMENT:              "Missing argument",
+	QMI_PROTOCOL_ERROR_ARGUMENT_TOO_LONG:             "Argument too long",
+	QMI_PROTOCOL_ERROR_INVALID_TRANSACTION_ID:        "Invalid transaction ID",
+	QMI_PROTOCOL_ERROR_DEVICE_IN_USE:                 "Device in use",
+	QMI_PROTOCOL_ERROR_NETWORK_UNSUPPORTED:           "Network unsupported",
+	QMI_PROTOCOL_ERROR_DEVICE_UNSUPPORTED:            "Device unsupported",
+	QMI_PROTOCOL_ERROR_NO_EFFECT:                     "No effect",
+	QMI_PROTOCOL_ERROR_NO_FREE_PROFILE:               "No free profile",
+	QMI_PROTOCOL_ERROR_INVALID_PDP_TYPE:              "Invalid PDP type",
+	QMI_PROTOCOL_ERROR_INVALID_TECHNOLOGY_PREFERENCE: "Invalid technology preference",
+	QMI_PROTOCOL_ERROR_INVALID_PROFILE_TYPE:          "Invalid profile type",
+	QMI_PROTOCOL_ERROR_INVALID_SERVICE_TYPE:          "Invalid service type",
+	QMI_PROTOCOL_ERROR_INVALID_REGISTER_ACTION:       "Invalid register action",
+	QMI_PROTOCOL_ERROR_INVALID_PS_ATTACH_ACTION:      "Invalid PS attach action",
+	QMI_PROTOCOL_ERROR_AUTHENTICATION_FAILED:         "Authentication failed",
+	QMI_PROTOCOL_ERROR_PIN_BLOCKED:                   "PIN blocked",
+	QMI_PROTOCOL_ERROR_PIN_ALWAYS_BLOCKED:            "PIN always blocked",
+	QMI_PROTOCOL_ERROR_UIM_UNINITIALIZED:             "UIM uninitialized",
+	QMI_PROTOCOL_ERROR_MAXIMUM_QOS_REQUESTS_IN_USE:   "Maximum QoS requests in use",
+	QMI_PROTOCOL_ERROR_INCORRECT_FLOW_FILTER:         "Incorrect flow filter",
+	QMI_PROTOCOL_ERROR_NETWORK_QOS_UNAWARE:           "Network QoS unaware",
+	QMI_PROTOCOL_ERROR_INVALID_QOS_ID:                "Invalid QoS ID",
+	QMI_PROTOCOL_ERROR_QOS_UNAVAILABLE:               "QoS unavailable",
+	QMI_PROTOCOL_ERROR_FLOW_SUSPENDED:                "Flow suspended",
+	QMI_PROTOCOL_ERROR_GENERAL_ERROR:                 "General error",
+	QMI_PROTOCOL_ERROR_UNKNOWN_ERROR:                 "Unknown error",
+	QMI_PROTOCOL_ERROR_INVALID_ARGUMENT:              "Invalid argument",
+	QMI_PROTOCOL_ERROR_INVALID_INDEX:                 "Invalid index",
+	QMI_PROTOCOL_ERROR_NO_ENTRY:                      "No entry",
+	QMI_PROTOCOL_ERROR_DEVICE_STORAGE_FULL:           "Device storage full",
+	QMI_PROTOCOL_ERROR_DEVICE_NOT_READY:              "Device not ready",
+	QMI_PROTOCOL_ERROR_NETWORK_NOT_READY:             "Network not ready",
+	QMI_PROTOCOL_ERROR_WMS_CAUSE_CODE:                "WMS cause code",
+	QMI_PROTOCOL_ERROR_WMS_MESSAGE_NOT_SENT:          "WMS message not sent",
+	QMI_PROTOCOL_ERROR_WMS_MESSAGE_DELIVERY_FAILURE:  "WMS message delivery failure",
+	QMI_PROTOCOL_ERROR_WMS_INVALID_MESSAGE_ID:        "WMS invalid message ID",
+	QMI_PROTOCOL_ERROR_WMS_ENCODING:                  "WMS encoding",
+	QMI_PROTOCOL_ERROR_AUTHENTICATION_LOCK:           "Authentication lock",
+	QMI_PROTOCOL_ERROR_INVALID_TRANSITION:            "Invalid transition",
+	QMI_PROTOCOL_ERROR_SESSION_INACTIVE:              "Session inactive",
+	QMI_PROTOCOL_ERROR_SESSION_INVALID:               "Session invalid",
+	QMI_PROTOCOL_ERROR_SESSION_OWNERSHIP:             "Session ownership",
+	QMI_PROTOCOL_ERROR_INSUFFICIENT_RESOURCES:        "Insufficient resources",
+	QMI_PROTOCOL_ERROR_DISABLED:                      "Disabled",
+	QMI_PROTOCOL_ERROR_INVALID_OPERATION:             "Invalid operation",
+	QMI_PROTOCOL_ERROR_INVALID_QMI_COMMAND:           "Invalid QMI command",
+	QMI_PROTOCOL_ERROR_WMS_T_PDU_TYPE:                "WMS T-PDU type",
+	QMI_PROTOCOL_ERROR_WMS_SMSC_ADDRESS:              "WMS SMSC address",
+	QMI_PROTOCOL_ERROR_INFORMATION_UNAVAILABLE:       "Information unavailable",
+	QMI_PROTOCOL_ERROR_SEGMENT_TOO_LONG:              "Segment too long",
+	QMI_PROTOCOL_ERROR_SEGMENT_ORDER:                 "Segment order",
+	QMI_PROTOCOL_ERROR_BUNDLING_NOT_SUPPORTED:        "Bundling not supported",
+	QMI_PROTOCOL_ERROR_POLICY_MISMATCH:               "Policy mismatch",
+	QMI_PROTOCOL_ERROR_SIM_FILE_NOT_FOUND:            "SIM file not found",
+	QMI_PROTOCOL_ERROR_EXTENDED_INTERNAL:             "Extended internal error",
+	QMI_PROTOCOL_ERROR_ACCESS_DENIED:                 "Access denied",
+	QMI_PROTOCOL_ERROR_HARDWARE_RESTRICTED:           "Hardware restricted",
+	QMI_PROTOCOL_ERROR_ACK_NOT_SENT:                  "ACK not sent",
+	QMI_PROTOCOL_ERROR_INJECT_TIMEOUT:                "Inject timeout",
+	QMI_PROTOCOL_ERROR_INCOMPATIBLE_STATE:            "Incompatible state",
+	QMI_PROTOCOL_ERROR_FDN_RESTRICT:                  "FDN restrict",
+	QMI_PROTOCOL_ERROR_SUPS_FAILURE_CASE:             "SUPS failure case",
+	QMI_PROTOCOL_ERROR_NO_RADIO:                      "No radio",
+	QMI_PROTOCOL_ERROR_NOT_SUPPORTED:                 "Not supported",
+	QMI_PROTOCOL_ERROR_NO_SUBSCRIPTION:               "No subscription",
+	QMI_PROTOCOL_ERROR_CARD_CALL_CONTROL_FAILED:      "Card call control failed",
+	QMI_PROTOCOL_ERROR_NETWORK_ABORTED:               "Network aborted",
+	QMI_PROTOCOL_ERROR_MSG_BLOCKED:                   "Message blocked",
+	QMI_PROTOCOL_ERROR_INVALID_SESSION_TYPE:          "Invalid session type",
+	QMI_PROTOCOL_ERROR_INVALID_PB_TYPE:               "Invalid PB type",
+	QMI_PROTOCOL_ERROR_NO_SIM:                        "No SIM",
+	QMI_PROTOCOL_ERROR_PB_NOT_READY:                  "PB not ready",
+	QMI_PROTOCOL_ERROR_PIN_RESTRICTION:               "PIN restriction",
+	QMI_PROTOCOL_ERROR_PIN2_RESTRICTION:              "PIN2 restriction",
+	QMI_PROTOCOL_ERROR_PUK_RESTRICTION:               "PUK restriction",
+	QMI_PROTOCOL_ERROR_PUK2_RESTRICTION:              "PUK2 restriction",
+	QMI_PROTOCOL_ERROR_PB_ACCESS_RESTRICTED:          "PB access restricted",
+	QMI_PROTOCOL_ERROR_PB_TEXT_TOO_LONG:              "PB text too long",
+	QMI_PROTOCOL_ERROR_PB_NUMBER_TOO_LONG:            "PB number too long",
+	QMI_PROTOCOL_ERROR_PB_HIDDEN_KEY_RESTRICTION:     "PB hidden key restriction",
+
+	QMI_PROTOCOL_ERROR_CAT_EVENT_REGISTRATION_FAILED: "Event registration failed",
+	QMI_PROTOCOL_ERROR_CAT_INVALID_TERMINAL_RESPONSE: "Invalid terminal response",
+	QMI_PROTOCOL_ERROR_CAT_INVALID_ENVELOPE_COMMAND:  "Invalid envelope command",
+	QMI_PROTOCOL_ERROR_CAT_ENVELOPE_COMMAND_BUSY:     "Envelope command busy",
+	QMI_PROTOCOL_ERROR_CAT_ENVELOPE_COMMAND_FAILED:   "Envelope command failed",
+}
+
+func (qe QMIError) Error() string {
+	desc := QMIErrorDescription[qe]
+	if desc == "" {
+		return "QMI Protocol Error: unknown error"
+	} else {
+		return "QMI Protocol Error: " + desc
+	}
+}
+
+// serviceErrors are the generated <SVC>ErrorDescription by service
+var serviceErrors = map[Service]map[QMIError]string{}
+
+func declareServiceErrors(svc Service, descs map[QMIError]string) {
+	serviceErrors[svc] = descs
+}
+
+// ErrorDescription describes a result code of svc, preferring the meaning
+// the service gives it over QMIErrorDescription; "" when unknown
+func ErrorDescription(svc Service, code QMIError) string {
+	if desc, ok := serviceErrors[svc][code]; ok {
+		return desc
+	}
+	return QMIErrorDescription[code]
+}
+
+// ServiceError is a failed operation with a result code the service
+// describes itself, such as the verbose codes of WDS. Other codes come as
+// a plain QMIError, which ServiceError unwraps to.
+type ServiceError struct {
+	Service Service
+	Code    QMIError
+}
+
+func (e ServiceError) Error() string {
+	return "QMI Protocol Error: " + ErrorDescription(e.Service, e.Code)
+}
+
+func (e ServiceError) Unwrap() error {
+	return e.Code
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
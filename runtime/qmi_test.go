@@ -0,0 +1,334 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/tlv"
+)
+
+func TestFindTag(t *testing.T) {
+	tlvs, _ := (&tlv.Builder{}).
+		AddUint8(0x01, 0x11).
+		AddUint16(0x10, 0x2222).
+		AddUint8(0x01, 0x33).
+		Bytes()
+
+	for _, test := range []struct {
+		tag  uint8
+		want []byte
+	}{
+		{0x01, []byte{0x11}},
+		{0x10, []byte{0x22, 0x22}},
+		{0x11, nil},
+	} {
+		got := findTag(bytes.NewBuffer(tlvs), test.tag)
+		if test.want == nil {
+			if got != nil {
+				t.Errorf("findTag(%#02x) = % x, want nil", test.tag, got.Bytes())
+			}
+			continue
+		}
+		if got == nil || !bytes.Equal(got.Bytes(), test.want) {
+			t.Errorf("findTag(%#02x) = %v, want % x", test.tag, got, test.want)
+		}
+	}
+
+	// a truncated TLV ends the lookup without reaching past the buffer
+	truncated := append(append([]byte(nil), tlvs...), 0x12, 0x05, 0x00, 0xff)
+	if got := findTag(bytes.NewBuffer(truncated), 0x12); got != nil {
+		t.Errorf("findTag of a truncated TLV = % x", got.Bytes())
+	}
+	if err := checkTLVs(bytes.NewBuffer(truncated)); err != tlv.ErrTruncated(0x12) {
+		t.Errorf("checkTLVs = %v, want tlv.ErrTruncated(0x12)", err)
+	}
+}
+
+func TestMarshal(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		msg   Message
+		cid   uint8
+		txid  uint16
+		frame string
+	}{{
+		"CTL, one byte txid",
+		&CTLAllocateCIDInput{Service: uint8(QMI_SERVICE_WDS)},
+		0, 0x105,
+		"01 0f00 00 00 00 00 05 2200 0400 01 0100 01",
+	}, {
+		"service, two byte txid",
+		&WDSStopNetworkInput{PacketDataHandle: 0x01020304},
+		3, 0x105,
+		"01 1300 00 01 03 00 0501 2100 0700 01 0400 04030201",
+	}, {
+		"no TLVs",
+		&DMSGetIDsInput{},
+		1, 7,
+		"01 0c00 00 02 01 00 0700 2500 0000",
+	}} {
+		buf, err := Marshal(test.msg, test.cid, test.txid, 0)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		want, _ := hex.DecodeString(stripSpaces(test.frame))
+		if !bytes.Equal(buf.Bytes(), want) {
+			t.Errorf("%s:\n got % x\nwant % x", test.name, buf.Bytes(), want)
+		}
+	}
+}
+
+func stripSpaces(s string) string {
+	return strings.ReplaceAll(s, " ", "")
+}
+
+func TestUnmarshal(t *testing.T) {
+	// DMS Get Manufacturer response of client 2, txid 0x0304
+	frame, _ := hex.DecodeString(stripSpaces(
+		"01 1a00 80 02 02 02 0403 2100 0e00 02 0400 00000000 01 0400 41434d45"))
+
+	var msg Message
+	cid, err := Unmarshal(frame, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cid != 0x030402 {
+		t.Errorf("cid = %#x, want 0x030402", cid)
+	}
+	resp, ok := msg.(*DMSGetManufacturerOutput)
+	if !ok {
+		t.Fatalf("decoded %T", msg)
+	}
+	if resp.Manufacturer != "ACME" {
+		t.Errorf("Manufacturer = %q", resp.Manufacturer)
+	}
+
+	for _, test := range []struct {
+		name  string
+		frame []byte
+		err   error
+	}{
+		{"short", frame[:11], io.ErrUnexpectedEOF},
+		{"length past the end", frame[:len(frame)-1], io.ErrUnexpectedEOF},
+		{"marker", append([]byte{0x02}, frame[1:]...), ErrBadMarker(0x02)},
+	} {
+		_, err := Unmarshal(test.frame, &msg)
+		if err != test.err {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.err)
+		}
+	}
+
+	unknown := append([]byte(nil), frame...)
+	unknown[9] = 0x7f
+	_, err = Unmarshal(unknown, &msg)
+	if !errors.As(err, &ErrBadMessage{}) {
+		t.Errorf("unknown message: err = %v, want ErrBadMessage", err)
+	}
+}
+
+// exported copies the exported fields but RawTLVs of the message m points
+// to: what encoding and decoding preserve
+func exported(m Message) interface{} {
+	v := reflect.ValueOf(m).Elem()
+	c := reflect.New(v.Type()).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		f := v.Type().Field(i)
+		if f.PkgPath == "" && f.Name != "RawTLVs" {
+			c.Field(i).Set(v.Field(i))
+		}
+	}
+	return c.Interface()
+}
+
+func sortedIDs(msgs map[uint16]func() Message) []uint16 {
+	var ids []uint16
+	for id := range msgs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// sizedFields are the guint-sized fields by message type, read back zero
+// padded to their size
+var sizedFields = map[reflect.Type]map[string]int{
+	reflect.TypeOf(DMSSetTimeInput{}): {"TimeValue": 6},
+}
+
+// prerequisites satisfy the prerequisites of fields by message type, which
+// would go missing otherwise
+var prerequisites = map[reflect.Type]func(m Message){
+	reflect.TypeOf(NASGetTxRxInfoOutput{}): func(m Message) {
+		info := reflect.Indirect(reflect.ValueOf(m).Elem().FieldByName("RxChain0Info"))
+		info.FieldByName("IsRadioTuned").SetUint(1)
+	},
+}
+
+// TestMessageRoundTrip encodes every registered message filled with
+// distinct values and decodes it back
+func TestMessageRoundTrip(t *testing.T) {
+	for _, registry := range []struct {
+		name         string
+		constructors map[Service]map[uint16]func() Message
+	}{
+		{"request", RequestConstructors},
+		{"response", TLVConstructors},
+		{"indication", IndicationConstructors},
+	} {
+		for svc, msgs := range registry.constructors {
+			for _, id := range sortedIDs(msgs) {
+				m := msgs[id]()
+				seq := 0
+				fill(reflect.ValueOf(m).Elem(), &seq)
+				for name, size := range sizedFields[reflect.TypeOf(m).Elem()] {
+					f := reflect.ValueOf(m).Elem().FieldByName(name)
+					f.SetBytes(append(f.Bytes(), make([]byte, size-f.Len())...))
+				}
+				if satisfy := prerequisites[reflect.TypeOf(m).Elem()]; satisfy != nil {
+					satisfy(m)
+				}
+
+				var got Message
+				var err error
+				if registry.name == "request" {
+					var buf bytes.Buffer
+					err = m.TLVsWriteTo(&buf)
+					if err == nil {
+						got = msgs[id]()
+						err = got.TLVsReadFrom(&buf)
+					}
+				} else {
+					got, err = sendAndRead(m, registry.name == "indication")
+				}
+				if err != nil {
+					t.Errorf("%s %T: %s", registry.name, m, err)
+					continue
+				}
+				if !reflect.DeepEqual(exported(got), exported(m)) {
+					t.Errorf("%s %s %04x:\n got %+v\nwant %+v", registry.name, svc, id, got, m)
+				}
+			}
+		}
+	}
+}
+
+// sendAndRead frames m as sent by the modem and decodes the frame
+func sendAndRead(m Message, indication bool) (Message, error) {
+	buf, err := Marshal(m, 1, 2, 0)
+	if err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	b[3] = 0x80
+	switch {
+	case indication && m.ServiceID() == QMI_SERVICE_CTL:
+		b[6] = 0x02
+	case indication:
+		b[6] = 0x04
+	}
+
+	var got Message
+	_, err = Unmarshal(b, &got)
+	return got, err
+}
+
+func TestSendFraming(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *DMSGetManufacturerInput:
+			return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+		}
+		return nil
+	})
+
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 2; i++ {
+		resp, err := dms.Send(&DMSGetManufacturerInput{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resp.(*DMSGetManufacturerOutput).Manufacturer; got != "ACME" {
+			t.Errorf("Manufacturer = %q", got)
+		}
+	}
+
+	modem.Lock()
+	defer modem.Unlock()
+	var frames [][]byte
+	for _, frame := range modem.frames {
+		if Service(frame[4]) == QMI_SERVICE_DMS {
+			frames = append(frames, frame)
+		}
+	}
+	if len(frames) != 2 {
+		t.Fatalf("modem read %d DMS frames, want 2", len(frames))
+	}
+	for i, frame := range frames {
+		// requests are flagged as sent by the control point, with
+		// transaction IDs counting from 1
+		want := []byte{0x01, 0x0c, 0x00, 0x00, 0x02, dms.ClientID, 0x00, uint8(i + 1), 0x00, 0x21, 0x00, 0x00, 0x00}
+		if !bytes.Equal(frame, want) {
+			t.Errorf("frame %d:\n got % x\nwant % x", i, frame, want)
+		}
+	}
+}
+
+// TestSendValue sends a request passed by value, as callers did before the
+// message methods took pointers
+func TestSendValue(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *DMSGetManufacturerInput:
+			return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+		}
+		return nil
+	})
+
+	input := DMSGetManufacturerInput{}
+	resp, err := dev.SendDMSGetManufacturerInput(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := resp.(*DMSGetManufacturerOutput).Manufacturer; got != "ACME" {
+		t.Errorf("Manufacturer = %q", got)
+	}
+}
+
+func TestSendOperationFailed(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetModelInput); !ok {
+			return nil
+		}
+		resp := &DMSGetModelOutput{}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = 0x0003
+		return resp
+	})
+
+	dms, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := dms.Send(&DMSGetModelInput{})
+	if err != QMIError(3) {
+		t.Errorf("err = %v, want QMIError(3)", err)
+	}
+	if resp != nil {
+		t.Errorf("resp = %v, want nil", resp)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
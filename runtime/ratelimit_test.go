@@ -0,0 +1,144 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// nasModem answers NAS Get Operator Name and DMS Get Manufacturer
+func nasModem(req Message) Message {
+	switch req.(type) {
+	case *NASGetOperatorNameInput:
+		return &NASGetOperatorNameOutput{}
+	case *DMSGetManufacturerInput:
+		return &DMSGetManufacturerOutput{}
+	}
+	return nil
+}
+
+// sendAsync sends m in the background
+func sendAsync(ctx context.Context, dev *Device, m Message) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := dev.SendContext(ctx, m)
+		done <- err
+	}()
+	return done
+}
+
+// pending fails t unless done is still waiting
+func pending(t *testing.T, what string, done <-chan error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		t.Fatalf("%s returned early: %v", what, err)
+	case <-time.After(20 * time.Millisecond):
+	}
+}
+
+// returned fails t unless done yields want within a second of real time
+func returned(t *testing.T, what string, done <-chan error, want error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if err != want {
+			t.Fatalf("%s: %v, want %v", what, err, want)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func TestRateLimitPacing(t *testing.T) {
+	clk := fakeClock(t)
+	dev, modem := openFake(t, nasModem, WithRateLimit(QMI_SERVICE_NAS, Rate{Interval: 200 * time.Millisecond, Burst: 1}))
+	ctx := context.Background()
+
+	_, err := dev.SendContext(ctx, &NASGetOperatorNameInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := sendAsync(ctx, dev, &NASGetOperatorNameInput{})
+	clk.awaitTimers(t, 1)
+
+	// other services are not held up
+	_, err = dev.SendContext(ctx, &DMSGetManufacturerInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clk.advance(t, 199*time.Millisecond)
+	pending(t, "second request", done)
+	if n := len(modem.received(QMI_SERVICE_NAS)); n != 1 {
+		t.Fatalf("%d requests sent before their time", n)
+	}
+	clk.advance(t, time.Millisecond)
+	returned(t, "second request", done, nil)
+
+	stats := dev.Stats()
+	if stats.Throttled != 1 || stats.ThrottleWait != 200*time.Millisecond {
+		t.Errorf("throttled %d for %s", stats.Throttled, stats.ThrottleWait)
+	}
+
+	// idling refills the burst, no more
+	clk.advance(t, time.Second)
+	_, err = dev.SendContext(ctx, &NASGetOperatorNameInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Stats().Throttled != 1 {
+		t.Error("paced after idling")
+	}
+	done = sendAsync(ctx, dev, &NASGetOperatorNameInput{})
+	clk.awaitTimers(t, 1)
+	clk.advance(t, 200*time.Millisecond)
+	returned(t, "request after the burst", done, nil)
+}
+
+func TestRateLimitCancel(t *testing.T) {
+	clk := fakeClock(t)
+	dev, _ := openFake(t, nasModem, WithRateLimit(QMI_SERVICE_NAS, Rate{Interval: 200 * time.Millisecond, Burst: 1}))
+
+	_, err := dev.SendContext(context.Background(), &NASGetOperatorNameInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := sendAsync(ctx, dev, &NASGetOperatorNameInput{})
+	clk.awaitTimers(t, 1)
+	cancel()
+	// the clock stands still, the wait ends with the context
+	returned(t, "cancelled request", done, context.Canceled)
+	clk.awaitTimers(t, 0)
+
+	// the token came back: the next request waits an interval, not two
+	done = sendAsync(context.Background(), dev, &NASGetOperatorNameInput{})
+	clk.awaitTimers(t, 1)
+	clk.advance(t, 200*time.Millisecond)
+	returned(t, "next request", done, nil)
+}
+
+// TestDefaultRateLimit paces every service but CTL
+func TestDefaultRateLimit(t *testing.T) {
+	clk := fakeClock(t)
+	dev, _ := openFake(t, nasModem, WithDefaultRateLimit(Rate{Interval: time.Second, Burst: 2}))
+	ctx := context.Background()
+
+	for _, m := range []Message{&DMSGetManufacturerInput{}, &DMSGetManufacturerInput{}, &NASGetOperatorNameInput{}} {
+		_, err := dev.SendContext(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// allocating the NAS client ID above went through CTL unpaced
+	done := sendAsync(ctx, dev, &DMSGetManufacturerInput{})
+	clk.awaitTimers(t, 1)
+	clk.advance(t, time.Second)
+	returned(t, "third DMS request", done, nil)
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
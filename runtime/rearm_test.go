@@ -0,0 +1,58 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// TestConfigureEventReport configures NAS event reporting twice, with
+// thresholds down to -128 dBm, and expects the last configuration on the
+// wire as given, and alone again after Reconnect
+func TestConfigureEventReport(t *testing.T) {
+	dev, modem := openFake(t, reportModem(false))
+	ctx := context.Background()
+	nas, err := dev.NAS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a pointer with qmioptions, setField sees through it
+	config := func(thresholds ...int8) *NASSetEventReportInput {
+		msg := &NASSetEventReportInput{}
+		setField(msg, "SignalStrengthIndicator", struct {
+			Report     bool
+			Thresholds []int8
+		}{true, thresholds})
+		return msg
+	}
+	last := config(-128, -75, 0, 127)
+	for _, input := range []*NASSetEventReportInput{config(-100, -80), last} {
+		err = nas.ConfigureEventReport(ctx, *input)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	sent := modem.received(QMI_SERVICE_NAS)
+	if len(sent) != 2 || !reflect.DeepEqual(sent[1], last) {
+		t.Fatalf("modem read %v", sent)
+	}
+
+	fresh, f := newFakeModem(t, reportModem(false))
+	dev.reopen = func() (*os.File, error) { return f, nil }
+	err = dev.Reconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "event report", func() bool { return len(fresh.received(QMI_SERVICE_NAS)) > 0 })
+	rearmed := fresh.received(QMI_SERVICE_NAS)
+	if len(rearmed) != 1 || !reflect.DeepEqual(rearmed[0], last) {
+		t.Errorf("re-armed with %v, want %v", rearmed, last)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,112 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// reportModem answers NAS Set Event Report, and DMS Get Manufacturer
+// when answer is set
+func reportModem(answer bool) func(req Message) Message {
+	return func(req Message) Message {
+		switch req.(type) {
+		case *NASSetEventReportInput:
+			return &NASSetEventReportOutput{}
+		case *DMSGetManufacturerInput:
+			if answer {
+				return &DMSGetManufacturerOutput{Manufacturer: "ACME"}
+			}
+		}
+		return nil
+	}
+}
+
+// nextIndication returns the next message of sub
+func nextIndication(t *testing.T, sub <-chan Message) Message {
+	t.Helper()
+	select {
+	case msg := <-sub:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("no indication")
+	}
+	return nil
+}
+
+// TestReconnect replaces the transport under Sends awaiting responses of
+// several services: they fail with ErrReset, while a subscription lives on
+// with a ResetEvent in-band and the event report is configured anew
+func TestReconnect(t *testing.T) {
+	dev, modem := openFake(t, reportModem(false))
+	ctx := context.Background()
+
+	nas, err := dev.NAS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = nas.ConfigureEventReport(ctx, NASSetEventReportInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, cancel := dev.Subscribe(QMI_SERVICE_WDS, 0x0022)
+	defer cancel()
+	status := &WDSPacketServiceStatusIndication{}
+	status.ConnectionStatus.Status = 2
+	modem.send(status, 0xff, 0, true)
+	if _, ok := nextIndication(t, sub).(*WDSPacketServiceStatusIndication); !ok {
+		t.Fatal("indication before Reconnect not delivered")
+	}
+
+	var waiting []<-chan error
+	for _, m := range []Message{&DMSGetManufacturerInput{}, &WDSGetPacketServiceStatusInput{}, &NASGetOperatorNameInput{}} {
+		waiting = append(waiting, sendAsync(ctx, dev, m))
+	}
+	waitFor(t, "requests", func() bool {
+		return len(modem.received(QMI_SERVICE_DMS)) == 1 &&
+			len(modem.received(QMI_SERVICE_WDS)) == 1 &&
+			len(modem.received(QMI_SERVICE_NAS)) == 2
+	})
+
+	fresh, f := newFakeModem(t, reportModem(true))
+	dev.reopen = func() (*os.File, error) { return f, nil }
+	err = dev.Reconnect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, done := range waiting {
+		returned(t, "send awaiting a response", done, ErrReset(dev.name))
+	}
+	dev.Lock()
+	awaited := len(dev.ch)
+	dev.Unlock()
+	if awaited != 0 {
+		t.Errorf("%d responses awaited after Reconnect", awaited)
+	}
+
+	ev, ok := nextIndication(t, sub).(*ResetEvent)
+	if !ok || ev.Service != QMI_SERVICE_WDS || ev.ID != 0x0022 {
+		t.Fatalf("subscription got %#v, want a ResetEvent", ev)
+	}
+	if len(fresh.received(QMI_SERVICE_NAS)) != 1 {
+		t.Error("event report not configured on the new transport")
+	}
+	fresh.send(status, 0xff, 0, true)
+	if _, ok := nextIndication(t, sub).(*WDSPacketServiceStatusIndication); !ok {
+		t.Error("indication after Reconnect not delivered")
+	}
+
+	resp, err := dev.SendContext(ctx, &DMSGetManufacturerInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := resp.(*DMSGetManufacturerOutput).Manufacturer; m != "ACME" {
+		t.Errorf("manufacturer %q", m)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
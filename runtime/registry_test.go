@@ -0,0 +1,84 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestVerifyRegistry drops the constructor of a generated message, as a
+// registration the generator skipped would, and expects it reported
+func TestVerifyRegistry(t *testing.T) {
+	err := VerifyRegistry()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cons := TLVConstructors[QMI_SERVICE_DMS][0x21]
+	delete(TLVConstructors[QMI_SERVICE_DMS], 0x21)
+	defer func() { TLVConstructors[QMI_SERVICE_DMS][0x21] = cons }()
+
+	err = VerifyRegistry()
+	var missing ErrUnregistered
+	if !errors.As(err, &missing) {
+		t.Fatalf("err = %v, want ErrUnregistered", err)
+	}
+	if len(missing) != 1 || missing[0].Service != QMI_SERVICE_DMS || missing[0].MessageID != 0x21 {
+		t.Errorf("missing %+v", missing)
+	}
+	if want := "messages are not registered: DMSGetManufacturerOutput (Service QMI_SERVICE_DMS 21)"; err.Error() != want {
+		t.Errorf("err = %q\nwant %q", err, want)
+	}
+}
+
+// TestRegistryConflict registers another type for DMS Get Manufacturer and
+// expects devices refused unless overrides are allowed
+func TestRegistryConflict(t *testing.T) {
+	cons := TLVConstructors[QMI_SERVICE_DMS][0x21]
+	defer func() {
+		TLVConstructors[QMI_SERVICE_DMS][0x21] = cons
+		registryConflicts = nil
+	}()
+
+	// the same type again is no conflict
+	registerMessage(cons)
+	if conflicts := RegistryConflicts(); len(conflicts) != 0 {
+		t.Fatalf("conflicts %v", conflicts)
+	}
+
+	registerMessage(func() Message { return &rawMessage{svc: QMI_SERVICE_DMS, id: 0x21} })
+	conflicts := RegistryConflicts()
+	want := RegistryConflict{
+		Registry:  "message",
+		Service:   QMI_SERVICE_DMS,
+		ID:        0x21,
+		Previous:  "*qmi.DMSGetManufacturerOutput",
+		Overrider: "*qmi.rawMessage",
+	}
+	if len(conflicts) != 1 || conflicts[0] != want {
+		t.Fatalf("conflicts %+v, want %+v", conflicts, want)
+	}
+	if _, ok := TLVConstructors[QMI_SERVICE_DMS][0x21]().(*rawMessage); !ok {
+		t.Error("the later registration does not win")
+	}
+
+	var errs ErrRegistryConflicts
+	if err := VerifyRegistry(); !errors.As(err, &errs) || len(errs) != 1 {
+		t.Errorf("VerifyRegistry: %v, want ErrRegistryConflicts", err)
+	}
+	_, f := newFakeModem(t, nil)
+	if _, err := NewDevice(f, "fake"); !errors.As(err, &errs) {
+		t.Errorf("NewDevice: %v, want ErrRegistryConflicts", err)
+	}
+
+	_, f = newFakeModem(t, nil)
+	dev, err := NewDevice(f, "fake", WithRegistryOverrides())
+	if err != nil {
+		t.Fatalf("NewDevice with overrides: %s", err)
+	}
+	dev.Close()
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
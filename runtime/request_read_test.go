@@ -0,0 +1,50 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// TestRequestReadFrom decodes requests sniffed from the host with the
+// generated input types, one of a common-ref and one without TLVs
+func TestRequestReadFrom(t *testing.T) {
+	for _, test := range []struct {
+		frame  string
+		want   Message
+		fields map[string]interface{}
+	}{
+		{"01 1800 00 03 02 00 0500 2200 0c00 01 0100 02 10 0500 fa00 0100 08", &NASInitiateNetworkRegisterInput{},
+			map[string]interface{}{"Action": uint8(2), "MCC": uint16(250), "MNC": uint16(1), "RadioAccessTechnology": uint8(8)}},
+		{"01 0c00 00 02 01 00 0700 2500 0000", &DMSGetIDsInput{}, nil},
+	} {
+		frame, _ := hex.DecodeString(stripSpaces(test.frame))
+		svc, _, id, tlvs, err := parseFrame(frame)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cons, ok := RequestConstructors[svc][id]
+		if !ok {
+			t.Fatalf("no request %s %04x", svc, id)
+		}
+		req := cons()
+		err = req.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			t.Errorf("%T: %s", req, err)
+			continue
+		}
+		if typeName(req) != typeName(test.want) {
+			t.Errorf("decoded %T, want %T", req, test.want)
+		}
+		for name, want := range test.fields {
+			if got := indirect(req, name); got != want {
+				t.Errorf("%T.%s = %v, want %v", req, name, got, want)
+			}
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
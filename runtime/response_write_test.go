@@ -0,0 +1,51 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// TestResponseWriteTo encodes a response as a modem would: the result TLV
+// and the IMEI set, the optional TLVs left nil skipped
+func TestResponseWriteTo(t *testing.T) {
+	resp := &DMSGetIDsOutput{}
+	resp.ErrorStatus = 1
+	resp.ErrorCode = QMI_PROTOCOL_ERROR_INTERNAL
+	setField(resp, "IMEI", "350000000000001")
+
+	var buf bytes.Buffer
+	err := resp.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := findTag(bytes.NewBuffer(buf.Bytes()), 0x02); b == nil || !bytes.Equal(b.Bytes(), []byte{1, 0, 3, 0}) {
+		t.Errorf("result TLV % x", b)
+	}
+	if b := findTag(bytes.NewBuffer(buf.Bytes()), 0x11); b == nil || b.String() != "350000000000001" {
+		t.Errorf("IMEI TLV %q", b)
+	}
+	for _, f := range []struct {
+		name string
+		tag  uint8
+	}{{"Esn", 0x10}, {"Meid", 0x12}} {
+		nil_ptr := reflect.ValueOf(resp).Elem().FieldByName(f.name).Kind() == reflect.Ptr
+		if b := findTag(bytes.NewBuffer(buf.Bytes()), f.tag); nil_ptr && b != nil {
+			t.Errorf("nil %s written", f.name)
+		}
+	}
+
+	got := &DMSGetIDsOutput{}
+	err = got.TLVsReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(exported(got), exported(resp)) {
+		t.Errorf("read back %+v, want %+v", got, resp)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,69 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestScaledFields decodes signal strengths in units, scaled by 0.1 or
+// not, negative and positive, next to an unscaled raw integer
+func TestScaledFields(t *testing.T) {
+	type lte = struct {
+		RSSI NASGetSignalInfoOutputLTESignalStrengthRSSI
+		RSRQ int8
+		RSRP NASGetSignalInfoOutputLTESignalStrengthRSRP
+		SNR  NASGetSignalInfoOutputLTESignalStrengthSNR
+	}
+	for _, test := range []struct {
+		tlvs string
+		want lte
+		snr  float64
+		text []string
+	}{
+		{"02 0400 0000 0000 14 0600 b9 f6 a1ff c9ff", lte{-71, -10, -95, -55}, -5.5,
+			[]string{"-71 dBm", "-95 dBm", "-5.5 dB"}},
+		{"02 0400 0000 0000 14 0600 c4 fb b4ff 7b00", lte{-60, -5, -76, 123}, 12.3,
+			[]string{"-60 dBm", "-76 dBm", "12.3 dB"}},
+	} {
+		tlvs, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		msg := &NASGetSignalInfoOutput{}
+		err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if err != nil {
+			t.Errorf("%s: %s", test.tlvs, err)
+			continue
+		}
+		got := reflect.Indirect(reflect.ValueOf(msg).Elem().FieldByName("LTESignalStrength")).Interface().(lte)
+		if got != test.want {
+			t.Errorf("decoded %+v, want %+v", got, test.want)
+		}
+		if f := got.SNR.Float(); f != test.snr {
+			t.Errorf("SNR %v, want %v", f, test.snr)
+		}
+		if f := got.RSRP.Float(); f != float64(test.want.RSRP) {
+			t.Errorf("RSRP %v, want %d", f, test.want.RSRP)
+		}
+		s := msg.String()
+		for _, want := range test.text {
+			if !strings.Contains(s, want) {
+				t.Errorf("no %q in %s", want, s)
+			}
+		}
+
+		var buf bytes.Buffer
+		err = msg.TLVsWriteTo(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(buf.Bytes(), tlvs) {
+			t.Errorf("\n got % x\nwant % x", buf.Bytes(), tlvs)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
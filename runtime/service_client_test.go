@@ -0,0 +1,42 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import "testing"
+
+// TestServiceClient sends through the typed client of DMS, which must be
+// the client GetService allocated
+func TestServiceClient(t *testing.T) {
+	dev, modem := openFake(t, identityModem)
+	dms, err := dev.DMS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := dev.GetService(QMI_SERVICE_DMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dms.Client != client {
+		t.Errorf("DMS() client %d, GetService client %d", dms.ClientID, client.ClientID)
+	}
+
+	resp, err := dms.GetManufacturer(DMSGetManufacturerInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Manufacturer != "ACME" {
+		t.Errorf("manufacturer %q", resp.Manufacturer)
+	}
+	if _, err := dms.GetIDs(DMSGetIDsInput{}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(modem.received(QMI_SERVICE_DMS)); n != 2 {
+		t.Errorf("%d DMS requests", n)
+	}
+	if n := dms.TxCount(); n != 2 {
+		t.Errorf("TxCount %d, want 2", n)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
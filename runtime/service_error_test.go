@@ -0,0 +1,71 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestServiceErrorDescription resolves a code WDS and DMS both describe,
+// differently, and one neither does
+func TestServiceErrorDescription(t *testing.T) {
+	for _, test := range []struct {
+		svc  Service
+		code QMIError
+		want string
+	}{
+		{QMI_SERVICE_WDS, 0x1001, "Call throttled"},
+		{QMI_SERVICE_DMS, 0x1001, "Activation in progress"},
+		{QMI_SERVICE_NAS, 0x1001, ""},
+		{QMI_SERVICE_WDS, 0x000E, QMIErrorDescription[0x000E]},
+	} {
+		if got := ErrorDescription(test.svc, test.code); got != test.want {
+			t.Errorf("%s %#04x: %q, want %q", test.svc, uint16(test.code), got, test.want)
+		}
+	}
+}
+
+// TestServiceErrorResponse expects a response failing with a code of the
+// service to return a ServiceError, which still matches the bare code
+func TestServiceErrorResponse(t *testing.T) {
+	dev, _ := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *WDSStartNetworkInput:
+			resp := &WDSStartNetworkOutput{}
+			resp.ErrorStatus = 1
+			resp.ErrorCode = 0x1001
+			return resp
+		case *DMSGetIDsInput:
+			resp := &DMSGetIDsOutput{}
+			resp.ErrorStatus = 1
+			resp.ErrorCode = 0x000E
+			return resp
+		}
+		return nil
+	})
+
+	_, err := dev.WDSStartNetwork(WDSStartNetworkInput{})
+	var serr ServiceError
+	if !errors.As(err, &serr) {
+		t.Fatalf("err = %#v, want ServiceError", err)
+	}
+	if serr.Service != QMI_SERVICE_WDS || serr.Code != 0x1001 {
+		t.Errorf("ServiceError %+v", serr)
+	}
+	if !errors.Is(err, QMIError(0x1001)) {
+		t.Error("ServiceError does not match QMIError(0x1001)")
+	}
+	if want := "QMI Protocol Error: Call throttled"; err.Error() != want {
+		t.Errorf("%q, want %q", err.Error(), want)
+	}
+
+	// codes the service does not describe stay bare
+	_, err = dev.DMSGetIDs(DMSGetIDsInput{})
+	if errors.As(err, &serr) || !errors.Is(err, QMIError(0x000E)) {
+		t.Errorf("err = %#v, want QMIError(0x000E)", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
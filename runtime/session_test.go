@@ -0,0 +1,204 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// sessionModem is a modem with a data session up on APN "internet",
+// handle 0x1234, until it is stopped
+func sessionModem(up *int32) func(req Message) Message {
+	return func(req Message) Message {
+		switch req := req.(type) {
+		case *WDSStartNetworkInput:
+			atomic.StoreInt32(up, 1)
+			return &WDSStartNetworkOutput{PacketDataHandle: 0x1234}
+		case *WDSStopNetworkInput:
+			resp := &WDSStopNetworkOutput{}
+			if req.PacketDataHandle != 0x1234 || atomic.LoadInt32(up) == 0 {
+				resp.ErrorStatus = 1
+				resp.ErrorCode = 0x0001
+			}
+			atomic.StoreInt32(up, 0)
+			return resp
+		case *WDSGetPacketServiceStatusInput:
+			resp := &WDSGetPacketServiceStatusOutput{ConnectionStatus: 1}
+			if atomic.LoadInt32(up) != 0 {
+				resp.ConnectionStatus = 2
+			}
+			return resp
+		}
+		return nil
+	}
+}
+
+// verifyConnected fails unless the packet service is connected
+func verifyConnected(client *Client, st *SessionState) error {
+	resp, err := client.Send(&WDSGetPacketServiceStatusInput{})
+	if err != nil {
+		return err
+	}
+	if resp.(*WDSGetPacketServiceStatusOutput).ConnectionStatus != 2 {
+		return errors.New("disconnected")
+	}
+	return nil
+}
+
+// startSession brings a session up in a process which then exits without
+// stopping it, and returns the state file it left behind
+func startSession(t *testing.T, up *int32) (string, *SessionState) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "session.json")
+
+	dev, _ := openFake(t, sessionModem(up))
+	wds, err := dev.GetService(QMI_SERVICE_WDS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := wds.Send(&WDSStartNetworkInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := &SessionState{
+		Device:           dev.name,
+		APN:              "internet",
+		PacketDataHandle: resp.(*WDSStartNetworkOutput).PacketDataHandle,
+		MuxID:            1,
+		Service:          QMI_SERVICE_WDS,
+		ClientID:         wds.ClientID,
+	}
+	err = SaveSessionState(path, st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Close()
+	return path, st
+}
+
+// frameCIDs returns the client IDs of the WDS frames the modem read
+func frameCIDs(m *fakeModem) []uint8 {
+	m.Lock()
+	defer m.Unlock()
+	var cids []uint8
+	for _, frame := range m.frames {
+		if Service(frame[4]) == QMI_SERVICE_WDS {
+			cids = append(cids, frame[5])
+		}
+	}
+	return cids
+}
+
+func TestRestoreSessionAdopts(t *testing.T) {
+	up := int32(0)
+	path, saved := startSession(t, &up)
+
+	// the restarted process
+	dev, modem := openFake(t, sessionModem(&up))
+	wds, st, err := dev.RestoreSession(path, verifyConnected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st == nil || *st != *saved {
+		t.Fatalf("state %+v, want %+v", st, saved)
+	}
+	if wds.ClientID != saved.ClientID {
+		t.Errorf("client ID %d, want %d", wds.ClientID, saved.ClientID)
+	}
+	if got, _ := dev.GetService(QMI_SERVICE_WDS); got != wds {
+		t.Error("GetService does not return the adopted client")
+	}
+	for _, req := range modem.received(QMI_SERVICE_CTL) {
+		if _, ok := req.(*CTLAllocateCIDInput); ok {
+			t.Error("a client ID was allocated")
+		}
+	}
+	for _, cid := range frameCIDs(modem) {
+		if cid != saved.ClientID {
+			t.Errorf("WDS request to client %d, want %d", cid, saved.ClientID)
+		}
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("state file of an adopted session: %s", err)
+	}
+}
+
+func TestRestoreSessionTeardown(t *testing.T) {
+	up := int32(0)
+	path, saved := startSession(t, &up)
+
+	dev, modem := openFake(t, sessionModem(&up))
+	wds, st, err := dev.RestoreSession(path, nil)
+	if err != nil || st == nil {
+		t.Fatalf("RestoreSession: %+v, %v", st, err)
+	}
+	_, err = wds.Send(&WDSStopNetworkInput{PacketDataHandle: st.PacketDataHandle})
+	if err != nil {
+		t.Fatalf("stopping the adopted session: %s", err)
+	}
+	err = ClearSessionState(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if atomic.LoadInt32(&up) != 0 {
+		t.Error("the session is still up")
+	}
+	reqs := modem.received(QMI_SERVICE_WDS)
+	if len(reqs) != 1 {
+		t.Fatalf("%d WDS requests, want 1", len(reqs))
+	}
+	if cids := frameCIDs(modem); cids[0] != saved.ClientID {
+		t.Errorf("Stop Network to client %d, want %d", cids[0], saved.ClientID)
+	}
+	if st, err := LoadSessionState(path); st != nil || err != nil {
+		t.Errorf("state after teardown: %+v, %v", st, err)
+	}
+}
+
+func TestRestoreSessionGone(t *testing.T) {
+	up := int32(0)
+	path, _ := startSession(t, &up)
+	// the modem dropped the session meanwhile
+	atomic.StoreInt32(&up, 0)
+
+	dev, _ := openFake(t, sessionModem(&up))
+	wds, st, err := dev.RestoreSession(path, verifyConnected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st != nil {
+		t.Errorf("state of a session gone: %+v", st)
+	}
+	if wds == nil {
+		t.Error("no client to tear down with")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("state file of a session gone: %v", err)
+	}
+}
+
+func TestRestoreSessionOtherDevice(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.json")
+	err := SaveSessionState(path, &SessionState{Device: "other", Service: QMI_SERVICE_WDS, ClientID: 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dev, _ := openFake(t, nil)
+	client, st, err := dev.RestoreSession(path, nil)
+	if client != nil || st != nil || err != nil {
+		t.Errorf("state of another device restored: %v, %+v, %v", client, st, err)
+	}
+
+	if st, err := LoadSessionState(filepath.Join(t.TempDir(), "none.json")); st != nil || err != nil {
+		t.Errorf("missing state file: %+v, %v", st, err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
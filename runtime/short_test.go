@@ -0,0 +1,42 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/tlv"
+)
+
+// TestShortTLVs decodes TLVs holding less than their length or their
+// fields claim, which must fail rather than leave the fields zeroed
+func TestShortTLVs(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		msg  Message
+		tlvs string
+		want error
+	}{
+		// 5 bytes declared, 2 left in the message
+		{"length past the end", &DMSGetIDsOutput{}, "02 0400 0000 0000 10 0500 3335", tlv.ErrTruncated(0x10)},
+		// a guint16 in a byte
+		{"short scalar", &NASSetSystemSelectionPreferenceInput{}, "11 0100 01", io.ErrUnexpectedEOF},
+		// 6 bytes of guint-sized in 4
+		{"short guint-sized", &DMSSetTimeInput{}, "01 0400 01020304", io.ErrUnexpectedEOF},
+		// 3 bytes of a guint16, a guint16 and a guint8
+		{"short struct", &NASInitiateNetworkRegisterInput{}, "01 0100 01 10 0300 fa00 63", io.ErrUnexpectedEOF},
+	} {
+		tlvs, _ := hex.DecodeString(stripSpaces(test.tlvs))
+		err := test.msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: err = %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,176 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// requestNames are the types of the requests the modem read, in order
+func (m *fakeModem) requestNames() []string {
+	m.Lock()
+	defer m.Unlock()
+	names := make([]string, len(m.requests))
+	for i, req := range m.requests {
+		names[i] = typeName(req)
+	}
+	return names
+}
+
+func typeName(v interface{}) string {
+	name := reflect.TypeOf(v).String()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// indexOf is the position of name in names, -1 if missing
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// TestShutdownClean waits for the Get IDs in flight, refuses new sends
+// meanwhile, releases the DMS client and closes the device
+func TestShutdownClean(t *testing.T) {
+	release := make(chan struct{})
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetIDsInput); ok {
+			<-release
+		}
+		return identityModem(req)
+	})
+	if _, err := dev.GetService(QMI_SERVICE_DMS); err != nil {
+		t.Fatal(err)
+	}
+	inflight := sendAsync(context.Background(), dev, &DMSGetIDsInput{})
+	waitFor(t, "Get IDs sent", func() bool { return len(modem.received(QMI_SERVICE_DMS)) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- dev.Shutdown(ctx) }()
+	pending(t, "Shutdown", done)
+
+	_, err := dev.Send(&DMSGetManufacturerInput{})
+	if _, ok := err.(ErrShuttingDown); !ok {
+		t.Errorf("Send while shutting down: %v", err)
+	}
+
+	close(release)
+	if err := <-inflight; err != nil {
+		t.Errorf("in-flight Get IDs: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	names := modem.requestNames()
+	if i := indexOf(names, "CTLReleaseCIDInput"); i < indexOf(names, "DMSGetIDsInput") {
+		t.Errorf("requests %v, want DMS released after Get IDs", names)
+	}
+	if _, err := dev.Send(&DMSGetManufacturerInput{}); err == nil {
+		t.Error("Send after Shutdown succeeded")
+	}
+	if err := dev.Shutdown(ctx); err == nil {
+		t.Error("second Shutdown succeeded")
+	}
+}
+
+// TestShutdownDeadline gives up on a transaction the modem never answers
+// once ctx expires, reports the steps cut short and closes the device
+func TestShutdownDeadline(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*DMSGetIDsInput); ok {
+			return nil
+		}
+		return identityModem(req)
+	}, WithLogger(log.New(ioutil.Discard, "", 0)))
+	if _, err := dev.GetService(QMI_SERVICE_DMS); err != nil {
+		t.Fatal(err)
+	}
+	send_ctx, cancel_send := context.WithCancel(context.Background())
+	defer cancel_send()
+	inflight := sendAsync(send_ctx, dev, &DMSGetIDsInput{})
+	waitFor(t, "Get IDs sent", func() bool { return len(modem.received(QMI_SERVICE_DMS)) == 1 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	err := dev.Shutdown(ctx)
+	var failed ErrShutdown
+	if !errors.As(err, &failed) {
+		t.Fatalf("Shutdown: %v, want ErrShutdown", err)
+	}
+	if failed[0].Step != "drain" || !errors.Is(failed[0].Err, context.DeadlineExceeded) {
+		t.Errorf("first step %s: %v, want drain cut short", failed[0].Step, failed[0].Err)
+	}
+	for _, step := range failed {
+		if step.Step == "close" {
+			t.Errorf("close failed: %v", step.Err)
+		}
+	}
+
+	cancel_send()
+	<-inflight
+	if _, err := dev.Send(&DMSGetManufacturerInput{}); err == nil {
+		t.Error("Send after Shutdown succeeded")
+	}
+}
+
+// TestShutdownActiveSession stops the data session left through the hook
+// of the ConnectionManager before the WDS client is released
+func TestShutdownActiveSession(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		switch req.(type) {
+		case *WDSStartNetworkInput:
+			resp := &WDSStartNetworkOutput{}
+			setField(resp, "PacketDataHandle", uint32(0x1234))
+			return resp
+		case *WDSStopNetworkInput:
+			return &WDSStopNetworkOutput{}
+		}
+		return nil
+	})
+	cm, err := NewConnectionManager(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := cm.Connect(ctx, "internet"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = dev.Shutdown(ctx)
+	if err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if s := cm.Sessions(); len(s) != 0 {
+		t.Errorf("sessions %x left", s)
+	}
+	var stopped []uint32
+	for _, req := range modem.received(QMI_SERVICE_WDS) {
+		if _, ok := req.(*WDSStopNetworkInput); ok {
+			stopped = append(stopped, uint32Field(req, "PacketDataHandle"))
+		}
+	}
+	if len(stopped) != 1 || stopped[0] != 0x1234 {
+		t.Errorf("stopped %x, want 1234", stopped)
+	}
+	names := modem.requestNames()
+	if indexOf(names, "CTLReleaseCIDInput") < indexOf(names, "WDSStopNetworkInput") {
+		t.Errorf("requests %v, want the session stopped before WDS is released", names)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,69 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestStrictEnums decodes connection statuses in range, at its bounds and
+// out of it: warnings with WithStrictEnums, raw values passed through
+// either way
+func TestStrictEnums(t *testing.T) {
+	var status QMIWdsConnectionStatus
+	handle := func(req Message) Message {
+		if _, ok := req.(*WDSGetPacketServiceStatusInput); ok {
+			resp := &WDSGetPacketServiceStatusOutput{}
+			setField(resp, "ConnectionStatus", status)
+			return resp
+		}
+		return nil
+	}
+	strict, _ := openFake(t, handle, WithStrictEnums())
+	lenient, _ := openFake(t, handle)
+
+	for _, test := range []struct {
+		status QMIWdsConnectionStatus
+		valid  bool
+	}{
+		{QMIWdsConnectionStatusConnected, true},
+		{QMIWdsConnectionStatusDisconnected, true},
+		{QMIWdsConnectionStatusAuthenticating, true},
+		{0, false},
+		{5, false},
+		{0xff, false},
+	} {
+		status = test.status
+		if test.status.IsValid() != test.valid {
+			t.Errorf("%s: IsValid %v", test.status, !test.valid)
+		}
+
+		resp, err := strict.Send(&WDSGetPacketServiceStatusInput{})
+		if got := indirect(resp, "ConnectionStatus"); got != test.status {
+			t.Errorf("strict %s: decoded %v", test.status, got)
+		}
+		var partial *PartialDecodeError
+		switch {
+		case test.valid && err != nil:
+			t.Errorf("strict %s: %v", test.status, err)
+		case !test.valid && !errors.As(err, &partial):
+			t.Errorf("strict %s: %v, want a PartialDecodeError", test.status, err)
+		case !test.valid:
+			if partial.Err != nil || len(partial.Warnings) != 1 || partial.Warnings[0].Field != "ConnectionStatus" || partial.Warnings[0].Value != test.status {
+				t.Errorf("strict %s: %+v", test.status, partial)
+			}
+		}
+
+		resp, err = lenient.Send(&WDSGetPacketServiceStatusInput{})
+		if err != nil {
+			t.Errorf("lenient %s: %v", test.status, err)
+		}
+		if got := indirect(resp, "ConnectionStatus"); got != test.status {
+			t.Errorf("lenient %s: decoded %v", test.status, got)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
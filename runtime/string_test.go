@@ -0,0 +1,126 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"bytes"
+	"encoding/hex"
+	"reflect"
+	"testing"
+)
+
+// TestStringArray reads an array of strings each preceded by its length,
+// an empty one among them, and writes the same bytes back
+func TestStringArray(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000" +
+		" 10 0300 00 00 00 11 0c00 03 03 4d5453 00 05 54656c6532" +
+		" 12 0600 000000 000000 13 0000"))
+	msg := &NASGetOperatorNameOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reflect.Indirect(reflect.ValueOf(msg).Elem().FieldByName("PLMNNames")).Interface()
+	if want := []string{"MTS", "", "Tele2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %q, want %q", got, want)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), tlvs) {
+		t.Errorf("\n got % x\nwant % x", buf.Bytes(), tlvs)
+	}
+}
+
+// TestStringArrayShort expects an element longer than the TLV to fail
+func TestStringArrayShort(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000 11 0400 01 05 4d54"))
+	err := (&NASGetOperatorNameOutput{}).TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err == nil {
+		t.Error("truncated element accepted")
+	}
+}
+
+// TestStringInSequence reads a string between two other fields of a
+// sequence, bounded by its length prefix
+func TestStringInSequence(t *testing.T) {
+	tlvs, _ := hex.DecodeString(stripSpaces("02 0400 0000 0000" +
+		" 10 0800 01 05 54656c6532 07 11 0100 00" +
+		" 12 0600 000000 000000 13 0000"))
+	msg := &NASGetOperatorNameOutput{}
+	err := msg.TLVsReadFrom(bytes.NewBuffer(tlvs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := reflect.Indirect(reflect.ValueOf(msg).Elem().FieldByName("ServiceProviderName")).Interface()
+	want := struct {
+		NameDisplayCondition uint8
+		Name                 string
+		NameSource           uint8
+	}{1, "Tele2", 7}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+
+	var buf bytes.Buffer
+	err = msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf.Bytes(), tlvs) {
+		t.Errorf("\n got % x\nwant % x", buf.Bytes(), tlvs)
+	}
+}
+
+// TestStringWrite writes strings inside a sequence with their length
+// prefix or padded to their fixed size, and reads them back
+func TestStringWrite(t *testing.T) {
+	msg := &NASGetOperatorNameOutput{}
+	setField(msg, "ServiceProviderName", struct {
+		NameDisplayCondition uint8
+		Name                 string
+		NameSource           uint8
+	}{1, "MTS", 2})
+	setField(msg, "OperatorCode", struct {
+		MCC string
+		MNC string
+	}{"250", "1"})
+
+	var buf bytes.Buffer
+	err := msg.TLVsWriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		tag     uint8
+		payload string
+	}{
+		{0x10, "01 03 4d5453 02"},
+		{0x12, "323530 310000"},
+	} {
+		want, _ := hex.DecodeString(stripSpaces(test.payload))
+		got := findTag(bytes.NewBuffer(buf.Bytes()), test.tag)
+		if got == nil || !bytes.Equal(got.Bytes(), want) {
+			t.Errorf("TLV %02x: % x, want % x", test.tag, got, want)
+		}
+	}
+
+	read := &NASGetOperatorNameOutput{}
+	err = read.TLVsReadFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, field := range []string{"ServiceProviderName", "OperatorCode"} {
+		got := reflect.ValueOf(read).Elem().FieldByName(field).Interface()
+		want := reflect.ValueOf(msg).Elem().FieldByName(field).Interface()
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: read %+v, want %+v", field, got, want)
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
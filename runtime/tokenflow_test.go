@@ -0,0 +1,157 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// sendAndWait runs SendAndWait of req in the background
+func sendAndWait(ctx context.Context, dev *Device, req Message) <-chan interface{} {
+	done := make(chan interface{}, 1)
+	go func() {
+		ind, err := dev.SendAndWait(ctx, req)
+		if err != nil {
+			done <- err
+			return
+		}
+		done <- ind
+	}()
+	return done
+}
+
+// listConfigs is a PDC List Configs indication of token listing a config
+// of type typ
+func listConfigs(token uint32, typ uint32) *PDCListConfigsIndication {
+	ind := &PDCListConfigsIndication{}
+	setField(ind, "Token", token)
+	setField(ind, "Configs", []struct {
+		ConfigType uint32
+		ID         []uint8
+	}{{typ, []uint8{1}}})
+	return ind
+}
+
+func configType(ind *PDCListConfigsIndication) uint32 {
+	configs := reflect.Indirect(reflect.ValueOf(ind).Elem().FieldByName("Configs"))
+	if configs.Len() == 0 {
+		return 0
+	}
+	return uint32(configs.Index(0).Field(0).Uint())
+}
+
+// pdcModem acknowledges List Configs, the test sends the indications
+func pdcModem(req Message) Message {
+	if _, ok := req.(*PDCListConfigsInput); ok {
+		return &PDCListConfigsOutput{}
+	}
+	return nil
+}
+
+func TestTokenFlowMatched(t *testing.T) {
+	dev, modem := openFake(t, pdcModem)
+	pdc, err := dev.GetService(QMI_SERVICE_PDC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := &PDCListConfigsInput{}
+	setField(req, "Token", uint32(7))
+	done := sendAndWait(context.Background(), dev, req)
+	waitFor(t, "List Configs", func() bool { return len(modem.received(QMI_SERVICE_PDC)) == 1 })
+
+	// the indication of another request comes first
+	modem.send(listConfigs(8, 1), pdc.ClientID, 0, true)
+	modem.send(listConfigs(7, 2), pdc.ClientID, 0, true)
+
+	select {
+	case res := <-done:
+		ind, ok := res.(*PDCListConfigsIndication)
+		if !ok {
+			t.Fatalf("SendAndWait: %v", res)
+		}
+		if typ := configType(ind); typ != 2 {
+			t.Errorf("indication of config type %d returned, want 2", typ)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SendAndWait did not return")
+	}
+}
+
+func TestTokenFlowResponseToken(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*UIMReadTransparentInput); !ok {
+			return nil
+		}
+		resp := &UIMReadTransparentOutput{}
+		setField(resp, "ResponseInIndication", uint32(0x55))
+		return resp
+	})
+	uim, err := dev.GetService(QMI_SERVICE_UIM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := sendAndWait(context.Background(), dev, &UIMReadTransparentInput{})
+	waitFor(t, "Read Transparent", func() bool { return len(modem.received(QMI_SERVICE_UIM)) == 1 })
+
+	for _, token := range []uint32{0x54, 0x55} {
+		ind := &UIMReadTransparentIndication{}
+		setField(ind, "ResponseInIndication", token)
+		setField(ind, "ReadResult", []uint8{byte(token)})
+		modem.send(ind, uim.ClientID, 0, true)
+	}
+
+	select {
+	case res := <-done:
+		ind, ok := res.(*UIMReadTransparentIndication)
+		if !ok {
+			t.Fatalf("SendAndWait: %v", res)
+		}
+		result := reflect.Indirect(reflect.ValueOf(ind).Elem().FieldByName("ReadResult")).Bytes()
+		if !reflect.DeepEqual(result, []uint8{0x55}) {
+			t.Errorf("read result % x, want 55", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SendAndWait did not return")
+	}
+}
+
+func TestTokenFlowTimeout(t *testing.T) {
+	dev, modem := openFake(t, pdcModem)
+	pdc, err := dev.GetService(QMI_SERVICE_PDC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	req := &PDCListConfigsInput{}
+	setField(req, "Token", uint32(7))
+	done := sendAndWait(ctx, dev, req)
+	waitFor(t, "List Configs", func() bool { return len(modem.received(QMI_SERVICE_PDC)) == 1 })
+	modem.send(listConfigs(8, 1), pdc.ClientID, 0, true)
+
+	select {
+	case res := <-done:
+		if res != context.DeadlineExceeded {
+			t.Errorf("SendAndWait: %v, want context.DeadlineExceeded", res)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SendAndWait did not return")
+	}
+}
+
+func TestTokenFlowUnregistered(t *testing.T) {
+	dev, _ := openFake(t, nil)
+	_, err := dev.SendAndWait(context.Background(), &DMSGetIDsInput{})
+	if err != ErrNoTokenFlow(0x25) {
+		t.Errorf("err = %v, want ErrNoTokenFlow", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,51 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// txids are the transaction IDs of the frames the modem read for svc
+func (m *fakeModem) txids(svc Service) []uint16 {
+	m.Lock()
+	defer m.Unlock()
+	var ids []uint16
+	for _, frame := range m.frames {
+		switch {
+		case Service(frame[4]) != svc:
+		case svc == QMI_SERVICE_CTL:
+			ids = append(ids, uint16(frame[7]))
+		default:
+			ids = append(ids, binary.LittleEndian.Uint16(frame[7:]))
+		}
+	}
+	return ids
+}
+
+// TestTransactionSeed expects the transaction IDs of every client to count
+// up from the seed, the 8-bit ones of CTL wrapping to 1
+func TestTransactionSeed(t *testing.T) {
+	dev, modem := openFake(t, identityModem, WithTransactionSeed(0xfe))
+	for i := 0; i < 2; i++ {
+		if _, err := dev.Send(&DMSGetManufacturerInput{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := dev.Send(&DMSGetIDsInput{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Sync, then Allocate CID of DMS
+	if got := modem.txids(QMI_SERVICE_CTL); !reflect.DeepEqual(got, []uint16{0xff, 0x01}) {
+		t.Errorf("CTL transaction IDs %x", got)
+	}
+	if got := modem.txids(QMI_SERVICE_DMS); !reflect.DeepEqual(got, []uint16{0xff, 0x100, 0x101}) {
+		t.Errorf("DMS transaction IDs %x", got)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,69 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestUnansweredMessage sends WMS Send Ack, marked no-response in the data
+// files: Send returns once it is written, leaving nothing pending, and a
+// response the modem sends anyway is counted as unknown
+func TestUnansweredMessage(t *testing.T) {
+	dev, modem := openFake(t, nil)
+	ack := &WMSSendAckInput{}
+	ack.Information.TransactionID = 7
+	ack.Information.Success = 1
+
+	done := make(chan Message, 1)
+	go func() {
+		resp, err := dev.SendContext(context.Background(), ack)
+		if err != nil {
+			t.Error(err)
+		}
+		done <- resp
+	}()
+	var resp Message
+	select {
+	case resp = <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Send Ack awaits a response")
+	}
+	if resp != nil {
+		t.Errorf("Send Ack returned %#v", resp)
+	}
+
+	waitFor(t, "Send Ack", func() bool { return len(modem.received(QMI_SERVICE_WMS)) == 1 })
+	reqs := modem.received(QMI_SERVICE_WMS)
+	if got := reqs[0].(*WMSSendAckInput); got.Information != ack.Information {
+		t.Errorf("modem read %+v", got.Information)
+	}
+	dev.Lock()
+	waiting := len(dev.ch)
+	dev.Unlock()
+	if waiting != 0 {
+		t.Errorf("%d responses awaited", waiting)
+	}
+
+	ids := modem.txids(QMI_SERVICE_WMS)
+	modem.Lock()
+	cid := modem.frames[len(modem.frames)-1][5]
+	modem.Unlock()
+	modem.send(&WMSSendAckOutput{}, cid, ids[0], false)
+	waitFor(t, "unknown response", func() bool { return dev.Stats().UnknownResponses == 1 })
+
+	// the client goes on
+	_, err := dev.SendContext(context.Background(), ack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "second Send Ack", func() bool { return len(modem.received(QMI_SERVICE_WMS)) == 2 })
+	if ids := modem.txids(QMI_SERVICE_WMS); len(ids) != 2 || ids[1] != ids[0]+1 {
+		t.Errorf("transaction IDs %v", ids)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
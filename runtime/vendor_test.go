@@ -0,0 +1,77 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"strings"
+	"testing"
+)
+
+// telitModem answers the Telit firmware query, which shares its ID with
+// Get Band Capabilities
+func telitModem(req Message) Message {
+	if _, ok := req.(*DMSGetBandCapabilitiesInput); ok {
+		return &DMSTelitGetFirmwareInfoOutput{Version: "LE910C1-"}
+	}
+	return nil
+}
+
+// TestVendorMessage decodes a response of the vendor specific message as
+// the standard one unless the vendor is given
+func TestVendorMessage(t *testing.T) {
+	if v := (&DMSTelitGetFirmwareInfoInput{}).MessageVendor(); v != 0x1BC7 {
+		t.Errorf("MessageVendor %04x", v)
+	}
+	if id := (&DMSTelitGetFirmwareInfoInput{}).MessageID(); id != (&DMSGetBandCapabilitiesInput{}).MessageID() {
+		t.Errorf("message ID %04x", id)
+	}
+
+	buf, err := Marshal(&DMSTelitGetFirmwareInfoOutput{Version: "LE910C1-"}, 1, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	if _, err := Unmarshal(buf.Bytes(), &msg); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*DMSGetBandCapabilitiesOutput); !ok {
+		t.Errorf("standard decoding %T", msg)
+	}
+	if _, err := UnmarshalVendor(buf.Bytes(), &msg, 0x1BC7); err != nil {
+		t.Fatal(err)
+	}
+	if resp, ok := msg.(*DMSTelitGetFirmwareInfoOutput); !ok || resp.Version != "LE910C1-" {
+		t.Errorf("vendor decoding %#v", msg)
+	}
+	if _, err := UnmarshalVendor(buf.Bytes(), &msg, 0x1234); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*DMSGetBandCapabilitiesOutput); !ok {
+		t.Errorf("other vendor decoding %T", msg)
+	}
+}
+
+// TestVendorDevice sends the vendor specific message to a device opened
+// for the vendor, and expects the others to refuse it up front
+func TestVendorDevice(t *testing.T) {
+	dev, _ := openFake(t, telitModem, WithVendor(0x1BC7))
+	resp, err := dev.DMSTelitGetFirmwareInfo(DMSTelitGetFirmwareInfoInput{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Version != "LE910C1-" {
+		t.Errorf("version %q", resp.Version)
+	}
+
+	dev, modem := openFake(t, telitModem)
+	_, err = dev.DMSTelitGetFirmwareInfo(DMSTelitGetFirmwareInfoInput{})
+	if err == nil || !strings.Contains(err.Error(), "is specific to vendor") {
+		t.Errorf("got %v", err)
+	}
+	if n := len(modem.received(QMI_SERVICE_DMS)); n != 0 {
+		t.Errorf("%d DMS requests sent", n)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
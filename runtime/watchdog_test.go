@@ -0,0 +1,215 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// pingModem answers CTL Get Version Info unless wedged is set, then
+// according to silent: not at all or with a failure
+func pingModem(wedged *int32, silent bool) func(req Message) Message {
+	return func(req Message) Message {
+		if _, ok := req.(*CTLGetVersionInfoInput); !ok {
+			return nil
+		}
+		resp := &CTLGetVersionInfoOutput{}
+		if atomic.LoadInt32(wedged) == 0 {
+			return resp
+		}
+		if silent {
+			return nil
+		}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = 0x0001
+		return resp
+	}
+}
+
+func pings(m *fakeModem) int {
+	n := 0
+	for _, req := range m.received(QMI_SERVICE_CTL) {
+		if _, ok := req.(*CTLGetVersionInfoInput); ok {
+			n++
+		}
+	}
+	return n
+}
+
+func expectWedged(t *testing.T, ch <-chan *WedgedEvent) *WedgedEvent {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		return ev
+	case <-time.After(time.Second):
+		t.Fatal("no WedgedEvent")
+	}
+	return nil
+}
+
+func expectQuiet(t *testing.T, ch <-chan *WedgedEvent) {
+	t.Helper()
+	select {
+	case ev := <-ch:
+		t.Fatalf("unexpected %+v", ev)
+	default:
+	}
+}
+
+func TestWatchdogStartsAfterSync(t *testing.T) {
+	fakeClock(t)
+	modem, f := newFakeModem(t, func(req Message) Message {
+		if _, ok := req.(*CTLSyncInput); !ok {
+			return nil
+		}
+		resp := &CTLSyncOutput{}
+		resp.ErrorStatus = 1
+		resp.ErrorCode = 0x0001
+		return resp
+	})
+
+	_, err := NewDevice(f, "fake", WithWatchdog(WatchdogConfig{Interval: time.Second}))
+	if err != QMIError(1) {
+		t.Fatalf("err = %v, want QMIError(1)", err)
+	}
+	clk.awaitTickers(t, 0)
+	if _, err := f.Write([]byte{1}); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("transport of a failed NewDevice: write err = %v, want os.ErrClosed", err)
+	}
+	if n := pings(modem); n != 0 {
+		t.Errorf("%d pings", n)
+	}
+}
+
+func TestWatchdogDetects(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		fakeClock(t)
+		wedged := int32(1)
+		events := make(chan *WedgedEvent, 1)
+		dev, modem := openFake(t, pingModem(&wedged, silent), WithWatchdog(WatchdogConfig{
+			// also the ping timeout, which runs in real time
+			Interval:  20 * time.Millisecond,
+			Threshold: 2,
+			OnWedged:  func(ev *WedgedEvent) { events <- ev },
+		}))
+		clk.awaitTickers(t, 1)
+
+		clk.advance(t, 20*time.Millisecond)
+		clk.advance(t, 20*time.Millisecond)
+
+		ev := expectWedged(t, events)
+		if ev.Failures != 2 || ev.Device != "fake" {
+			t.Errorf("silent %t: %+v", silent, ev)
+		}
+		want := error(QMIError(1))
+		if silent {
+			want = context.DeadlineExceeded
+		}
+		if ev.Err != want {
+			t.Errorf("silent %t: Err = %v, want %v", silent, ev.Err, want)
+		}
+		if n := pings(modem); n != 2 {
+			t.Errorf("silent %t: %d pings, want 2", silent, n)
+		}
+
+		select {
+		case got := <-dev.Events():
+			if got != ev {
+				t.Errorf("silent %t: event %+v", silent, got)
+			}
+		default:
+			t.Errorf("silent %t: no WedgedEvent on the event stream", silent)
+		}
+
+		// counting starts over
+		clk.advance(t, 20*time.Millisecond)
+		clk.advance(t, 20*time.Millisecond)
+		if ev := expectWedged(t, events); ev.Failures != 2 {
+			t.Errorf("silent %t: %d failures after the first event, want 2", silent, ev.Failures)
+		}
+		dev.Close()
+	}
+}
+
+func TestWatchdogPausesForLongOperations(t *testing.T) {
+	fakeClock(t)
+	wedged := int32(1)
+	events := make(chan *WedgedEvent, 1)
+	dev, modem := openFake(t, pingModem(&wedged, false), WithWatchdog(WatchdogConfig{
+		Interval:  time.Second,
+		Threshold: 2,
+		OnWedged:  func(ev *WedgedEvent) { events <- ev },
+	}))
+	clk.awaitTickers(t, 1)
+
+	nas, err := dev.GetService(QMI_SERVICE_NAS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a Network Scan is allowed minutes, the modem does not answer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := nas.SendContext(ctx, &NASNetworkScanInput{})
+		done <- err
+	}()
+	waitFor(t, "the scan", func() bool {
+		dev.Lock()
+		defer dev.Unlock()
+		return dev.longOps == 1
+	})
+
+	for i := 0; i < 4; i++ {
+		clk.advance(t, time.Second)
+	}
+	expectQuiet(t, events)
+	if n := pings(modem); n != 0 {
+		t.Errorf("%d pings during the scan", n)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Fatalf("scan: %v", err)
+	}
+	clk.advance(t, time.Second)
+	clk.advance(t, time.Second)
+	expectWedged(t, events)
+}
+
+func TestWatchdogRecovers(t *testing.T) {
+	fakeClock(t)
+	wedged := int32(1)
+	events := make(chan *WedgedEvent, 1)
+	dev, _ := openFake(t, pingModem(&wedged, false), WithWatchdog(WatchdogConfig{
+		Interval: time.Second,
+		OnWedged: func(ev *WedgedEvent) { events <- ev },
+		Recover:  (*Device).Reconnect,
+	}))
+	clk.awaitTickers(t, 1)
+
+	healthy := int32(0)
+	fresh, f := newFakeModem(t, pingModem(&healthy, false))
+	dev.reopen = func() (*os.File, error) { return f, nil }
+
+	clk.advance(t, time.Second)
+	expectWedged(t, events)
+
+	// a tick is taken once the previous one is handled
+	clk.advance(t, time.Second)
+	clk.advance(t, time.Second)
+	expectQuiet(t, events)
+	if n := pings(fresh); n == 0 {
+		t.Error("the reopened modem got no ping")
+	}
+	if len(fresh.received(QMI_SERVICE_CTL)) == 0 {
+		t.Error("the reopened modem was not synchronized")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,162 @@
+//go:build qmiruntime
+// +build qmiruntime
+
+package qmi
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func rawSend(n int) *WMSRawSendInput {
+	m := &WMSRawSendInput{}
+	m.RawMessageData.RawData = make([]uint8, n)
+	return m
+}
+
+func TestFrameTooLarge(t *testing.T) {
+	dev, modem := openFake(t, func(req Message) Message {
+		if _, ok := req.(*WMSRawSendInput); !ok {
+			return nil
+		}
+		return &WMSRawSendOutput{MessageIndex: 7}
+	})
+	wms, err := dev.GetService(QMI_SERVICE_WMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.MaxWriteSize() != DEFAULT_MAX_WRITE_SIZE {
+		t.Fatalf("MaxWriteSize = %d without a cdc-wdm descriptor", dev.MaxWriteSize())
+	}
+
+	// 13 bytes of QMUX and SDU header, 3 of TLV header, 1 + 2 of format
+	// and size prefix: 4077 bytes of data fill 4096
+	_, err = wms.Send(rawSend(4078))
+	var too_large *ErrFrameTooLarge
+	if !errors.As(err, &too_large) {
+		t.Fatalf("err = %v, want ErrFrameTooLarge", err)
+	}
+	want := ErrFrameTooLarge{Service: QMI_SERVICE_WMS, MessageID: 0x0020, Size: 4097, Limit: 4096}
+	if *too_large != want {
+		t.Errorf("err = %+v, want %+v", *too_large, want)
+	}
+	if reqs := modem.received(QMI_SERVICE_WMS); len(reqs) != 0 {
+		t.Errorf("modem read %d WMS frames of an oversized request", len(reqs))
+	}
+
+	resp, err := wms.Send(rawSend(4077))
+	if err != nil {
+		t.Fatalf("frame of the limit: %s", err)
+	}
+	if resp.(*WMSRawSendOutput).MessageIndex != 7 {
+		t.Errorf("resp = %v", resp)
+	}
+
+	dev.SetMaxWriteSize(8192)
+	_, err = wms.Send(rawSend(5000))
+	if err != nil {
+		t.Fatalf("raised limit: %s", err)
+	}
+
+	// the QMUX length field caps frames regardless of the limit
+	dev.SetMaxWriteSize(1 << 20)
+	_, err = wms.Send(rawSend(QMUX_MAX_FRAME_SIZE))
+	if err == nil {
+		t.Error("frame beyond the QMUX length field was sent")
+	}
+	if reqs := modem.received(QMI_SERVICE_WMS); len(reqs) != 2 {
+		t.Errorf("modem read %d WMS frames, want 2", len(reqs))
+	}
+}
+
+func rawSendModem(req Message) Message {
+	if _, ok := req.(*WMSRawSendInput); !ok {
+		return nil
+	}
+	return &WMSRawSendOutput{}
+}
+
+func TestBlockedWrite(t *testing.T) {
+	modem, f := newFakeModem(t, rawSendModem)
+	// a send buffer of a few KiB fills with a frame or two
+	raw, err := f.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw.Control(func(fd uintptr) {
+		syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF, 1)
+	})
+	dev, err := NewDevice(f, "fake")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	wms, err := dev.GetService(QMI_SERVICE_WMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resume := modem.stall()
+	for i := 0; dev.Stats().WriteStalls == 0; i++ {
+		if i == 16 {
+			t.Fatal("writes never stalled")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		_, err := wms.SendContext(ctx, rawSend(2000))
+		cancel()
+		// the modem may take one more frame before it stalls
+		if err != nil && err != context.DeadlineExceeded {
+			t.Fatalf("send %d: err = %v, want context.DeadlineExceeded", i, err)
+		}
+	}
+
+	dev.Lock()
+	pending := len(dev.ch)
+	dev.Unlock()
+	if pending != 0 {
+		t.Errorf("%d transactions left registered", pending)
+	}
+
+	// the stalled endpoint recovers, writes without a deadline of their
+	// own must not run into the one of the abandoned write
+	resume()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	timer := time.AfterFunc(time.Second, cancel)
+	defer timer.Stop()
+	_, err = wms.SendContext(ctx, rawSend(10))
+	if err != nil {
+		t.Fatalf("send after the stall: %s", err)
+	}
+}
+
+func TestWriteDeadlineCleared(t *testing.T) {
+	dev, _ := openFake(t, rawSendModem)
+	wms, err := dev.GetService(QMI_SERVICE_WMS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	_, err = wms.SendContext(ctx, rawSend(10))
+	cancel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	// a context without deadline writes from a goroutine, on the same file
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	timer := time.AfterFunc(time.Second, cancel)
+	defer timer.Stop()
+	_, err = wms.SendContext(ctx, rawSend(10))
+	if err != nil {
+		t.Fatalf("send after the deadline passed: %s", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
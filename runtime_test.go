@@ -0,0 +1,137 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fixtureModule is the module of the package generated from testdata/data,
+// the one the examples and qmitrace import
+const fixtureModule = "bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmi"
+
+// generatorModule is the module of qmigen
+const generatorModule = "bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+
+// generateFixture generates the data files of testdata/data with o into a
+// module in a temporary directory, which it returns
+func generateFixture(t *testing.T, o Options) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds a generated package")
+	}
+
+	inputs, err := filepath.Glob("testdata/data/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	o.Generator = "qmigen"
+	err = GenerateFiles(inputs, dir, o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the runtime imports the tlv package of this module
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := "module " + fixtureModule + "\n\ngo 1.15\n\n" +
+		"require " + generatorModule + " v0.0.0\n\n" +
+		"replace " + generatorModule + " => " + wd + "\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte(mod), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copyFiles(t, dir, "go.sum")
+	return dir
+}
+
+// goTool runs the go command in dir, failing t with its output
+func goTool(t *testing.T, dir string, env []string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	// a go.work around the working directory must not apply
+	cmd.Env = append(append(os.Environ(), "GOWORK=off"), env...)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("go %s: %s\n%s", strings.Join(args, " "), err, out)
+	}
+}
+
+// copyFiles copies the files matching pattern into dir
+func copyFiles(t *testing.T, dir, pattern string) {
+	t.Helper()
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(dir, filepath.Base(file)), data, 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestRuntime runs the tests of runtime/ in the package generated from
+// testdata/data, which is where the runtime compiles: with the default
+// options and again with those changing the generated types, adding the
+// qmioptions build tag. The latter run once more on the byte order
+// fallback of big-endian hosts, which must produce the same frames, and
+// build for MIPS BE.
+func TestRuntime(t *testing.T) {
+	options := Options{
+		RetainRawTLVs:     true,
+		OptionalPointers:  true,
+		PresenceAccessors: true,
+		DirectEncoding:    true,
+		Internal:          true,
+		StringPolicy:      "replace",
+	}
+	for _, variant := range []struct {
+		tags string
+		opts Options
+	}{
+		{"qmiruntime", Options{}},
+		{"qmiruntime qmioptions", options},
+		{"qmiruntime qmioptions " + byteOrderFallbackTag, options},
+	} {
+		dir := generateFixture(t, variant.opts)
+		copyFiles(t, dir, "runtime/*_test.go")
+		goTool(t, dir, nil, "vet", "-tags", variant.tags, ".")
+		// the runtime is concurrent throughout; the benchmarks run once,
+		// to keep them working
+		goTool(t, dir, nil, "test", "-race", "-tags", variant.tags, "-bench", ".", "-benchtime", "1x", ".")
+		if strings.HasSuffix(variant.tags, byteOrderFallbackTag) {
+			goTool(t, dir, []string{"GOOS=linux", "GOARCH=mips"}, "test", "-c", "-o", os.DevNull, "-tags", "qmiruntime qmioptions", ".")
+		}
+	}
+}
+
+// TestEmbeddedRuntime makes sure footer.go is rendered from the current
+// runtime/qmi.go, so generated code carries what the runtime tests cover
+func TestEmbeddedRuntime(t *testing.T) {
+	want, err := EmbedRuntime("runtime/qmi.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadFile("footer.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Error("footer.go is stale, run go generate")
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
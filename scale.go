@@ -0,0 +1,204 @@
+package qmigen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// QMIScale is the type of an integer field carrying a "scale" or "unit":
+// the raw integer, with Float() in units and String() spelling the unit
+type QMIScale struct {
+	Name  string
+	Type  string // underlying Go type
+	Scale float64
+	Unit  string
+	Field string // name in the data file
+}
+
+// prepareScales names the types of scaled fields in tlvs after their path
+// from the type prefix, e.g. NASGetSignalInfoOutputLTESignalStrengthSNR
+func (gen *generator) prepareScales(prefix string, tlvs []QMITLV) error {
+	for i := range tlvs {
+		err := tlvs[i].QMITLVField.prepareScale(gen, prefix)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (field *QMITLVField) prepareScale(gen *generator, prefix string) error {
+	path := prefix + gen.goName(field.Name)
+	switch field.Format {
+	case "struct", "sequence":
+		for i := range field.Contents {
+			err := field.Contents[i].prepareScale(gen, path)
+			if err != nil {
+				return err
+			}
+		}
+	case "array":
+		if field.ArrayElement != nil {
+			return field.ArrayElement.prepareScale(gen, path)
+		}
+	}
+
+	// common-refs resolved into a message keep the type of the common one
+	if field.scaled != nil || field.Scale == "" && field.Unit == "" {
+		return nil
+	}
+
+	tname := strings.TrimPrefix(field.Format, "g")
+	switch tname {
+	case "int8", "uint8", "int16", "uint16", "int32", "uint32", "int64", "uint64":
+	default:
+		return fmt.Errorf("field %q: scale of format %q is unsupported", field.Name, field.Format)
+	}
+	if field.PublicFormat != "" {
+		return fmt.Errorf("field %q: scale of public-format %q is unsupported", field.Name, field.PublicFormat)
+	}
+
+	scale := 1.0
+	if field.Scale != "" {
+		var err error
+		scale, err = strconv.ParseFloat(field.Scale, 64)
+		if err != nil || scale <= 0 || math.IsInf(scale, 0) {
+			return fmt.Errorf("field %q: scale %q is not a positive number", field.Name, field.Scale)
+		}
+	}
+
+	field.scaled = &QMIScale{
+		Name:  path,
+		Type:  tname,
+		Scale: scale,
+		Unit:  field.Unit,
+		Field: field.Name,
+	}
+	gen.pendingScales = append(gen.pendingScales, field.scaled)
+	return nil
+}
+
+// FloatExpr converts v to units. Decimal fractions divide by their
+// reciprocal, as 0.1 has no exact float64: 3 * 0.1 != 0.3, 3 / 10 == 0.3
+func (qs *QMIScale) FloatExpr(v ast.Expr) ast.Expr {
+	f := &ast.CallExpr{
+		Fun:  commonIdent("float64"),
+		Args: []ast.Expr{cloneExpr(v)},
+	}
+	if qs.Scale == 1 {
+		return f
+	}
+
+	op, factor := token.MUL, qs.Scale
+	if r := 1 / qs.Scale; r > 1 && math.Abs(r-math.Round(r)) < 1e-9*r {
+		op, factor = token.QUO, math.Round(r)
+	}
+	lit := &ast.BasicLit{
+		Kind:  token.FLOAT,
+		Value: strconv.FormatFloat(factor, 'g', -1, 64),
+	}
+	if !strings.ContainsAny(lit.Value, ".e") {
+		lit.Kind = token.INT
+	}
+	return &ast.BinaryExpr{X: f, Op: op, Y: lit}
+}
+
+// GenDecls emits the type, Float() and String():
+//
+//	// X counts steps of 0.1 dBm
+//	type X int16
+//
+//	func (v X) Float() float64 { return float64(v) / 10 }
+//
+//	func (v X) String() string { return formatUnits(v.Float(), "dBm") }
+func (qs *QMIScale) GenDecls(gen *generator) []ast.Decl {
+	step := strings.TrimSpace(strconv.FormatFloat(qs.Scale, 'g', -1, 64) + " " + qs.Unit)
+	if qs.Scale == 1 && qs.Unit != "" {
+		gen.docComments["type "+qs.Name+" "] = fmt.Sprintf("%s is in %s", qs.Name, qs.Unit)
+	} else {
+		gen.docComments["type "+qs.Name+" "] = fmt.Sprintf("%s counts steps of %s", qs.Name, step)
+	}
+
+	recv := func() *ast.FieldList {
+		return &ast.FieldList{
+			List: []*ast.Field{
+				&ast.Field{
+					Names: []*ast.Ident{ast.NewIdent("v")},
+					Type:  ast.NewIdent(qs.Name),
+				},
+			},
+		}
+	}
+
+	return []ast.Decl{
+		&ast.GenDecl{
+			Tok: token.TYPE,
+			Specs: []ast.Spec{
+				&ast.TypeSpec{
+					Name: ast.NewIdent(qs.Name),
+					Type: ast.NewIdent(qs.Type),
+				},
+			},
+		},
+		&ast.FuncDecl{
+			Recv: recv(),
+			Name: commonIdent("Float"),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{
+						&ast.Field{Type: commonIdent("float64")},
+					},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.ReturnStmt{
+						Results: []ast.Expr{qs.FloatExpr(ast.NewIdent("v"))},
+					},
+				},
+			},
+		},
+		&ast.FuncDecl{
+			Recv: recv(),
+			Name: commonIdent("String"),
+			Type: &ast.FuncType{
+				Params: &ast.FieldList{},
+				Results: &ast.FieldList{
+					List: []*ast.Field{
+						&ast.Field{Type: commonIdent("string")},
+					},
+				},
+			},
+			Body: &ast.BlockStmt{
+				List: []ast.Stmt{
+					&ast.ReturnStmt{
+						Results: []ast.Expr{
+							&ast.CallExpr{
+								Fun: commonIdent("formatUnits"),
+								Args: []ast.Expr{
+									&ast.CallExpr{
+										Fun: &ast.SelectorExpr{
+											X:   ast.NewIdent("v"),
+											Sel: commonIdent("Float"),
+										},
+									},
+									&ast.BasicLit{
+										Kind:  token.STRING,
+										Value: strconv.Quote(qs.Unit),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
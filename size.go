@@ -0,0 +1,51 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/token"
+	"io"
+	"sort"
+)
+
+type sizeEntry struct {
+	Name  string
+	Decls int
+	Bytes int
+}
+
+// declSize estimates the generated code size of decls as the length of
+// their formatted source
+func declSize(decls []ast.Decl) int {
+	n := 0
+	for _, decl := range decls {
+		var buf bytes.Buffer
+		err := format.Node(&buf, token.NewFileSet(), decl)
+		if err != nil {
+			continue
+		}
+		n += buf.Len()
+	}
+	return n
+}
+
+// printSizeReport lists messages of a generated file, largest first
+func printSizeReport(w io.Writer, file string, sizes []sizeEntry) {
+	sort.SliceStable(sizes, func(i, j int) bool {
+		return sizes[i].Bytes > sizes[j].Bytes
+	})
+
+	total := 0
+	for _, s := range sizes {
+		total += s.Bytes
+	}
+
+	fmt.Fprintf(w, "%s: %d messages, %d bytes\n", file, len(sizes), total)
+	for _, s := range sizes {
+		fmt.Fprintf(w, "  %8d %4d %s\n", s.Bytes, s.Decls, s.Name)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
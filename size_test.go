@@ -0,0 +1,89 @@
+package qmigen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestSizeReport expects a line for every message of the data files
+func TestSizeReport(t *testing.T) {
+	var report bytes.Buffer
+	out := filepath.Join(t.TempDir(), "dms.go")
+	err := GenerateFile(out, "testdata/data/qmi-service-dms.json", Options{
+		Generator:  "qmigen",
+		SizeReport: &report,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// regenerating must not print the report again
+	src, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(src), "-size-report") {
+		t.Error("-size-report in the //go:generate line")
+	}
+
+	lines := strings.Split(strings.TrimSpace(report.String()), "\n")
+	if !strings.Contains(lines[0], ": 10 messages, ") {
+		t.Fatalf("header %q", lines[0])
+	}
+	names := map[string]bool{}
+	for _, line := range lines[1:] {
+		fields := strings.Fields(line)
+		names[strings.Join(fields[2:], " ")] = true
+	}
+	for _, name := range []string{"DMS Get Manufacturer", "DMS Get IDs", "DMS Set Operating Mode"} {
+		if !names[name] {
+			t.Errorf("no size of %q in\n%s", name, report.String())
+		}
+	}
+}
+
+// mainSource is a program opening a device after calling register
+func mainSource(register ...string) string {
+	return "package main\n\nimport qmi \"" + fixtureModule + "\"\n\nfunc main() {\n\tqmi." +
+		strings.Join(register, "()\n\tqmi.") + "()\n\tqmi.Open(\"/dev/cdc-wdm0\")\n}\n"
+}
+
+// TestExplicitRegisterSize builds a program registering every message and
+// one registering two, the linker must drop the others from the latter
+func TestExplicitRegisterSize(t *testing.T) {
+	dir := generateFixture(t, Options{ExplicitRegister: true})
+
+	sizes := map[string]int64{}
+	for name, register := range map[string][]string{
+		"full": {"RegisterCommonTLVs", "RegisterAllCTL", "RegisterAllDMS", "RegisterAllLOC", "RegisterAllNAS",
+			"RegisterAllPDC", "RegisterAllUIM", "RegisterAllWDS", "RegisterAllWMS"},
+		"two": {"RegisterCommonTLVs", "RegisterCTLAllocateCID", "RegisterDMSGetManufacturer"},
+	} {
+		src := filepath.Join(dir, "cmd", name)
+		err := os.MkdirAll(src, 0777)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(filepath.Join(src, "main.go"), []byte(mainSource(register...)), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+		bin := filepath.Join(dir, name)
+		goTool(t, dir, nil, "build", "-ldflags=-s -w", "-o", bin, "./cmd/"+name)
+		fi, err := os.Stat(bin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[name] = fi.Size()
+	}
+
+	t.Logf("full %d bytes, two messages %d bytes", sizes["full"], sizes["two"])
+	if delta := sizes["full"] - sizes["two"]; delta < 100<<10 {
+		t.Errorf("two messages save %d bytes, want at least 100 KiB", delta)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
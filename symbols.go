@@ -0,0 +1,176 @@
+package qmigen
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"strings"
+)
+
+// symbolTable maps the Go identifiers of a scope to the data file entities
+// generating them
+type symbolTable map[string]string
+
+// declare records ident for entity, failing when another entity already
+// generates it
+func (st symbolTable) declare(ident, entity string) error {
+	if prev, ok := st[ident]; ok && prev != entity {
+		return fmt.Errorf("%s and %s are both generated as %s", prev, entity, ident)
+	}
+	st[ident] = entity
+	return nil
+}
+
+// declareDecls records the top level identifiers of decls for entity among
+// the symbols of the run, methods keyed by "Type.Method", and entity as
+// the origin of decls
+func (gen *generator) declareDecls(decls []ast.Decl, entity string) error {
+	for _, decl := range decls {
+		gen.origins[decl] = entity
+		var idents []*ast.Ident
+		switch d := decl.(type) {
+		case *ast.FuncDecl:
+			if d.Recv == nil {
+				if d.Name.Name != "init" {
+					idents = append(idents, d.Name)
+				}
+				break
+			}
+			recv := strings.TrimPrefix(recvName(d.Recv.List[0].Type), "*")
+			idents = append(idents, ast.NewIdent(recv+"."+d.Name.Name))
+		case *ast.GenDecl:
+			if d.Tok == token.IMPORT {
+				continue
+			}
+			for _, spec := range d.Specs {
+				switch s := spec.(type) {
+				case *ast.TypeSpec:
+					idents = append(idents, s.Name)
+				case *ast.ValueSpec:
+					idents = append(idents, s.Names...)
+				}
+			}
+		}
+
+		for _, ident := range idents {
+			if ident.Name == "_" {
+				continue
+			}
+			err := gen.symbols.declare(ident.Name, entity)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// methodsOf lists the methods decls generate on typ, fields of the same
+// name would not compile
+func methodsOf(decls []ast.Decl, typ string) []string {
+	var methods []string
+	for _, decl := range decls {
+		d, ok := decl.(*ast.FuncDecl)
+		if !ok || d.Recv == nil {
+			continue
+		}
+		if strings.TrimPrefix(recvName(d.Recv.List[0].Type), "*") == typ {
+			methods = append(methods, d.Name.Name)
+		}
+	}
+	return methods
+}
+
+// methodTable starts the scope of a struct with its methods
+func methodTable(methods []string) symbolTable {
+	st := symbolTable{}
+	for _, method := range methods {
+		st[method] = "method " + method
+	}
+	return st
+}
+
+// checkFields makes sure the TLVs of a generated struct, the fields
+// promoted from embedded common structs and the methods of the struct
+// have distinct Go names. Structs nested in TLVs are checked likewise.
+func (gen *generator) checkFields(tlvs []QMITLV, methods []string, output bool) error {
+	st := methodTable(methods)
+	if output && gen.opts.RetainRawTLVs {
+		st.declare("RawTLVs", "-raw-tlvs field RawTLVs")
+	}
+
+	fields := make([]QMITLVField, len(tlvs))
+	for i := range tlvs {
+		fields[i] = tlvs[i].QMITLVField
+	}
+	return gen.declareFields(st, fields, "TLV")
+}
+
+// checkSymbols checks the fields of the input and output types
+func (qm *QMIMessage) checkSymbols(gen *generator, decls []ast.Decl) error {
+	prefix := qm.Service + gen.goName(qm.Name)
+	err := gen.checkFields(qm.Input, methodsOf(decls, prefix+"Input"), false)
+	if err != nil {
+		return err
+	}
+	return gen.checkFields(qm.Output, methodsOf(decls, prefix+"Output"), true)
+}
+
+// checkSymbols checks the fields of the indication type
+func (qi *QMIIndication) checkSymbols(gen *generator, decls []ast.Decl) error {
+	typ := qi.Service + gen.goName(qi.Name) + "Indication"
+	return gen.checkFields(qi.Output, methodsOf(decls, typ), true)
+}
+
+func (gen *generator) declareFields(st symbolTable, fields []QMITLVField, kind string) error {
+	for _, field := range fields {
+		entity := fmt.Sprintf("%s %q", kind, field.Name)
+
+		switch {
+		case field.Name == "" && field.CommonRef != "":
+			entity = fmt.Sprintf("common-ref %q", field.CommonRef)
+			err := st.declare("QMIStruct"+gen.goName(field.CommonRef), entity)
+			if err != nil {
+				return err
+			}
+
+			common, err := field.ResolveCommonRef(gen)
+			if err != nil {
+				return err
+			}
+			promoted := common.Contents
+			if len(promoted) == 0 {
+				promoted = []QMITLVField{common.QMITLVField}
+			}
+			for _, p := range promoted {
+				if p.Name == "" {
+					continue
+				}
+				err = st.declare(gen.goName(p.Name), fmt.Sprintf("field %q of %s", p.Name, entity))
+				if err != nil {
+					return err
+				}
+			}
+			continue
+		case field.Name != "":
+			err := st.declare(gen.goName(field.Name), entity)
+			if err != nil {
+				return err
+			}
+		}
+
+		contents := field.Contents
+		if field.Format == "array" && field.ArrayElement != nil {
+			contents = field.ArrayElement.Contents
+		}
+		if len(contents) > 0 {
+			err := gen.declareFields(symbolTable{}, contents, "field")
+			if err != nil {
+				return fmt.Errorf("%s: %w", entity, err)
+			}
+		}
+	}
+	return nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
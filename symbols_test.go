@@ -0,0 +1,61 @@
+package qmigen
+
+import (
+	"strings"
+	"testing"
+)
+
+// tlv is a data file TLV of id and format
+func tlv(name, id, format string) string {
+	return `{ "name" : "` + name + `", "id" : "` + id + `", "type" : "TLV", "since" : "1.0", "format" : "` + format + `" }`
+}
+
+// TestCollisions generates entities whose Go names collide and expects
+// errors naming both
+func TestCollisions(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := func(name, id string, output ...string) string {
+		return `{ "name" : "` + name + `", "type" : "Message", "service" : "DMS", "id" : "` + id + `", "since" : "1.0",
+    "output" : [ ` + strings.Join(output, ", ") + ` ] }`
+	}
+	for _, test := range []struct {
+		name     string
+		entities []string
+		want     []string
+	}{
+		{"messages", []string{
+			message("Get ID", "0x0025"),
+			message("Get Id", "0x0026"),
+		}, []string{`"Get ID"`, `"Get Id"`, "DMSGetID"}},
+		{"TLVs", []string{
+			message("Get IDs", "0x0025", tlv("Serial Number", "0x10", "string"), tlv("Serial-Number", "0x11", "string")),
+		}, []string{`TLV "Serial Number"`, `TLV "Serial-Number"`, "SerialNumber"}},
+		{"common-ref field", []string{
+			message("Get IDs", "0x0025", `{ "common-ref" : "Operation Result" }`, tlv("Error Status", "0x10", "guint16")),
+		}, []string{`field "Error Status" of common-ref "Operation Result"`, `TLV "Error Status"`}},
+		{"method", []string{
+			message("Get IDs", "0x0025", tlv("Message ID", "0x10", "guint16")),
+		}, []string{`method MessageID`, `TLV "Message ID"`}},
+		{"struct fields", []string{
+			message("Get IDs", "0x0025", `{ "name" : "Info", "id" : "0x10", "type" : "TLV", "since" : "1.0", "format" : "sequence",
+      "contents" : [ { "name" : "Band Class", "format" : "guint8" }, { "name" : "band class", "format" : "guint8" } ] }`),
+		}, []string{`TLV "Info"`, `field "Band Class"`, `field "band class"`}},
+	} {
+		src := "[\n" + `{ "name" : "DMS", "type" : "Service" },` + "\n" + strings.Join(test.entities, ",\n") + "\n]"
+		_, err := Generate(strings.NewReader(src), Options{Common: common})
+		if err == nil {
+			t.Errorf("%s: no collision", test.name)
+			continue
+		}
+		for _, want := range test.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: no %s in %q", test.name, want, err)
+			}
+		}
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
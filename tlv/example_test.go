@@ -0,0 +1,35 @@
+package tlv_test
+
+import (
+	"fmt"
+
+	"bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen/tlv"
+)
+
+// A request with TLVs no data file describes: the mandatory 0x01 and a
+// vendor specific 0x10.
+func Example() {
+	b, err := (&tlv.Builder{}).
+		AddUint8(0x01, 0x04).
+		AddString(0x10, "internet", true).
+		Bytes()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("% x\n", b)
+
+	p := tlv.NewParser(b)
+	for p.Next() {
+		fmt.Printf("%02x: % x\n", p.Tag(), p.Payload())
+	}
+	if p.Err() != nil {
+		fmt.Println(p.Err())
+	}
+	// Output:
+	// 01 01 00 04 10 09 00 08 69 6e 74 65 72 6e 65 74
+	// 01: 04
+	// 10: 08 69 6e 74 65 72 6e 65 74
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,43 @@
+//go:build go1.18
+// +build go1.18
+
+package tlv
+
+import (
+	"bytes"
+	"testing"
+)
+
+// FuzzParseTLVs parses arbitrary bytes and builds the TLVs parsed again:
+// they must be the bytes up to the malformed TLV, if any
+func FuzzParseTLVs(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte{0x01, 0x02, 0x00, 0xaa, 0xbb, 0x10, 0x00, 0x00})
+	f.Add([]byte{0x12, 0x03, 0x00, 0xaa})
+	f.Add([]byte{0x01, 0x00})
+	f.Fuzz(func(t *testing.T, b []byte) {
+		p := NewParser(b)
+		built := &Builder{}
+		for p.Next() {
+			if len(p.Payload()) > len(b) {
+				t.Fatalf("TLV %#04x of %d bytes out of %d", p.Tag(), len(p.Payload()), len(b))
+			}
+			built.Add(p.Tag(), p.Payload())
+		}
+		got, err := built.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(b, got) {
+			t.Fatalf("parsed and built\n% x\nnot a prefix of\n% x", got, b)
+		}
+		if p.Err() == nil && len(got) != len(b) {
+			t.Fatalf("%d of %d bytes parsed without an error", len(got), len(b))
+		}
+		if p.Err() != nil && len(got) == len(b) {
+			t.Fatalf("all %d bytes parsed, yet %v", len(b), p.Err())
+		}
+	})
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,136 @@
+// Package tlv reads and writes the TLV area of QMI messages: a tag byte, a
+// little endian 16-bit length and that many bytes of payload, repeated.
+// The generated qmi package decodes with it, and it serves TLVs no data
+// file describes.
+package tlv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// ErrTruncated is reported by Parser for a TLV running past the end of the
+// buffer
+type ErrTruncated uint8
+
+func (e ErrTruncated) Error() string {
+	return fmt.Sprintf("TLV %#04x is truncated", uint8(e))
+}
+
+// Parser iterates over the TLVs of a message:
+//
+//	p := tlv.NewParser(payload)
+//	for p.Next() {
+//		handle(p.Tag(), p.Payload())
+//	}
+//	err := p.Err()
+//
+// Payloads alias the parsed buffer.
+type Parser struct {
+	b       []byte
+	tag     uint8
+	payload []byte
+	err     error
+}
+
+func NewParser(b []byte) *Parser {
+	return &Parser{b: b}
+}
+
+// Next moves to the next TLV, false at the end of the buffer or at a
+// malformed TLV
+func (p *Parser) Next() bool {
+	if p.err != nil || len(p.b) == 0 {
+		return false
+	}
+	if len(p.b) < 3 {
+		p.err = io.ErrUnexpectedEOF
+		return false
+	}
+
+	tag := p.b[0]
+	l := int(binary.LittleEndian.Uint16(p.b[1:]))
+	if len(p.b)-3 < l {
+		p.err = ErrTruncated(tag)
+		return false
+	}
+
+	p.tag = tag
+	p.payload = p.b[3 : 3+l]
+	p.b = p.b[3+l:]
+	return true
+}
+
+func (p *Parser) Tag() uint8 {
+	return p.tag
+}
+
+func (p *Parser) Payload() []byte {
+	return p.payload
+}
+
+// Err returns the error which stopped Next, if any
+func (p *Parser) Err() error {
+	return p.err
+}
+
+// Builder assembles the TLVs of a message. The first error sticks and is
+// returned by Bytes.
+type Builder struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (b *Builder) Add(tag uint8, payload []byte) *Builder {
+	if len(payload) > 0xffff {
+		if b.err == nil {
+			b.err = fmt.Errorf("TLV %#04x: payload of %d bytes is too large", tag, len(payload))
+		}
+		return b
+	}
+	b.buf.WriteByte(tag)
+	binary.Write(&b.buf, binary.LittleEndian, uint16(len(payload)))
+	b.buf.Write(payload)
+	return b
+}
+
+func (b *Builder) AddUint8(tag uint8, v uint8) *Builder {
+	return b.Add(tag, []byte{v})
+}
+
+func (b *Builder) AddUint16(tag uint8, v uint16) *Builder {
+	payload := make([]byte, 2)
+	binary.LittleEndian.PutUint16(payload, v)
+	return b.Add(tag, payload)
+}
+
+func (b *Builder) AddUint32(tag uint8, v uint32) *Builder {
+	payload := make([]byte, 4)
+	binary.LittleEndian.PutUint32(payload, v)
+	return b.Add(tag, payload)
+}
+
+// AddString adds s, preceded by its one byte length if prefixed
+func (b *Builder) AddString(tag uint8, s string, prefixed bool) *Builder {
+	if !prefixed {
+		return b.Add(tag, []byte(s))
+	}
+	if len(s) > 0xff {
+		if b.err == nil {
+			b.err = fmt.Errorf("TLV %#04x: string of %d bytes is too long", tag, len(s))
+		}
+		return b
+	}
+	return b.Add(tag, append([]byte{uint8(len(s))}, s...))
+}
+
+func (b *Builder) Bytes() ([]byte, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	return b.buf.Bytes(), nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,107 @@
+package tlv
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	p := NewParser([]byte{0x01, 0x02, 0x00, 0xaa, 0xbb, 0x10, 0x00, 0x00})
+	var tags []uint8
+	var payloads [][]byte
+	for p.Next() {
+		tags = append(tags, p.Tag())
+		payloads = append(payloads, p.Payload())
+	}
+	if p.Err() != nil {
+		t.Fatal(p.Err())
+	}
+	if !bytes.Equal(tags, []uint8{0x01, 0x10}) {
+		t.Errorf("tags % x, want 01 10", tags)
+	}
+	if len(payloads) != 2 || !bytes.Equal(payloads[0], []byte{0xaa, 0xbb}) || len(payloads[1]) != 0 {
+		t.Errorf("payloads % x", payloads)
+	}
+}
+
+func TestParserMalformed(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		b    []byte
+		tags int
+		err  error
+	}{
+		{"empty", nil, 0, nil},
+		{"short header", []byte{0x01, 0x00}, 0, io.ErrUnexpectedEOF},
+		{"short header after a TLV", []byte{0x01, 0x00, 0x00, 0x02}, 1, io.ErrUnexpectedEOF},
+		{"truncated", []byte{0x12, 0x03, 0x00, 0xaa, 0xbb}, 0, ErrTruncated(0x12)},
+		{"truncated after a TLV", []byte{0x01, 0x01, 0x00, 0xaa, 0x12, 0xff, 0xff}, 1, ErrTruncated(0x12)},
+	} {
+		p := NewParser(test.b)
+		tags := 0
+		for p.Next() {
+			tags++
+		}
+		if tags != test.tags || p.Err() != test.err {
+			t.Errorf("%s: %d TLVs, err %v, want %d, %v", test.name, tags, p.Err(), test.tags, test.err)
+		}
+		if p.Next() {
+			t.Errorf("%s: Next after the end", test.name)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	b, err := (&Builder{}).
+		AddUint8(0x01, 0x05).
+		AddUint16(0x02, 0x0102).
+		AddUint32(0x10, 0x01020304).
+		AddString(0x11, "ab", true).
+		AddString(0x12, "cd", false).
+		Add(0x13, nil).
+		Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{
+		0x01, 0x01, 0x00, 0x05,
+		0x02, 0x02, 0x00, 0x02, 0x01,
+		0x10, 0x04, 0x00, 0x04, 0x03, 0x02, 0x01,
+		0x11, 0x03, 0x00, 0x02, 'a', 'b',
+		0x12, 0x02, 0x00, 'c', 'd',
+		0x13, 0x00, 0x00,
+	}
+	if !bytes.Equal(b, want) {
+		t.Errorf("built\n% x\nwant\n% x", b, want)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		b    *Builder
+		err  string
+	}{
+		{"payload", (&Builder{}).Add(0x10, make([]byte, 0x10000)), "TLV 0x0010: payload of 65536 bytes is too large"},
+		{"string", (&Builder{}).AddString(0x11, strings.Repeat("a", 0x100), true), "TLV 0x0011: string of 256 bytes is too long"},
+		{"first error sticks", (&Builder{}).
+			AddString(0x11, strings.Repeat("a", 0x100), true).
+			Add(0x10, make([]byte, 0x10000)).
+			AddUint8(0x01, 1), "TLV 0x0011: string of 256 bytes is too long"},
+	} {
+		b, err := test.b.Bytes()
+		if err == nil || err.Error() != test.err || b != nil {
+			t.Errorf("%s: % x, %v, want %s", test.name, b, err, test.err)
+		}
+	}
+
+	// without a length prefix a long string is fine
+	_, err := (&Builder{}).AddString(0x11, strings.Repeat("a", 0x100), false).Bytes()
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,373 @@
+package qmigen
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+)
+
+var (
+	posType          = reflect.TypeOf(token.NoPos)
+	objectType       = reflect.TypeOf(&ast.Object{})
+	scopeType        = reflect.TypeOf(&ast.Scope{})
+	commentGroupType = reflect.TypeOf(&ast.CommentGroup{})
+	fieldListType    = reflect.TypeOf(&ast.FieldList{})
+	typeSpecType     = reflect.TypeOf(ast.TypeSpec{})
+)
+
+// equalNodes structurally compares two AST nodes ignoring positions,
+// comments and resolved objects.
+func equalNodes(a, b reflect.Value) bool {
+	if a.Type() != b.Type() {
+		return false
+	}
+
+	switch a.Kind() {
+	case reflect.Interface:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalNodes(a.Elem(), b.Elem())
+	case reflect.Ptr:
+		switch a.Type() {
+		case objectType, scopeType, commentGroupType:
+			return true
+		case fieldListType:
+			// nil and empty field lists render the same
+			if a.IsNil() {
+				a = reflect.ValueOf(&ast.FieldList{})
+			}
+			if b.IsNil() {
+				b = reflect.ValueOf(&ast.FieldList{})
+			}
+		}
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equalNodes(a.Elem(), b.Elem())
+	case reflect.Struct:
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).Type == posType {
+				// only the validity of TypeSpec.Assign is meaningful
+				if a.Type() == typeSpecType && a.Type().Field(i).Name == "Assign" {
+					if a.Field(i).Interface().(token.Pos).IsValid() !=
+						b.Field(i).Interface().(token.Pos).IsValid() {
+						return false
+					}
+				}
+				continue
+			}
+			if !equalNodes(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equalNodes(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
+
+func declName(decl ast.Decl) string {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		if d.Recv != nil && len(d.Recv.List) > 0 {
+			return fmt.Sprintf("method %s on %s", d.Name.Name, recvName(d.Recv.List[0].Type))
+		}
+		return "func " + d.Name.Name
+	case *ast.GenDecl:
+		if len(d.Specs) > 0 {
+			if ts, ok := d.Specs[0].(*ast.TypeSpec); ok {
+				return "type " + ts.Name.Name
+			}
+		}
+		return d.Tok.String()
+	}
+	return fmt.Sprintf("%T", decl)
+}
+
+func recvName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.StarExpr:
+		return "*" + recvName(e.X)
+	case *ast.Ident:
+		return e.Name
+	}
+	return fmt.Sprintf("%T", expr)
+}
+
+// formatVerified renders f and makes sure the rendered source parses back
+// into the same AST, so printer quirks cannot silently change semantics.
+func formatVerified(fs *token.FileSet, f *ast.File) ([]byte, error) {
+	var buf bytes.Buffer
+	err := format.Node(&buf, fs, f)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := parser.ParseFile(token.NewFileSet(), "", buf.Bytes(), 0)
+	if err != nil {
+		return nil, fmt.Errorf("generated code does not parse: %w", err)
+	}
+
+	if len(parsed.Decls) != len(f.Decls) {
+		return nil, fmt.Errorf(
+			"generated code has %d declarations instead of %d",
+			len(parsed.Decls), len(f.Decls),
+		)
+	}
+
+	for i, decl := range f.Decls {
+		if !equalNodes(reflect.ValueOf(decl), reflect.ValueOf(parsed.Decls[i])) {
+			return nil, fmt.Errorf(
+				"generated %s does not round-trip through go/parser",
+				declName(decl),
+			)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// checkSharedNodes makes sure no node occurs twice in f: go/ast expects a
+// tree, and positions or comments set on a shared node would show up in
+// every place it occurs.
+func checkSharedNodes(f *ast.File) error {
+	seen := map[ast.Node]bool{}
+	var shared []string
+	for _, decl := range f.Decls {
+		ast.Inspect(decl, func(n ast.Node) bool {
+			if n == nil {
+				return false
+			}
+			if seen[n] {
+				shared = append(shared, fmt.Sprintf("%s: %s", declName(decl), nodeName(n)))
+				return false
+			}
+			seen[n] = true
+			return true
+		})
+	}
+
+	if len(shared) > 0 {
+		return fmt.Errorf("generated code shares nodes: %s", strings.Join(shared, ", "))
+	}
+	return nil
+}
+
+// nodeName describes n for checkSharedNodes
+func nodeName(n ast.Node) string {
+	switch n := n.(type) {
+	case *ast.Ident:
+		return "identifier " + n.Name
+	case *ast.SelectorExpr:
+		return fmt.Sprintf("%s.%s", nodeName(n.X), n.Sel.Name)
+	}
+	return fmt.Sprintf("%T", n)
+}
+
+// checkRegistry makes sure every emitted Output and Indication type is
+// registered, a missing registerMessage only shows up as ErrBadMessage at
+// runtime.
+func checkRegistry(f *ast.File, registered map[string]bool) error {
+	var missing []string
+	for _, decl := range f.Decls {
+		d, ok := decl.(*ast.GenDecl)
+		if !ok || d.Tok != token.TYPE {
+			continue
+		}
+		for _, spec := range d.Specs {
+			ts := spec.(*ast.TypeSpec)
+			if ts.Assign.IsValid() {
+				continue
+			}
+			if !strings.HasSuffix(ts.Name.Name, "Output") && !strings.HasSuffix(ts.Name.Name, "Indication") {
+				continue
+			}
+			if !registered[ts.Name.Name] {
+				missing = append(missing, ts.Name.Name)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("generated types are not registered: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// typeCheck runs go/types over src, the generated file of decls, together
+// with the runtime and the common declarations of the files it layers on.
+// Errors name the data file entity generating the declaration at fault.
+// Those outside decls are left alone: the runtime refers to declarations
+// of qmi-service-ctl.go, which need not be among the files.
+func (gen *generator) typeCheck(src []byte, decls []ast.Decl) error {
+	var layers []*Registry
+	for r := gen.reg.parent; r != nil; r = r.parent {
+		if r.src != nil {
+			layers = append([]*Registry{r}, layers...)
+		}
+	}
+	if len(layers) == 0 && filepath.Base(gen.opts.Output) != "qmi-common.go" {
+		// no runtime to check against, as with Generate without Common
+		return nil
+	}
+
+	// the file comes last, so that redeclarations are reported in it
+	fs := token.NewFileSet()
+	var files []*ast.File
+	for _, r := range layers {
+		f, err := parser.ParseFile(fs, r.output, r.src, 0)
+		if err != nil {
+			return fmt.Errorf("%s does not parse: %w", r.output, err)
+		}
+		files = append(files, f)
+	}
+	// leOrder of -direct-encoding, whichever file declares it
+	order, err := byteOrderFiles(gen.opts)
+	if err != nil {
+		return err
+	}
+	f, err := parser.ParseFile(fs, "qmi-byteorder.go", order["qmi-byteorder.go"], 0)
+	if err != nil {
+		return err
+	}
+	files = append(files, f)
+	file, err := parser.ParseFile(fs, filepath.Base(gen.opts.Output), src, 0)
+	if err != nil {
+		return fmt.Errorf("generated code does not parse: %w", err)
+	}
+	files = append(files, file)
+
+	if gen.importer == nil {
+		gen.importer = importer.ForCompiler(token.NewFileSet(), "source", nil)
+	}
+	var errs []types.Error
+	conf := types.Config{
+		Importer: gen.importer,
+		Error: func(err error) {
+			errs = append(errs, err.(types.Error))
+		},
+	}
+	conf.Check(gen.opts.packageName(), fs, files, nil)
+
+	// the declarations of file up to those of the runtime are decls
+	for _, err := range errs {
+		for i, decl := range file.Decls {
+			if i >= len(decls) || err.Pos < decl.Pos() || err.Pos >= decl.End() {
+				continue
+			}
+			if entity := gen.origins[decls[i]]; entity != "" {
+				return fmt.Errorf("%s: generated code does not type-check: %w", entity, err)
+			}
+			return fmt.Errorf("generated code does not type-check: %w", err)
+		}
+	}
+	return nil
+}
+
+// qmigenModule is the module of qmigen, which generated packages require
+const qmigenModule = "bitbucket.sdc.yandex-team.ru/sdc/sdc-gated/qmigen"
+
+// moduleDir returns the source directory of module path as the go command
+// resolves it in the current directory
+func moduleDir(path string) (string, error) {
+	cmd := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", path)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go list -m %s: %w\n%s", path, err, stderr.Bytes())
+	}
+	dir := strings.TrimSpace(string(out))
+	if dir == "" {
+		return "", fmt.Errorf("module %s is not downloaded", path)
+	}
+	return dir, nil
+}
+
+// VerifyBuild copies the Go files of dir, a generated package, into a
+// temporary module and runs go build, go vet and go test there, with the go
+// command of PATH. The current directory must be in a module requiring
+// qmigen, or qmigen itself. The error carries the output of the step which failed.
+func VerifyBuild(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("%s: no Go files", dir)
+	}
+
+	tmp, err := ioutil.TempDir("", "qmigen-verify")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(filepath.Join(tmp, filepath.Base(file)), data, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	// the runtime imports the tlv package of qmigen, taken from the source
+	// the current directory builds with
+	qmigen_dir, err := moduleDir(qmigenModule)
+	if err != nil {
+		return err
+	}
+	mod := "module qmigen.verify\n\ngo 1.15\n\n" +
+		"require " + qmigenModule + " v0.0.0\n\n" +
+		"replace " + qmigenModule + " => " + qmigen_dir + "\n"
+	err = ioutil.WriteFile(filepath.Join(tmp, "go.mod"), []byte(mod), 0666)
+	if err != nil {
+		return err
+	}
+	sum, err := ioutil.ReadFile(filepath.Join(qmigen_dir, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(tmp, "go.sum"), sum, 0666)
+	if err != nil {
+		return err
+	}
+
+	for _, step := range []string{"build", "vet", "test"} {
+		cmd := exec.Command("go", step, "./...")
+		cmd.Dir = tmp
+		// a go.work around the working directory must not apply
+		cmd.Env = append(os.Environ(), "GOWORK=off")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("go %s of %s: %w\n%s", step, dir, err, out)
+		}
+	}
+	return nil
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go
@@ -0,0 +1,235 @@
+package qmigen
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// parseDecl parses src, a declaration of package qmi
+func parseDecl(t *testing.T, src string) (*token.FileSet, *ast.File) {
+	t.Helper()
+	fs := token.NewFileSet()
+	f, err := parser.ParseFile(fs, "", "package qmi\n"+src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fs, f
+}
+
+func TestFormatVerified(t *testing.T) {
+	fs, f := parseDecl(t, `func size(n, count int) int { return (n + 2) * count }`)
+	src, err := formatVerified(fs, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(src), "(n + 2) * count") {
+		t.Errorf("rendered\n%s", src)
+	}
+
+	// the length of an array of count elements of n bytes and a prefix,
+	// built without the ParenExpr the printer relies on: it renders as
+	// n + 2*count
+	ret := f.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ReturnStmt)
+	mul := ret.Results[0].(*ast.BinaryExpr)
+	mul.X = mul.X.(*ast.ParenExpr).X
+	_, err = formatVerified(fs, f)
+	if err == nil || !strings.Contains(err.Error(), "func size") {
+		t.Errorf("err = %v, want one naming func size", err)
+	}
+}
+
+func TestFormatVerifiedCompositeLiteral(t *testing.T) {
+	fs, f := parseDecl(t, `type T struct{ A int }
+
+func zero(v T) bool {
+	if v == (T{}) {
+		return true
+	}
+	return false
+}`)
+	_, err := formatVerified(fs, f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without parens the literal would open the block of the if
+	cond := f.Decls[1].(*ast.FuncDecl).Body.List[0].(*ast.IfStmt).Cond.(*ast.BinaryExpr)
+	cond.Y = cond.Y.(*ast.ParenExpr).X
+	_, err = formatVerified(fs, f)
+	if err == nil {
+		t.Error("composite literal in an if condition accepted")
+	}
+}
+
+func TestCheckSharedNodes(t *testing.T) {
+	_, f := parseDecl(t, `func a() int { return 1 }
+
+func b() int { return 2 }`)
+	err := checkSharedNodes(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ret := f.Decls[1].(*ast.FuncDecl).Body.List[0].(*ast.ReturnStmt)
+	ret.Results[0] = f.Decls[0].(*ast.FuncDecl).Body.List[0].(*ast.ReturnStmt).Results[0]
+	err = checkSharedNodes(f)
+	if err == nil || !strings.Contains(err.Error(), "func b") {
+		t.Errorf("err = %v, want one naming func b", err)
+	}
+}
+
+func TestCheckRegistry(t *testing.T) {
+	_, f := parseDecl(t, `type DMSGetIDsInput struct{}
+type DMSGetIDsOutput struct{}
+type DMSEventReportIndication struct{}
+type DMSGetIDsAlias = DMSGetIDsOutput`)
+	registered := map[string]bool{"DMSGetIDsOutput": true, "DMSEventReportIndication": true}
+	err := checkRegistry(f, registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the registration of an indication skipped
+	delete(registered, "DMSEventReportIndication")
+	err = checkRegistry(f, registered)
+	if err == nil || !strings.Contains(err.Error(), "DMSEventReportIndication") {
+		t.Errorf("err = %v, want one naming DMSEventReportIndication", err)
+	}
+}
+
+// TestGeneratedNodesUnshared generates testdata/data with the options
+// which add declarations of their own: generation fails should any
+// declaration share a node with another
+func TestGeneratedNodesUnshared(t *testing.T) {
+	inputs, err := filepath.Glob("testdata/data/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, o := range []Options{
+		{},
+		{ExplicitRegister: true},
+		{OptionalPointers: true, PresenceAccessors: true, RetainRawTLVs: true},
+		{DirectEncoding: true, StringPolicy: "replace"},
+	} {
+		o.SkipTypeCheck = true
+		err := GenerateFiles(inputs, t.TempDir(), o)
+		if err != nil {
+			t.Errorf("%+v: %s", o, err)
+		}
+	}
+}
+
+// TestTypeCheck expects a generated declaration which does not type-check
+// with the runtime to fail naming its data file entity, and errors past
+// the declarations of the file, as in the runtime, to be left alone
+func TestTypeCheck(t *testing.T) {
+	common, err := LoadRegistry("testdata/data/qmi-common.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen, err := newGenerator(Options{Output: "qmi-service-wds.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.reg = NewRegistry(common)
+
+	decls := "var WDSRate = QMIStructOperationResult{}\n\nvar WDSBroken = WDSNoSuchType(1)\n"
+	src := []byte("package qmi\n" + decls)
+	_, f := parseDecl(t, decls)
+	gen.origins[f.Decls[1]] = `Message "Get Broken"`
+
+	err = gen.typeCheck(src, f.Decls)
+	if err == nil || !strings.HasPrefix(err.Error(), `Message "Get Broken": generated code does not type-check: `) ||
+		!strings.Contains(err.Error(), "WDSNoSuchType") {
+		t.Errorf("err = %v, want one naming the message and the type", err)
+	}
+	delete(gen.origins, f.Decls[1])
+	err = gen.typeCheck(src, f.Decls)
+	if err == nil || !strings.HasPrefix(err.Error(), "generated code does not type-check: ") {
+		t.Errorf("err = %v without an entity", err)
+	}
+
+	// WDSBroken stands for the runtime here
+	err = gen.typeCheck(src, f.Decls[:1])
+	if err != nil {
+		t.Errorf("error outside the declarations: %s", err)
+	}
+
+	// without qmi-common.go there is no runtime to check against
+	gen.reg = NewRegistry(nil)
+	err = gen.typeCheck(src, f.Decls)
+	if err != nil {
+		t.Errorf("checked without a runtime: %s", err)
+	}
+}
+
+// roundTripTest is a test of the generated package VerifyBuild runs: a
+// response marshalled and unmarshalled again
+const roundTripTest = `package qmi
+
+import "testing"
+
+func TestRoundTrip(t *testing.T) {
+	buf, err := Marshal(&DMSGetManufacturerOutput{Manufacturer: "ACME"}, 1, 7, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	_, err = Unmarshal(buf.Bytes(), &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, ok := msg.(*DMSGetManufacturerOutput); !ok || m.Manufacturer != "ACME" {
+		t.Errorf("unmarshalled %v", msg)
+	}
+}
+`
+
+// TestVerifyBuild generates the data files of testdata/data with the
+// default options and expects the package to build, vet and pass its
+// tests in a module of its own, and a package failing vet to be reported
+// with the output of go vet
+func TestVerifyBuild(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a generated package")
+	}
+	inputs, err := filepath.Glob("testdata/data/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	err = GenerateFiles(inputs, dir, Options{Generator: "qmigen"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "roundtrip_test.go"), []byte(roundTripTest), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyBuild(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir = t.TempDir()
+	src := "package qmi\n\nimport \"fmt\"\n\nfunc Print() { fmt.Printf(\"%d\\n\", \"one\") }\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "qmi.go"), []byte(src), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifyBuild(dir)
+	if err == nil || !strings.HasPrefix(err.Error(), "go vet of "+dir) || !strings.Contains(err.Error(), "Printf format %d has arg") {
+		t.Errorf("err = %v, want the output of go vet", err)
+	}
+
+	if err := VerifyBuild(t.TempDir()); err == nil || !strings.HasSuffix(err.Error(), ": no Go files") {
+		t.Errorf("err = %v for an empty directory", err)
+	}
+}
+
+// vim: ai:ts=8:sw=8:noet:syntax=go